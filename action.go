@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/AScotM/filesystem_cap/fscap/hostid"
+)
+
+const (
+	// execDefaultTimeout bounds an action without a timeout of its own.
+	execDefaultTimeout = time.Minute
+	// execOutputMax is how much of an action's output is kept.
+	execOutputMax = 4096
+)
+
+// ExecAction is a command an alert route runs when one of its mounts
+// reaches On, "critical" unless set to "warning". Each argument may use
+// the DFMON_* variables the command also gets in its environment, e.g.
+// ["lvextend", "-r", "-L+10G", "$DFMON_DEVICE"]. No shell is involved.
+// It only runs with -allow-exec, at most once per -exec-cooldown per
+// filesystem.
+type ExecAction struct {
+	Command []string `json:"command"`
+	Timeout string   `json:"timeout,omitempty"`
+	On      string   `json:"on,omitempty"`
+}
+
+func (a ExecAction) check(key string) []error {
+	var errs []error
+	if len(a.Command) == 0 || a.Command[0] == "" {
+		errs = append(errs, fmt.Errorf("%s.command: required", key))
+	}
+	if a.Timeout != "" {
+		if d, err := time.ParseDuration(a.Timeout); err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("%s.timeout: invalid duration %q", key, a.Timeout))
+		}
+	}
+	if a.On != "" && a.On != "warning" && a.On != "critical" {
+		errs = append(errs, fmt.Errorf("%s.on: %q is not warning or critical", key, a.On))
+	}
+	return errs
+}
+
+func (a ExecAction) level() Status {
+	if a.On == "warning" {
+		return StatusWarning
+	}
+	return StatusCritical
+}
+
+func (a ExecAction) timeout() time.Duration {
+	if d, err := time.ParseDuration(a.Timeout); err == nil && d > 0 {
+		return d
+	}
+	return execDefaultTimeout
+}
+
+// ActionResult is how one run of an exec action went. Output is the
+// start of its combined stdout and stderr.
+type ActionResult struct {
+	Command  []string `json:"command"`
+	ExitCode int      `json:"exit_code"`
+	Output   string   `json:"output,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// hasExec reports whether any route has an exec action.
+func (a AlertConfig) hasExec() bool {
+	for _, r := range a.Routes {
+		if r.Exec != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// runActions runs the exec action of each mount's route whose block usage
+// reached the action's level, unless it ran for the filesystem within the
+// cooldown, per the state file. Results go to the events file in -watch
+// mode and to the log otherwise; with -alert a failed run is sent to the
+// route's sink as an "action" violation.
+func runActions(ctx context.Context, r Report, config Config, alerts AlertConfig, logger *log.Logger) {
+	now := time.Now()
+	type run struct {
+		d      FS
+		name   string
+		route  Route
+		status Status
+	}
+	var runs []run
+	// The cooldown is claimed before the actions run, so a collection
+	// overlapping this one does not start them again.
+	err := updateState(config.State, func(st *State) {
+		for key, at := range st.Actions {
+			if now.Sub(at) >= config.ExecCooldown {
+				delete(st.Actions, key)
+			}
+		}
+		if st.Actions == nil {
+			st.Actions = map[string]time.Time{}
+		}
+		for _, d := range r.Filesystems {
+			if d.Ignored || muted(d) || d.Denied {
+				continue
+			}
+			name, route, ok := alerts.route(d)
+			if !ok || route.Exec == nil {
+				continue
+			}
+			status := route.thresholds(d, config).Evaluate(d.Usage)
+			if status < route.Exec.level() {
+				continue
+			}
+			key := identityKey(d)
+			if _, ok := st.Actions[key]; ok {
+				continue
+			}
+			st.Actions[key] = now
+			runs = append(runs, run{d, name, route, status})
+		}
+	})
+	if err != nil {
+		logger.Printf("Warning: exec actions: %v", err)
+	}
+
+	host := hostid.Get()
+	var events []Event
+	for _, a := range runs {
+		d, name, route, status := a.d, a.name, a.route, a.status
+		t := route.thresholds(d, config)
+		res := runExec(ctx, *route.Exec, d, status, name)
+		e := newEvent(now, d, "exec", "", strconv.Itoa(res.ExitCode), config)
+		e.Rule, e.Command, e.Output, e.Error = name, res.Command, res.Output, res.Error
+		events = append(events, e)
+		if config.Events == nil {
+			logger.Printf("Exec action for %s exited %d: %s", d.Mount, res.ExitCode, sanitize(firstLine(res.Output+res.Error)))
+		}
+		if res.ExitCode == 0 || !config.Alert {
+			continue
+		}
+		v := newViolation(d, "action", d.Usage, status, t, name)
+		v.Status, v.Action = "failed", &res
+		v.Key = alertKey(v, host.Name, nil)
+		payload := AlertPayload{Host: host.Name, MachineID: host.MachineID, Sink: route.Sink, Violations: []Violation{v}}
+		if err := alerts.Sinks[route.Sink].send(ctx, payload); err != nil {
+			logger.Printf("Warning: alert sink %s: %v", route.Sink, err)
+		}
+	}
+	if config.Events != nil {
+		if err := config.Events.write(events); err != nil {
+			logger.Printf("Warning: cannot write events: %v", err)
+		}
+	}
+}
+
+// runExec runs a for d. The exit code is -1 when the command could not be
+// started or was killed at its timeout.
+func runExec(ctx context.Context, a ExecAction, d FS, status Status, rule string) ActionResult {
+	vars := map[string]string{
+		"DFMON_MOUNT":  d.Mount,
+		"DFMON_DEVICE": d.Device,
+		"DFMON_TYPE":   d.Type,
+		"DFMON_USAGE":  strconv.FormatFloat(d.Usage, 'f', 2, 64),
+		"DFMON_TOTAL":  strconv.FormatUint(d.Total, 10),
+		"DFMON_USED":   strconv.FormatUint(d.Used, 10),
+		"DFMON_FREE":   strconv.FormatUint(d.Free, 10),
+		"DFMON_STATUS": status.String(),
+		"DFMON_RULE":   rule,
+	}
+	argv := make([]string, len(a.Command))
+	for i, arg := range a.Command {
+		argv[i] = os.Expand(arg, func(k string) string {
+			if v, ok := vars[k]; ok {
+				return v
+			}
+			return os.Getenv(k)
+		})
+	}
+	res := ActionResult{Command: argv, ExitCode: -1}
+
+	ctx, cancel := context.WithTimeout(ctx, a.timeout())
+	defer cancel()
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	if scanCred != nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: scanCred}
+	}
+	cmd.Env = os.Environ()
+	for k, v := range vars {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	out := &cappedBuffer{max: execOutputMax}
+	cmd.Stdout, cmd.Stderr = out, out
+	// Children left holding the output open do not keep dfmon waiting.
+	cmd.WaitDelay = 5 * time.Second
+	err := cmd.Run()
+	res.Output = out.String()
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		res.Error = fmt.Sprintf("killed after %v", a.timeout())
+	case errors.As(err, &exitErr):
+		res.ExitCode = exitErr.ExitCode()
+	case err != nil:
+		res.Error = err.Error()
+	default:
+		res.ExitCode = 0
+	}
+	return res
+}
+
+// cappedBuffer keeps the first max bytes written to it and drops the rest.
+type cappedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
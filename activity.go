@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/AScotM/filesystem_cap/fscap/mountinfo"
+)
+
+// activitySample bounds how many top-level entries are looked at per
+// mount. Nothing below the top level is read.
+const activitySample = 64
+
+// activityCollector sets FS.LastActivity to the newest mtime of the mount
+// point and a sample of its top-level entries, a cheap hint at mounts
+// nobody writes to any more. Network filesystems are skipped unless
+// network is set by -probe. Enabled with -stale-days.
+type activityCollector struct{ network bool }
+
+func (activityCollector) Name() string { return "activity" }
+func (c activityCollector) Applies(m mountinfo.Mount) bool {
+	return c.network || !isNetworkFS(m.FSType)
+}
+func (activityCollector) Enrich(ctx context.Context, m mountinfo.Mount, d *FS) error {
+	dir := hostPath(m.MountPoint)
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return activityErr(err)
+	}
+	latest := fi.ModTime()
+
+	f, err := os.Open(dir)
+	if err != nil {
+		return activityErr(err)
+	}
+	defer f.Close()
+	entries, err := f.ReadDir(activitySample)
+	if err != nil && err != io.EOF {
+		return activityErr(err)
+	}
+	for _, e := range entries {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if info, err := e.Info(); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	d.LastActivity = &latest
+	return nil
+}
+
+// activityErr drops permission errors, which leave LastActivity unset
+// instead of being listed as collector failures.
+func activityErr(err error) error {
+	if errors.Is(err, fs.ErrPermission) {
+		return nil
+	}
+	return err
+}
+
+// markStale flags the entries with no activity in the last days days.
+func markStale(list []FS, days int, now time.Time) {
+	for i := range list {
+		if a := list[i].LastActivity; a != nil && now.Sub(*a) >= time.Duration(days)*24*time.Hour {
+			list[i].Stale = true
+		}
+	}
+}
+
+func staleLine(d FS, now time.Time) string {
+	return fmt.Sprintf("    stale: last activity %s, %d days ago",
+		d.LastActivity.Format("2006-01-02"), int(now.Sub(*d.LastActivity).Hours()/24))
+}
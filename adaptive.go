@@ -0,0 +1,131 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/AScotM/filesystem_cap/fscap/mountinfo"
+)
+
+// adaptiveSpan is the distance to the next threshold, in usage points, at
+// and beyond which a mount without a known fill rate is sampled at
+// -max-interval.
+const adaptiveSpan = 20.0
+
+// scheduler gives each mount its own collection interval in -watch and
+// -listen mode with -max-interval: mounts close to a threshold, or filling
+// towards one, are collected often and the rest rarely. Collections that
+// find a mount not yet due reuse its last entry.
+type scheduler struct {
+	min, max time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*schedEntry
+}
+
+type schedEntry struct {
+	fs       FS
+	next     time.Time
+	interval time.Duration
+}
+
+func newScheduler(min, max time.Duration) *scheduler {
+	return &scheduler{min: min, max: max, entries: map[string]*schedEntry{}}
+}
+
+// split returns the mounts due at now and the last entries of the others.
+// A mount due within a quarter of its interval is collected early, so due
+// mounts are batched into fewer collections.
+func (s *scheduler) split(mounts []mountinfo.Mount, now time.Time) ([]mountinfo.Mount, []FS) {
+	if s == nil {
+		return mounts, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var due []mountinfo.Mount
+	var cached []FS
+	for _, m := range mounts {
+		e, ok := s.entries[m.MountPoint]
+		if ok && now.Add(e.interval/4).Before(e.next) {
+			cached = append(cached, e.fs)
+			continue
+		}
+		due = append(due, m)
+	}
+	return due, cached
+}
+
+// update schedules the entries of list collected at now, and forgets
+// mounts that are gone.
+func (s *scheduler) update(list []FS, fresh []mountinfo.Mount, config Config, now time.Time) {
+	if s == nil {
+		return
+	}
+	collected := make(map[string]bool, len(fresh))
+	for _, m := range fresh {
+		collected[m.MountPoint] = true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seen := make(map[string]bool, len(list))
+	for _, d := range list {
+		seen[d.Mount] = true
+		if !collected[d.Mount] {
+			continue
+		}
+		iv := s.interval(d, config)
+		s.entries[d.Mount] = &schedEntry{fs: d, next: now.Add(iv), interval: iv}
+	}
+	for mount := range s.entries {
+		if !seen[mount] {
+			delete(s.entries, mount)
+		}
+	}
+}
+
+// interval is how long d can go uncollected: proportional to its distance
+// to the next threshold, and at most a quarter of the time its smoothed fill
+// rate needs to cover that distance.
+func (s *scheduler) interval(d FS, config Config) time.Duration {
+	if d.Denied || d.Ignored || muted(d) {
+		return s.max
+	}
+	t := blockThresholds(d, config)
+	limit := t.Warn
+	if d.Usage >= t.Warn {
+		limit = t.Crit
+	}
+	distance := limit - d.Usage
+	if distance <= 0 {
+		return s.min
+	}
+
+	iv := s.min + time.Duration(float64(s.max-s.min)*min(distance/adaptiveSpan, 1))
+	if g := d.Growth; g != nil && g.Smoothed > 0 {
+		reach := time.Duration(distance / 100 * float64(d.Total) / g.Smoothed / 4 * float64(time.Second))
+		iv = min(iv, reach)
+	}
+	return max(s.min, min(iv, s.max))
+}
+
+// Interval is one mount's current interval, for /debug/status.
+type Interval struct {
+	Mount    string    `json:"mount"`
+	Interval float64   `json:"interval_seconds"`
+	Next     time.Time `json:"next"`
+}
+
+func (s *scheduler) intervals() []Interval {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Interval, 0, len(s.entries))
+	for mount, e := range s.entries {
+		out = append(out, Interval{Mount: mount, Interval: e.interval.Seconds(), Next: e.next.UTC()})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Mount < out[j].Mount })
+	return out
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// target is one agent polled by -aggregate: the base URL of its -listen
+// address and the host label its series get.
+type target struct {
+	URL  string
+	Host string
+}
+
+// readTargets parses a -targets-file: one agent base URL per line,
+// optionally followed by the host label to use instead of the URL's host.
+// Blank lines and lines starting with # are skipped.
+func readTargets(path string) ([]target, error) {
+	b, err := readInput(path)
+	if err != nil {
+		return nil, err
+	}
+	var targets []target
+	seen := map[string]bool{}
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for line := 1; sc.Scan(); line++ {
+		f := strings.Fields(sc.Text())
+		if len(f) == 0 || strings.HasPrefix(f[0], "#") {
+			continue
+		}
+		if len(f) > 2 {
+			return nil, fmt.Errorf("%s:%d: want URL [HOST]", path, line)
+		}
+		u, err := url.Parse(f[0])
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("%s:%d: %q is not an http(s) URL", path, line, f[0])
+		}
+		t := target{URL: strings.TrimSuffix(f[0], "/"), Host: u.Hostname()}
+		if len(f) == 2 {
+			t.Host = f[1]
+		}
+		if seen[t.URL] {
+			return nil, fmt.Errorf("%s:%d: duplicate target %s", path, line, t.URL)
+		}
+		seen[t.URL] = true
+		targets = append(targets, t)
+	}
+	return targets, sc.Err()
+}
+
+// HostReport is the last poll of one agent. Report is only set while the
+// agent is up, so a host that stops answering drops out of the metrics
+// rather than showing its last numbers.
+type HostReport struct {
+	Host     string    `json:"host"`
+	Target   string    `json:"target"`
+	Up       bool      `json:"up"`
+	Error    string    `json:"error,omitempty"`
+	Polled   time.Time `json:"polled"`
+	Duration float64   `json:"duration_seconds"`
+	Report   *Report   `json:"report,omitempty"`
+}
+
+// aggregator polls the agents in its target list and serves their
+// filesystems as one set of metrics, with a host label. At most cap(sem)
+// agents are polled at once, each within timeout.
+type aggregator struct {
+	e        *exporter
+	timeout  time.Duration
+	sem      chan struct{}
+	sanitize bool
+
+	mu      sync.Mutex
+	targets []target
+	hosts   map[string]HostReport
+}
+
+func newAggregator(config Config, targets []target) *aggregator {
+	return &aggregator{
+		e:        newExporter(nil, config),
+		timeout:  config.TargetTimeout,
+		sem:      make(chan struct{}, max(config.MaxTargets, 1)),
+		sanitize: config.SanitizeFields,
+		targets:  targets,
+		hosts:    map[string]HostReport{},
+	}
+}
+
+// setTargets replaces the target list, forgetting agents no longer in it.
+func (a *aggregator) setTargets(targets []target) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.targets = targets
+	keep := map[string]bool{}
+	for _, t := range targets {
+		keep[t.URL] = true
+	}
+	for u := range a.hosts {
+		if !keep[u] {
+			delete(a.hosts, u)
+		}
+	}
+}
+
+// poll fetches every target once and records the results.
+func (a *aggregator) poll(ctx context.Context) {
+	a.mu.Lock()
+	targets := a.targets
+	a.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, t := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case a.sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-a.sem }()
+
+			start := time.Now()
+			r, err := a.fetch(ctx, t)
+			h := HostReport{Host: t.Host, Target: t.URL, Up: err == nil, Polled: start, Duration: time.Since(start).Seconds()}
+			if err != nil {
+				h.Error = err.Error()
+			} else {
+				h.Report = &r
+			}
+			a.mu.Lock()
+			// The target may have been dropped by a reload meanwhile.
+			if containsTarget(a.targets, t) {
+				a.hosts[t.URL] = h
+			}
+			a.mu.Unlock()
+		}()
+	}
+	wg.Wait()
+}
+
+func containsTarget(targets []target, t target) bool {
+	for _, o := range targets {
+		if o == t {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *aggregator) fetch(ctx context.Context, t target) (Report, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	var r Report
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.URL+"/v1/filesystems", nil)
+	if err != nil {
+		return r, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return r, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return r, fmt.Errorf("agent returned %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return r, err
+	}
+	if r.Schema > reportSchema {
+		return r, newerVersion(t.URL, "report schema", r.Schema, reportSchema)
+	}
+	if a.sanitize {
+		r.Sanitized = &Sanitization{Modified: sanitizeFields(&r)}
+	}
+	return r, nil
+}
+
+// reports returns the host reports in target list order.
+func (a *aggregator) reports() []HostReport {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var out []HostReport
+	for _, t := range a.targets {
+		if h, ok := a.hosts[t.URL]; ok {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+func (a *aggregator) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	hosts := a.reports()
+	var sb strings.Builder
+	var series []fsSeries
+	for _, h := range hosts {
+		if h.Report != nil {
+			series = append(series, fsSeries{host: h.Host, r: *h.Report})
+		}
+	}
+	a.e.writeFSMetrics(&sb, series)
+
+	fmt.Fprintf(&sb, "# HELP dfmon_target_up Whether the last poll of the agent succeeded.\n# TYPE dfmon_target_up gauge\n")
+	for _, h := range hosts {
+		up := 0
+		if h.Up {
+			up = 1
+		}
+		fmt.Fprintf(&sb, "dfmon_target_up{host=\"%s\",target=\"%s\"} %d\n", labelEscaper.Replace(h.Host), labelEscaper.Replace(h.Target), up)
+	}
+	fmt.Fprintf(&sb, "# HELP dfmon_target_poll_duration_seconds Time the last poll of the agent took.\n# TYPE dfmon_target_poll_duration_seconds gauge\n")
+	for _, h := range hosts {
+		fmt.Fprintf(&sb, "dfmon_target_poll_duration_seconds{host=\"%s\",target=\"%s\"} %g\n", labelEscaper.Replace(h.Host), labelEscaper.Replace(h.Target), h.Duration)
+	}
+	writeBuildInfo(&sb)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(sb.String()))
+}
+
+func (a *aggregator) serveHosts(w http.ResponseWriter, req *http.Request) {
+	hosts := a.reports()
+	if hosts == nil {
+		hosts = []HostReport{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Hosts []HostReport `json:"hosts"`
+	}{hosts})
+}
+
+// runAggregate implements -aggregate: it polls the agents in
+// -targets-file every -poll-interval and serves their filesystems on
+// -listen as /metrics, with a host label and a per-agent dfmon_target_up,
+// and as JSON on /v1/hosts. The targets file is read again on SIGHUP.
+func runAggregate(ctx context.Context, config Config, logger *log.Logger) {
+	if config.Listen == "" || config.TargetsFile == "" {
+		logger.Fatalf("-aggregate needs -listen and -targets-file")
+	}
+	targets, err := readTargets(config.TargetsFile)
+	if err != nil {
+		logger.Fatalf("Failed to read targets: %v", err)
+	}
+	a := newAggregator(config, targets)
+
+	changes := configChanges(ctx, config)
+	go func() {
+		ticker := time.NewTicker(config.PollInterval)
+		defer ticker.Stop()
+		for {
+			a.poll(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-changes:
+				if targets, err := readTargets(config.TargetsFile); err != nil {
+					logger.Printf("Warning: keeping the previous targets: %v", err)
+				} else {
+					a.setTargets(targets)
+					logger.Printf("Reloaded %s: %d target(s)", config.TargetsFile, len(targets))
+				}
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", a)
+	mux.HandleFunc("/v1/hosts", a.serveHosts)
+	srv, err := listenHTTP(ctx, config, mux, logger)
+	if err != nil {
+		logger.Fatalf("Failed to listen: %v", err)
+	}
+
+	sdNotify("READY=1")
+	defer sdNotify("STOPPING=1")
+	if err := srv.serve(); err != nil {
+		logger.Fatalf("Aggregator failed: %v", err)
+	}
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/AScotM/filesystem_cap/fscap/hostid"
+)
+
+// AlertConfig routes threshold violations to named sinks. Routes are
+// evaluated in order and the first match wins; unmatched filesystems go
+// to the Default sink, if any.
+type AlertConfig struct {
+	Sinks   map[string]SinkConfig `json:"sinks"`
+	Routes  []Route               `json:"routes"`
+	Default string                `json:"default"`
+	// known, when set, are the sinks routes may name, those of every
+	// file of a -config-dir configuration.
+	known map[string]SinkConfig
+}
+
+// hasSink reports whether name is a known sink.
+func (a AlertConfig) hasSink(name string) bool {
+	sinks := a.Sinks
+	if a.known != nil {
+		sinks = a.known
+	}
+	_, ok := sinks[name]
+	return ok
+}
+
+type SinkConfig struct {
+	Webhook string       `json:"webhook,omitempty"`
+	Email   *EmailConfig `json:"email,omitempty"`
+}
+
+type EmailConfig struct {
+	SMTP     string   `json:"smtp"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+}
+
+// Route matches filesystems by mount glob and/or type. Warn and Crit
+// override the global thresholds for the matched filesystems.
+type Route struct {
+	Name  string   `json:"name,omitempty"`
+	Match string   `json:"match,omitempty"`
+	Type  string   `json:"type,omitempty"`
+	Sink  string   `json:"sink"`
+	Warn  *float64 `json:"warn,omitempty"`
+	Crit  *float64 `json:"crit,omitempty"`
+	// Exec is run for the matched filesystems with -allow-exec.
+	Exec *ExecAction `json:"exec,omitempty"`
+}
+
+// Violation is one threshold crossed by a filesystem. Kind is "usage" for
+// block usage, "upper" for an overlay's upperdir, "effective" for the
+// fullest filesystem under an overlay's layers, "pool" for the thin pool
+// behind the filesystem, "inodes" for inode usage, "headroom" for a scheduled job
+// that will not fit, "quota" for the caller's quota, "rapid-growth" for
+// growth over -rate-warn or -rate-crit, "fs-errors" for errors the kernel
+// recorded on the filesystem, "fat-root" for the entries of a FAT root
+// directory, "flags" for statfs flags changed by a remount, or "action"
+// for an exec action that failed.
+type Violation struct {
+	Device string  `json:"device"`
+	Mount  string  `json:"mount"`
+	Type   string  `json:"type"`
+	Kind   string  `json:"kind"`
+	Usage  float64 `json:"usage"`
+	Status string  `json:"status"`
+	Warn   float64 `json:"warn"`
+	Crit   float64 `json:"crit"`
+	Rule   string  `json:"rule"`
+	// Schedule names the time window whose thresholds applied, if any.
+	Schedule string `json:"schedule,omitempty"`
+	// Resized carries the old and new size of a "resized" notice.
+	Resized *Resize `json:"resized,omitempty"`
+	// Annotations are the mount's config annotations, e.g. its team.
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Job is the scheduled job of a "headroom" violation.
+	Job *JobFit `json:"job,omitempty"`
+	// Quota carries the limits and grace period of a "quota" violation,
+	// whose Status is "grace" while the grace period runs.
+	Quota *Quota `json:"quota,omitempty"`
+	// Growth carries the measured window_rate of a "rapid-growth"
+	// violation.
+	Growth *Growth `json:"growth,omitempty"`
+	// Action is the failed run of an exec action, for "action".
+	Action *ActionResult `json:"action,omitempty"`
+	// FATRoot is the root directory of a "fat-root" violation.
+	FATRoot *FATRoot `json:"fat_root,omitempty"`
+	// FlagChange is the old and new statfs flags of a "flags" violation.
+	FlagChange *FlagChange `json:"flag_change,omitempty"`
+	// ErrorsCount and LastErrorTime are those of an "fs-errors" violation.
+	ErrorsCount   uint64     `json:"errors_count,omitempty"`
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+	// Key is the same on every host reporting this violation in this
+	// state, for receivers to deduplicate on.
+	Key string `json:"key"`
+}
+
+type AlertPayload struct {
+	Host       string      `json:"host"`
+	MachineID  string      `json:"machine_id,omitempty"`
+	Sink       string      `json:"sink"`
+	Violations []Violation `json:"violations"`
+}
+
+func (a AlertConfig) check(path string) []error {
+	var errs []error
+	for i, r := range a.Routes {
+		key := fmt.Sprintf("%s: alerts.routes[%d]", path, i)
+		if _, err := filepath.Match(r.Match, ""); err != nil {
+			errs = append(errs, fmt.Errorf("%s.match: bad pattern %q", key, r.Match))
+		}
+		if !a.hasSink(r.Sink) {
+			errs = append(errs, fmt.Errorf("%s.sink: unknown sink %q", key, r.Sink))
+		}
+		errs = append(errs, checkOrder(key, r.Warn, r.Crit)...)
+		if r.Exec != nil {
+			errs = append(errs, r.Exec.check(key+".exec")...)
+		}
+	}
+	if a.Default != "" && !a.hasSink(a.Default) {
+		errs = append(errs, fmt.Errorf("%s: alerts.default: unknown sink %q", path, a.Default))
+	}
+	names := make([]string, 0, len(a.Sinks))
+	for name := range a.Sinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		errs = append(errs, a.Sinks[name].check(fmt.Sprintf("%s: alerts.sinks.%s", path, name))...)
+	}
+	return errs
+}
+
+func (s SinkConfig) check(key string) []error {
+	if (s.Webhook == "") == (s.Email == nil) {
+		return []error{fmt.Errorf("%s: exactly one of webhook or email required", key)}
+	}
+	if s.Webhook != "" {
+		u, err := url.Parse(s.Webhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return []error{fmt.Errorf("%s.webhook: %q is not an http(s) URL", key, s.Webhook)}
+		}
+		return nil
+	}
+
+	var errs []error
+	e := s.Email
+	if _, _, err := net.SplitHostPort(e.SMTP); err != nil {
+		errs = append(errs, fmt.Errorf("%s.email.smtp: want host:port, got %q", key, e.SMTP))
+	}
+	if e.From == "" {
+		errs = append(errs, fmt.Errorf("%s.email.from: required", key))
+	}
+	if len(e.To) == 0 {
+		errs = append(errs, fmt.Errorf("%s.email.to: no recipients", key))
+	}
+	if e.Password != "" && e.Username == "" {
+		errs = append(errs, fmt.Errorf("%s.email.password: set without username", key))
+	}
+	return errs
+}
+
+// route returns the rule name and route that applies to d. The default
+// route carries no threshold overrides.
+func (a AlertConfig) route(d FS) (string, Route, bool) {
+	for i, r := range a.Routes {
+		if r.matches(d) {
+			name := r.Name
+			if name == "" {
+				name = fmt.Sprintf("routes[%d]", i)
+			}
+			return name, r, true
+		}
+	}
+	if a.Default != "" {
+		return "default", Route{Sink: a.Default}, true
+	}
+	return "", Route{}, false
+}
+
+func (r Route) matches(d FS) bool {
+	if r.Type != "" && r.Type != d.Type {
+		return false
+	}
+	if r.Match != "" {
+		if ok, _ := filepath.Match(r.Match, d.Mount); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (r Route) thresholds(d FS, config Config) Thresholds {
+	t := blockThresholds(d, config)
+	if r.Warn != nil {
+		t.Warn = *r.Warn
+	}
+	if r.Crit != nil {
+		t.Crit = *r.Crit
+	}
+	return t
+}
+
+// judge is d with the route's thresholds in place of its block ones, for
+// failedChecks to evaluate the block-based checks against.
+func (r Route) judge(d FS, config Config) FS {
+	if r.Warn == nil && r.Crit == nil {
+		return d
+	}
+	t := r.thresholds(d, config)
+	applied := AppliedThresholds{}
+	if d.Thresholds != nil {
+		applied = *d.Thresholds
+	}
+	applied.Warn, applied.Crit = t.Warn, t.Crit
+	d.Thresholds = &applied
+	return d
+}
+
+// checkViolations is the violation a failed check of d makes, carrying
+// what the check found and the limits it was judged against: t for the
+// block-based ones. A headroom check makes one for each job that does not
+// fit.
+func checkViolations(d FS, c fsCheck, t Thresholds, config Config, rule string) []Violation {
+	switch c.Kind {
+	case "pool":
+		t = config.poolLimits()
+	case "inodes", "fat-root":
+		t = config.inodeLimits()
+	case "headroom":
+		var out []Violation
+		for _, f := range d.Jobs {
+			if !f.Fits {
+				job := f
+				v := newViolation(d, c.Kind, c.Usage, c.Status, t, rule)
+				v.Job = &job
+				out = append(out, v)
+			}
+		}
+		return out
+	}
+	v := newViolation(d, c.Kind, c.Usage, c.Status, t, rule)
+	switch c.Kind {
+	case "fat-root":
+		v.FATRoot = d.FATRoot
+	case "rapid-growth":
+		v.Growth = d.Growth
+	case "fs-errors":
+		v.ErrorsCount, v.LastErrorTime = d.ErrorsCount, d.LastErrorTime
+	case "flags":
+		v.FlagChange = d.FlagChange
+	case "quota":
+		v.Status, v.Quota = d.Quota.statusName(), d.Quota
+	}
+	return []Violation{v}
+}
+
+// routeViolations groups the filesystems over their thresholds by sink,
+// along with notices for those resized since the last collection.
+func routeViolations(list []FS, config Config, alerts AlertConfig) map[string][]Violation {
+	out := map[string][]Violation{}
+	for _, d := range list {
+		if d.Ignored || muted(d) {
+			continue
+		}
+		name, r, ok := alerts.route(d)
+		if !ok {
+			continue
+		}
+		t := r.thresholds(d, config)
+		if d.Resized != nil {
+			v := newViolation(d, "resized", d.Usage, StatusOK, t, name)
+			v.Status, v.Resized = "resized", d.Resized
+			out[r.Sink] = append(out[r.Sink], v)
+		}
+		for _, c := range failedChecks(r.judge(d, config), config) {
+			out[r.Sink] = append(out[r.Sink], checkViolations(d, c, t, config, name)...)
+		}
+	}
+	return out
+}
+
+func newViolation(d FS, kind string, usage float64, status Status, t Thresholds, rule string) Violation {
+	v := Violation{
+		Device:      d.Device,
+		Mount:       d.Mount,
+		Type:        d.Type,
+		Kind:        kind,
+		Usage:       usage,
+		Status:      status.String(),
+		Warn:        t.Warn,
+		Crit:        t.Crit,
+		Rule:        rule,
+		Annotations: d.Annotations,
+	}
+	if d.Thresholds != nil {
+		v.Schedule = d.Thresholds.Schedule
+	}
+	return v
+}
+
+// groupViolations adds the groups over their thresholds to out, keyed by
+// the group's sink or the alerts default.
+func groupViolations(out map[string][]Violation, groups []Group, alerts AlertConfig) {
+	for _, g := range groups {
+		status := groupStatus(g)
+		sink := g.sink
+		if sink == "" {
+			sink = alerts.Default
+		}
+		if status == StatusOK || sink == "" {
+			continue
+		}
+		out[sink] = append(out[sink], Violation{
+			Mount:  g.Name,
+			Kind:   "group",
+			Usage:  g.Usage,
+			Status: status.String(),
+			Warn:   g.Warn,
+			Crit:   g.Crit,
+			Rule:   "group " + g.Name,
+		})
+	}
+}
+
+func sendAlerts(ctx context.Context, r Report, config Config, alerts AlertConfig, logger *log.Logger) {
+	host := hostid.Get()
+	violations := routeViolations(r.Filesystems, config, alerts)
+	groupViolations(violations, r.Groups, alerts)
+	uuids := deviceUUIDs()
+	for _, list := range violations {
+		for i := range list {
+			list[i].Key = alertKey(list[i], host.Name, uuids)
+		}
+	}
+	if config.State != "" && (config.DedupWindow > 0 || config.Watch > 0) {
+		if err := suppressRecent(violations, config.State, config.DedupWindow, time.Now()); err != nil {
+			logger.Printf("Warning: alert dedup: %v", err)
+		}
+	}
+	for sink, violations := range violations {
+		payload := AlertPayload{Host: host.Name, MachineID: host.MachineID, Sink: sink, Violations: violations}
+		if err := alerts.Sinks[sink].send(ctx, payload); err != nil {
+			logger.Printf("Warning: alert sink %s: %v", sink, err)
+		}
+	}
+}
+
+func (s SinkConfig) send(ctx context.Context, payload AlertPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if s.Webhook != "" {
+		return postWebhook(ctx, s.Webhook, body)
+	}
+	return s.Email.send(payload, body)
+}
+
+func postWebhook(ctx context.Context, url string, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (e *EmailConfig) send(payload AlertPayload, body []byte) error {
+	// Mount points and names may hold line breaks, which would end the
+	// header or forge lines of the message.
+	s := strictSanitize
+	var lines []string
+	for _, v := range payload.Violations {
+		if v.Resized != nil {
+			lines = append(lines, fmt.Sprintf("resized: %s from %s to %s (rule %s)",
+				s(v.Mount), fmtBytes(v.Resized.Old, true), fmtBytes(v.Resized.New, true), s(v.Rule)))
+			continue
+		}
+		if v.Job != nil {
+			lines = append(lines, fmt.Sprintf("%s: insufficient headroom for job %s on %s: needs %s + %s, %s free at %s (rule %s)",
+				v.Status, s(v.Job.Name), s(v.Mount), fmtBytes(v.Job.Size, true), fmtBytes(v.Job.Margin, true),
+				fmtBytes(v.Job.Free, true), v.Job.Next.Format("Mon 15:04"), s(v.Rule)))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s %.0f%% on %s (rule %s)", v.Status, v.Kind, v.Usage, s(v.Mount), s(v.Rule)))
+	}
+
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: dfmon: %d filesystem alert(s) on %s\r\n\r\n%s\r\n\r\n%s\r\n",
+		e.From, strings.Join(e.To, ", "), len(payload.Violations), s(payload.Host),
+		strings.Join(lines, "\r\n"), body)
+
+	auth, err := e.auth()
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(e.SMTP, auth, e.From, e.To, []byte(msg))
+}
+
+// auth is the PLAIN authentication for the server, nil without a
+// username. It is bound to the host alone, without brackets or port, as
+// net/smtp compares it with the name it dialed.
+func (e *EmailConfig) auth() (smtp.Auth, error) {
+	if e.Username == "" {
+		return nil, nil
+	}
+	host, _, err := net.SplitHostPort(e.SMTP)
+	if err != nil {
+		return nil, err
+	}
+	return smtp.PlainAuth("", e.Username, e.Password, host), nil
+}
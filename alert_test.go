@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEmailAuthHost(t *testing.T) {
+	for addr, host := range map[string]string{
+		"smtp.example.com:587": "smtp.example.com",
+		"192.0.2.1:25":         "192.0.2.1",
+		"[::1]:587":            "::1",
+		"[2001:db8::25]:465":   "2001:db8::25",
+	} {
+		e := &EmailConfig{SMTP: addr, Username: "dfmon", Password: "secret"}
+		auth, err := e.auth()
+		if err != nil {
+			t.Errorf("%s: %v", addr, err)
+			continue
+		}
+		// PLAIN refuses to start against any other host than its own.
+		if _, _, err := auth.Start(&smtp.ServerInfo{Name: host, TLS: true}); err != nil {
+			t.Errorf("%s: auth for %s: %v", addr, host, err)
+		}
+	}
+	if auth, err := (&EmailConfig{SMTP: "[::1]:587"}).auth(); auth != nil || err != nil {
+		t.Errorf("without a username: %v, %v", auth, err)
+	}
+	if _, err := (&EmailConfig{SMTP: "::1", Username: "dfmon"}).auth(); err == nil {
+		t.Error("an address without a port was accepted")
+	}
+}
+
+func TestRoute(t *testing.T) {
+	warn := 70.0
+	alerts := AlertConfig{
+		Routes: []Route{
+			{Name: "db", Match: "/var/lib/postgresql*", Sink: "dba", Warn: &warn},
+			{Name: "var", Match: "/var/*", Sink: "platform"},
+			{Match: "/*", Type: "xfs", Sink: "storage"},
+		},
+	}
+	tests := []struct {
+		d          FS
+		name, sink string
+		ok         bool
+	}{
+		// The first matching route wins where patterns overlap.
+		{FS{Mount: "/var/lib/postgresql", Type: "ext4"}, "db", "dba", true},
+		{FS{Mount: "/var/log", Type: "xfs"}, "var", "platform", true},
+		{FS{Mount: "/srv", Type: "xfs"}, "routes[2]", "storage", true},
+		{FS{Mount: "/srv", Type: "ext4"}, "", "", false},
+	}
+	for _, tt := range tests {
+		name, r, ok := alerts.route(tt.d)
+		if name != tt.name || r.Sink != tt.sink || ok != tt.ok {
+			t.Errorf("route(%s %s) = %q, %q, %v; want %q, %q, %v", tt.d.Mount, tt.d.Type, name, r.Sink, ok, tt.name, tt.sink, tt.ok)
+		}
+	}
+
+	alerts.Default = "platform"
+	if name, r, ok := alerts.route(FS{Mount: "/srv", Type: "ext4"}); name != "default" || r.Sink != "platform" || !ok || r.Warn != nil {
+		t.Errorf("no match with a default: %q, %+v, %v", name, r, ok)
+	}
+
+	config := Config{WarnThreshold: 80, CritThreshold: 90}
+	d := FS{Mount: "/var/lib/postgresql", Usage: 75}
+	if got := routeViolations([]FS{d}, config, alerts); len(got["dba"]) != 1 || got["dba"][0].Rule != "db" || got["dba"][0].Warn != 70 {
+		t.Errorf("routeViolations = %+v, want one violation for dba under rule db", got)
+	}
+}
+
+// TestRouteViolationsChecks checks alerts come from the same checks as
+// the status and exit code, with a route's thresholds in place of the
+// block ones.
+func TestRouteViolationsChecks(t *testing.T) {
+	config := Config{WarnThreshold: 80, CritThreshold: 90, InodeWarn: 80, InodeCrit: 95}
+	d := FS{Mount: "/var/lib/containers", Type: "overlay", Usage: 92,
+		UpperDir: "/upper", UpperUsage: 92, OverlayDepth: 1, EffectiveUsage: 85,
+		Inodes: 100, InodeUsage: 96, ErrorsCount: 2,
+		Quota: &Quota{Used: 120, Limit: 100, Hard: 100},
+		Jobs:  []JobFit{{Name: "backup", Fits: false}, {Name: "logs", Fits: true}, {Name: "dump", Fits: false}},
+	}
+	kinds := func(vs []Violation) map[string]string {
+		m := map[string]string{}
+		for _, v := range vs {
+			m[v.Kind] += v.Status + " "
+		}
+		return m
+	}
+
+	got := kinds(routeViolations([]FS{d}, config, AlertConfig{Default: "ops"})["ops"])
+	want := map[string]string{}
+	for _, c := range failedChecks(d, config) {
+		want[c.Kind] += c.Status.String() + " "
+	}
+	want["headroom"] += want["headroom"]
+	want["quota"] = d.Quota.statusName() + " "
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("default route: %v\nchecks: %v", got, want)
+	}
+
+	crit := 99.0
+	routed := kinds(routeViolations([]FS{d}, config, AlertConfig{Routes: []Route{{Match: "/var/lib/*", Sink: "ops", Crit: &crit}}})["ops"])
+	for _, kind := range []string{"usage", "upper"} {
+		if routed[kind] != "warning " {
+			t.Errorf("%s with crit 99: %q", kind, routed[kind])
+		}
+	}
+	if routed["inodes"] != "critical " {
+		t.Errorf("inodes follow the inode limits, not the route: %q", routed["inodes"])
+	}
+}
+
+func TestSuppressOngoing(t *testing.T) {
+	state := filepath.Join(t.TempDir(), "state.json")
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	crit := Violation{Mount: "/", Kind: "usage", Status: "critical", Key: "crit"}
+	resized := Violation{Mount: "/", Kind: "resized", Status: "resized", Key: "resized", Resized: &Resize{Old: 1, New: 2}}
+	send := func(list ...Violation) []Violation {
+		t.Helper()
+		v := map[string][]Violation{"ops": list}
+		if err := suppressRecent(v, state, 0, now); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(time.Minute)
+		return v["ops"]
+	}
+
+	if got := send(crit, resized); len(got) != 2 {
+		t.Fatalf("first interval sent %+v", got)
+	}
+	if got := send(crit, resized); len(got) != 1 || got[0].Key != "resized" {
+		t.Errorf("an ongoing violation was resent: %+v", got)
+	}
+	if got := send(); len(got) != 0 {
+		t.Errorf("sent %+v with nothing wrong", got)
+	}
+	if got := send(crit); len(got) != 1 {
+		t.Errorf("a violation back after clearing was not sent: %+v", got)
+	}
+}
+
+func TestSuppressWindow(t *testing.T) {
+	state := filepath.Join(t.TempDir(), "state.json")
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	crit := Violation{Mount: "/", Kind: "usage", Status: "critical", Key: "crit"}
+	for _, tt := range []struct {
+		after time.Duration
+		sent  bool
+	}{{0, true}, {time.Minute, false}, {time.Hour, true}} {
+		v := map[string][]Violation{"ops": {crit}}
+		if err := suppressRecent(v, state, time.Hour, now.Add(tt.after)); err != nil {
+			t.Fatal(err)
+		}
+		if sent := len(v["ops"]) == 1; sent != tt.sent {
+			t.Errorf("after %v: sent = %v, want %v", tt.after, sent, tt.sent)
+		}
+	}
+}
+
+// TestAlertKeyRenumbered checks that a filesystem keeps its key when its
+// device is renumbered, dm-3 coming back as dm-5 after a reboot.
+func TestAlertKeyRenumbered(t *testing.T) {
+	dev := t.TempDir()
+	os.Mkdir(filepath.Join(dev, "mapper"), 0o755)
+	for _, name := range []string{"dm-3", "dm-5"} {
+		if err := os.WriteFile(filepath.Join(dev, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := filepath.Join(dev, "mapper", "vg-data")
+	boot := func(dm string) string {
+		t.Helper()
+		os.Remove(link)
+		if err := os.Symlink(filepath.Join(dev, dm), link); err != nil {
+			t.Fatal(err)
+		}
+		v := Violation{Mount: "/data", Device: link, Type: "ext4", Kind: "usage", Status: "critical"}
+		return alertKey(v, "db1", map[string]string{resolveDevice(link): "0f3c9a52-5e7b-4c1e-9d0a-8f2b6c4d1e7a"})
+	}
+	if before, after := boot("dm-3"), boot("dm-5"); before != after {
+		t.Errorf("key changed from %s to %s across the renumbering", before, after)
+	}
+
+	// So does one named by its kernel name.
+	v := Violation{Mount: "/data", Device: filepath.Join(dev, "dm-3"), Type: "xfs", Kind: "usage", Status: "warning"}
+	a := alertKey(v, "db1", map[string]string{filepath.Join(dev, "dm-3"): "uuid-1"})
+	v.Device = filepath.Join(dev, "dm-5")
+	if b := alertKey(v, "db2", map[string]string{filepath.Join(dev, "dm-5"): "uuid-1"}); a != b {
+		t.Errorf("one UUID on two hosts has keys %s and %s", a, b)
+	}
+}
+
+func TestAlertKey(t *testing.T) {
+	nfs := Violation{Mount: "/mnt/nas", Device: "nas:/export", Type: "nfs4", Kind: "usage", Status: "critical"}
+	local := Violation{Mount: "/srv", Device: "/dev/nonexistent", Type: "ext4", Kind: "usage", Status: "critical"}
+	with := func(v Violation, f func(*Violation)) Violation {
+		f(&v)
+		return v
+	}
+	tests := []struct {
+		name string
+		a, b Violation
+		ha   string
+		hb   string
+		same bool
+	}{
+		{"export on two hosts", nfs, with(nfs, func(v *Violation) { v.Mount = "/net/nas" }), "web1", "web2", true},
+		{"trailing slash", nfs, with(nfs, func(v *Violation) { v.Device = "nas:/export/" }), "web1", "web2", true},
+		{"other export", nfs, with(nfs, func(v *Violation) { v.Device = "nas:/home" }), "web1", "web1", false},
+		{"state", nfs, with(nfs, func(v *Violation) { v.Status = "warning" }), "web1", "web1", false},
+		{"kind", nfs, with(nfs, func(v *Violation) { v.Kind = "inodes" }), "web1", "web1", false},
+		{"no uuid, same host", local, local, "db1", "db1", true},
+		{"no uuid, two hosts", local, local, "db1", "db2", false},
+		{"group", Violation{Mount: "nas", Kind: "group", Status: "critical", Type: "nfs"}, Violation{Mount: "nas", Kind: "group", Status: "critical", Type: "nfs"}, "db1", "db2", false},
+	}
+	for _, tt := range tests {
+		a, b := alertKey(tt.a, tt.ha, nil), alertKey(tt.b, tt.hb, nil)
+		if (a == b) != tt.same {
+			t.Errorf("%s: keys %s and %s, same = %v, want %v", tt.name, a, b, a == b, tt.same)
+		}
+	}
+}
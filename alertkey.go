@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// alertIdentity names what a violation is about in a way every host
+// agrees on: the server and export of a network filesystem, the UUID of a
+// local one, so that renumbered devices (dm-3 becoming dm-5 after a
+// reboot) keep their key. Anything else is only known by this host.
+func alertIdentity(v Violation, host string, uuids map[string]string) string {
+	if v.Kind != "group" && isNetworkFS(v.Type) {
+		return "net:" + strings.TrimSuffix(v.Device, "/")
+	}
+	if uuid, ok := uuids[resolveDevice(v.Device)]; ok && v.Device != "" {
+		return "uuid:" + uuid
+	}
+	return "host:" + host + ":" + v.Kind + ":" + v.Mount
+}
+
+// alertKey is the idempotency key of v: a hash of its identity, kind and
+// state, so receivers can drop the copies other hosts send.
+func alertKey(v Violation, host string, uuids map[string]string) string {
+	sum := sha256.Sum256([]byte(alertIdentity(v, host, uuids) + "\x00" + v.Kind + "\x00" + v.Status))
+	return hex.EncodeToString(sum[:12])
+}
+
+// suppressRecent drops the violations whose key was already sent, per the
+// state file, and records the send time of the rest. With a window, keys
+// are forgotten once it has passed. Without, as in -watch mode, a key is
+// kept while its violation lasts and forgotten once it clears, so a mount
+// that stays critical is sent once. Resize notices, each about a change
+// of its own, are never suppressed that way.
+func suppressRecent(violations map[string][]Violation, statePath string, window time.Duration, now time.Time) error {
+	return updateState(statePath, func(st *State) { suppressSent(violations, st, window, now) })
+}
+
+func suppressSent(violations map[string][]Violation, st *State, window time.Duration, now time.Time) {
+	oneOff := func(v Violation) bool { return window == 0 && v.Resized != nil }
+	current := map[string]bool{}
+	for _, list := range violations {
+		for _, v := range list {
+			current[v.Key] = !oneOff(v)
+		}
+	}
+	for key, sent := range st.Alerts {
+		if window > 0 && now.Sub(sent) >= window || window == 0 && !current[key] {
+			delete(st.Alerts, key)
+		}
+	}
+	if st.Alerts == nil {
+		st.Alerts = map[string]time.Time{}
+	}
+
+	for sink, list := range violations {
+		var keep []Violation
+		for _, v := range list {
+			if _, ok := st.Alerts[v.Key]; ok && !oneOff(v) {
+				continue
+			}
+			keep = append(keep, v)
+		}
+		if len(keep) == 0 {
+			delete(violations, sink)
+			continue
+		}
+		violations[sink] = keep
+	}
+	for _, list := range violations {
+		for _, v := range list {
+			if !oneOff(v) {
+				st.Alerts[v.Key] = now
+			}
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// AnnotationRule attaches free-form metadata, such as the owning team, to
+// the mounts matching Match. Later rules override earlier ones per key.
+type AnnotationRule struct {
+	Match string            `json:"match"`
+	Set   map[string]string `json:"set"`
+}
+
+// promLabelName is what Prometheus accepts as a label name.
+var promLabelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+func (r AnnotationRule) check(key string) []error {
+	var errs []error
+	if r.Match == "" {
+		errs = append(errs, fmt.Errorf("%s.match: required", key))
+	} else if _, err := filepath.Match(r.Match, ""); err != nil {
+		errs = append(errs, fmt.Errorf("%s.match: bad pattern %q", key, r.Match))
+	}
+	if len(r.Set) == 0 {
+		errs = append(errs, fmt.Errorf("%s.set: no annotations", key))
+	}
+	for k := range r.Set {
+		if k == "" {
+			errs = append(errs, fmt.Errorf("%s.set: empty key", key))
+		}
+	}
+	return errs
+}
+
+func applyAnnotations(list []FS, rules []AnnotationRule) {
+	for i := range list {
+		for _, r := range rules {
+			if ok, _ := filepath.Match(r.Match, list[i].Mount); !ok {
+				continue
+			}
+			if list[i].Annotations == nil {
+				list[i].Annotations = map[string]string{}
+			}
+			for k, v := range r.Set {
+				list[i].Annotations[k] = v
+			}
+		}
+	}
+}
+
+// parseAnnotationLabels splits -annotation-labels, rejecting keys that are
+// not label names or that clash with the built-in labels.
+func parseAnnotationLabels(s string) ([]string, error) {
+	keys := splitList(s)
+	for _, k := range keys {
+		if !promLabelName.MatchString(k) {
+			return nil, fmt.Errorf("%q is not a valid label name", k)
+		}
+		if k == "device" || k == "mountpoint" || k == "fstype" {
+			return nil, fmt.Errorf("%q is a built-in label", k)
+		}
+	}
+	return keys, nil
+}
+
+func annotationsLine(d FS) string {
+	keys := make([]string, 0, len(d.Annotations))
+	for k := range d.Annotations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = sanitize(k) + "=" + sanitize(d.Annotations[k])
+	}
+	return "    annotations: " + strings.Join(parts, " ")
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// Drift is one difference between the current collection and a baseline.
+type Drift struct {
+	Mount    string  `json:"mount"`
+	Device   string  `json:"device"`
+	Kind     string  `json:"kind"`
+	Baseline float64 `json:"baseline,omitempty"`
+	Current  float64 `json:"current,omitempty"`
+}
+
+// loadBaseline reads a file written by -o json. The bare array of older
+// releases and envelopes without a schema are read as they are; the file
+// is left alone since it is the user's, not dfmon's.
+func loadBaseline(path string) ([]FS, error) {
+	b, err := readInput(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []FS
+	if b = bytes.TrimSpace(b); len(b) > 0 && b[0] == '[' {
+		err = json.Unmarshal(b, &list)
+	} else {
+		var r Report
+		err = json.Unmarshal(b, &r)
+		list = r.Filesystems
+		if err == nil && r.Schema > reportSchema {
+			return nil, newerVersion(path, "report schema", r.Schema, reportSchema)
+		}
+		if err == nil && r.Truncated {
+			return nil, fmt.Errorf("%s: written with -top, leaving out %d filesystems", path, r.Hidden)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return list, nil
+}
+
+// compareBaseline keys filesystems on identity, preferring the entry for
+// the same mount among several, then on mount path for baselines written
+// before identities, using the device to pick between several entries for
+// one path. Total changes beyond tolerance percent count as resizes; usage
+// more than maxRise points above the baseline counts as growth.
+func compareBaseline(current, baseline []FS, tolerance, maxRise float64) []Drift {
+	byMount := map[string][]int{}
+	byIdentity := map[string][]int{}
+	for i, b := range baseline {
+		byMount[b.Mount] = append(byMount[b.Mount], i)
+		if b.Identity != "" {
+			byIdentity[b.Identity] = append(byIdentity[b.Identity], i)
+		}
+	}
+
+	matched := make([]bool, len(baseline))
+	var drift []Drift
+	for _, d := range current {
+		i := matchIdentity(d, baseline, byIdentity[d.Identity], matched)
+		if i < 0 {
+			i = matchBaseline(d, baseline, byMount[d.Mount], matched)
+		}
+		if i < 0 {
+			drift = append(drift, Drift{Mount: d.Mount, Device: d.Device, Kind: "new"})
+			continue
+		}
+		matched[i] = true
+		b := baseline[i]
+
+		if b.Total > 0 && math.Abs(float64(d.Total)-float64(b.Total))/float64(b.Total)*100 > tolerance {
+			drift = append(drift, Drift{Mount: d.Mount, Device: d.Device, Kind: "resized",
+				Baseline: float64(b.Total), Current: float64(d.Total)})
+		}
+		if d.Usage-b.Usage > maxRise {
+			drift = append(drift, Drift{Mount: d.Mount, Device: d.Device, Kind: "usage",
+				Baseline: b.Usage, Current: d.Usage})
+		}
+	}
+
+	for i, b := range baseline {
+		if !matched[i] {
+			drift = append(drift, Drift{Mount: b.Mount, Device: b.Device, Kind: "missing"})
+		}
+	}
+	return drift
+}
+
+func matchIdentity(d FS, baseline []FS, candidates []int, matched []bool) int {
+	found := -1
+	for _, i := range candidates {
+		if matched[i] || d.Identity == "" {
+			continue
+		}
+		if baseline[i].Mount == d.Mount {
+			return i
+		}
+		if found < 0 {
+			found = i
+		}
+	}
+	return found
+}
+
+// matchBaseline skips entries whose UUID shows they are another
+// filesystem that used to be mounted at the same path.
+func matchBaseline(d FS, baseline []FS, candidates []int, matched []bool) int {
+	found := -1
+	for _, i := range candidates {
+		if matched[i] || differentUUID(d, baseline[i]) {
+			continue
+		}
+		if baseline[i].Device == d.Device {
+			return i
+		}
+		if found < 0 {
+			found = i
+		}
+	}
+	return found
+}
+
+func printDrift(drift []Drift, humanReadable bool) {
+	fmt.Println()
+	if len(drift) == 0 {
+		fmt.Println(T("drift.none"))
+		return
+	}
+	fmt.Println(T("drift"))
+	for _, d := range drift {
+		d.Mount, d.Device = sanitize(d.Mount), sanitize(d.Device)
+		switch d.Kind {
+		case "resized":
+			fmt.Printf("  %-8s %s: %s -> %s\n", d.Kind, d.Mount,
+				fmtBytes(uint64(d.Baseline), humanReadable), fmtBytes(uint64(d.Current), humanReadable))
+		case "usage":
+			fmt.Printf("  %-8s %s: %.2f%% -> %.2f%%\n", d.Kind, d.Mount, d.Baseline, d.Current)
+		default:
+			fmt.Printf("  %-8s %s (%s)\n", d.Kind, d.Mount, d.Device)
+		}
+	}
+}
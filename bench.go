@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/AScotM/filesystem_cap/fscap/mountinfo"
+)
+
+const (
+	benchBlockSize    = 1 << 20
+	benchAlign        = 4096
+	benchFsyncSamples = 16
+	oDirect           = 0x4000
+)
+
+type benchResult struct {
+	Direct     bool
+	Written    uint64
+	WriteTime  time.Duration
+	ReadTime   time.Duration
+	FsyncTotal time.Duration
+}
+
+// runBench implements "dfmon bench": it measures sequential throughput and
+// fsync latency on a mount with a temporary file that is always removed.
+func runBench(ctx context.Context, config Config, args []string, logger *log.Logger) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	size := fs.String("size", "256M", "Size of the temporary test file")
+	force := fs.Bool("force", false, "Allow read-only and network filesystems")
+	fs.Float64Var(&config.WarnThreshold, "w", config.WarnThreshold, "Warning threshold")
+	fs.Float64Var(&config.CritThreshold, "c", config.CritThreshold, "Critical threshold")
+	fs.BoolVar(&config.HumanReadable, "h", config.HumanReadable, "Human readable sizes")
+	fs.BoolVar(&config.NoColor, "no-color", config.NoColor, "Disable color output")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: dfmon bench [-size SIZE] [-force] MOUNT")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	n, err := parseSize(*size)
+	if err != nil {
+		logger.Fatalf("Invalid -size: %v", err)
+	}
+	n = (n + benchBlockSize - 1) / benchBlockSize * benchBlockSize
+
+	mounts, err := readMounts()
+	if err != nil {
+		logger.Fatalf("Failed to read mounts: %v", err)
+	}
+	m, ok := findMount(mounts, filepath.Clean(fs.Arg(0)))
+	if !ok {
+		logger.Fatalf("Not a mount point: %s", fs.Arg(0))
+	}
+	list := analyze([]mountinfo.Mount{m}, logger, ctx, nil, config)
+	if len(list) == 0 {
+		logger.Fatalf("Cannot get usage for %s", m.MountPoint)
+	}
+	d := list[0]
+
+	if !*force && isReadOnly(d) {
+		logger.Fatalf("Refusing to benchmark read-only filesystem %s (use -force)", d.Mount)
+	}
+	if !*force && isNetworkFS(d.Type) {
+		logger.Fatalf("Refusing to benchmark network filesystem %s (use -force)", d.Mount)
+	}
+	if p := simulate(d, n, config); p.Insufficient || p.After == StatusCritical {
+		logger.Fatalf("Refusing to benchmark %s: writing %s would reach %.2f%% (critical at %.0f%%)",
+			d.Mount, fmtBytes(n, true), p.NewUsage, config.CritThreshold)
+	}
+
+	res, err := bench(ctx, hostPath(d.Mount), n)
+	if err != nil {
+		logger.Fatalf("Benchmark failed: %v", err)
+	}
+
+	printTableRow(d, d.Device, config)
+	mode := "O_DIRECT"
+	if !res.Direct {
+		mode = "buffered"
+	}
+	fmt.Printf("    write %s/s, read %s/s, fsync %.2f ms avg (%s, %s)\n",
+		fmtBytes(rate(res.Written, res.WriteTime), true),
+		fmtBytes(rate(res.Written, res.ReadTime), true),
+		float64(res.FsyncTotal.Microseconds())/1000/benchFsyncSamples,
+		fmtBytes(res.Written, true), mode)
+}
+
+func rate(n uint64, d time.Duration) uint64 {
+	if d <= 0 {
+		return 0
+	}
+	return uint64(float64(n) / d.Seconds())
+}
+
+func bench(ctx context.Context, dir string, size uint64) (benchResult, error) {
+	var res benchResult
+	name := filepath.Join(dir, fmt.Sprintf(".dfmon-bench-%d", os.Getpid()))
+
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL|oDirect, 0600)
+	res.Direct = err == nil
+	if errors.Is(err, syscall.EINVAL) {
+		// The filesystem does not support O_DIRECT.
+		f, err = os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	}
+	if err != nil {
+		return res, err
+	}
+	defer os.Remove(name)
+	defer f.Close()
+
+	buf := alignedBuffer(benchBlockSize)
+	rand.Read(buf)
+
+	start := time.Now()
+	for res.Written < size {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		if _, err := f.Write(buf); err != nil {
+			return res, err
+		}
+		res.Written += benchBlockSize
+	}
+	if err := f.Sync(); err != nil {
+		return res, err
+	}
+	res.WriteTime = time.Since(start)
+
+	if _, err := f.Seek(0, 0); err != nil {
+		return res, err
+	}
+	start = time.Now()
+	for read := uint64(0); read < res.Written; read += benchBlockSize {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		if _, err := f.Read(buf); err != nil {
+			return res, err
+		}
+	}
+	res.ReadTime = time.Since(start)
+
+	small := buf[:benchAlign]
+	for i := 0; i < benchFsyncSamples; i++ {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		if _, err := f.WriteAt(small, int64(i*benchAlign)); err != nil {
+			return res, err
+		}
+		start = time.Now()
+		if err := f.Sync(); err != nil {
+			return res, err
+		}
+		res.FsyncTotal += time.Since(start)
+	}
+	return res, nil
+}
+
+// alignedBuffer returns a buffer whose start is aligned for O_DIRECT.
+func alignedBuffer(size int) []byte {
+	b := make([]byte, size+benchAlign)
+	off := 0
+	if r := int(uintptr(unsafe.Pointer(&b[0])) % benchAlign); r != 0 {
+		off = benchAlign - r
+	}
+	return b[off : off+size]
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// Capability is one feature this binary may or may not be able to use on
+// this host, as found by probing at runtime.
+type Capability struct {
+	Name      string `json:"name"`
+	Supported bool   `json:"supported"`
+	Reason    string `json:"reason"`
+}
+
+// Capabilities is the "dfmon capabilities" and /v1/capabilities document.
+type Capabilities struct {
+	Version      string       `json:"version"`
+	OS           string       `json:"os"`
+	Arch         string       `json:"arch"`
+	Kernel       string       `json:"kernel"`
+	Capabilities []Capability `json:"capabilities"`
+}
+
+func probeCapabilities() Capabilities {
+	c := Capabilities{
+		Version: buildInfo().Version,
+		OS:      runtime.GOOS,
+		Arch:    runtime.GOARCH,
+		Kernel:  kernelRelease(),
+	}
+	probes := []func() Capability{
+		probeLandlock,
+		probeFile("diskstats", "/proc/diskstats", "-io rates"),
+		probeDeleted,
+		probeQuotactl,
+		probeQuotaCommand,
+		probeDmsetup,
+		probeBtrfs,
+		probeFile("journald", "/run/systemd/journal/socket", "journal socket"),
+		probeNotify,
+	}
+	for _, p := range probes {
+		c.Capabilities = append(c.Capabilities, p())
+	}
+	for _, col := range collectors {
+		c.Capabilities = append(c.Capabilities, Capability{Name: "collector." + col.Name(), Supported: true, Reason: "built in"})
+	}
+	return c
+}
+
+func probeLandlock() Capability {
+	if abi := landlockABI(); abi > 0 {
+		return Capability{"landlock", true, fmt.Sprintf("ABI %d; -sandbox restricts the process", abi)}
+	}
+	return Capability{"landlock", false, "landlock_create_ruleset unavailable (Linux 5.13+ with landlock enabled)"}
+}
+
+func probeFile(name, path, use string) func() Capability {
+	return func() Capability {
+		if _, err := os.Stat(path); err != nil {
+			return Capability{name, false, err.Error()}
+		}
+		return Capability{name, true, path + " present (" + use + ")"}
+	}
+}
+
+func probeDeleted() Capability {
+	if _, err := os.ReadDir("/proc/self/fd"); err != nil {
+		return Capability{"deleted-space", false, err.Error()}
+	}
+	if os.Geteuid() != 0 {
+		return Capability{"deleted-space", true, "own processes only; other users' need root"}
+	}
+	return Capability{"deleted-space", true, "all processes"}
+}
+
+// probeQuotactl calls quotactl with a null device: EFAULT or ENOENT mean
+// the call exists, ENOSYS that the kernel was built without quota support.
+func probeQuotactl() Capability {
+	const qGetInfo = 0x800005
+	_, _, errno := syscall.Syscall6(syscall.SYS_QUOTACTL, qGetInfo<<8, 0, 0, 0, 0, 0)
+	if errno == syscall.ENOSYS {
+		return Capability{"quotactl", false, "kernel built without quota support"}
+	}
+	return Capability{"quotactl", true, "syscall available"}
+}
+
+func probeQuotaCommand() Capability {
+	path, err := exec.LookPath("quota")
+	if err != nil {
+		return Capability{"nfs-quota.fallback", false, "quota(1) not in PATH; -nfs-quota uses rquotad RPC only"}
+	}
+	return Capability{"nfs-quota.fallback", true, path}
+}
+
+func probeDmsetup() Capability {
+	path, err := exec.LookPath("dmsetup")
+	if err != nil {
+		return Capability{"thin", false, "dmsetup(8) not in PATH"}
+	}
+	return Capability{"thin", true, path}
+}
+
+func probeBtrfs() Capability {
+	b, err := os.ReadFile("/proc/filesystems")
+	if err != nil {
+		return Capability{"btrfs", false, err.Error()}
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.TrimSpace(strings.TrimPrefix(line, "nodev")) == "btrfs" {
+			return Capability{"btrfs", true, "btrfs registered; subvolumes shown as subtrees"}
+		}
+	}
+	return Capability{"btrfs", false, "btrfs not in /proc/filesystems"}
+}
+
+func probeNotify() Capability {
+	if sock := os.Getenv("NOTIFY_SOCKET"); sock != "" {
+		return Capability{"sd-notify", true, "NOTIFY_SOCKET=" + sock}
+	}
+	return Capability{"sd-notify", false, "NOTIFY_SOCKET not set (not run by systemd with Type=notify)"}
+}
+
+func writeCapabilities(w io.Writer, c Capabilities, format string) {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(c)
+		return
+	}
+	fmt.Fprintf(w, "dfmon %s on %s/%s, kernel %s\n", c.Version, c.OS, c.Arch, c.Kernel)
+	for _, cp := range c.Capabilities {
+		mark := "no "
+		if cp.Supported {
+			mark = "yes"
+		}
+		fmt.Fprintf(w, "%-20s %s  %s\n", cp.Name, mark, sanitize(cp.Reason))
+	}
+}
+
+// runCapabilities implements "dfmon capabilities".
+func runCapabilities(ctx context.Context, config Config, args []string, logger *log.Logger) {
+	fs := flag.NewFlagSet("capabilities", flag.ExitOnError)
+	format := fs.String("o", "table", "Output format (table, json)")
+	fs.Parse(args)
+	if *format != "table" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "dfmon: invalid -o: %q (table, json)\n", *format)
+		os.Exit(2)
+	}
+	writeCapabilities(os.Stdout, probeCapabilities(), *format)
+}
+
+func serveCapabilities(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	writeCapabilities(w, probeCapabilities(), "json")
+}
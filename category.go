@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// The categories -group-display category sections the table by, in the
+// order they are printed. Types no rule or built-in class covers go in
+// categoryOther, after any category the config adds.
+const (
+	categoryLocal   = "Local"
+	categoryNetwork = "Network"
+	categoryMemory  = "Memory"
+	categoryVirtual = "Virtual/Container"
+	categoryOther   = "Other"
+)
+
+var categoryOrder = []string{categoryLocal, categoryNetwork, categoryMemory, categoryVirtual}
+
+var localTypes = map[string]bool{
+	"ext2": true, "ext3": true, "ext4": true, "xfs": true, "btrfs": true,
+	"zfs": true, "f2fs": true, "jfs": true, "reiserfs": true, "bcachefs": true,
+	"nilfs2": true, "vfat": true, "exfat": true, "ntfs": true, "ntfs3": true,
+	"hfsplus": true, "apfs": true, "iso9660": true, "udf": true, "fuseblk": true,
+}
+
+var memoryTypes = map[string]bool{
+	"tmpfs": true, "ramfs": true, "devtmpfs": true, "hugetlbfs": true,
+}
+
+var virtualTypes = map[string]bool{
+	"overlay": true, "aufs": true, "shiftfs": true, "fuse.fuse-overlayfs": true,
+	"squashfs": true, "proc": true, "sysfs": true, "cgroup": true, "cgroup2": true,
+	"devpts": true, "mqueue": true, "nsfs": true, "autofs": true,
+}
+
+// categoryOf classifies the filesystem type t, the config's categories
+// mapping taking precedence over the built-in classes.
+func categoryOf(t string, mapping map[string]string) string {
+	if c, ok := mapping[t]; ok {
+		return c
+	}
+	switch {
+	case localTypes[t]:
+		return categoryLocal
+	case isNetworkFS(t):
+		return categoryNetwork
+	case memoryTypes[t]:
+		return categoryMemory
+	case virtualTypes[t]:
+		return categoryVirtual
+	}
+	return categoryOther
+}
+
+func applyCategories(list []FS, mapping map[string]string) {
+	for i := range list {
+		list[i].Category = categoryOf(list[i].Type, mapping)
+	}
+}
+
+func checkCategories(path string, mapping map[string]string) []error {
+	var errs []error
+	for _, t := range sortedKeys(mapping) {
+		if mapping[t] == "" {
+			errs = append(errs, fmt.Errorf("%s: categories.%s: empty category", path, t))
+		}
+	}
+	return errs
+}
+
+// categorySection is one category's rows, in the order of the report.
+type categorySection struct {
+	Name        string
+	Filesystems []FS
+}
+
+// sections partitions list by category, so the report's sort order holds
+// within each. The built-in categories come first, then those the config
+// adds by name, then categoryOther; empty ones are left out.
+func sections(list []FS) []categorySection {
+	byName := map[string][]FS{}
+	for _, d := range list {
+		byName[d.Category] = append(byName[d.Category], d)
+	}
+	var names []string
+	for _, name := range categoryOrder {
+		if _, ok := byName[name]; ok {
+			names = append(names, name)
+		}
+	}
+	var added []string
+	for name := range byName {
+		if !slices.Contains(categoryOrder, name) && name != categoryOther {
+			added = append(added, name)
+		}
+	}
+	sort.Strings(added)
+	names = append(names, added...)
+	if _, ok := byName[categoryOther]; ok {
+		names = append(names, categoryOther)
+	}
+
+	out := make([]categorySection, len(names))
+	for i, name := range names {
+		out[i] = categorySection{name, byName[name]}
+	}
+	return out
+}
+
+// displayCategories is displayTable with a header and a subtotal for each
+// category. Under -top the subtotals are of the rows shown.
+func displayCategories(list []FS, config Config) {
+	printTableHeader()
+	shown, hidden := topN(list, config.Top)
+	for i, s := range sections(shown) {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("== %s ==\n", sanitize(s.Name))
+		for _, d := range s.Filesystems {
+			printTableRows(d, config)
+		}
+		if !config.NoSummary {
+			fmt.Println("  " + summarize(s.Filesystems, config).String(config.HumanReadable))
+		}
+	}
+	if len(hidden) > 0 {
+		fmt.Println()
+		fmt.Println(remainderLine(hidden))
+	}
+
+	if !config.NoSummary {
+		fmt.Println()
+		fmt.Println(summarize(list, config).String(config.HumanReadable))
+	}
+}
+
+// CategoryReport is a category's entry in the JSON output of
+// -group-display category.
+type CategoryReport struct {
+	Filesystems []FS     `json:"filesystems"`
+	Summary     *Summary `json:"summary,omitempty"`
+}
+
+// encodeCategories writes the JSON envelope of report with its
+// filesystems nested under their categories instead of listed flat.
+func encodeCategories(enc *json.Encoder, report Report, config Config) error {
+	categories := map[string]CategoryReport{}
+	for _, s := range sections(report.Filesystems) {
+		c := CategoryReport{Filesystems: s.Filesystems}
+		if !config.NoSummary {
+			summary := summarize(s.Filesystems, config)
+			c.Summary = &summary
+		}
+		categories[s.Name] = c
+	}
+	// The outer Filesystems hides the envelope's, leaving it out.
+	return enc.Encode(struct {
+		Report
+		Filesystems []FS                      `json:"filesystems,omitempty"`
+		Categories  map[string]CategoryReport `json:"categories"`
+	}{Report: report, Categories: categories})
+}
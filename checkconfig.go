@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// runCheckConfig implements "dfmon check-config": it reports every problem
+// in the config file and shows which rules match the mounted filesystems.
+// With -config-dir it also shows the merged config and the file each
+// setting came from. It exits 1 if any problem was found.
+func runCheckConfig(ctx context.Context, config Config, args []string, logger *log.Logger) {
+	flags := flag.NewFlagSet("check-config", flag.ExitOnError)
+	probe := flags.Bool("probe-sinks", false, "Also try connecting to each webhook and SMTP server")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: dfmon [-config FILE] [-config-dir DIR] check-config [-probe-sinks]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	parts, err := readConfigParts(config.ConfigPath, config.ConfigDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if len(parts) == 0 {
+		fmt.Println("No config file found; using defaults")
+	}
+	for _, p := range parts {
+		fmt.Println("Config:", p.path)
+	}
+	fc, sources := mergeConfig(parts)
+
+	problems := checkParts(parts, fc)
+	problems = append(problems, checkPartsEffective(parts, config)...)
+	if *probe {
+		for name, s := range fc.Alerts.Sinks {
+			if err := s.probe(ctx); err != nil {
+				problems = append(problems, fmt.Errorf("%s: alerts.sinks.%s: %v", sourceOf(sources, "alerts.sinks."+name), name, err))
+			}
+		}
+	}
+	if config.ConfigDir != "" {
+		if err := displayMerged(fc, sources); err != nil {
+			logger.Fatalf("Failed to show the merged config: %v", err)
+		}
+		fmt.Println()
+	}
+
+	// Without live collection there are no mounts to match rules against;
+	// the file itself is still checked.
+	if liveCollection {
+		mounts, err := readMounts()
+		if err != nil {
+			logger.Fatalf("Failed to read mounts: %v", err)
+		}
+		filtered := filterFuse(filterMounts(mounts, config.ExcludeTypes, nil), config.Fuse, nil)
+		list := analyze(filtered, logger, ctx, nil, config)
+		displayRuleMatches(list, fc, config)
+	}
+
+	if len(problems) > 0 {
+		fmt.Println()
+		for _, p := range problems {
+			fmt.Fprintln(os.Stderr, "error:", p)
+		}
+		os.Exit(1)
+	}
+	fmt.Println()
+	fmt.Println("Config OK")
+}
+
+// displayRuleMatches lists, per filesystem, the rules that apply to it now.
+func displayRuleMatches(list []FS, fc FileConfig, config Config) {
+	now := time.Now().In(config.Location)
+	fmt.Printf("%s %s\n", fitCell("Mount", 25), "Rules")
+	for _, d := range list {
+		var rules []string
+		for i, r := range fc.Ignore {
+			if r.matches(d) {
+				rules = append(rules, fmt.Sprintf("ignore[%d]", i))
+			}
+		}
+		for i, r := range fc.Thresholds {
+			if r.matches(d, now) {
+				name := r.Name
+				if name == "" {
+					name = fmt.Sprintf("thresholds[%d]", i)
+				}
+				rules = append(rules, name)
+			}
+		}
+		for _, j := range fc.Jobs {
+			if ok, _ := filepath.Match(j.Match, d.Mount); ok {
+				rules = append(rules, "job:"+j.Name)
+			}
+		}
+		for i, r := range fc.UsageBasis {
+			if ok, _ := filepath.Match(r.Match, d.Mount); ok {
+				rules = append(rules, fmt.Sprintf("usage_basis[%d]:%s", i, r.Basis))
+			}
+		}
+		if name, r, ok := fc.Alerts.route(d); ok {
+			rules = append(rules, "alerts:"+name+"->"+r.Sink)
+		}
+		for _, g := range buildGroups([]FS{d}, fc.Groups, config) {
+			if len(g.Mounts) > 0 {
+				rules = append(rules, "group:"+g.Name)
+			}
+		}
+		if len(rules) == 0 {
+			rules = []string{"-"}
+		}
+		fmt.Printf("%s %s\n", fitCell(sanitize(d.Mount), 25), strings.Join(rules, ", "))
+	}
+}
+
+// probe checks that the sink's server accepts TCP connections.
+func (s SinkConfig) probe(ctx context.Context) error {
+	addr := ""
+	if s.Webhook != "" {
+		u, err := url.Parse(s.Webhook)
+		if err != nil {
+			return err
+		}
+		addr = u.Host
+		if u.Port() == "" {
+			port := "80"
+			if u.Scheme == "https" {
+				port = "443"
+			}
+			addr = net.JoinHostPort(u.Hostname(), port)
+		}
+	} else if s.Email != nil {
+		addr = s.Email.SMTP
+	}
+
+	var d net.Dialer
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/AScotM/filesystem_cap/fscap/mountinfo"
+)
+
+// collectorTimeout bounds each enricher per mount, unless it has a
+// Timeout method of its own.
+const collectorTimeout = 2 * time.Second
+
+// Collector adds filesystem- or platform-specific detail to an entry after
+// the base statfs collection. Enrich works on its own copy of the entry,
+// which is kept only if it returns in time without error.
+type Collector interface {
+	Name() string
+	Applies(m mountinfo.Mount) bool
+	Enrich(ctx context.Context, m mountinfo.Mount, d *FS) error
+}
+
+// collectors is the registry, in the order enrichers run.
+var collectors = []Collector{
+	fuseOwnerCollector{},
+	tmpfsCapCollector{},
+	subtreeCollector{},
+	nfsQuotaCollector{},
+	localQuotaCollector{},
+	overlayUpperCollector{},
+	overlayChainCollector{},
+	activityCollector{},
+	fsErrorsCollector{},
+	fatRootCollector{},
+	zfsSnapshotCollector{},
+	btrfsSnapshotCollector{},
+}
+
+// enabledCollectors returns the registered collectors minus those named in
+// disable, a comma-separated list.
+func enabledCollectors(disable string, config Config) ([]Collector, error) {
+	off := map[string]bool{}
+	for _, name := range strings.Split(disable, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			off[name] = true
+		}
+	}
+	if config.RawStatfs {
+		off["tmpfs"] = true
+	}
+	if !config.NFSQuota {
+		off["nfsquota"] = true
+	}
+	if !config.LocalQuota {
+		off["quota"] = true
+	}
+	if config.StaleDays == 0 {
+		off["activity"] = true
+	}
+	if !config.FATCheck {
+		off["fatroot"] = true
+	}
+	if !config.Snapshots {
+		off["zfssnap"], off["btrfssnap"] = true, true
+	}
+	if config.InContainer {
+		// A container often has no /sys, or one showing the host's
+		// devices rather than its volumes.
+		off["fserrors"] = true
+	}
+
+	var out []Collector
+	known := map[string]bool{}
+	for _, c := range collectors {
+		known[c.Name()] = true
+		if a, ok := c.(activityCollector); ok {
+			a.network = config.Probe
+			c = a
+		}
+		if !off[c.Name()] {
+			out = append(out, c)
+		}
+	}
+	for name := range off {
+		if !known[name] {
+			return nil, fmt.Errorf("unknown collector %q", name)
+		}
+	}
+	return out, nil
+}
+
+// enrich runs the applicable collectors on d, recording failures in
+// d.Errors instead of dropping the entry.
+func enrich(ctx context.Context, m mountinfo.Mount, d *FS, enabled []Collector) {
+	for _, c := range enabled {
+		if !c.Applies(m) {
+			continue
+		}
+		timeout := collectorTimeout
+		if t, ok := c.(interface{ Timeout() time.Duration }); ok {
+			timeout = t.Timeout()
+		}
+		cctx, cancel := context.WithTimeout(ctx, timeout)
+		done := make(chan error, 1)
+		work := *d
+		go func() { done <- c.Enrich(cctx, m, &work) }()
+
+		select {
+		case err := <-done:
+			if err != nil {
+				d.Errors = append(d.Errors, c.Name()+": "+err.Error())
+			} else {
+				*d = work
+			}
+		case <-cctx.Done():
+			stats.timeouts.Add(1)
+			d.Errors = append(d.Errors, c.Name()+": "+cctx.Err().Error())
+		}
+		cancel()
+	}
+}
+
+type fuseOwnerCollector struct{}
+
+func (fuseOwnerCollector) Name() string                   { return "fuse" }
+func (fuseOwnerCollector) Applies(m mountinfo.Mount) bool { return isFuse(m.FSType) }
+func (fuseOwnerCollector) Enrich(ctx context.Context, m mountinfo.Mount, d *FS) error {
+	d.Owner = fuseOwner(m)
+	return nil
+}
+
+type tmpfsCapCollector struct{}
+
+func (tmpfsCapCollector) Name() string                   { return "tmpfs" }
+func (tmpfsCapCollector) Applies(m mountinfo.Mount) bool { return m.FSType == "tmpfs" }
+func (tmpfsCapCollector) Enrich(ctx context.Context, m mountinfo.Mount, d *FS) error {
+	applyTmpfsCap(d, m.SuperOptions)
+	return nil
+}
+
+type subtreeCollector struct{}
+
+func (subtreeCollector) Name() string                   { return "subtree" }
+func (subtreeCollector) Applies(m mountinfo.Mount) bool { return m.Root != "/" }
+func (subtreeCollector) Enrich(ctx context.Context, m mountinfo.Mount, d *FS) error {
+	d.Subtree = m.Root
+	if sv := mountOption(m.SuperOptions, "subvol"); m.FSType == "btrfs" && sv != "" {
+		d.Subtree = "subvol=" + sv
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AScotM/filesystem_cap/fscap/mountinfo"
+)
+
+func collectorNames(list []Collector) string {
+	var names []string
+	for _, c := range list {
+		names = append(names, c.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+func TestEnabledCollectors(t *testing.T) {
+	tests := []struct {
+		name    string
+		disable string
+		config  Config
+		want    string
+	}{
+		{"defaults", "", Config{}, "fuse,tmpfs,subtree,overlay,overlaychain,fserrors"},
+		{"all on", "", Config{NFSQuota: true, LocalQuota: true, StaleDays: 30, FATCheck: true, Snapshots: true},
+			"fuse,tmpfs,subtree,nfsquota,quota,overlay,overlaychain,activity,fserrors,fatroot,zfssnap,btrfssnap"},
+		{"disabled", " fuse, overlaychain ,", Config{}, "tmpfs,subtree,overlay,fserrors"},
+		{"raw statfs", "", Config{RawStatfs: true}, "fuse,subtree,overlay,overlaychain,fserrors"},
+		{"container", "", Config{InContainer: true}, "fuse,tmpfs,subtree,overlay,overlaychain"},
+	}
+	for _, tt := range tests {
+		got, err := enabledCollectors(tt.disable, tt.config)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if names := collectorNames(got); names != tt.want {
+			t.Errorf("%s: collectors = %s, want %s", tt.name, names, tt.want)
+		}
+	}
+
+	if _, err := enabledCollectors("fuse,nosuch", Config{}); err == nil || !strings.Contains(err.Error(), `"nosuch"`) {
+		t.Errorf("unknown collector: err = %v", err)
+	}
+
+	// -probe lets the activity collector look at network mounts.
+	nfs := mountinfo.Mount{FSType: "nfs4"}
+	for _, probe := range []bool{false, true} {
+		got, _ := enabledCollectors("", Config{StaleDays: 30, Probe: probe})
+		for _, c := range got {
+			if c.Name() == "activity" && c.Applies(nfs) != probe {
+				t.Errorf("probe %v: activity applies to nfs4 = %v", probe, !probe)
+			}
+		}
+	}
+}
+
+// fakeCollector is a Collector whose Enrich runs fn.
+type fakeCollector struct {
+	name    string
+	applies bool
+	timeout time.Duration
+	fn      func(ctx context.Context, d *FS) error
+}
+
+func (c fakeCollector) Name() string                   { return c.name }
+func (c fakeCollector) Applies(m mountinfo.Mount) bool { return c.applies }
+func (c fakeCollector) Timeout() time.Duration         { return c.timeout }
+func (c fakeCollector) Enrich(ctx context.Context, m mountinfo.Mount, d *FS) error {
+	return c.fn(ctx, d)
+}
+
+func TestEnrich(t *testing.T) {
+	owner := func(name string) func(context.Context, *FS) error {
+		return func(ctx context.Context, d *FS) error {
+			d.Owner = name
+			return nil
+		}
+	}
+	enabled := []Collector{
+		fakeCollector{name: "first", applies: true, timeout: time.Second, fn: owner("alice")},
+		fakeCollector{name: "skipped", applies: false, timeout: time.Second, fn: owner("mallory")},
+		// A failing collector's partial changes are dropped.
+		fakeCollector{name: "failing", applies: true, timeout: time.Second, fn: func(ctx context.Context, d *FS) error {
+			d.Owner = "half-done"
+			return errors.New("no such attribute")
+		}},
+		// So are a slow one's, and the rest still run.
+		fakeCollector{name: "slow", applies: true, timeout: 10 * time.Millisecond, fn: func(ctx context.Context, d *FS) error {
+			<-ctx.Done()
+			time.Sleep(10 * time.Millisecond)
+			d.Owner = "late"
+			return nil
+		}},
+		fakeCollector{name: "last", applies: true, timeout: time.Second, fn: func(ctx context.Context, d *FS) error {
+			d.Subtree = "/sub"
+			return nil
+		}},
+	}
+
+	d := FS{Mount: "/data"}
+	before := stats.timeouts.Load()
+	enrich(context.Background(), mountinfo.Mount{MountPoint: "/data"}, &d, enabled)
+
+	if d.Owner != "alice" || d.Subtree != "/sub" {
+		t.Errorf("owner %q, subtree %q; want alice and /sub", d.Owner, d.Subtree)
+	}
+	want := []string{"failing: no such attribute", "slow: context deadline exceeded"}
+	if strings.Join(d.Errors, "\n") != strings.Join(want, "\n") {
+		t.Errorf("errors = %q, want %q", d.Errors, want)
+	}
+	if n := stats.timeouts.Load() - before; n != 1 {
+		t.Errorf("%d timeouts counted, want 1", n)
+	}
+}
+
+func TestCollectorApplies(t *testing.T) {
+	tests := []struct {
+		c     Collector
+		m     mountinfo.Mount
+		wants bool
+	}{
+		{fuseOwnerCollector{}, mountinfo.Mount{FSType: "fuse.sshfs"}, true},
+		{fuseOwnerCollector{}, mountinfo.Mount{FSType: "fuseblk"}, true},
+		{fuseOwnerCollector{}, mountinfo.Mount{FSType: "ext4"}, false},
+		{tmpfsCapCollector{}, mountinfo.Mount{FSType: "tmpfs"}, true},
+		{tmpfsCapCollector{}, mountinfo.Mount{FSType: "devtmpfs"}, false},
+		{subtreeCollector{}, mountinfo.Mount{Root: "/"}, false},
+		{subtreeCollector{}, mountinfo.Mount{Root: "/home"}, true},
+		{overlayUpperCollector{}, mountinfo.Mount{FSType: "overlay"}, true},
+		{overlayChainCollector{}, mountinfo.Mount{FSType: "ext4"}, false},
+		{activityCollector{}, mountinfo.Mount{FSType: "ext4"}, true},
+		{activityCollector{}, mountinfo.Mount{FSType: "nfs"}, false},
+		{activityCollector{}, mountinfo.Mount{FSType: "cifs"}, false},
+		{activityCollector{network: true}, mountinfo.Mount{FSType: "nfs"}, true},
+		{fsErrorsCollector{}, mountinfo.Mount{FSType: "ext4"}, true},
+		{fsErrorsCollector{}, mountinfo.Mount{FSType: "xfs"}, false},
+		{fatRootCollector{}, mountinfo.Mount{FSType: "vfat"}, true},
+		{fatRootCollector{}, mountinfo.Mount{FSType: "exfat"}, false},
+		{localQuotaCollector{}, mountinfo.Mount{FSType: "tmpfs"}, false},
+		{zfsSnapshotCollector{}, mountinfo.Mount{FSType: "zfs"}, true},
+		{btrfsSnapshotCollector{}, mountinfo.Mount{FSType: "zfs"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.c.Applies(tt.m); got != tt.wants {
+			t.Errorf("%s applies to %+v = %v, want %v", tt.c.Name(), tt.m, got, tt.wants)
+		}
+	}
+}
+
+func TestSubtreeCollector(t *testing.T) {
+	tests := []struct {
+		m    mountinfo.Mount
+		want string
+	}{
+		{mountinfo.Mount{FSType: "ext4", Root: "/srv/data"}, "/srv/data"},
+		{mountinfo.Mount{FSType: "btrfs", Root: "/@home", SuperOptions: "rw,subvolid=257,subvol=/@home"}, "subvol=/@home"},
+		{mountinfo.Mount{FSType: "btrfs", Root: "/@home", SuperOptions: "rw"}, "/@home"},
+	}
+	for _, tt := range tests {
+		var d FS
+		if err := (subtreeCollector{}).Enrich(context.Background(), tt.m, &d); err != nil {
+			t.Fatal(err)
+		}
+		if d.Subtree != tt.want {
+			t.Errorf("%+v: subtree %q, want %q", tt.m, d.Subtree, tt.want)
+		}
+	}
+}
+
+func TestFuseOwnerCollector(t *testing.T) {
+	var d FS
+	m := mountinfo.Mount{FSType: "fuse.sshfs", SuperOptions: "rw,user_id=4294967294,group_id=1000"}
+	if err := (fuseOwnerCollector{}).Enrich(context.Background(), m, &d); err != nil {
+		t.Fatal(err)
+	}
+	// No user has that uid, so the number stands in for the name.
+	if d.Owner != "4294967294" {
+		t.Errorf("owner = %q", d.Owner)
+	}
+
+	d = FS{}
+	m.SuperOptions = "rw"
+	(fuseOwnerCollector{}).Enrich(context.Background(), m, &d)
+	if d.Owner != "" {
+		t.Errorf("owner without user_id = %q", d.Owner)
+	}
+}
+
+func TestActivityCollector(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	recent := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for name, mtime := range map[string]time.Time{"old": old, "recent": recent} {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(p, mtime, mtime)
+	}
+	os.Chtimes(dir, old, old)
+
+	var d FS
+	if err := (activityCollector{}).Enrich(context.Background(), mountinfo.Mount{MountPoint: dir}, &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.LastActivity == nil || !d.LastActivity.Equal(recent) {
+		t.Errorf("last activity %v, want %v", d.LastActivity, recent)
+	}
+
+	// A mount point gone since the table was read is a failure.
+	d = FS{}
+	err := (activityCollector{}).Enrich(context.Background(), mountinfo.Mount{MountPoint: filepath.Join(dir, "gone")}, &d)
+	if err == nil || d.LastActivity != nil {
+		t.Errorf("missing mount point: err %v, last activity %v", err, d.LastActivity)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// csvColumns maps each -columns token to its value. Tokens ending in _h
+// are the human-readable form of the raw byte column of the same name.
+var csvColumns = map[string]func(d FS, config Config) string{
+	"device":       func(d FS, _ Config) string { return csvField(d.Device) },
+	"mount":        func(d FS, _ Config) string { return csvField(d.Mount) },
+	"type":         func(d FS, _ Config) string { return csvField(d.Type) },
+	"category":     func(d FS, _ Config) string { return csvField(d.Category) },
+	"total":        func(d FS, _ Config) string { return strconv.FormatUint(d.Total, 10) },
+	"used":         func(d FS, _ Config) string { return strconv.FormatUint(d.Used, 10) },
+	"free":         func(d FS, _ Config) string { return strconv.FormatUint(d.Free, 10) },
+	"total_h":      func(d FS, c Config) string { return fmtBytes(d.Total, c.HumanReadable) },
+	"used_h":       func(d FS, c Config) string { return fmtBytes(d.Used, c.HumanReadable) },
+	"free_h":       func(d FS, c Config) string { return fmtBytes(d.Free, c.HumanReadable) },
+	"usage":        func(d FS, _ Config) string { return fmt.Sprintf("%.2f", d.Usage) },
+	"usage_basis":  func(d FS, _ Config) string { return usageBasis(d) },
+	"inodes":       func(d FS, _ Config) string { return inodeColumn(d, strconv.FormatUint(d.Inodes, 10)) },
+	"inodes_free":  func(d FS, _ Config) string { return inodeColumn(d, strconv.FormatUint(d.InodesFree, 10)) },
+	"inode_usage":  func(d FS, _ Config) string { return inodeColumn(d, fmt.Sprintf("%.2f", d.InodeUsage)) },
+	"inode_state":  func(d FS, _ Config) string { return d.InodeState },
+	"options":      func(d FS, _ Config) string { return csvQuote(d.Options) },
+	"flags":        func(d FS, _ Config) string { return csvQuote(strings.Join(d.Flags, "|")) },
+	"fsid":         func(d FS, _ Config) string { return d.Fsid },
+	"identity":     func(d FS, _ Config) string { return csvField(d.Identity) },
+	"dev":          func(d FS, _ Config) string { return d.Dev },
+	"status":       func(d FS, c Config) string { return fsStatus(d, c).String() },
+	"block_size":   func(d FS, _ Config) string { return strconv.FormatUint(d.BlockSize, 10) },
+	"total_blocks": func(d FS, _ Config) string { return strconv.FormatUint(d.TotalBlocks, 10) },
+	"used_blocks":  func(d FS, _ Config) string { return strconv.FormatUint(d.UsedBlocks, 10) },
+	"free_blocks":  func(d FS, _ Config) string { return strconv.FormatUint(d.FreeBlocks, 10) },
+	"avail_blocks": func(d FS, _ Config) string { return strconv.FormatUint(d.AvailBlocks, 10) },
+	"errors_count": func(d FS, _ Config) string { return strconv.FormatUint(d.ErrorsCount, 10) },
+	"last_activity": func(d FS, _ Config) string {
+		if d.LastActivity == nil {
+			return ""
+		}
+		return d.LastActivity.UTC().Format(time.RFC3339)
+	},
+	"overlay_depth":   func(d FS, _ Config) string { return strconv.Itoa(d.OverlayDepth) },
+	"effective_usage": func(d FS, _ Config) string { return fmt.Sprintf("%.2f", d.EffectiveUsage) },
+	"snapshot_used":   func(d FS, _ Config) string { return strconv.FormatUint(d.SnapshotUsed, 10) },
+}
+
+// inodeColumn is v, or empty when d has no inode accounting to show
+// rather than a zero.
+func inodeColumn(d FS, v string) string {
+	if d.Inodes == 0 {
+		return ""
+	}
+	return v
+}
+
+// parseColumns splits a -columns list, rejecting unknown tokens.
+func parseColumns(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var cols []string
+	for _, c := range strings.Split(s, ",") {
+		c = strings.TrimSpace(c)
+		if _, ok := csvColumns[c]; !ok {
+			var known []string
+			for k := range csvColumns {
+				known = append(known, k)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("unknown column %q (known: %s)", c, strings.Join(known, ","))
+		}
+		cols = append(cols, c)
+	}
+	return cols, nil
+}
+
+// printColumnsRow writes d as the requested columns; the header is the
+// token list itself.
+func printColumnsRow(w io.Writer, d FS, config Config) {
+	for i, c := range config.Columns {
+		if i > 0 {
+			fmt.Fprint(w, ",")
+		}
+		fmt.Fprint(w, csvColumns[c](d, config))
+	}
+	fmt.Fprintln(w)
+}
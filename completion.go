@@ -0,0 +1,132 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// subcommands are the commands dfmon takes after its flags.
+var subcommands = []string{"path", "plan", "bench", "tmp-usage", "events", "history", "capabilities", "check-config", "completion"}
+
+// runCompletion implements "dfmon completion": it prints a completion
+// script for the shell, generated from the flag registry.
+func runCompletion(args []string) {
+	flags := flag.NewFlagSet("completion", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: dfmon completion bash|zsh|fish")
+		fmt.Fprintln(flags.Output(), "  e.g. source <(dfmon completion bash)")
+	}
+	flags.Parse(args)
+	write := map[string]func(io.Writer, *flag.FlagSet){"bash": bashCompletion, "zsh": zshCompletion, "fish": fishCompletion}[flags.Arg(0)]
+	if flags.NArg() != 1 || write == nil {
+		flags.Usage()
+		os.Exit(2)
+	}
+	write(os.Stdout, flag.CommandLine)
+}
+
+// completionFlag is a top-level flag as the scripts offer it.
+type completionFlag struct {
+	Name, Long, Usage string
+	Value             bool
+	Complete          []string
+}
+
+func completionFlags(fs *flag.FlagSet) []completionFlag {
+	var out []completionFlag
+	fs.VisitAll(func(f *flag.Flag) {
+		if isLongFlag(f.Name) {
+			return
+		}
+		b, isBool := f.Value.(boolFlag)
+		usage, _, _ := strings.Cut(f.Usage, "\n")
+		info := flagRegistry[f.Name]
+		out = append(out, completionFlag{f.Name, info.Long, usage, !isBool || !b.IsBoolFlag(), info.Complete})
+	})
+	return out
+}
+
+func (f completionFlag) spellings() []string {
+	s := []string{"-" + f.Name}
+	if f.Long != "" {
+		s = append(s, "--"+f.Long)
+	}
+	return s
+}
+
+func bashCompletion(w io.Writer, fs *flag.FlagSet) {
+	flags := completionFlags(fs)
+	var words []string
+	cases := map[string][]string{}
+	for _, f := range flags {
+		words = append(words, f.spellings()...)
+		if len(f.Complete) == 0 {
+			continue
+		}
+		action := fmt.Sprintf("compgen -W %q -- \"$cur\"", strings.Join(f.Complete, " "))
+		switch f.Complete[0] {
+		case "file":
+			action = `compgen -f -- "$cur"`
+		case "dir":
+			action = `compgen -d -- "$cur"`
+		}
+		cases[action] = append(cases[action], f.spellings()...)
+	}
+	actions := make([]string, 0, len(cases))
+	for a := range cases {
+		actions = append(actions, a)
+	}
+	sort.Strings(actions)
+
+	fmt.Fprintf(w, "# bash completion for dfmon, from \"dfmon completion bash\"\n")
+	fmt.Fprintf(w, "_dfmon() {\n")
+	fmt.Fprintf(w, "\tlocal cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}\n")
+	fmt.Fprintf(w, "\tcase $prev in\n")
+	for _, a := range actions {
+		fmt.Fprintf(w, "\t%s)\n\t\tCOMPREPLY=($(%s))\n\t\treturn\n\t\t;;\n", strings.Join(cases[a], "|"), a)
+	}
+	fmt.Fprintf(w, "\tesac\n")
+	fmt.Fprintf(w, "\tcase $cur in\n")
+	fmt.Fprintf(w, "\t-*) COMPREPLY=($(compgen -W %q -- \"$cur\")) ;;\n", strings.Join(words, " "))
+	fmt.Fprintf(w, "\t*) COMPREPLY=($(compgen -W %q -- \"$cur\")) ;;\n", strings.Join(subcommands, " "))
+	fmt.Fprintf(w, "\tesac\n}\n")
+	fmt.Fprintf(w, "complete -F _dfmon dfmon\n")
+}
+
+func zshCompletion(w io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintf(w, "# zsh completion for dfmon, from \"dfmon completion zsh\"\n")
+	fmt.Fprintf(w, "autoload -U +X bashcompinit && bashcompinit\n")
+	bashCompletion(w, fs)
+}
+
+func fishCompletion(w io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintf(w, "# fish completion for dfmon, from \"dfmon completion fish\"\n")
+	fmt.Fprintf(w, "complete -c dfmon -f\n")
+	fmt.Fprintf(w, "complete -c dfmon -n __fish_use_subcommand -a %s\n", fishQuote(strings.Join(subcommands, " ")))
+	for _, f := range completionFlags(fs) {
+		line := "complete -c dfmon -o " + fishQuote(f.Name)
+		if f.Long != "" {
+			line += " -l " + fishQuote(f.Long)
+		}
+		switch {
+		case !f.Value:
+		case len(f.Complete) == 0:
+			line += " -r"
+		case f.Complete[0] == "file":
+			line += " -r -F"
+		case f.Complete[0] == "dir":
+			line += " -x -a '(__fish_complete_directories)'"
+		default:
+			line += " -x -a " + fishQuote(strings.Join(f.Complete, " "))
+		}
+		fmt.Fprintf(w, "%s -d %s\n", line, fishQuote(f.Usage))
+	}
+}
+
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/AScotM/filesystem_cap/fscap/mountinfo"
+)
+
+// syntheticStatfs answers statfs for any path with sizes derived from the
+// path, so concurrent callers can check what they got.
+func syntheticStatfs(path string, s *syscall.Statfs_t) error {
+	h := fnv.New32a()
+	h.Write([]byte(path))
+	blocks := uint64(1000 + h.Sum32()%9000)
+	*s = syscall.Statfs_t{Bsize: 4096, Frsize: 4096, Blocks: blocks, Bfree: blocks / 2, Bavail: blocks / 3,
+		Files: 1000, Ffree: 900}
+	return nil
+}
+
+// syntheticMounts is a root and n mounts mixing the types the default
+// enrichers look at, none of which exists on disk. The root has no device
+// number, as in the /proc/mounts fallback, so the table is not taken for
+// another root's.
+func syntheticMounts(n int) []mountinfo.Mount {
+	types := []struct{ fsType, options string }{
+		{"ext4", "rw"},
+		{"xfs", "rw,attr2"},
+		{"btrfs", "rw,subvol=/@data"},
+		{"fuse.sshfs", "rw,user_id=4294967294,group_id=0"},
+		{"tmpfs", "rw,size=1048576k"},
+		{"overlay", "rw,lowerdir=/l,upperdir=/fake/upper,workdir=/fake/work"},
+	}
+	mounts := []mountinfo.Mount{{ID: 1, Root: "/", MountPoint: "/", FSType: "ext4", Source: "/dev/fake", Options: "rw"}}
+	for i := 0; i < n; i++ {
+		typ := types[i%len(types)]
+		mounts = append(mounts, mountinfo.Mount{ID: 100 + i, ParentID: 1, MajorMinor: fmt.Sprintf("0:%d", 100+i),
+			Root: "/", MountPoint: fmt.Sprintf("/fake/m%03d", i), FSType: typ.fsType,
+			Source: fmt.Sprintf("/dev/fake%d", i), Options: "rw,relatime", SuperOptions: typ.options})
+	}
+	return mounts
+}
+
+func concurrentConfig(tb testing.TB, shared *scheduler) Config {
+	config := Config{OutputFormat: "json", Fuse: "all", ShowAll: true, SortBy: "mount", Location: time.UTC,
+		WarnThreshold: 70, CritThreshold: 90, InodeWarn: 100, InodeCrit: 100, Scheduler: shared}
+	collectors, err := enabledCollectors("", config)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	config.Collectors = collectors
+	return config
+}
+
+// TestCollectConcurrent overlaps collections sharing one config, logger,
+// scheduler and state file, as overlapping scrapes do. Run it with -race.
+func TestCollectConcurrent(t *testing.T) {
+	const mounts, callers = 60, 40
+	fakeStatfs(t, syntheticStatfs)
+	fakeSelfMounts(t, syntheticMounts(mounts))
+	config := concurrentConfig(t, newScheduler(time.Millisecond, time.Second))
+	config.State = filepath.Join(t.TempDir(), "state.json")
+	config.ETAAlpha, config.RateWindow = 0.3, time.Hour
+	fileConfig := FileConfig{Thresholds: []ThresholdRule{{Name: "tmp", Match: "/fake/m00*", Crit: floatPtr(50)}}}
+	var logs bytes.Buffer
+	logger := log.New(&logs, "", 0)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, err := collect(context.Background(), config, fileConfig, logger)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if len(r.Warnings) > 0 {
+				errs <- fmt.Errorf("warnings: %q", r.Warnings)
+				return
+			}
+			if len(r.Filesystems) != mounts+1 {
+				errs <- fmt.Errorf("%d filesystems, want %d", len(r.Filesystems), mounts+1)
+				return
+			}
+			for _, d := range r.Filesystems {
+				var s syscall.Statfs_t
+				syntheticStatfs(d.Mount, &s)
+				if d.Total != s.Blocks*4096 || d.Free != s.Bavail*4096 {
+					errs <- fmt.Errorf("%s: total %d, free %d; want %d, %d", d.Mount, d.Total, d.Free, s.Blocks*4096, s.Bavail*4096)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if len(config.Collectors) == 0 || config.Scheduler == nil {
+		t.Error("the shared config was modified")
+	}
+
+	st, err := loadState(config.State)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st.Mounts) != mounts+1 {
+		t.Errorf("state holds %d mounts, want %d", len(st.Mounts), mounts+1)
+	}
+}
+
+// TestUpdateStateConcurrent checks overlapping updates of the state file
+// each see the one before, so none is lost.
+func TestUpdateStateConcurrent(t *testing.T) {
+	const callers = 50
+	path := filepath.Join(t.TempDir(), "state.json")
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := updateState(path, func(st *State) {
+				if st.Alerts == nil {
+					st.Alerts = map[string]time.Time{}
+				}
+				st.Alerts["key"+strconv.Itoa(i)] = time.Now()
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	st, err := loadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st.Alerts) != callers {
+		t.Errorf("%d of %d updates kept", len(st.Alerts), callers)
+	}
+}
+
+func floatPtr(v float64) *float64 { return &v }
+
+// BenchmarkCollectParallel measures collections overlapping on one
+// scheduler, where they contend.
+func BenchmarkCollectParallel(b *testing.B) {
+	fakeStatfs(b, syntheticStatfs)
+	fakeSelfMounts(b, syntheticMounts(200))
+	for _, shared := range []bool{false, true} {
+		var s *scheduler
+		if shared {
+			s = newScheduler(0, time.Nanosecond)
+		}
+		config := concurrentConfig(b, s)
+		logger := log.New(&bytes.Buffer{}, "", 0)
+		b.Run(fmt.Sprintf("scheduler=%v", shared), func(b *testing.B) {
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := collect(context.Background(), config, FileConfig{}, logger); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
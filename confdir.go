@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// configPart is one file of the configuration: -config or a default
+// location, then the files of -config-dir.
+type configPart struct {
+	path string
+	fc   FileConfig
+}
+
+// readConfigParts reads the config file, as readFileConfig does, and the
+// *.json, *.yaml, *.yml and *.toml files in dir in lexical order. It returns no parts when neither
+// names a file.
+func readConfigParts(path, dir string) ([]configPart, error) {
+	var parts []configPart
+	fc, used, err := readFileConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	if used != "" {
+		parts = append(parts, configPart{used, fc})
+	}
+	if dir == "" {
+		return parts, nil
+	}
+	files, err := configDirFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range files {
+		fc, _, err := readFileConfig(p)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, configPart{p, fc})
+	}
+	return parts, nil
+}
+
+// configDirFiles lists the config files in dir, of any extension in
+// configExts, sorted by name. Hidden files, such as editor backups, are
+// skipped.
+func configDirFiles(dir string) ([]string, error) {
+	if fi, err := os.Stat(dir); err != nil {
+		return nil, err
+	} else if !fi.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	var files []string
+	for _, ext := range configExts {
+		m, err := filepath.Glob(filepath.Join(dir, "*"+ext))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, m...)
+	}
+	sort.Strings(files)
+	out := files[:0]
+	for _, f := range files {
+		if fi, err := os.Stat(f); err == nil && fi.Mode().IsRegular() && filepath.Base(f)[0] != '.' {
+			out = append(out, f)
+		}
+	}
+	return out, nil
+}
+
+// configSource is the file a setting of the merged config came from.
+type configSource struct {
+	Setting string `json:"setting"`
+	File    string `json:"file"`
+}
+
+// mergeConfig merges parts in order, a later file winning over an earlier
+// one:
+//
+//   - alerts.default is taken from the last file setting it.
+//   - alerts.sinks merge by name; a sink defined again replaces the
+//     earlier definition as a whole. categories merge by type likewise.
+//   - thresholds and alerts.routes are first-match lists, so a later
+//     file's rules go before the earlier ones to override them.
+//   - All other lists append: ignore rules, groups, deep and sparse
+//     globs, plugins, jobs, and annotations and usage_basis rules, where
+//     the later rule already wins.
+//
+// It also returns where each setting came from.
+func mergeConfig(parts []configPart) (FileConfig, []configSource) {
+	var fc FileConfig
+	from := map[string][]string{}
+	sinkFrom := map[string]string{}
+	defaultFrom := ""
+	categoryFrom := map[string]string{}
+	for _, p := range parts {
+		c := p.fc
+		fc.Ignore = mergeList(fc.Ignore, c.Ignore, from, "ignore", p.path, false)
+		fc.Thresholds = mergeList(fc.Thresholds, c.Thresholds, from, "thresholds", p.path, true)
+		fc.Groups = mergeList(fc.Groups, c.Groups, from, "groups", p.path, false)
+		fc.Deep = mergeList(fc.Deep, c.Deep, from, "deep", p.path, false)
+		fc.Sparse = mergeList(fc.Sparse, c.Sparse, from, "sparse", p.path, false)
+		fc.Plugins = mergeList(fc.Plugins, c.Plugins, from, "plugins", p.path, false)
+		fc.Jobs = mergeList(fc.Jobs, c.Jobs, from, "jobs", p.path, false)
+		fc.Annotations = mergeList(fc.Annotations, c.Annotations, from, "annotations", p.path, false)
+		fc.UsageBasis = mergeList(fc.UsageBasis, c.UsageBasis, from, "usage_basis", p.path, false)
+		fc.Alerts.Routes = mergeList(fc.Alerts.Routes, c.Alerts.Routes, from, "alerts.routes", p.path, true)
+		for name, s := range c.Alerts.Sinks {
+			if fc.Alerts.Sinks == nil {
+				fc.Alerts.Sinks = map[string]SinkConfig{}
+			}
+			fc.Alerts.Sinks[name] = s
+			sinkFrom[name] = p.path
+		}
+		if c.Alerts.Default != "" {
+			fc.Alerts.Default, defaultFrom = c.Alerts.Default, p.path
+		}
+		for t, name := range c.Categories {
+			if fc.Categories == nil {
+				fc.Categories = map[string]string{}
+			}
+			fc.Categories[t] = name
+			categoryFrom[t] = p.path
+		}
+	}
+
+	var sources []configSource
+	for _, name := range []string{"ignore", "thresholds", "groups", "deep", "sparse", "plugins", "jobs", "annotations", "usage_basis", "alerts.routes"} {
+		for i, f := range from[name] {
+			sources = append(sources, configSource{fmt.Sprintf("%s[%d]", name, i), f})
+		}
+	}
+	for _, name := range sortedKeys(sinkFrom) {
+		sources = append(sources, configSource{"alerts.sinks." + name, sinkFrom[name]})
+	}
+	if defaultFrom != "" {
+		sources = append(sources, configSource{"alerts.default", defaultFrom})
+	}
+	for _, t := range sortedKeys(categoryFrom) {
+		sources = append(sources, configSource{"categories." + t, categoryFrom[t]})
+	}
+	return fc, sources
+}
+
+// mergeList adds the elements of add, from file, after those of list, or
+// before them with front, keeping from[name] in step.
+func mergeList[T any](list, add []T, from map[string][]string, name, file string, front bool) []T {
+	files := make([]string, len(add))
+	for i := range files {
+		files[i] = file
+	}
+	if front {
+		from[name] = append(files, from[name]...)
+		return append(append([]T(nil), add...), list...)
+	}
+	from[name] = append(from[name], files...)
+	return append(list, add...)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// checkParts validates each file on its own, so problems are located by
+// the file and index they have there. Sink names are resolved against
+// the merged config, as a route may name a sink another file defines.
+func checkParts(parts []configPart, merged FileConfig) []error {
+	var errs []error
+	for _, p := range parts {
+		p.fc.Alerts.known = merged.Alerts.Sinks
+		errs = append(errs, p.fc.check(p.path)...)
+	}
+	return errs
+}
+
+// checkPartsEffective is checkEffective on each file.
+func checkPartsEffective(parts []configPart, config Config) []error {
+	var errs []error
+	for _, p := range parts {
+		errs = append(errs, p.fc.checkEffective(p.path, config)...)
+	}
+	return errs
+}
+
+// displayMerged prints the merged config and the file of each setting,
+// for check-config with -config-dir. SMTP passwords are masked.
+func displayMerged(fc FileConfig, sources []configSource) error {
+	sinks := map[string]SinkConfig{}
+	for name, s := range fc.Alerts.Sinks {
+		if s.Email != nil && s.Email.Password != "" {
+			e := *s.Email
+			e.Password = "********"
+			s.Email = &e
+		}
+		sinks[name] = s
+	}
+	fc.Alerts.Sinks = sinks
+	b, err := json.MarshalIndent(fc, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println()
+	fmt.Println("Effective config:")
+	fmt.Println(string(b))
+	fmt.Println()
+	fmt.Println("Sources:")
+	if len(sources) == 0 {
+		fmt.Println("  (none)")
+	}
+	for _, s := range sources {
+		fmt.Printf("  %s %s\n", fitCell(s.Setting, 24), s.File)
+	}
+	return nil
+}
+
+// sourceOf returns the file setting came from, "" if none.
+func sourceOf(sources []configSource, setting string) string {
+	for _, s := range sources {
+		if s.Setting == setting {
+			return s.File
+		}
+	}
+	return ""
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// sameConfig is one config in each format dfmon reads.
+var sameConfig = map[string]string{
+	"config.json": `{
+  "ignore": [{"mount": "/snap/*"}],
+  "thresholds": [{"name": "backup", "match": "/backup", "warn": 90, "crit": 95}],
+  "alerts": {
+    "sinks": {"ops": {"webhook": "https://example.com/hook"}},
+    "default": "ops"
+  },
+  "categories": {"zfs": "Pools"}
+}`,
+	"config.yaml": `
+ignore:
+  - mount: /snap/*
+thresholds:
+  - name: backup
+    match: /backup
+    warn: 90
+    crit: 95
+alerts:
+  sinks:
+    ops:
+      webhook: https://example.com/hook
+  default: ops
+categories:
+  zfs: Pools
+`,
+	"config.toml": `
+categories = { zfs = "Pools" }
+
+[[ignore]]
+mount = "/snap/*"
+
+[[thresholds]]
+name = "backup"
+match = "/backup"
+warn = 90
+crit = 95
+
+[alerts]
+default = "ops"
+
+[alerts.sinks.ops]
+webhook = "https://example.com/hook"
+`,
+}
+
+func TestConfigFormats(t *testing.T) {
+	dir := t.TempDir()
+	var want FileConfig
+	for _, name := range []string{"config.json", "config.yaml", "config.toml"} {
+		path := filepath.Join(dir, name)
+		writeFile(t, path, sameConfig[name])
+		fc, used, err := readFileConfig(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if used != path {
+			t.Errorf("%s: read from %s", name, used)
+		}
+		if name == "config.json" {
+			want = fc
+			if len(fc.Thresholds) != 1 || *fc.Thresholds[0].Crit != 95 || fc.Alerts.Sinks["ops"].Webhook == "" {
+				t.Fatalf("config.json parsed as %+v", fc)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(fc, want) {
+			t.Errorf("%s = %+v, want %+v as from JSON", name, fc, want)
+		}
+	}
+
+	// .yml is YAML too.
+	path := filepath.Join(dir, "config.yml")
+	writeFile(t, path, sameConfig["config.yaml"])
+	if fc, _, err := readFileConfig(path); err != nil || !reflect.DeepEqual(fc, want) {
+		t.Errorf("config.yml = %+v, %v", fc, err)
+	}
+}
+
+func TestConfigFormatErrors(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"bad.json": `{"ignore": [`,
+		"bad.yaml": "ignore:\n  - mount: [\n",
+		"bad.toml": "ignore = [\n",
+		// Valid YAML, but not of the config's shape.
+		"shape.yaml": "ignore: /snap\n",
+	} {
+		path := filepath.Join(dir, name)
+		writeFile(t, path, content)
+		_, _, err := readFileConfig(path)
+		if err == nil {
+			t.Errorf("%s: no error", name)
+		} else if !strings.HasPrefix(err.Error(), path+": ") {
+			t.Errorf("%s: error %q does not name the file", name, err)
+		}
+	}
+}
+
+func TestConfigDirMixedFormats(t *testing.T) {
+	dir := t.TempDir()
+	main := filepath.Join(dir, "main.json")
+	writeFile(t, main, `{"thresholds": [{"name": "base", "match": "/*", "warn": 70}], "alerts": {"default": "a", "sinks": {"a": {"webhook": "https://a.example.com"}}}, "deep": ["/home"]}`)
+	conf := filepath.Join(dir, "conf.d")
+	if err := os.Mkdir(conf, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(conf, "10-web.yaml"), "thresholds:\n  - name: web\n    match: /srv\n    warn: 85\ndeep: [/srv]\n")
+	writeFile(t, filepath.Join(conf, "20-db.toml"), "deep = [\"/var/lib\"]\n\n[[thresholds]]\nname = \"db\"\nmatch = \"/var/lib\"\nwarn = 90\n\n[alerts]\ndefault = \"b\"\n\n[alerts.sinks.b]\nwebhook = \"https://b.example.com\"\n")
+	writeFile(t, filepath.Join(conf, "30-extra.json"), `{"sparse": ["/var/lib/images"]}`)
+	writeFile(t, filepath.Join(conf, ".10-web.yaml.swp"), "not a config")
+	writeFile(t, filepath.Join(conf, "README"), "not a config")
+
+	files, err := configDirFiles(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, filepath.Base(f))
+	}
+	if want := []string{"10-web.yaml", "20-db.toml", "30-extra.json"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("configDirFiles = %v, want %v", names, want)
+	}
+
+	parts, err := readConfigParts(main, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc, sources := mergeConfig(parts)
+	var rules []string
+	for _, r := range fc.Thresholds {
+		rules = append(rules, r.Name)
+	}
+	// Thresholds are first-match, so later files' rules go first.
+	if want := []string{"db", "web", "base"}; !reflect.DeepEqual(rules, want) {
+		t.Errorf("thresholds = %v, want %v", rules, want)
+	}
+	if want := []string{"/home", "/srv", "/var/lib"}; !reflect.DeepEqual(fc.Deep, want) {
+		t.Errorf("deep = %v, want %v", fc.Deep, want)
+	}
+	if fc.Alerts.Default != "b" || len(fc.Alerts.Sinks) != 2 {
+		t.Errorf("alerts = %+v, want default b and both sinks", fc.Alerts)
+	}
+	from := map[string]string{}
+	for _, s := range sources {
+		from[s.Setting] = filepath.Base(s.File)
+	}
+	for setting, file := range map[string]string{
+		"thresholds[0]":  "20-db.toml",
+		"thresholds[1]":  "10-web.yaml",
+		"thresholds[2]":  "main.json",
+		"alerts.default": "20-db.toml",
+		"alerts.sinks.a": "main.json",
+		"sparse[0]":      "30-extra.json",
+	} {
+		if from[setting] != file {
+			t.Errorf("%s from %q, want %s", setting, from[setting], file)
+		}
+	}
+	if _, err := loadFileConfig(main, Config{ConfigDir: conf, WarnThreshold: 80, CritThreshold: 95}); err != nil {
+		t.Errorf("loadFileConfig: %v", err)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileConfig is the on-disk configuration loaded from -config.
+type FileConfig struct {
+	Ignore []IgnoreRule `json:"ignore"`
+	Alerts AlertConfig  `json:"alerts"`
+	// Thresholds override -w/-c per mount, optionally on a schedule.
+	Thresholds []ThresholdRule `json:"thresholds"`
+	Groups     []GroupConfig   `json:"groups"`
+	// Deep lists mount globs whose largest subdirectory is shown with -deep.
+	Deep []string `json:"deep"`
+	// Sparse lists mount globs scanned for sparse files with -sparse-check.
+	Sparse  []string       `json:"sparse"`
+	Plugins []PluginConfig `json:"plugins"`
+	// Jobs are recurring writers whose next run must fit in the free space.
+	Jobs        []JobConfig      `json:"jobs"`
+	Annotations []AnnotationRule `json:"annotations"`
+	UsageBasis  []UsageBasisRule `json:"usage_basis"`
+	// Categories map filesystem types to the -group-display category
+	// sections, overriding the built-in classes, e.g. {"zfs": "Pools"}.
+	Categories map[string]string `json:"categories"`
+}
+
+// IgnoreRule matches filesystems that are still listed but never evaluated
+// against thresholds. Every non-empty field must match.
+type IgnoreRule struct {
+	Device string `json:"device,omitempty"`
+	Mount  string `json:"mount,omitempty"`
+	UUID   string `json:"uuid,omitempty"`
+	Label  string `json:"label,omitempty"`
+}
+
+func defaultConfigPaths() []string {
+	var dirs, paths []string
+	if dir, err := os.UserConfigDir(); err == nil {
+		dirs = append(dirs, filepath.Join(dir, "dfmon"))
+	}
+	for _, dir := range append(dirs, "/etc/dfmon") {
+		for _, ext := range configExts {
+			paths = append(paths, filepath.Join(dir, "config"+ext))
+		}
+	}
+	return paths
+}
+
+// loadFileConfig reads and validates the config file at path, merged with
+// those in -config-dir, including the thresholds its rules produce
+// together with the flags in config. With an empty path the default
+// locations are tried and a missing file yields an empty config.
+func loadFileConfig(path string, config Config) (FileConfig, error) {
+	parts, err := readConfigParts(path, config.ConfigDir)
+	if err != nil || len(parts) == 0 {
+		return FileConfig{}, err
+	}
+	fc, _ := mergeConfig(parts)
+	if err := errors.Join(checkParts(parts, fc)...); err != nil {
+		return fc, err
+	}
+	return fc, errors.Join(checkPartsEffective(parts, config)...)
+}
+
+// readFileConfig parses the config file without validating it, returning
+// the path it was read from, or "" if no default file exists.
+func readFileConfig(path string) (FileConfig, string, error) {
+	var fc FileConfig
+	paths := []string{path}
+	if path == "" {
+		paths = defaultConfigPaths()
+	}
+
+	for _, p := range paths {
+		b, err := os.ReadFile(p)
+		if errors.Is(err, fs.ErrNotExist) && path == "" {
+			continue
+		}
+		if err != nil {
+			return fc, p, err
+		}
+		if err := decodeConfig(p, b, &fc); err != nil {
+			return fc, p, fmt.Errorf("%s: %v", p, err)
+		}
+		return fc, p, nil
+	}
+	return fc, "", nil
+}
+
+// check returns every problem in fc, each located by file and key.
+func (fc FileConfig) check(path string) []error {
+	var errs []error
+	for i, r := range fc.Ignore {
+		key := fmt.Sprintf("%s: ignore[%d]", path, i)
+		if r == (IgnoreRule{}) {
+			errs = append(errs, fmt.Errorf("%s: empty rule", key))
+		}
+		if _, err := filepath.Match(r.Mount, ""); err != nil {
+			errs = append(errs, fmt.Errorf("%s.mount: bad pattern %q", key, r.Mount))
+		}
+	}
+	for i, r := range fc.Thresholds {
+		errs = append(errs, r.check(fmt.Sprintf("%s: thresholds[%d]", path, i))...)
+	}
+	for i, p := range fc.Deep {
+		if _, err := filepath.Match(p, ""); err != nil {
+			errs = append(errs, fmt.Errorf("%s: deep[%d]: bad pattern %q", path, i, p))
+		}
+	}
+	for i, p := range fc.Sparse {
+		if _, err := filepath.Match(p, ""); err != nil {
+			errs = append(errs, fmt.Errorf("%s: sparse[%d]: bad pattern %q", path, i, p))
+		}
+	}
+	for i, p := range fc.Plugins {
+		errs = append(errs, p.check(fmt.Sprintf("%s: plugins[%d]", path, i))...)
+	}
+	for i, j := range fc.Jobs {
+		errs = append(errs, j.check(fmt.Sprintf("%s: jobs[%d]", path, i))...)
+	}
+	for i, r := range fc.Annotations {
+		errs = append(errs, r.check(fmt.Sprintf("%s: annotations[%d]", path, i))...)
+	}
+	for i, r := range fc.UsageBasis {
+		errs = append(errs, r.check(fmt.Sprintf("%s: usage_basis[%d]", path, i))...)
+	}
+	for i, g := range fc.Groups {
+		key := fmt.Sprintf("%s: groups[%d]", path, i)
+		errs = append(errs, g.check(key)...)
+		if g.Sink != "" && !fc.Alerts.hasSink(g.Sink) {
+			errs = append(errs, fmt.Errorf("%s.sink: unknown sink %q", key, g.Sink))
+		}
+	}
+	errs = append(errs, checkCategories(path, fc.Categories)...)
+	return append(errs, fc.Alerts.check(path)...)
+}
+
+// checkOrder reports thresholds outside 0-100 or with warn above crit.
+// Either may be nil when the rule leaves it unset.
+func checkOrder(key string, warn, crit *float64) []error {
+	var errs []error
+	for _, t := range []struct {
+		name string
+		v    *float64
+	}{{"warn", warn}, {"crit", crit}} {
+		if t.v != nil && (*t.v < 0 || *t.v > 100) {
+			errs = append(errs, fmt.Errorf("%s.%s: %g is outside 0-100", key, t.name, *t.v))
+		}
+	}
+	if warn != nil && crit != nil && *warn > *crit {
+		errs = append(errs, fmt.Errorf("%s: warn %g is above crit %g", key, *warn, *crit))
+	}
+	return errs
+}
+
+// applyRules applies the rules of the config file to list: the job
+// windows, ignore rules, usage bases, annotations, categories and
+// threshold rules, in that order, as every output sees them.
+func applyRules(list []FS, fileConfig FileConfig, config Config, now time.Time) {
+	now = now.In(config.Location)
+	applyJobs(list, fileConfig.Jobs, now)
+	applyIgnore(list, fileConfig.Ignore)
+	applyUsageBasis(list, fileConfig.UsageBasis)
+	applyAnnotations(list, fileConfig.Annotations)
+	applyCategories(list, fileConfig.Categories)
+	applyThresholdRules(list, fileConfig.Thresholds, config, now)
+}
+
+func applyIgnore(list []FS, rules []IgnoreRule) {
+	if len(rules) == 0 {
+		return
+	}
+	for i := range list {
+		for _, r := range rules {
+			if r.matches(list[i]) {
+				list[i].Ignored = true
+				break
+			}
+		}
+	}
+}
+
+func (r IgnoreRule) matches(d FS) bool {
+	if r.Device != "" && !sameDevice(r.Device, d.Device) {
+		return false
+	}
+	if r.Mount != "" {
+		if ok, _ := filepath.Match(r.Mount, d.Mount); !ok {
+			return false
+		}
+	}
+	if r.UUID != "" && !sameDevice(filepath.Join("/dev/disk/by-uuid", r.UUID), d.Device) {
+		return false
+	}
+	if r.Label != "" && !sameDevice(filepath.Join("/dev/disk/by-label", escapeLabel(r.Label)), d.Device) {
+		return false
+	}
+	return true
+}
+
+// sameDevice reports whether two device paths name the same node after
+// resolving symlinks such as /dev/disk/by-uuid or /dev/mapper entries.
+func sameDevice(a, b string) bool {
+	if a == b {
+		return true
+	}
+	ra, rb := resolveDevice(a), resolveDevice(b)
+	return ra != "" && ra == rb
+}
+
+// resolveDevice returns the device node path names, or "" if it does not
+// resolve.
+func resolveDevice(path string) string {
+	r, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return ""
+	}
+	return r
+}
+
+// escapeLabel applies the udev encoding used for /dev/disk/by-label names.
+func escapeLabel(label string) string {
+	var sb strings.Builder
+	for _, c := range []byte(label) {
+		if c == '/' || c == '\\' || c <= ' ' {
+			fmt.Fprintf(&sb, `\x%02x`, c)
+			continue
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
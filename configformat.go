@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// configExts are the config file extensions dfmon reads, in the order the
+// default locations are tried.
+var configExts = []string{".json", ".yaml", ".yml", ".toml"}
+
+// decodeConfig parses a config file in the format its extension names,
+// JSON unless it is .yaml, .yml or .toml. YAML and TOML go through JSON,
+// so every format has the same keys and the same validation.
+func decodeConfig(path string, b []byte, fc *FileConfig) error {
+	var v any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(b, &v); err != nil {
+			return err
+		}
+	case ".toml":
+		var m map[string]any
+		if err := toml.Unmarshal(b, &m); err != nil {
+			return err
+		}
+		v = m
+	default:
+		return json.Unmarshal(b, fc)
+	}
+	v, err := jsonValue(v)
+	if err != nil {
+		return err
+	}
+	b, err = json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, fc)
+}
+
+// jsonValue converts the mappings YAML decodes with non-string keys into
+// objects JSON can encode.
+func jsonValue(v any) (any, error) {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			e, err := jsonValue(e)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = e
+		}
+		return v, nil
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			e, err := jsonValue(e)
+			if err != nil {
+				return nil, err
+			}
+			switch k.(type) {
+			case string, int, int64, uint64, float64, bool:
+				m[fmt.Sprint(k)] = e
+			default:
+				return nil, fmt.Errorf("unsupported key %v", k)
+			}
+		}
+		return m, nil
+	case []any:
+		for i, e := range v {
+			e, err := jsonValue(e)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = e
+		}
+		return v, nil
+	}
+	return v, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/AScotM/filesystem_cap/fscap/mountinfo"
+)
+
+func detectContainer() (bool, error) {
+	for _, p := range []string{"/.dockerenv", "/run/.containerenv"} {
+		if _, err := os.Stat(p); err == nil {
+			return true, nil
+		}
+	}
+
+	// With hidepid, or in a user namespace, pid 1 may not be visible.
+	b, err := os.ReadFile("/proc/1/cgroup")
+	if err != nil {
+		return false, err
+	}
+	for _, k := range []string{"docker", "kubepods", "containerd", "libpod", "lxc"} {
+		if strings.Contains(string(b), k) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func resolveContainerMode(mode string) (bool, error) {
+	switch mode {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	default:
+		return detectContainer()
+	}
+}
+
+// labelContainerRoot marks the root overlay as the container rootfs and
+// records the upper directory backing it when the options expose it.
+func labelContainerRoot(list []FS, mounts []mountinfo.Mount) {
+	backing := ""
+	for _, m := range mounts {
+		if m.MountPoint == "/" && m.FSType == "overlay" {
+			backing = mountOption(m.SuperOptions, "upperdir")
+		}
+	}
+
+	for i := range list {
+		if list[i].Mount == "/" && list[i].Type == "overlay" {
+			list[i].Label = "container rootfs"
+			list[i].Backing = backing
+		}
+	}
+}
+
+func mountOption(options, key string) string {
+	for _, o := range strings.Split(options, ",") {
+		if v, ok := strings.CutPrefix(o, key+"="); ok {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestResolveContainerMode(t *testing.T) {
+	for mode, want := range map[string]bool{"yes": true, "no": false} {
+		if got, err := resolveContainerMode(mode); err != nil || got != want {
+			t.Errorf("resolveContainerMode(%q) = %v, %v, want %v", mode, got, err, want)
+		}
+	}
+}
+
+func TestContainerSkipsSysfs(t *testing.T) {
+	has := func(config Config, name string) bool {
+		enabled, err := enabledCollectors("", config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range enabled {
+			if c.Name() == name {
+				return true
+			}
+		}
+		return false
+	}
+	if !has(Config{}, "fserrors") {
+		t.Error("fserrors is not enabled on the host")
+	}
+	if has(Config{InContainer: true}, "fserrors") {
+		t.Error("fserrors is enabled in a container")
+	}
+}
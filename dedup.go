@@ -0,0 +1,80 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// fsKey identifies the filesystem behind an entry: its device number,
+// which all subvolumes and bind mounts of one superblock share, else its
+// UUID, else the statfs fsid, else only the mount itself.
+func fsKey(d FS) string {
+	switch {
+	case d.Dev != "":
+		return d.Dev
+	case strings.HasPrefix(d.Identity, "uuid:"):
+		return d.Identity
+	case d.Fsid != "" && d.Fsid != "0000000000000000":
+		return "fsid:" + d.Fsid
+	}
+	return "mount:" + d.Mount
+}
+
+// dedup collapses filesystems mounted more than once. Mounts of the same
+// subtree are duplicates and dropped; mounts of a different subtree (btrfs
+// subvolumes, bind mounts of a subdirectory) become children of the entry
+// for the filesystem root.
+func dedup(list []FS, tr *trace) []FS {
+	groups := map[string][]int{}
+	var order []string
+	for i, d := range list {
+		key := fsKey(d)
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], i)
+	}
+
+	var out []FS
+	for _, key := range order {
+		idx := groups[key]
+		p := idx[0]
+		for _, i := range idx[1:] {
+			if rootFirst(list[i], list[p]) {
+				p = i
+			}
+		}
+
+		parent := list[p]
+		seen := map[string]bool{parent.Subtree: true}
+		for _, i := range idx {
+			if i == p {
+				continue
+			}
+			if seen[list[i].Subtree] {
+				tr.removed(list[i].ID, list[i].Mount, "dedup", "same filesystem and subtree as %s", parent.Mount)
+				continue
+			}
+			tr.kept(list[i].ID, list[i].Mount, "dedup", "same filesystem as %s, shown as its child", parent.Mount)
+			seen[list[i].Subtree] = true
+			parent.Children = append(parent.Children, list[i])
+		}
+		sort.Slice(parent.Children, func(i, j int) bool {
+			return parent.Children[i].Mount < parent.Children[j].Mount
+		})
+		out = append(out, parent)
+	}
+	return out
+}
+
+// rootFirst orders candidates for the parent row: the filesystem root
+// before subtrees, then shorter mount paths.
+func rootFirst(a, b FS) bool {
+	if (a.Subtree == "") != (b.Subtree == "") {
+		return a.Subtree == ""
+	}
+	if len(a.Subtree) != len(b.Subtree) {
+		return len(a.Subtree) < len(b.Subtree)
+	}
+	return len(a.Mount) < len(b.Mount)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Largest is the biggest immediate subdirectory of a "deep" mount. Partial
+// is set while the scan has not yet covered every subdirectory.
+type Largest struct {
+	Path    string    `json:"path"`
+	Bytes   uint64    `json:"bytes"`
+	Partial bool      `json:"partial,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// DeepState is the scan progress for one mount: the sizes of the
+// subdirectories measured so far, and when the last full pass finished.
+// Cursor is the last path counted in the unfinished subdirectory Dir,
+// whose running total is in Pending.
+type DeepState struct {
+	Sizes    map[string]uint64 `json:"sizes"`
+	Dir      string            `json:"dir,omitempty"`
+	Cursor   string            `json:"cursor,omitempty"`
+	Pending  uint64            `json:"pending,omitempty"`
+	Complete time.Time         `json:"complete,omitempty"`
+}
+
+var errBudget = errors.New("scan budget exhausted")
+
+// applyDeep finds the largest subdirectory of each mount matching the
+// config's deep patterns. Each mount gets budget per run; a scan that runs
+// out resumes on the next run from the sizes kept in the state file, and a
+// finished one is reused until ttl passes. Without a state file every run
+// starts over.
+func applyDeep(ctx context.Context, list []FS, patterns []string, statePath string, budget, ttl time.Duration, now time.Time) error {
+	st := State{Version: stateVersion, Mounts: map[string]MountState{}}
+	var err error
+	if statePath != "" {
+		st, err = readState(statePath)
+		var corrupt *corruptError
+		if err != nil && !errors.As(err, &corrupt) {
+			return err
+		}
+	}
+	if st.Deep == nil {
+		st.Deep = map[string]DeepState{}
+	}
+
+	errs := []error{err}
+	scanned := map[string]DeepState{}
+	for i := range list {
+		d := &list[i]
+		if !matchAny(patterns, d.Mount) {
+			continue
+		}
+		ds := st.Deep[d.Mount]
+		if ds.Complete.IsZero() || now.Sub(ds.Complete) > ttl {
+			// An expired pass starts over; an unfinished one resumes.
+			if !ds.Complete.IsZero() || ds.Sizes == nil {
+				ds = DeepState{Sizes: map[string]uint64{}}
+			}
+			res, serr := runScan(ctx, scanRequest{Kind: "deep", Path: hostPath(d.Mount), Deep: ds, Deadline: now.Add(budget)})
+			if serr != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", d.Mount, serr))
+				continue
+			}
+			ds = res.Deep
+			if res.Complete {
+				ds.Complete = now
+			}
+			scanned[d.Mount] = ds
+		}
+		st.Deep[d.Mount] = ds
+		d.Largest = largest(ds, now)
+	}
+
+	err = errors.Join(errs...)
+	if statePath == "" {
+		return err
+	}
+	// The file is read again for the update, as a collection overlapping
+	// this one may have changed it while the scans ran.
+	return errors.Join(err, updateState(statePath, func(cur *State) {
+		if cur.Deep == nil {
+			cur.Deep = map[string]DeepState{}
+		}
+		for mount, ds := range scanned {
+			cur.Deep[mount] = ds
+		}
+	}))
+}
+
+func matchAny(patterns []string, mount string) bool {
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, mount); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func largest(ds DeepState, now time.Time) *Largest {
+	var l *Largest
+	for path, n := range ds.Sizes {
+		if l == nil || n > l.Bytes {
+			l = &Largest{Path: path, Bytes: n}
+		}
+	}
+	// The directory being scanned is already at least Pending bytes.
+	if ds.Dir != "" && (l == nil || ds.Pending > l.Bytes) {
+		l = &Largest{Path: ds.Dir, Bytes: ds.Pending}
+	}
+	if l != nil {
+		l.Partial = ds.Complete.IsZero()
+		l.Time = ds.Complete
+		if l.Partial {
+			l.Time = now
+		}
+	}
+	return l
+}
+
+// scanDeep sizes the subdirectories of mount not already in ds, staying on
+// mount's filesystem. It reports whether every subdirectory is done.
+func scanDeep(mount string, ds *DeepState, deadline time.Time) bool {
+	var root syscall.Stat_t
+	if syscall.Lstat(mount, &root) != nil {
+		return true
+	}
+	entries, err := os.ReadDir(mount)
+	if err != nil {
+		return true
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	w := newFSWalker(uint64(root.Dev))
+
+	for _, e := range entries {
+		path := filepath.Join(mount, e.Name())
+		if _, ok := ds.Sizes[path]; ok || !e.IsDir() {
+			continue
+		}
+		if ds.Dir != path {
+			ds.Dir, ds.Cursor, ds.Pending = path, "", 0
+		}
+		if !dirSize(w, path, deadline, ds) {
+			return false
+		}
+		ds.Sizes[path] = ds.Pending
+		ds.Dir, ds.Cursor, ds.Pending = "", "", 0
+	}
+	return true
+}
+
+// dirSize adds the allocated size under path on the walker's filesystem
+// to ds.Pending, skipping everything up to ds.Cursor in walk order. It
+// returns false if the deadline passed first, leaving the cursor at the
+// last path counted.
+func dirSize(w *fsWalker, path string, deadline time.Time, ds *DeepState) bool {
+	resume := ds.Cursor
+	err := w.walk(path, func(p string, de fs.DirEntry, st *syscall.Stat_t, err error) error {
+		if err != nil {
+			return nil
+		}
+		if resume != "" {
+			switch {
+			case p == resume:
+				resume = ""
+				return nil
+			case inside(resume, p):
+				// Ancestor of the cursor: descend without recounting it.
+				return nil
+			case walkBefore(p, resume):
+				if de.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			resume = ""
+		}
+		if time.Now().After(deadline) {
+			return errBudget
+		}
+		ds.Pending += uint64(st.Blocks) * 512
+		ds.Cursor = p
+		return nil
+	})
+	return err != errBudget
+}
+
+// inside reports whether p is dir or below it.
+func inside(p, dir string) bool {
+	return p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+// walkBefore reports whether WalkDir visits a before b, comparing path
+// elements in the lexical order it reads directories in.
+func walkBefore(a, b string) bool {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] != bs[i] {
+			return as[i] < bs[i]
+		}
+	}
+	return len(as) < len(bs)
+}
+
+func largestLine(l *Largest, humanReadable bool) string {
+	line := "    largest: " + sanitize(l.Path) + " " + fmtBytes(l.Bytes, humanReadable)
+	if l.Partial {
+		line += " (scan in progress)"
+	}
+	return line
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/AScotM/filesystem_cap/fscap/mountinfo"
+)
+
+// requireFeatures are the features that degrade with a warning instead of
+// failing, and that -require can make mandatory. Warnings name their
+// feature before the first colon.
+var requireFeatures = []string{"container", "deep", "deleted-space", "io", "mountinfo", "sandbox", "sparse", "state", "thin"}
+
+// mountTableWarning is set by readMounts when it had to fall back from
+// /proc/self/mountinfo, and cleared when it did not.
+var mountTableWarning atomic.Pointer[string]
+
+// selfMounts is replaceable so collection can run against a synthetic
+// mount table.
+var selfMounts = mountinfo.Self
+
+// readMountTable reads the mount table, falling back to the older formats
+// when mountinfo is not readable, as under some restricted /proc setups.
+func readMountTable() ([]mountinfo.Mount, error) {
+	mounts, err := selfMounts()
+	mountTableWarning.Store(nil)
+	if err == nil {
+		return mounts, nil
+	}
+	for _, path := range []string{"/proc/mounts", "/etc/mtab"} {
+		f, ferr := os.Open(path)
+		if ferr != nil {
+			continue
+		}
+		mounts, ferr := mountinfo.ParseMounts(f)
+		f.Close()
+		if ferr == nil && len(mounts) > 0 {
+			w := fmt.Sprintf("mountinfo: %v; using %s, without device numbers or subtrees", err, path)
+			mountTableWarning.Store(&w)
+			return mounts, nil
+		}
+	}
+	return nil, err
+}
+
+func parseRequire(s string) (map[string]bool, error) {
+	known := map[string]bool{}
+	for _, f := range requireFeatures {
+		known[f] = true
+	}
+	out := map[string]bool{}
+	for _, f := range splitList(s) {
+		if !known[f] {
+			return nil, fmt.Errorf("unknown feature %q (%s)", f, strings.Join(requireFeatures, ", "))
+		}
+		out[f] = true
+	}
+	return out, nil
+}
+
+// checkRequired fails if any warning comes from a feature in require.
+func checkRequired(warnings []string, require map[string]bool) error {
+	var failed []string
+	for _, w := range warnings {
+		if feature, _, ok := strings.Cut(w, ":"); ok && require[feature] {
+			failed = append(failed, w)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	sort.Strings(failed)
+	return fmt.Errorf("required feature unavailable: %s", strings.Join(failed, "; "))
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const deletedTopN = 3
+
+// DeletedProc is a process holding deleted files open on a filesystem.
+type DeletedProc struct {
+	PID   int    `json:"pid"`
+	Comm  string `json:"comm"`
+	Bytes uint64 `json:"bytes"`
+}
+
+type deletedUsage struct {
+	bytes uint64
+	procs map[int]*DeletedProc
+}
+
+// devString formats st_dev as the "major:minor" used by mountinfo.
+func devString(dev uint64) string {
+	major := (dev>>8)&0xfff | (dev>>32)&^uint64(0xfff)
+	minor := dev&0xff | (dev>>12)&^uint64(0xff)
+	return fmt.Sprintf("%d:%d", major, minor)
+}
+
+// scanDeleted walks /proc/*/fd for descriptors on deleted files and sums
+// their sizes per device. Each file is counted once however many
+// descriptors refer to it. Processes that cannot be inspected are counted
+// as a warning, and the scan stops when the timeout expires.
+func scanDeleted(timeout time.Duration) (map[string]*deletedUsage, []string) {
+	deadline := time.Now().Add(timeout)
+	usage := map[string]*deletedUsage{}
+	seen := map[[2]uint64]bool{}
+	var warnings []string
+
+	pids, err := filepath.Glob("/proc/[0-9]*")
+	if err != nil {
+		return usage, []string{"deleted-space: " + err.Error()}
+	}
+
+	denied := 0
+	for _, dir := range pids {
+		if time.Now().After(deadline) {
+			warnings = append(warnings, fmt.Sprintf("deleted-space: scan stopped after %v, results are partial", timeout))
+			break
+		}
+		pid, _ := strconv.Atoi(filepath.Base(dir))
+
+		fds, err := os.ReadDir(filepath.Join(dir, "fd"))
+		if err != nil {
+			if os.IsPermission(err) {
+				denied++
+			}
+			continue
+		}
+
+		var comm string
+		for _, fd := range fds {
+			link := filepath.Join(dir, "fd", fd.Name())
+			target, err := os.Readlink(link)
+			if err != nil || !strings.HasSuffix(target, " (deleted)") {
+				continue
+			}
+			var st syscall.Stat_t
+			if err := syscall.Stat(link, &st); err != nil || st.Mode&syscall.S_IFMT != syscall.S_IFREG {
+				continue
+			}
+			key := [2]uint64{uint64(st.Dev), st.Ino}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			dev := devString(uint64(st.Dev))
+			u := usage[dev]
+			if u == nil {
+				u = &deletedUsage{procs: map[int]*DeletedProc{}}
+				usage[dev] = u
+			}
+			p := u.procs[pid]
+			if p == nil {
+				if comm == "" {
+					b, _ := os.ReadFile(filepath.Join(dir, "comm"))
+					comm = strings.TrimSpace(string(b))
+				}
+				p = &DeletedProc{PID: pid, Comm: comm}
+				u.procs[pid] = p
+			}
+			p.Bytes += uint64(st.Size)
+			u.bytes += uint64(st.Size)
+		}
+	}
+
+	if denied > 0 {
+		warnings = append(warnings, fmt.Sprintf("deleted-space: %d processes not readable, results are partial", denied))
+	}
+	return usage, warnings
+}
+
+func applyDeleted(list []FS, usage map[string]*deletedUsage) {
+	for i := range list {
+		u := usage[list[i].Dev]
+		if u == nil {
+			continue
+		}
+		list[i].DeletedSpace = u.bytes
+		for _, p := range u.procs {
+			list[i].DeletedTop = append(list[i].DeletedTop, *p)
+		}
+		top := list[i].DeletedTop
+		sort.Slice(top, func(a, b int) bool { return top[a].Bytes > top[b].Bytes })
+		if len(top) > deletedTopN {
+			list[i].DeletedTop = top[:deletedTopN]
+		}
+	}
+}
+
+func deletedLine(d FS, humanReadable bool) string {
+	var procs []string
+	for _, p := range d.DeletedTop {
+		procs = append(procs, fmt.Sprintf("%d %s %s", p.PID, sanitize(p.Comm), fmtBytes(p.Bytes, humanReadable)))
+	}
+	return fmt.Sprintf("    deleted but open: %s (%s)", fmtBytes(d.DeletedSpace, humanReadable), strings.Join(procs, ", "))
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// envPrefix starts the environment variable behind every flag. Variables
+// are applied before the command line is parsed, so a setting comes from,
+// in rising precedence: the flag's default, its variable, the flag. The
+// config file's threshold rules then override -w/-c, however set, for the
+// mounts they match. A variable that does not parse stops dfmon at
+// startup, as a bad flag does.
+const envPrefix = "DFMON_"
+
+// envName is the variable of a flag: the one its flagRegistry entry names,
+// or else its name, or the long one of a single-letter flag, upper-cased
+// with dashes as underscores.
+func envName(flagName string) string {
+	info := flagRegistry[flagName]
+	if info.Env != "" {
+		return envPrefix + info.Env
+	}
+	if info.Long != "" {
+		flagName = info.Long
+	}
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+type boolFlag interface{ IsBoolFlag() bool }
+
+// applyEnv sets each flag in fs from its variable, if present, returning
+// every variable that does not parse. Booleans also accept yes/no and
+// on/off.
+func applyEnv(fs *flag.FlagSet) error {
+	var errs []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if isLongFlag(f.Name) {
+			return
+		}
+		name := envName(f.Name)
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		if b, isBool := f.Value.(boolFlag); isBool && b.IsBoolFlag() {
+			switch strings.ToLower(v) {
+			case "yes", "on":
+				v = "true"
+			case "no", "off", "":
+				v = "false"
+			}
+		}
+		if err := fs.Set(f.Name, v); err != nil {
+			errs = append(errs, fmt.Sprintf("%s=%q: %v", name, v, err))
+		}
+	})
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// printEnvNames lists the variables for usage, sorted by flag name.
+func printEnvNames(fs *flag.FlagSet) {
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if !isLongFlag(f.Name) {
+			names = append(names, f.Name)
+		}
+	})
+	sort.Strings(names)
+	out := fs.Output()
+	fmt.Fprintf(out, "\nEnvironment (overridden by flags; config threshold rules override both):\n")
+	for _, n := range names {
+		fmt.Fprintf(out, "  %-36s -%s\n", envName(n), n)
+	}
+}
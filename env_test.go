@@ -0,0 +1,161 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// envFlags is a flag set with the thresholds as main declares them.
+func envFlags(config *Config) *flag.FlagSet {
+	fs := flag.NewFlagSet("dfmon", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Float64Var(&config.WarnThreshold, "w", 80, "")
+	fs.Float64Var(&config.CritThreshold, "c", 90, "")
+	fs.BoolVar(&config.HumanReadable, "h", false, "")
+	addLongFlags(fs)
+	return fs
+}
+
+func TestEnvName(t *testing.T) {
+	tests := []struct{ flag, want string }{
+		{"w", "DFMON_WARN"},
+		{"iw", "DFMON_INODE_WARN"},
+		{"x+", "DFMON_EXCLUDE_TYPES_ADD"},
+		{"scan-user", "DFMON_SCAN_USER"},
+		{"no-such-flag", "DFMON_NO_SUCH_FLAG"},
+	}
+	for _, tt := range tests {
+		if got := envName(tt.flag); got != tt.want {
+			t.Errorf("envName(%q) = %s, want %s", tt.flag, got, tt.want)
+		}
+	}
+}
+
+// TestEnvRegistry checks every single-letter flag is set by the variable
+// its long spelling names, through the long flag too, and that no two
+// flags share a variable.
+func TestEnvRegistry(t *testing.T) {
+	owner := map[string]string{}
+	for short, info := range flagRegistry {
+		name := envName(short)
+		if other, ok := owner[name]; ok {
+			t.Errorf("%s is the variable of both -%s and -%s", name, short, other)
+		}
+		owner[name] = short
+		if info.Long == "" {
+			continue
+		}
+
+		fs := flag.NewFlagSet("dfmon", flag.ContinueOnError)
+		v := fs.String(short, "", "")
+		addLongFlags(fs)
+		t.Setenv(name, "from-env")
+		if err := applyEnv(fs); err != nil {
+			t.Errorf("-%s: %v", short, err)
+		}
+		if *v != "from-env" {
+			t.Errorf("-%s from %s = %q", short, name, *v)
+		}
+		if long := fs.Lookup(info.Long); long == nil || long.Value.String() != "from-env" {
+			t.Errorf("--%s does not share -%s's value", info.Long, short)
+		}
+	}
+}
+
+func TestApplyEnvBool(t *testing.T) {
+	tests := []struct {
+		v    string
+		want bool
+	}{
+		{"yes", true}, {"YES", true}, {"on", true}, {"1", true}, {"true", true}, {"True", true},
+		{"no", false}, {"off", false}, {"0", false}, {"false", false}, {"", false},
+	}
+	for _, tt := range tests {
+		var config Config
+		config.HumanReadable = !tt.want
+		fs := envFlags(&config)
+		t.Setenv("DFMON_HUMAN", tt.v)
+		if err := applyEnv(fs); err != nil {
+			t.Errorf("DFMON_HUMAN=%q: %v", tt.v, err)
+		}
+		if config.HumanReadable != tt.want {
+			t.Errorf("DFMON_HUMAN=%q: -h = %v, want %v", tt.v, config.HumanReadable, tt.want)
+		}
+	}
+}
+
+func TestApplyEnvInvalid(t *testing.T) {
+	for _, tt := range []struct{ name, v string }{{"DFMON_WARN", "eighty"}, {"DFMON_HUMAN", "maybe"}} {
+		t.Run(tt.name, func(t *testing.T) {
+			var config Config
+			t.Setenv(tt.name, tt.v)
+			err := applyEnv(envFlags(&config))
+			if err == nil || !strings.Contains(err.Error(), tt.name+`="`+tt.v+`"`) {
+				t.Errorf("err = %v", err)
+			}
+		})
+	}
+}
+
+// TestEnvPrecedence checks a flag on the command line overrides its
+// variable, which overrides the default, and that the config file's
+// threshold rules override both for the mounts they match.
+func TestEnvPrecedence(t *testing.T) {
+	tests := []struct {
+		name      string
+		env, args []string
+		want      float64
+	}{
+		{"default", nil, nil, 80},
+		{"env", []string{"DFMON_WARN=60"}, nil, 60},
+		{"flag", nil, []string{"-w", "70"}, 70},
+		{"flag over env", []string{"DFMON_WARN=60"}, []string{"-w", "70"}, 70},
+		{"long flag over env", []string{"DFMON_WARN=60"}, []string{"--warn-threshold", "75"}, 75},
+	}
+	warn, crit := 50.0, 95.0
+	fileConfig := FileConfig{Thresholds: []ThresholdRule{{Name: "data", Match: "/data", Warn: &warn, Crit: &crit}}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Config{Location: time.UTC}
+			fs := envFlags(&config)
+			for _, kv := range tt.env {
+				k, v, _ := strings.Cut(kv, "=")
+				t.Setenv(k, v)
+			}
+			if err := applyEnv(fs); err != nil {
+				t.Fatal(err)
+			}
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			list := []FS{{Mount: "/data"}, {Mount: "/srv"}}
+			applyRules(list, fileConfig, config, time.Now())
+			if got := blockThresholds(list[0], config).Warn; got != warn {
+				t.Errorf("/data warn = %g, want the rule's %g", got, warn)
+			}
+			if got := blockThresholds(list[1], config).Warn; got != tt.want {
+				t.Errorf("/srv warn = %g, want %g", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestEnvInvalidExits checks dfmon refuses to start on a variable it
+// cannot parse rather than running with the default.
+func TestEnvInvalidExits(t *testing.T) {
+	dfmon := buildDfmon(t)
+	cmd := exec.Command(dfmon, "-config", emptyConfig(t), "-container", "no")
+	cmd.Env = append(withoutDfmonEnv(), "DFMON_CRIT=ninety")
+	out, err := cmd.CombinedOutput()
+	if exit, ok := err.(*exec.ExitError); !ok || exit.ExitCode() != 2 {
+		t.Errorf("err = %v, want exit status 2", err)
+	}
+	if !strings.Contains(string(out), `dfmon: invalid environment: DFMON_CRIT="ninety"`) {
+		t.Errorf("output:\n%s", out)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"syscall"
+)
+
+// The causes a collection can fail for, to test with errors.Is. Failures
+// on one mount come as a *MountError wrapping both the cause and the
+// underlying error, so errors.Is also matches e.g. syscall.ESTALE.
+var (
+	errMountGone   = errors.New("mount is gone")
+	errPermission  = errors.New("permission denied")
+	errTimeout     = errors.New("timed out")
+	errUnsupported = fmt.Errorf("live collection is only supported on Linux, not %s", runtime.GOOS)
+)
+
+// MountError is a failure of operation Op on the mount at Mount.
+type MountError struct {
+	Op    string
+	Mount string
+	Err   error
+}
+
+func (e *MountError) Error() string {
+	return fmt.Sprintf("%s %s: %v", e.Op, e.Mount, e.Err)
+}
+
+func (e *MountError) Unwrap() []error {
+	if cause := errorCause(e.Err); cause != nil {
+		return []error{cause, e.Err}
+	}
+	return []error{e.Err}
+}
+
+// errorCause maps an error from statfs or a collector to the cause it
+// stands for, or nil if it is none of them.
+func errorCause(err error) error {
+	switch {
+	case errors.Is(err, syscall.ENOENT), errors.Is(err, syscall.ENOTDIR),
+		errors.Is(err, syscall.ESTALE), errors.Is(err, syscall.ENOTCONN), errors.Is(err, syscall.ENODEV):
+		return errMountGone
+	case errors.Is(err, syscall.EACCES), errors.Is(err, syscall.EPERM):
+		return errPermission
+	case errors.Is(err, syscall.ETIMEDOUT), errors.Is(err, context.DeadlineExceeded):
+		return errTimeout
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"syscall"
+	"testing"
+)
+
+func TestErrorCause(t *testing.T) {
+	tests := []struct {
+		err  error
+		want error
+	}{
+		{syscall.ENOENT, errMountGone},
+		{syscall.ENOTDIR, errMountGone},
+		{syscall.ESTALE, errMountGone},
+		{syscall.ENOTCONN, errMountGone},
+		{syscall.ENODEV, errMountGone},
+		{syscall.EACCES, errPermission},
+		{syscall.EPERM, errPermission},
+		{syscall.ETIMEDOUT, errTimeout},
+		{context.DeadlineExceeded, errTimeout},
+		{&fs.PathError{Op: "statfs", Path: "/mnt", Err: syscall.ESTALE}, errMountGone},
+		{fmt.Errorf("upperdir /x: %w", syscall.EACCES), errPermission},
+		{syscall.EIO, nil},
+		{errors.New("something else"), nil},
+		{nil, nil},
+	}
+	for _, tt := range tests {
+		if got := errorCause(tt.err); got != tt.want {
+			t.Errorf("errorCause(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+// TestMountErrorWrapping checks errors.Is and errors.As through the layers
+// a *MountError passes on its way to a caller.
+func TestMountErrorWrapping(t *testing.T) {
+	base := &MountError{Op: "statfs", Mount: "/mnt/nfs", Err: syscall.ESTALE}
+	wrapped := fmt.Errorf("collect: %w", fmt.Errorf("analyze: %w", base))
+	joined := errors.Join(errors.New("other"), wrapped)
+
+	for name, err := range map[string]error{"bare": base, "wrapped": wrapped, "joined": joined} {
+		if !errors.Is(err, errMountGone) || !errors.Is(err, syscall.ESTALE) {
+			t.Errorf("%s: not errMountGone and ESTALE: %v", name, err)
+		}
+		if errors.Is(err, errPermission) || errors.Is(err, errTimeout) {
+			t.Errorf("%s: matches another cause: %v", name, err)
+		}
+		var me *MountError
+		if !errors.As(err, &me) || me.Mount != "/mnt/nfs" || me.Op != "statfs" {
+			t.Errorf("%s: errors.As = %+v", name, me)
+		}
+		var errno syscall.Errno
+		if !errors.As(err, &errno) || errno != syscall.ESTALE {
+			t.Errorf("%s: errno %v", name, errno)
+		}
+	}
+	if got := base.Error(); got != "statfs /mnt/nfs: "+syscall.ESTALE.Error() {
+		t.Errorf("Error() = %q", got)
+	}
+
+	// An error with no known cause still unwraps to itself.
+	other := &MountError{Op: "statfs", Mount: "/", Err: syscall.EIO}
+	if !errors.Is(other, syscall.EIO) || errors.Is(other, errMountGone) {
+		t.Errorf("EIO: %v", other)
+	}
+}
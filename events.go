@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event is one line of the -events-file audit log.
+type Event struct {
+	Time       time.Time `json:"time"`
+	Mount      string    `json:"mount"`
+	Device     string    `json:"device"`
+	Identity   string    `json:"identity,omitempty"`
+	Event      string    `json:"event"`
+	Old        string    `json:"old,omitempty"`
+	New        string    `json:"new,omitempty"`
+	Usage      float64   `json:"usage"`
+	InodeUsage float64   `json:"inode_usage"`
+	Warn       float64   `json:"warn"`
+	Crit       float64   `json:"crit"`
+	Rule       string    `json:"rule,omitempty"`
+	// Severity grades a change of statfs flags: "critical" for a
+	// "readonly" event, "ok" for a "readwrite" one done with nothing else,
+	// and "warning" for a "flags" event or a readwrite one that also
+	// changed other flags.
+	Severity string `json:"severity,omitempty"`
+	// Cause is "config-change" for transitions caused by a reloaded
+	// config rather than by the filesystem.
+	Cause string `json:"cause,omitempty"`
+	// Error is the validation error of a "config-error" event.
+	Error string `json:"error,omitempty"`
+	// Period is "hour" or "day" for an "aggregate" record, which stands
+	// for Count compacted records from Time on, Usage being their mean.
+	Period string  `json:"period,omitempty"`
+	Count  int     `json:"count,omitempty"`
+	Min    float64 `json:"min_usage,omitempty"`
+	Max    float64 `json:"max_usage,omitempty"`
+	// Command and Output are the command line and the start of the output
+	// of an "exec" event, whose New is the exit code.
+	Command []string `json:"command,omitempty"`
+	Output  string   `json:"output,omitempty"`
+}
+
+// eventLog appends an Event for every state change between successive
+// collections: status transitions, mounts appearing or disappearing,
+// remounts between read-only and read-write and any other change to a
+// mount's statfs flags. The first collection only establishes the
+// starting state. A config that fails to reload is logged as a
+// "config-error" event. With history limits set, each mount's usage is
+// also recorded every historySampleEvery as a "sample" event, which
+// compaction folds into aggregates.
+type eventLog struct {
+	path string
+	f    *os.File
+	prev map[string]FS
+
+	limits    historyLimits
+	compacted time.Time
+	sampled   time.Time
+}
+
+func openEventLog(path string, limits historyLimits) (*eventLog, error) {
+	unlock, err := lockFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if err := prepareEventLog(path); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &eventLog{path: path, f: f, limits: limits}, nil
+}
+
+// reopen switches to the file now at the log's path if compaction, here
+// or by "dfmon history compact", replaced the one open. The history lock
+// must be held.
+func (l *eventLog) reopen() error {
+	open, err := l.f.Stat()
+	if err != nil {
+		return err
+	}
+	cur, err := os.Stat(l.path)
+	if err != nil || os.SameFile(open, cur) {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	l.f.Close()
+	l.f = f
+	return nil
+}
+
+// maybeCompact compacts the log at most every historyCompactEvery, when
+// limits are set.
+func (l *eventLog) maybeCompact(now time.Time) error {
+	if !l.limits.enabled() || now.Sub(l.compacted) < historyCompactEvery {
+		return nil
+	}
+	l.compacted = now
+	_, _, err := compactHistory(l.path, l.limits, now)
+	return err
+}
+
+func eventState(d FS, config Config) string {
+	switch {
+	case d.Denied:
+		return "denied"
+	case d.Ignored:
+		return "ignored"
+	case muted(d):
+		return "muted"
+	}
+	return fsStatus(d, config).String()
+}
+
+// observe records the changes since the previous call, tagged with cause
+// if it is not empty.
+func (l *eventLog) observe(list []FS, config Config, now time.Time, cause string) error {
+	cur := make(map[string]FS, len(list))
+	for _, d := range list {
+		cur[d.Mount] = d
+	}
+	if l.prev == nil {
+		l.prev = cur
+		return l.write(l.samples(list, config, now))
+	}
+
+	var events []Event
+	for _, d := range list {
+		p, ok := l.prev[d.Mount]
+		if !ok {
+			events = append(events, newEvent(now, d, "appeared", "", eventState(d, config), config))
+			continue
+		}
+		if old, s := eventState(p, config), eventState(d, config); old != s {
+			events = append(events, newEvent(now, d, "status", old, s, config))
+		}
+		if r := resized(d, p.Total); r != nil && !p.Denied {
+			events = append(events, newEvent(now, d, "resized", strconv.FormatUint(r.Old, 10), strconv.FormatUint(r.New, 10), config))
+		}
+		if old, new, severity, ok := flagChange(p, d); ok {
+			e := newEvent(now, d, flagEvent(p, d), old, new, config)
+			e.Severity = severity
+			events = append(events, e)
+		}
+	}
+	for mount, p := range l.prev {
+		if _, ok := cur[mount]; !ok {
+			events = append(events, newEvent(now, p, "disappeared", eventState(p, config), "", config))
+		}
+	}
+	l.prev = cur
+	for i := range events {
+		events[i].Cause = cause
+	}
+	return l.write(append(events, l.samples(list, config, now)...))
+}
+
+// samples returns a "sample" event for each mount that is neither denied
+// nor ignored, if the log is compacted and historySampleEvery has passed
+// since the last.
+func (l *eventLog) samples(list []FS, config Config, now time.Time) []Event {
+	if !l.limits.enabled() || now.Sub(l.sampled) < historySampleEvery {
+		return nil
+	}
+	l.sampled = now
+	var events []Event
+	for _, d := range list {
+		if !d.Denied && !d.Ignored {
+			events = append(events, newEvent(now, d, "sample", "", "", config))
+		}
+	}
+	return events
+}
+
+func (l *eventLog) configError(now time.Time, err error) error {
+	return l.write([]Event{{Time: now, Event: "config-error", Error: err.Error()}})
+}
+
+func (l *eventLog) write(events []Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	unlock, err := lockFile(l.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := l.reopen(); err != nil {
+		return err
+	}
+	for _, e := range events {
+		// One write per record: O_APPEND keeps it whole even if dfmon is
+		// killed straight after.
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if _, err := l.f.Write(append(b, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newEvent(now time.Time, d FS, kind, old, new string, config Config) Event {
+	t := blockThresholds(d, config)
+	e := Event{
+		Time: now, Mount: d.Mount, Device: d.Device, Identity: d.Identity, Event: kind, Old: old, New: new,
+		Usage: d.Usage, InodeUsage: d.InodeUsage, Warn: t.Warn, Crit: t.Crit,
+	}
+	if d.Thresholds != nil {
+		e.Rule = d.Thresholds.Rule
+	}
+	return e
+}
+
+// runEvents implements "dfmon events": it filters an -events-file and
+// its -history-segments, e.g. "dfmon events -mount /var -to critical
+// -first FILE" answers when /var first went critical.
+func runEvents(ctx context.Context, config Config, args []string, logger *log.Logger) {
+	flags := flag.NewFlagSet("events", flag.ExitOnError)
+	mount := flags.String("mount", "", "Only events for mounts matching this glob")
+	to := flags.String("to", "", "Only events entering this state (ok, warning, critical, ...)")
+	kind := flags.String("event", "", "Only events of this kind (status, appeared, disappeared, resized, readonly, readwrite, flags, sample, aggregate, exec)")
+	first := flags.Bool("first", false, "Only the first matching event per mount")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: dfmon events [-mount GLOB] [-to STATE] [-event KIND] [-first] FILE")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	// The segments history compaction moved records to come first, oldest
+	// first, so -first finds the first event of all.
+	files := []string{flags.Arg(0)}
+	if flags.Arg(0) != "-" {
+		files = append(historySegments(flags.Arg(0)), files...)
+	}
+	seen := map[string]bool{}
+	for _, name := range files {
+		err := scanEvents(name, logger, func(e Event) {
+			if ok, _ := filepath.Match(*mount, e.Mount); *mount != "" && !ok {
+				return
+			}
+			if *to != "" && e.New != *to || *kind != "" && e.Event != *kind {
+				return
+			}
+			if *first {
+				if seen[e.Mount] {
+					return
+				}
+				seen[e.Mount] = true
+			}
+			printEvent(e, config)
+		})
+		if err != nil {
+			logger.Fatalf("Failed to read events: %v", err)
+		}
+	}
+}
+
+// scanEvents calls fn for each record of the events file name, which may
+// be compressed, warning of the lines it cannot parse.
+func scanEvents(name string, logger *log.Logger, fn func(Event)) error {
+	b, err := readInput(name)
+	if err != nil {
+		return err
+	}
+	// A crash mid-write can leave the last line unterminated.
+	truncated := 0
+	if len(b) > 0 && b[len(b)-1] != '\n' {
+		truncated = bytes.Count(b, []byte("\n")) + 1
+	}
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for line := 1; sc.Scan(); line++ {
+		if line == 1 {
+			if version, ok := parseEventsHeader(sc.Bytes()); ok {
+				if version > eventsVersion {
+					return newerVersion(name, "events file", version, eventsVersion)
+				}
+				continue
+			}
+		}
+		var e Event
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			if line == truncated {
+				logger.Printf("Warning: %s:%d: skipping truncated last line", name, line)
+				continue
+			}
+			logger.Printf("Warning: %s:%d: %v", name, line, err)
+			continue
+		}
+		fn(e)
+	}
+	return sc.Err()
+}
+
+func printEvent(e Event, config Config) {
+	when := e.Time.In(config.Location).Format(time.RFC3339)
+	if e.Event == "config-error" {
+		fmt.Printf("%s config-error: %s\n", when, sanitize(e.Error))
+		return
+	}
+	if e.Event == "exec" {
+		result := "exit " + e.New
+		if e.Error != "" {
+			result += ": " + sanitize(e.Error)
+		}
+		fmt.Printf("%s %s exec %s rule=%s (usage %.2f%%): %s\n",
+			when, sanitize(e.Mount), sanitize(strings.Join(e.Command, " ")), e.Rule, e.Usage, result)
+		if e.Output != "" {
+			fmt.Printf("    %s\n", strings.ReplaceAll(sanitize(strings.TrimRight(e.Output, "\n")), "\n", "\n    "))
+		}
+		return
+	}
+	if e.Event == "aggregate" {
+		fmt.Printf("%s %s aggregate of %d per %s (usage min %.2f%%, mean %.2f%%, max %.2f%%)\n",
+			when, sanitize(e.Mount), e.Count, e.Period, e.Min, e.Usage, e.Max)
+		return
+	}
+	change := e.Event
+	old, new := e.Old, e.New
+	if e.Event == "resized" {
+		old, new = sizeString(old), sizeString(new)
+	}
+	if old != "" || new != "" {
+		change = fmt.Sprintf("%s %s -> %s", e.Event, orDash(old), orDash(new))
+	}
+	rule := ""
+	if e.Rule != "" {
+		rule = " rule=" + e.Rule
+	}
+	if e.Severity != "" {
+		rule += " severity=" + e.Severity
+	}
+	if e.Cause != "" {
+		rule += " cause=" + e.Cause
+	}
+	fmt.Printf("%s %s %s (usage %.2f%%, inodes %.2f%%, warn %g, crit %g%s)\n",
+		when, sanitize(e.Mount), change, e.Usage, e.InodeUsage, e.Warn, e.Crit, rule)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
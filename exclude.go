@@ -0,0 +1,86 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/AScotM/filesystem_cap/fscap/mountinfo"
+)
+
+// defaultExcludes are the mounts left out unless -x replaces the list. An
+// entry is a filesystem type, or type:dir to exclude that type only at or
+// below dir.
+var defaultExcludes = []string{
+	"proc", "sysfs", "devtmpfs", "tmpfs", "cgroup", "cgroup2", "devpts",
+	"squashfs", "nsfs", "tracefs", "bpf", "ramfs",
+	// Container runtimes mount an overlay rootfs per container, including
+	// each pod's pause container.
+	"overlay:/var/lib/docker", "overlay:/var/lib/containers",
+	"overlay:/run/containerd", "overlay:/run/k3s",
+}
+
+// containerExcludes also hide the host pseudo filesystems that leak into a
+// container's mount table, leaving the rootfs and volumes.
+var containerExcludes = append(append([]string{}, defaultExcludes...),
+	"mqueue", "shm", "securityfs", "debugfs", "pstore", "fusectl",
+	"configfs", "autofs", "binfmt_misc", "hugetlbfs")
+
+// excludeSet applies -x+ and -x- to base. Removing a bare type also drops
+// its type:dir entries.
+func excludeSet(base, add, remove []string) []string {
+	drop := map[string]bool{}
+	for _, r := range remove {
+		drop[r] = true
+	}
+	out := []string{}
+	seen := map[string]bool{}
+	for _, e := range append(append([]string{}, base...), add...) {
+		typ, _, _ := strings.Cut(e, ":")
+		if e == "" || seen[e] || drop[e] || drop[typ] {
+			continue
+		}
+		seen[e] = true
+		out = append(out, e)
+	}
+	return out
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var out []string
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// exclusion returns the exclude entry matching m, or "" if none does.
+func exclusion(m mountinfo.Mount, excludes []string) string {
+	for _, e := range excludes {
+		typ, dir, scoped := strings.Cut(e, ":")
+		if typ != m.FSType {
+			continue
+		}
+		if !scoped {
+			return e
+		}
+		if rel, err := filepath.Rel(dir, m.MountPoint); err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return e
+		}
+	}
+	return ""
+}
+
+func filterMounts(mounts []mountinfo.Mount, excludes []string, tr *trace) []mountinfo.Mount {
+	var filtered []mountinfo.Mount
+	for _, m := range mounts {
+		if e := exclusion(m, excludes); e != "" {
+			tr.removed(m.ID, m.MountPoint, "exclude", "type %s matches exclude entry %q", m.FSType, e)
+			continue
+		}
+		filtered = append(filtered, m)
+	}
+	return filtered
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/AScotM/filesystem_cap/fscap/mountinfo"
+)
+
+func TestExcludeSet(t *testing.T) {
+	base := []string{"proc", "tmpfs", "overlay:/var/lib/docker", "overlay:/run/k3s"}
+	tests := []struct {
+		name        string
+		add, remove []string
+		want        string
+	}{
+		{"defaults", nil, nil, "proc,tmpfs,overlay:/var/lib/docker,overlay:/run/k3s"},
+		{"added", []string{"fuse.sshfs", "nfs"}, nil, "proc,tmpfs,overlay:/var/lib/docker,overlay:/run/k3s,fuse.sshfs,nfs"},
+		{"already there", []string{"tmpfs"}, nil, "proc,tmpfs,overlay:/var/lib/docker,overlay:/run/k3s"},
+		{"removed", nil, []string{"tmpfs"}, "proc,overlay:/var/lib/docker,overlay:/run/k3s"},
+		{"removed scoped", nil, []string{"overlay:/run/k3s"}, "proc,tmpfs,overlay:/var/lib/docker"},
+		// A bare type takes its scoped entries with it.
+		{"removed type", nil, []string{"overlay"}, "proc,tmpfs"},
+		{"not there", nil, []string{"zfs"}, "proc,tmpfs,overlay:/var/lib/docker,overlay:/run/k3s"},
+		// Removal wins over addition.
+		{"both", []string{"nfs"}, []string{"nfs", "proc"}, "tmpfs,overlay:/var/lib/docker,overlay:/run/k3s"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(excludeSet(base, tt.add, tt.remove), ","); got != tt.want {
+			t.Errorf("%s: %s, want %s", tt.name, got, tt.want)
+		}
+	}
+	if got := excludeSet(nil, nil, nil); got == nil || len(got) != 0 {
+		t.Errorf("empty set = %#v, want an empty list", got)
+	}
+}
+
+// TestDefaultExcludes checks the defaults hide modern pseudo filesystems
+// and container overlays, but not an overlay root.
+func TestDefaultExcludes(t *testing.T) {
+	for _, m := range []mountinfo.Mount{
+		{FSType: "squashfs", MountPoint: "/snap/core/1"},
+		{FSType: "nsfs", MountPoint: "/run/netns/a"},
+		{FSType: "tracefs", MountPoint: "/sys/kernel/tracing"},
+		{FSType: "bpf", MountPoint: "/sys/fs/bpf"},
+		{FSType: "ramfs", MountPoint: "/run/credentials/a"},
+		{FSType: "overlay", MountPoint: "/run/containerd/io.containerd.runtime.v2.task/k8s.io/pause/rootfs"},
+		{FSType: "overlay", MountPoint: "/var/lib/containers/storage/overlay/abc/merged"},
+	} {
+		if exclusion(m, defaultExcludes) == "" {
+			t.Errorf("%s on %s is not excluded by default", m.FSType, m.MountPoint)
+		}
+	}
+	if e := exclusion(mountinfo.Mount{FSType: "overlay", MountPoint: "/"}, defaultExcludes); e != "" {
+		t.Errorf("overlay root excluded by %q", e)
+	}
+}
+
+func TestExclusion(t *testing.T) {
+	excludes := []string{"proc", "overlay:/var/lib/docker"}
+	tests := []struct {
+		fsType, mount string
+		want          string
+	}{
+		{"proc", "/proc", "proc"},
+		{"ext4", "/proc", ""},
+		{"overlay", "/var/lib/docker", "overlay:/var/lib/docker"},
+		{"overlay", "/var/lib/docker/overlay2/abc/merged", "overlay:/var/lib/docker"},
+		{"overlay", "/var/lib/dockerd/merged", ""},
+		{"overlay", "/", ""},
+		{"overlay", "/var/lib", ""},
+		{"ext4", "/var/lib/docker", ""},
+	}
+	for _, tt := range tests {
+		if got := exclusion(mountinfo.Mount{FSType: tt.fsType, MountPoint: tt.mount}, excludes); got != tt.want {
+			t.Errorf("%s on %s: exclusion %q, want %q", tt.fsType, tt.mount, got, tt.want)
+		}
+	}
+}
+
+// TestExcludeFlags checks how -x, -x+ and -x- compose, through the
+// exclude_types the JSON output reports.
+func TestExcludeFlags(t *testing.T) {
+	exe := buildDfmon(t)
+	defaults := strings.Join(defaultExcludes, ",")
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, defaults},
+		{[]string{"-x+", "nfs, cifs"}, defaults + ",nfs,cifs"},
+		{[]string{"-x-", "tmpfs,overlay"}, strings.Join(excludeSet(defaultExcludes, nil, []string{"tmpfs", "overlay"}), ",")},
+		{[]string{"-x", "proc,sysfs"}, "proc,sysfs"},
+		{[]string{"-x", "proc,sysfs", "-x+", "nfs", "-x-", "proc"}, "sysfs,nfs"},
+		{[]string{"-x", ""}, ""},
+		{[]string{"-container", "yes"}, strings.Join(containerExcludes, ",")},
+		{[]string{"-container", "yes", "-x-", "autofs"}, strings.Join(excludeSet(containerExcludes, nil, []string{"autofs"}), ",")},
+	}
+	for _, tt := range tests {
+		args := append(append([]string{"-config", emptyConfig(t), "-container", "no", "-o", "json"}, quietThresholds...), tt.args...)
+		cmd := exec.Command(exe, args...)
+		cmd.Env = withoutDfmonEnv()
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("%v: %v", tt.args, err)
+		}
+		var r struct {
+			ExcludeTypes []string `json:"exclude_types"`
+		}
+		if err := json.Unmarshal(out, &r); err != nil {
+			t.Fatalf("%v: %v", tt.args, err)
+		}
+		if got := strings.Join(r.ExcludeTypes, ","); got != tt.want {
+			t.Errorf("%v: exclude_types %s, want %s", tt.args, got, tt.want)
+		}
+	}
+}
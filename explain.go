@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/AScotM/filesystem_cap/fscap/mountinfo"
+)
+
+// traceStages are the stages of collect that can drop or mark a mount, in
+// the order they run.
+var traceStages = []string{"exclude", "fuse", "file-bind", "schedule", "statfs", "ignore", "dedup"}
+
+// Decision is what one stage of collect did with a mount. Action is
+// "removed" when the stage dropped it, "kept" when it was kept with a
+// note, or "passed".
+type Decision struct {
+	Stage  string `json:"stage"`
+	Action string `json:"action"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// trace records the decisions collect makes about each mount, by mount
+// ID, instead of dropping entries silently. Removals are also logged when
+// a logger is set. A nil *trace records nothing.
+type trace struct {
+	mu     sync.Mutex
+	byID   map[int][]Decision
+	logger *log.Logger
+}
+
+func newTrace(logger *log.Logger) *trace {
+	return &trace{byID: map[int][]Decision{}, logger: logger}
+}
+
+func (t *trace) removed(id int, mount, stage, format string, args ...any) {
+	t.add(id, mount, Decision{Stage: stage, Action: "removed", Detail: fmt.Sprintf(format, args...)})
+}
+
+func (t *trace) kept(id int, mount, stage, format string, args ...any) {
+	t.add(id, mount, Decision{Stage: stage, Action: "kept", Detail: fmt.Sprintf(format, args...)})
+}
+
+func (t *trace) add(id int, mount string, d Decision) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.byID[id] = append(t.byID[id], d)
+	if t.logger != nil && d.Action == "removed" {
+		t.logger.Printf("Skipping %s at %s: %s", mount, d.Stage, d.Detail)
+	}
+}
+
+// decisions returns one decision per stage for the mount, "passed" where
+// the stage recorded nothing, up to the stage that removed it.
+func (t *trace) decisions(id int) []Decision {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var out []Decision
+	for _, stage := range traceStages {
+		d := Decision{Stage: stage, Action: "passed"}
+		for _, r := range t.byID[id] {
+			if r.Stage == stage {
+				d = r
+			}
+		}
+		out = append(out, d)
+		if d.Action == "removed" {
+			break
+		}
+	}
+	return out
+}
+
+// Explanation is the -explain result for one mount.
+type Explanation struct {
+	Path      string     `json:"path"`
+	Mount     string     `json:"mount"`
+	Device    string     `json:"device"`
+	Type      string     `json:"type"`
+	Decisions []Decision `json:"decisions"`
+	// Outcome is "included" or "removed by STAGE".
+	Outcome string `json:"outcome"`
+}
+
+// runExplain implements -explain: it runs a full collection while tracing
+// it and tells, for the mount at or containing path, which stage kept or
+// removed it.
+func runExplain(ctx context.Context, config Config, fileConfig FileConfig, logger *log.Logger) {
+	path, err := filepath.Abs(config.Explain)
+	if err != nil {
+		logger.Fatalf("Invalid -explain: %v", err)
+	}
+	mounts, err := readMounts()
+	if err != nil {
+		logger.Fatalf("Failed to read mounts: %v", err)
+	}
+	targets := explainTargets(mounts, path)
+	if len(targets) == 0 {
+		logger.Fatalf("No mount contains %s", path)
+	}
+
+	config.Trace = newTrace(nil)
+	r, err := collect(ctx, config, fileConfig, logger)
+	if err != nil {
+		logger.Fatalf("Failed to collect: %v", err)
+	}
+	ignored := map[int]bool{}
+	for _, d := range r.Filesystems {
+		for _, c := range append([]FS{d}, d.Children...) {
+			if c.Ignored {
+				ignored[c.ID] = true
+			}
+		}
+	}
+
+	var out []Explanation
+	for _, m := range targets {
+		if ignored[m.ID] {
+			config.Trace.kept(m.ID, m.MountPoint, "ignore", "matches an ignore rule; listed but not evaluated")
+		}
+		e := Explanation{Path: path, Mount: m.MountPoint, Device: m.Source, Type: m.FSType,
+			Decisions: config.Trace.decisions(m.ID), Outcome: "included"}
+		if last := e.Decisions[len(e.Decisions)-1]; last.Action == "removed" {
+			e.Outcome = "removed by " + last.Stage
+		}
+		out = append(out, e)
+	}
+
+	if config.OutputFormat == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(out); err != nil {
+			logger.Fatalf("Failed to write explanation: %v", err)
+		}
+		return
+	}
+	for i, e := range out {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%s: %s (%s on %s): %s\n", sanitize(e.Path), sanitize(e.Mount), e.Type, sanitize(e.Device), e.Outcome)
+		for _, d := range e.Decisions {
+			line := fmt.Sprintf("  %-10s %s", d.Stage, d.Action)
+			if d.Detail != "" {
+				line += ": " + sanitize(d.Detail)
+			}
+			fmt.Println(line)
+		}
+	}
+}
+
+// explainTargets returns the mounts on path, all of them when several are
+// stacked there, or else the innermost mount containing it.
+func explainTargets(mounts []mountinfo.Mount, path string) []mountinfo.Mount {
+	var on []mountinfo.Mount
+	var best mountinfo.Mount
+	found := false
+	for _, m := range mounts {
+		if m.MountPoint == path {
+			on = append(on, m)
+			continue
+		}
+		rel, err := filepath.Rel(m.MountPoint, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			continue
+		}
+		// Later entries shadow earlier ones on the same path.
+		if !found || len(m.MountPoint) >= len(best.MountPoint) {
+			best, found = m, true
+		}
+	}
+	if len(on) > 0 || !found {
+		return on
+	}
+	return []mountinfo.Mount{best}
+}
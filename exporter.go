@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AScotM/filesystem_cap/fscap/hostid"
+)
+
+var errBusy = errors.New("too many scrapes waiting for a collection")
+
+// exporter serves the collected filesystems as Prometheus metrics.
+// Concurrent scrapes share one in-progress collection, and the result is
+// reused for ttl so that frequent or replicated scrapers do not multiply
+// statfs calls. At most cap(waiting) scrapes may wait for a collection at
+// once; the rest are rejected with 503.
+type exporter struct {
+	collect func(context.Context) (Report, error)
+	config  Config
+	ttl     time.Duration
+	waiting chan struct{}
+
+	mu       sync.Mutex
+	cached   Report
+	cachedAt time.Time
+	flight   *flight
+	lastOK   bool
+	ready    bool
+
+	hits        atomic.Uint64
+	misses      atomic.Uint64
+	rejected    atomic.Uint64
+	collections atomic.Uint64
+}
+
+type flight struct {
+	done  chan struct{}
+	start time.Time
+	r     Report
+	err   error
+}
+
+func newExporter(collect func(context.Context) (Report, error), config Config) *exporter {
+	return &exporter{
+		collect: collect,
+		config:  config,
+		ttl:     config.CacheTTL,
+		waiting: make(chan struct{}, max(config.MaxCollections, 1)),
+	}
+}
+
+// get returns a cached report if it is fresh, otherwise joins or starts a
+// collection. The collection itself does not use ctx, so a scraper that
+// gives up does not fail the others sharing it.
+func (e *exporter) get(ctx context.Context) (Report, error) {
+	e.mu.Lock()
+	if !e.cachedAt.IsZero() && time.Since(e.cachedAt) < e.ttl {
+		r := e.cached
+		e.mu.Unlock()
+		e.hits.Add(1)
+		return r, nil
+	}
+	e.misses.Add(1)
+
+	select {
+	case e.waiting <- struct{}{}:
+	default:
+		e.mu.Unlock()
+		e.rejected.Add(1)
+		return Report{}, errBusy
+	}
+	defer func() { <-e.waiting }()
+
+	f := e.flight
+	if f == nil {
+		f = &flight{done: make(chan struct{}), start: time.Now()}
+		e.flight = f
+		go e.run(f)
+	}
+	e.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.r, f.err
+	case <-ctx.Done():
+		return Report{}, ctx.Err()
+	}
+}
+
+func (e *exporter) run(f *flight) {
+	e.collections.Add(1)
+	start := time.Now()
+	f.r, f.err = e.collect(context.Background())
+	stats.finished(start, f.err)
+
+	e.mu.Lock()
+	if f.err == nil {
+		e.cached, e.cachedAt = f.r, time.Now()
+	}
+	e.flight = nil
+	e.lastOK = f.err == nil
+	notify := e.lastOK && !e.ready
+	e.ready = e.ready || notify
+	e.mu.Unlock()
+	close(f.done)
+	if notify {
+		sdNotify("READY=1")
+	}
+}
+
+// petWatchdog pets the systemd watchdog every half timeout while the last
+// collection succeeded and none has been running for the whole timeout,
+// so a mount that wedges the collection gets the exporter restarted.
+func (e *exporter) petWatchdog(ctx context.Context, timeout time.Duration) {
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		e.mu.Lock()
+		ok := e.lastOK && (e.flight == nil || time.Since(e.flight.start) < timeout)
+		e.mu.Unlock()
+		if ok {
+			sdNotify("WATCHDOG=1")
+		}
+	}
+}
+
+func (e *exporter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r, err := e.get(req.Context())
+	if errors.Is(err, errBusy) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(e.ttl.Seconds()))+1))
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	e.writeMetrics(w, r)
+}
+
+// metric is a per-filesystem gauge; with has, only the filesystems it
+// holds for get a series. Denied filesystems, whose numbers are zeros
+// rather than measurements, never get one.
+type metric struct {
+	name, help string
+	value      func(FS) float64
+	has        func(FS) bool
+}
+
+func hasInodes(d FS) bool { return d.Inodes > 0 }
+
+var fsMetrics = []metric{
+	{"dfmon_filesystem_size_bytes", "Filesystem size in bytes.", func(d FS) float64 { return float64(d.Total) }, nil},
+	{"dfmon_filesystem_used_bytes", "Filesystem used bytes.", func(d FS) float64 { return float64(d.Used) }, nil},
+	{"dfmon_filesystem_free_bytes", "Filesystem bytes available to unprivileged users.", func(d FS) float64 { return float64(d.Free) }, nil},
+	{"dfmon_filesystem_usage_percent", "Filesystem block usage in percent.", func(d FS) float64 { return d.Usage }, nil},
+	{"dfmon_filesystem_inodes", "Filesystem inode count.", func(d FS) float64 { return float64(d.Inodes) }, hasInodes},
+	{"dfmon_filesystem_inodes_free", "Filesystem free inodes.", func(d FS) float64 { return float64(d.InodesFree) }, hasInodes},
+	{"dfmon_filesystem_inode_state", "Inode accounting (1 available, 0 not applicable, -1 unknown).", func(d FS) float64 { return inodeStateValue[d.InodeState] },
+		func(d FS) bool { return d.InodeState != "" }},
+}
+
+func (e *exporter) writeMetrics(w http.ResponseWriter, r Report) {
+	var sb strings.Builder
+	e.writeFSMetrics(&sb, []fsSeries{{r: r}})
+
+	counters := []struct {
+		name, help string
+		v          uint64
+	}{
+		{"dfmon_cache_hits_total", "Scrapes served from the collection cache.", e.hits.Load()},
+		{"dfmon_cache_misses_total", "Scrapes that waited for a collection.", e.misses.Load()},
+		{"dfmon_scrapes_rejected_total", "Scrapes rejected because too many were waiting.", e.rejected.Load()},
+		{"dfmon_collections_total", "Collections run.", e.collections.Load()},
+	}
+	for _, c := range counters {
+		fmt.Fprintf(&sb, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", c.name, c.help, c.name, c.name, c.v)
+	}
+	writeBuildInfo(&sb)
+	host := hostid.Get()
+	fmt.Fprintf(&sb, "# HELP dfmon_host_info The identity of this host in dfmon's reports and alerts.\n# TYPE dfmon_host_info gauge\n")
+	fmt.Fprintf(&sb, "dfmon_host_info{host=\"%s\",machine_id=\"%s\"} 1\n", labelEscaper.Replace(host.Name), labelEscaper.Replace(host.MachineID))
+	if e.config.SelfMetrics {
+		e.writeSelfMetrics(&sb)
+	}
+	w.Write([]byte(sb.String()))
+}
+
+// writeBuildInfo writes dfmon_build_info, carrying what -version prints
+// as labels.
+func writeBuildInfo(sb *strings.Builder) {
+	bi := buildInfo()
+	fmt.Fprintf(sb, "# HELP dfmon_build_info The version of dfmon serving the metrics.\n# TYPE dfmon_build_info gauge\n")
+	fmt.Fprintf(sb, "dfmon_build_info{version=\"%s\",commit=\"%s\",build_date=\"%s\",go_version=\"%s\"} 1\n",
+		labelEscaper.Replace(bi.Version), labelEscaper.Replace(bi.Commit), labelEscaper.Replace(bi.Date), labelEscaper.Replace(bi.GoVersion))
+}
+
+// fsSeries is the report of one host for writeFSMetrics. Host is empty
+// for the exporter's own filesystems and becomes a host label otherwise.
+type fsSeries struct {
+	host string
+	r    Report
+}
+
+// writeFSMetrics appends the per-filesystem series of each report.
+func (e *exporter) writeFSMetrics(sb *strings.Builder, series []fsSeries) {
+	labels := make([]map[string]string, len(series))
+	for i, s := range series {
+		labels[i] = mountLabels(s.r.Filesystems, e.config.LabelStyle)
+	}
+	for _, m := range fsMetrics {
+		fmt.Fprintf(sb, "# HELP %s %s\n# TYPE %s gauge\n", m.name, m.help, m.name)
+		for i, s := range series {
+			for _, d := range s.r.Filesystems {
+				if !d.Denied && (m.has == nil || m.has(d)) {
+					fmt.Fprintf(sb, "%s{%s} %g\n", m.name, e.seriesLabels(s.host, d, labels[i]), m.value(d))
+				}
+			}
+		}
+	}
+
+	fmt.Fprintf(sb, "# HELP dfmon_filesystem_denied Whether the filesystem could not be stat'ed for lack of permission (1) or was (0).\n# TYPE dfmon_filesystem_denied gauge\n")
+	for i, s := range series {
+		for _, d := range s.r.Filesystems {
+			denied := 0
+			if d.Denied {
+				denied = 1
+			}
+			fmt.Fprintf(sb, "dfmon_filesystem_denied{%s} %d\n", e.seriesLabels(s.host, d, labels[i]), denied)
+		}
+	}
+
+	fmt.Fprintf(sb, "# HELP dfmon_filesystem_status Threshold status (0 ok, 1 warning, 2 critical).\n# TYPE dfmon_filesystem_status gauge\n")
+	for i, s := range series {
+		for _, d := range s.r.Filesystems {
+			if d.Denied {
+				continue
+			}
+			status := StatusOK
+			if !d.Ignored && !muted(d) {
+				status = fsStatus(d, e.config)
+			}
+			fmt.Fprintf(sb, "dfmon_filesystem_status{%s} %d\n", e.seriesLabels(s.host, d, labels[i]), status)
+		}
+	}
+}
+
+func (e *exporter) seriesLabels(host string, d FS, mounts map[string]string) string {
+	if host == "" {
+		return e.fsLabels(d, mounts)
+	}
+	return fmt.Sprintf(`host="%s",`, labelEscaper.Replace(host)) + e.fsLabels(d, mounts)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// fsLabels formats d's labels, with the mountpoint from mountLabels and
+// the annotation keys given by -annotation-labels, empty when unset.
+func (e *exporter) fsLabels(d FS, mounts map[string]string) string {
+	s := fmt.Sprintf(`device="%s",mountpoint="%s",fstype="%s"`,
+		labelEscaper.Replace(d.Device), labelEscaper.Replace(mounts[d.Mount]), labelEscaper.Replace(d.Type))
+	for _, k := range e.config.AnnotationLabels {
+		s += fmt.Sprintf(`,%s="%s"`, k, labelEscaper.Replace(d.Annotations[k]))
+	}
+	return s
+}
+
+// invalidate drops the cached collection, so the next scrape sees a
+// reloaded config.
+func (e *exporter) invalidate() {
+	e.mu.Lock()
+	e.cachedAt = time.Time{}
+	e.mu.Unlock()
+}
+
+// serveFilesystems writes the -o json envelope of the current collection,
+// for dfmon -aggregate to poll.
+func (e *exporter) serveFilesystems(w http.ResponseWriter, req *http.Request) {
+	r, err := e.get(req.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(envelope(r, e.config))
+}
+
+// runExporter serves /metrics, /v1/filesystems and /v1/capabilities on
+// config.Listen until ctx is cancelled, and /debug/status with
+// -self-metrics. The config file
+// is reloaded on SIGHUP, or on change with -watch-config, without
+// restarting the server.
+func runExporter(ctx context.Context, config Config, fileConfig FileConfig, logger *log.Logger) {
+	var current atomic.Pointer[FileConfig]
+	current.Store(&fileConfig)
+	e := newExporter(func(ctx context.Context) (Report, error) {
+		return collect(ctx, config, *current.Load(), logger)
+	}, config)
+
+	changes := configChanges(ctx, config)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-changes:
+			}
+			if fc, err := reloadConfig(*current.Load(), config, logger); err == nil {
+				current.Store(&fc)
+				e.invalidate()
+			}
+		}
+	}()
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+	mux.HandleFunc("/v1/filesystems", e.serveFilesystems)
+	mux.HandleFunc("/v1/capabilities", serveCapabilities)
+	if config.SelfMetrics {
+		mux.HandleFunc("/debug/status", e.serveStatus)
+	}
+	srv, err := listenHTTP(ctx, config, mux, logger)
+	if err != nil {
+		logger.Fatalf("Failed to listen: %v", err)
+	}
+
+	// Readiness is sent by the first collection that succeeds. This one
+	// also primes the cache for the first scrape; if it fails, the next
+	// scrape tries again.
+	if _, err := e.get(ctx); err != nil {
+		logger.Printf("Warning: failed to collect: %v", err)
+	}
+	if wd := sdWatchdogInterval(); wd > 0 {
+		go e.petWatchdog(ctx, wd)
+	}
+	defer sdNotify("STOPPING=1")
+	if err := srv.serve(); err != nil {
+		logger.Fatalf("Exporter failed: %v", err)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestBuildInfoMetric(t *testing.T) {
+	e := newExporter(nil, Config{})
+	w := httptest.NewRecorder()
+	e.writeMetrics(w, Report{})
+	bi := buildInfo()
+	want := `dfmon_build_info{version="` + bi.Version + `",commit="` + bi.Commit +
+		`",build_date="` + bi.Date + `",go_version="` + bi.GoVersion + `"} 1`
+	if !strings.Contains(w.Body.String(), want+"\n") {
+		t.Errorf("metrics lack %s:\n%s", want, w.Body)
+	}
+	if !strings.Contains(w.Body.String(), "# TYPE dfmon_build_info gauge\n") {
+		t.Error("dfmon_build_info has no TYPE line")
+	}
+}
+
+func TestDeniedMetrics(t *testing.T) {
+	e := newExporter(nil, Config{WarnThreshold: 80, CritThreshold: 90, InodeWarn: 80, InodeCrit: 90})
+	r := Report{Filesystems: []FS{
+		{Device: "/dev/sda1", Mount: "/", Type: "ext4", Total: 100, Used: 50, Free: 50, Usage: 50, Inodes: 10, InodesFree: 5},
+		{Device: "fuse", Mount: "/home/u/.cache/doc", Type: "fuse", Denied: true},
+	}}
+	w := httptest.NewRecorder()
+	e.writeMetrics(w, r)
+	body := w.Body.String()
+
+	ok := `device="/dev/sda1",mountpoint="/",fstype="ext4"`
+	denied := `device="fuse",mountpoint="/home/u/.cache/doc",fstype="fuse"`
+	for _, line := range []string{
+		"dfmon_filesystem_size_bytes{" + ok + "} 100",
+		"dfmon_filesystem_usage_percent{" + ok + "} 50",
+		"dfmon_filesystem_status{" + ok + "} 0",
+		"dfmon_filesystem_denied{" + ok + "} 0",
+		"dfmon_filesystem_denied{" + denied + "} 1",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics lack %s", line)
+		}
+	}
+	for _, l := range strings.Split(body, "\n") {
+		if strings.Contains(l, denied) && !strings.HasPrefix(l, "dfmon_filesystem_denied{") {
+			t.Errorf("denied mount has series %s", l)
+		}
+	}
+}
+
+// TestSingleFlight hammers the handler with 100 concurrent scrapes, which
+// must all be served by one collection and then by the cache.
+func TestSingleFlight(t *testing.T) {
+	const scrapes = 100
+	var calls atomic.Int32
+	release := make(chan struct{})
+	collect := func(context.Context) (Report, error) {
+		calls.Add(1)
+		<-release
+		return Report{Filesystems: []FS{{Device: "/dev/sda1", Mount: "/", Type: "ext4", Total: 100}}}, nil
+	}
+	e := newExporter(collect, Config{CacheTTL: time.Minute, MaxCollections: scrapes})
+
+	var wg sync.WaitGroup
+	codes := make([]int, scrapes)
+	for i := 0; i < scrapes; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			e.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			codes[i] = w.Code
+		}(i)
+	}
+	// Hold the collection until every scrape has joined it.
+	for e.misses.Load() < scrapes {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("%d collections ran for %d concurrent scrapes, want 1", n, scrapes)
+	}
+	for i, c := range codes {
+		if c != http.StatusOK {
+			t.Errorf("scrape %d: status %d", i, c)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if calls.Load() != 1 || e.hits.Load() != 1 {
+		t.Errorf("a scrape within -cache-ttl collected again: %d collections, %d hits", calls.Load(), e.hits.Load())
+	}
+	if !strings.Contains(w.Body.String(), "dfmon_cache_hits_total 1\n") || !strings.Contains(w.Body.String(), "dfmon_cache_misses_total 100\n") {
+		t.Errorf("cache counters:\n%s", w.Body)
+	}
+}
+
+func TestScrapesRejected(t *testing.T) {
+	release := make(chan struct{})
+	collect := func(context.Context) (Report, error) {
+		<-release
+		return Report{}, nil
+	}
+	e := newExporter(collect, Config{CacheTTL: 10 * time.Second, MaxCollections: 1})
+
+	done := make(chan struct{})
+	go func() {
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		close(done)
+	}()
+	for e.misses.Load() < 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "11" {
+		t.Errorf("excess scrape: status %d, Retry-After %q; want 503, 11", w.Code, w.Header().Get("Retry-After"))
+	}
+	if e.rejected.Load() != 1 {
+		t.Errorf("rejected = %d, want 1", e.rejected.Load())
+	}
+	close(release)
+	<-done
+}
+
+// notifySocket listens as systemd's notification socket for the rest of
+// the test and returns the states sent to it.
+func notifySocket(t *testing.T) (path string, states <-chan string) {
+	t.Helper()
+	path = filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("no unixgram sockets: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	ch := make(chan string, 64)
+	go func() {
+		buf := make([]byte, 256)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				close(ch)
+				return
+			}
+			ch <- string(buf[:n])
+		}
+	}()
+	return path, ch
+}
+
+func nextState(t *testing.T, states <-chan string) string {
+	t.Helper()
+	select {
+	case s := <-states:
+		return s
+	case <-time.After(10 * time.Second):
+		t.Fatal("no notification")
+		return ""
+	}
+}
+
+// TestExporterReady checks readiness waits for a collection that succeeds
+// and the watchdog is only petted while the last one did.
+func TestExporterReady(t *testing.T) {
+	path, states := notifySocket(t)
+	t.Setenv("NOTIFY_SOCKET", path)
+	fail := true
+	e := newExporter(func(context.Context) (Report, error) {
+		if fail {
+			return Report{}, errors.New("mount wedged")
+		}
+		return Report{}, nil
+	}, Config{})
+
+	e.get(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.petWatchdog(ctx, 20*time.Millisecond)
+	select {
+	case s := <-states:
+		t.Fatalf("sent %q after a failed collection", s)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	fail = false
+	e.get(context.Background())
+	if s := nextState(t, states); s != "READY=1" {
+		t.Fatalf("first state %q, want READY=1", s)
+	}
+	if s := nextState(t, states); s != "WATCHDOG=1" {
+		t.Fatalf("then %q, want WATCHDOG=1", s)
+	}
+	e.invalidate()
+	e.get(context.Background())
+	if s := nextState(t, states); s != "WATCHDOG=1" {
+		t.Errorf("after a second collection %q, want WATCHDOG=1 only", s)
+	}
+}
+
+func TestExporterNotify(t *testing.T) {
+	dfmon := buildDfmon(t)
+	path, states := notifySocket(t)
+	cmd := exec.Command(dfmon, "-config", emptyConfig(t), "-container", "no", "-listen", "127.0.0.1:0")
+	cmd.Env = append(withoutDfmonEnv(), "NOTIFY_SOCKET="+path, "WATCHDOG_USEC=200000")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+
+	if s := nextState(t, states); s != "READY=1" {
+		t.Fatalf("first state %q, want READY=1", s)
+	}
+	if s := nextState(t, states); s != "WATCHDOG=1" {
+		t.Fatalf("then %q, want WATCHDOG=1", s)
+	}
+	cmd.Process.Signal(syscall.SIGTERM)
+	for {
+		s := nextState(t, states)
+		if s == "STOPPING=1" {
+			break
+		}
+		if s != "WATCHDOG=1" {
+			t.Fatalf("unexpected %q before STOPPING=1", s)
+		}
+	}
+	cmd.Wait()
+}
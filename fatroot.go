@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/AScotM/filesystem_cap/fscap/mountinfo"
+)
+
+// fatTypes are the FAT filesystems, whose root directory holds a fixed
+// number of entries on FAT12 and FAT16, 512 on most media.
+var fatTypes = map[string]bool{"vfat": true, "msdos": true}
+
+// fatMaxDirEntries is the most entries any FAT directory may hold, and
+// so the limit of a FAT32 root directory.
+const fatMaxDirEntries = 65536
+
+// FATRoot is the use of a FAT root directory's entry slots, with
+// -fat-check. Entries is a lower bound: deleted entries and the volume
+// label take slots too.
+type FATRoot struct {
+	Entries int `json:"entries"`
+	Limit   int `json:"limit"`
+}
+
+func (r *FATRoot) Usage() float64 {
+	return float64(r.Entries) / float64(r.Limit) * 100
+}
+
+// fatRootCollector sets FS.FATRoot from the boot sector's root entry
+// count and the names in the root directory. It needs read access to the
+// device.
+type fatRootCollector struct{}
+
+func (fatRootCollector) Name() string                   { return "fatroot" }
+func (fatRootCollector) Applies(m mountinfo.Mount) bool { return fatTypes[m.FSType] }
+func (fatRootCollector) Enrich(ctx context.Context, m mountinfo.Mount, d *FS) error {
+	limit, err := fatRootLimit(m.Source)
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(hostPath(m.MountPoint))
+	if err != nil {
+		return err
+	}
+	root := &FATRoot{Limit: limit}
+	for _, e := range entries {
+		root.Entries += fatSlots(e.Name())
+	}
+	d.FATRoot = root
+	return nil
+}
+
+// fatRootLimit reads BPB_RootEntCnt from the boot sector of device; it is
+// zero on FAT32, whose root directory is an ordinary cluster chain.
+func fatRootLimit(device string) (int, error) {
+	f, err := os.Open(device)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var boot [512]byte
+	if _, err := io.ReadFull(f, boot[:]); err != nil {
+		return 0, fmt.Errorf("%s: boot sector: %v", device, err)
+	}
+	if boot[510] != 0x55 || boot[511] != 0xaa {
+		return 0, errors.New(device + ": no FAT boot sector")
+	}
+	if n := binary.LittleEndian.Uint16(boot[17:19]); n > 0 {
+		return int(n), nil
+	}
+	return fatMaxDirEntries, nil
+}
+
+// fatSlots is the number of 32-byte directory entries name takes: one
+// for the short name, plus one per 13 UTF-16 units of a long name for
+// any name that is not a plain 8.3 name in a single case.
+func fatSlots(name string) int {
+	if fatShortName(name) {
+		return 1
+	}
+	return 1 + (len(utf16.Encode([]rune(name)))+12)/13
+}
+
+func fatShortName(name string) bool {
+	base, ext, _ := strings.Cut(name, ".")
+	if base == "" || len(base) > 8 || len(ext) > 3 || strings.Contains(ext, ".") {
+		return false
+	}
+	for _, part := range []string{base, ext} {
+		if part != strings.ToUpper(part) && part != strings.ToLower(part) {
+			return false
+		}
+		for _, r := range part {
+			if r > 0x7e || r <= ' ' || strings.ContainsRune(`"*+,/:;<=>?[\]|`, r) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// fatRootStatus holds the root directory's entries against the inode
+// thresholds, FAT having no inodes to run out of instead.
+func fatRootStatus(d FS, config Config) Status {
+	if d.FATRoot == nil || d.FATRoot.Limit == 0 {
+		return StatusOK
+	}
+	return config.inodeLimits().Evaluate(d.FATRoot.Usage())
+}
+
+func fatRootLine(r *FATRoot) string {
+	return fmt.Sprintf("    root directory: %d of %d entries (%.2f%%)", r.Entries, r.Limit, r.Usage())
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+
+	"github.com/AScotM/filesystem_cap/fscap/mountinfo"
+)
+
+// mountIsDir reports whether a mount point is a directory. lstat(2) never
+// triggers automounts or follows symlinks. Results are cached for the run;
+// paths that cannot be stat'd count as directories so they are still
+// reported.
+func mountIsDir(path string, cache map[string]bool) bool {
+	if isDir, ok := cache[path]; ok {
+		return isDir
+	}
+	isDir := true
+	if fi, err := os.Lstat(hostPath(path)); err == nil {
+		isDir = fi.IsDir()
+	}
+	cache[path] = isDir
+	return isDir
+}
+
+// filterFileBinds drops bind mounts of single files such as the
+// resolv.conf and hostname mounts container runtimes create, returning how
+// many were dropped.
+func filterFileBinds(mounts []mountinfo.Mount, cache map[string]bool, tr *trace) ([]mountinfo.Mount, int) {
+	var filtered []mountinfo.Mount
+	suppressed := 0
+	for _, m := range mounts {
+		if !mountIsDir(m.MountPoint, cache) {
+			tr.removed(m.ID, m.MountPoint, "file-bind", "bind mount of a single file, shown with -a")
+			suppressed++
+			continue
+		}
+		filtered = append(filtered, m)
+	}
+	return filtered, suppressed
+}
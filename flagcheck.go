@@ -0,0 +1,136 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// flagRule describes a flag that is ignored, or conflicts, in some
+// combinations. The rule fires when Flag is passed and either -o is one of
+// Formats or the flag named by Requires was not passed.
+type flagRule struct {
+	Flag     string
+	Formats  []string
+	Requires string
+	Conflict bool
+}
+
+var flagRules = []flagRule{
+	{Flag: "no-color", Formats: []string{"json", "csv"}},
+	{Flag: "no-summary", Formats: []string{"csv", "oneline", "tree"}},
+	{Flag: "oneline-min", Formats: []string{"table", "json", "csv", "tree"}},
+	{Flag: "top", Formats: []string{"csv", "oneline"}},
+	{Flag: "deleted-space", Formats: []string{"oneline"}},
+	{Flag: "ndjson", Formats: []string{"table", "csv", "oneline", "tree"}, Conflict: true},
+	{Flag: "output-file", Formats: []string{"table", "oneline", "tree"}, Conflict: true},
+	{Flag: "stream", Formats: []string{"oneline", "tree"}, Conflict: true},
+	{Flag: "group-display", Formats: []string{"csv", "oneline", "tree"}},
+	{Flag: "columns", Formats: []string{"table", "json", "oneline", "tree"}},
+	{Flag: "explain", Formats: []string{"csv", "oneline", "tree"}},
+	{Flag: "compress", Requires: "output-file"},
+	{Flag: "baseline-strict", Requires: "baseline"},
+	{Flag: "baseline-tolerance", Requires: "baseline"},
+	{Flag: "baseline-usage", Requires: "baseline"},
+	{Flag: "io-sample", Requires: "io"},
+	{Flag: "deleted-timeout", Requires: "deleted-space"},
+	{Flag: "eta-alpha", Requires: "state"},
+	{Flag: "eta-min-samples", Requires: "state"},
+	{Flag: "rate-warn", Requires: "state"},
+	{Flag: "rate-crit", Requires: "state"},
+	{Flag: "rate-window", Requires: "state"},
+	{Flag: "dedup-window", Requires: "state"},
+	{Flag: "dedup-window", Requires: "alert"},
+	{Flag: "allow-exec", Requires: "state"},
+	{Flag: "exec-cooldown", Requires: "allow-exec"},
+	{Flag: "events-file", Requires: "watch"},
+	{Flag: "history-retention", Requires: "events-file"},
+	{Flag: "history-max-size", Requires: "events-file"},
+	{Flag: "history-segments", Requires: "history-max-size"},
+	{Flag: "spark", Requires: "watch"},
+	{Flag: "spark", Formats: []string{"json", "csv", "oneline", "tree"}},
+	{Flag: "deep-budget", Requires: "deep"},
+	{Flag: "probe", Requires: "stale-days"},
+	{Flag: "thin-warn", Requires: "thin"},
+	{Flag: "thin-crit", Requires: "thin"},
+	{Flag: "deep-ttl", Requires: "deep"},
+	{Flag: "sparse-min-size", Requires: "sparse-check"},
+	{Flag: "sparse-max-files", Requires: "sparse-check"},
+	{Flag: "sparse-budget", Requires: "sparse-check"},
+	{Flag: "sparse-ttl", Requires: "sparse-check"},
+	{Flag: "cache-ttl", Requires: "listen"},
+	{Flag: "tls-cert", Requires: "listen"},
+	{Flag: "basic-auth-file", Requires: "listen"},
+	{Flag: "unix-socket", Requires: "watch"},
+	{Flag: "targets-file", Requires: "aggregate"},
+	{Flag: "poll-interval", Requires: "aggregate"},
+	{Flag: "target-timeout", Requires: "aggregate"},
+	{Flag: "max-concurrent-targets", Requires: "aggregate"},
+	{Flag: "socket-mode", Requires: "unix-socket"},
+	{Flag: "socket-group", Requires: "unix-socket"},
+	{Flag: "self-metrics", Requires: "listen"},
+	{Flag: "label-style", Requires: "listen"},
+	{Flag: "annotation-labels", Requires: "listen"},
+	{Flag: "min-interval", Requires: "max-interval"},
+	{Flag: "max-concurrent-collections", Requires: "listen"},
+}
+
+func (r flagRule) String() string {
+	effect := "ignored"
+	if r.Conflict {
+		effect = "not allowed"
+	}
+	if r.Requires != "" {
+		return fmt.Sprintf("-%s is %s without -%s", r.Flag, effect, r.Requires)
+	}
+	return fmt.Sprintf("-%s is %s with -o %s", r.Flag, effect, strings.Join(r.Formats, ", "))
+}
+
+func (r flagRule) applies(format string) bool {
+	if !flagPassed(r.Flag) {
+		return false
+	}
+	if r.Requires != "" {
+		return !flagPassed(r.Requires)
+	}
+	for _, f := range r.Formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// checkFlags reports the rules the command line trips. Conflicts, and with
+// -strict-flags every finding, end the program.
+func checkFlags(format string, strict bool) {
+	fatal := false
+	for _, r := range flagRules {
+		if !r.applies(format) {
+			continue
+		}
+		if r.Conflict || strict {
+			fmt.Fprintf(os.Stderr, "dfmon: %v\n", r)
+			fatal = true
+		} else {
+			fmt.Fprintf(os.Stderr, "dfmon: Warning: %v\n", r)
+		}
+	}
+	if fatal {
+		os.Exit(2)
+	}
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: dfmon [flags] [%s ...]\n", strings.Join(subcommands, "|"))
+	printFlagGroups(flag.CommandLine)
+	fmt.Fprintf(out, "\nFlag combinations (errors with -strict-flags):\n")
+	for _, r := range flagRules {
+		fmt.Fprintf(out, "  %v\n", r)
+	}
+	printEnvNames(flag.CommandLine)
+	fmt.Fprintf(out, "\nExit status, over every filesystem whether shown or not:\n")
+	fmt.Fprintf(out, "  0 ok, 1 warning or -baseline-strict drift, 2 critical, 3 an output could not be written\n")
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// flagInfo is what dfmon knows of a top-level flag beyond the flag
+// package: its help section, a long spelling for the single-letter flags,
+// its environment variable when not derived from the name, and what its
+// value completes to in the shell, a list of words or "file" or "dir".
+type flagInfo struct {
+	Group    string
+	Long     string
+	Env      string
+	Complete []string
+}
+
+// flagGroups orders the sections of -help.
+var flagGroups = []string{"Output", "Filtering", "Thresholds", "Alerting", "Daemon", "Collection", "General"}
+
+// flagRegistry describes every top-level flag. It drives -help, the
+// DFMON_* variables and the completion scripts; a flag missing from it
+// is listed under "Other".
+var flagRegistry = map[string]flagInfo{
+	"o":               {Group: "Output", Long: "output", Complete: sortedKeys(outputFormats)},
+	"h":               {Group: "Output", Long: "human"},
+	"s":               {Group: "Output", Long: "sort", Complete: sortKeys},
+	"no-color":        {Group: "Output"},
+	"no-summary":      {Group: "Output"},
+	"verbose":         {Group: "Output"},
+	"ndjson":          {Group: "Output"},
+	"progress":        {Group: "Output"},
+	"dedup":           {Group: "Output"},
+	"columns":         {Group: "Output"},
+	"group-display":   {Group: "Output", Complete: []string{"category"}},
+	"output-file":     {Group: "Output", Complete: []string{"file"}},
+	"compress":        {Group: "Output", Complete: []string{"none", "gzip", "zstd"}},
+	"stream":          {Group: "Output"},
+	"oneline-min":     {Group: "Output"},
+	"top":             {Group: "Output"},
+	"native-blocks":   {Group: "Output"},
+	"sanitize-fields": {Group: "Output"},
+	"spark":           {Group: "Output"},
+	"status-fd":       {Group: "Output"},
+	"lang":            {Group: "Output", Complete: []string{"en", "de"}},
+
+	"a":         {Group: "Filtering", Long: "all"},
+	"x":         {Group: "Filtering", Long: "exclude-types"},
+	"x+":        {Group: "Filtering", Long: "exclude-types-add"},
+	"x-":        {Group: "Filtering", Long: "exclude-types-remove"},
+	"fuse":      {Group: "Filtering", Complete: []string{"auto", "all", "mine", "none"}},
+	"container": {Group: "Filtering", Complete: []string{"auto", "yes", "no"}},
+	"root":      {Group: "Filtering", Complete: []string{"dir"}},
+	"explain":   {Group: "Filtering", Complete: []string{"file"}},
+
+	"w":                  {Group: "Thresholds", Long: "warn-threshold", Env: "WARN"},
+	"c":                  {Group: "Thresholds", Long: "crit-threshold", Env: "CRIT"},
+	"iw":                 {Group: "Thresholds", Long: "inode-warn"},
+	"ic":                 {Group: "Thresholds", Long: "inode-crit"},
+	"thin-warn":          {Group: "Thresholds"},
+	"thin-crit":          {Group: "Thresholds"},
+	"rate-warn":          {Group: "Thresholds"},
+	"rate-crit":          {Group: "Thresholds"},
+	"rate-window":        {Group: "Thresholds"},
+	"tz":                 {Group: "Thresholds"},
+	"baseline":           {Group: "Thresholds", Complete: []string{"file"}},
+	"baseline-strict":    {Group: "Thresholds"},
+	"baseline-tolerance": {Group: "Thresholds"},
+	"baseline-usage":     {Group: "Thresholds"},
+
+	"alert":         {Group: "Alerting"},
+	"dedup-window":  {Group: "Alerting"},
+	"allow-exec":    {Group: "Alerting"},
+	"exec-cooldown": {Group: "Alerting"},
+
+	"watch":                      {Group: "Daemon"},
+	"watch-config":               {Group: "Daemon"},
+	"events-file":                {Group: "Daemon", Complete: []string{"file"}},
+	"history-retention":          {Group: "Daemon"},
+	"history-max-size":           {Group: "Daemon"},
+	"history-segments":           {Group: "Daemon"},
+	"max-interval":               {Group: "Daemon"},
+	"min-interval":               {Group: "Daemon"},
+	"listen":                     {Group: "Daemon"},
+	"tls-cert":                   {Group: "Daemon", Complete: []string{"file"}},
+	"tls-key":                    {Group: "Daemon", Complete: []string{"file"}},
+	"tls-client-ca":              {Group: "Daemon", Complete: []string{"file"}},
+	"basic-auth-file":            {Group: "Daemon", Complete: []string{"file"}},
+	"label-style":                {Group: "Daemon", Complete: labelStyles},
+	"annotation-labels":          {Group: "Daemon"},
+	"self-metrics":               {Group: "Daemon"},
+	"cache-ttl":                  {Group: "Daemon"},
+	"max-concurrent-collections": {Group: "Daemon"},
+	"unix-socket":                {Group: "Daemon", Complete: []string{"file"}},
+	"socket-mode":                {Group: "Daemon"},
+	"socket-group":               {Group: "Daemon"},
+	"aggregate":                  {Group: "Daemon"},
+	"targets-file":               {Group: "Daemon", Complete: []string{"file"}},
+	"poll-interval":              {Group: "Daemon"},
+	"target-timeout":             {Group: "Daemon"},
+	"max-concurrent-targets":     {Group: "Daemon"},
+
+	"state":              {Group: "Collection", Complete: []string{"file"}},
+	"eta-alpha":          {Group: "Collection"},
+	"eta-min-samples":    {Group: "Collection"},
+	"disable-collectors": {Group: "Collection"},
+	"deleted-space":      {Group: "Collection"},
+	"deleted-timeout":    {Group: "Collection"},
+	"io":                 {Group: "Collection"},
+	"io-sample":          {Group: "Collection"},
+	"nfs-quota":          {Group: "Collection"},
+	"quota":              {Group: "Collection"},
+	"fat-check":          {Group: "Collection"},
+	"snapshots":          {Group: "Collection"},
+	"thin":               {Group: "Collection"},
+	"stale-days":         {Group: "Collection"},
+	"probe":              {Group: "Collection"},
+	"raw-statfs":         {Group: "Collection"},
+	"deep":               {Group: "Collection"},
+	"deep-budget":        {Group: "Collection"},
+	"deep-ttl":           {Group: "Collection"},
+	"sparse-check":       {Group: "Collection"},
+	"sparse-min-size":    {Group: "Collection"},
+	"sparse-max-files":   {Group: "Collection"},
+	"sparse-budget":      {Group: "Collection"},
+	"sparse-ttl":         {Group: "Collection"},
+	"scan-user":          {Group: "Collection"},
+	"sandbox":            {Group: "Collection"},
+	"require":            {Group: "Collection"},
+
+	"config":       {Group: "General", Complete: []string{"file"}},
+	"config-dir":   {Group: "General", Complete: []string{"dir"}},
+	"strict-flags": {Group: "General"},
+	"node-name":    {Group: "General"},
+	"machine-id":   {Group: "General"},
+	"version":      {Group: "General"},
+}
+
+// addLongFlags registers the long spelling of each single-letter flag in
+// fs, sharing its value, so -w 80 and --warn-threshold 80 are the same.
+func addLongFlags(fs *flag.FlagSet) {
+	for name, info := range flagRegistry {
+		if f := fs.Lookup(name); f != nil && info.Long != "" {
+			fs.Var(f.Value, info.Long, f.Usage)
+		}
+	}
+}
+
+// shortFlag is the flag that name spells, name itself unless it is the
+// long spelling of a single-letter flag.
+func shortFlag(name string) string {
+	for short, info := range flagRegistry {
+		if info.Long == name && name != "" {
+			return short
+		}
+	}
+	return name
+}
+
+func isLongFlag(name string) bool { return shortFlag(name) != name }
+
+// parseCommandLine is flag.Parse, except that an unknown flag is reported
+// with the closest known one rather than followed by the whole help.
+func parseCommandLine() {
+	fs := flag.CommandLine
+	fs.Init(os.Args[0], flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	err := fs.Parse(os.Args[1:])
+	fs.SetOutput(nil)
+	if err == flag.ErrHelp {
+		fs.Usage()
+		os.Exit(0)
+	}
+	if err == nil {
+		return
+	}
+	msg := err.Error()
+	if name, ok := strings.CutPrefix(msg, "flag provided but not defined: -"); ok {
+		if s := suggestFlag(fs, name); s != "" {
+			msg += fmt.Sprintf(" (did you mean %s?)", dashed(s))
+		}
+	}
+	fmt.Fprintf(os.Stderr, "dfmon: %s\nRun 'dfmon -help' for the flags.\n", msg)
+	os.Exit(2)
+}
+
+// suggestFlag is the flag of fs closest to the unknown name: one it
+// starts, or else one a few edits away.
+func suggestFlag(fs *flag.FlagSet, name string) string {
+	name = strings.TrimPrefix(name, "-")
+	best, bestDist := "", len(name)/3+1
+	fs.VisitAll(func(f *flag.Flag) {
+		if len(name) >= 3 && strings.HasPrefix(f.Name, name) && (best == "" || bestDist > 0) {
+			best, bestDist = f.Name, 0
+			return
+		}
+		if d := editDistance(name, f.Name); d < bestDist {
+			best, bestDist = f.Name, d
+		}
+	})
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// dashed writes name as typed: one dash for the historical flags, two
+// for the long spellings.
+func dashed(name string) string {
+	if isLongFlag(name) {
+		return "--" + name
+	}
+	return "-" + name
+}
+
+// printFlagGroups is flag.PrintDefaults in the sections of flagGroups,
+// with each single-letter flag's long spelling beside it.
+func printFlagGroups(fs *flag.FlagSet) {
+	out := fs.Output()
+	byGroup := map[string][]*flag.Flag{}
+	fs.VisitAll(func(f *flag.Flag) {
+		if isLongFlag(f.Name) {
+			return
+		}
+		group := flagRegistry[f.Name].Group
+		if group == "" {
+			group = "Other"
+		}
+		byGroup[group] = append(byGroup[group], f)
+	})
+	for _, group := range append(flagGroups, "Other") {
+		if len(byGroup[group]) == 0 {
+			continue
+		}
+		fmt.Fprintf(out, "\n%s:\n", group)
+		for _, f := range byGroup[group] {
+			printFlag(out, f)
+		}
+	}
+}
+
+func printFlag(out io.Writer, f *flag.Flag) {
+	kind, usage := flag.UnquoteUsage(f)
+	line := "  -" + f.Name
+	if long := flagRegistry[f.Name].Long; long != "" {
+		line += ", --" + long
+	}
+	if kind != "" {
+		line += " " + kind
+	}
+	switch {
+	case f.DefValue == "" || f.DefValue == "0" || f.DefValue == "false" || f.DefValue == "0s":
+	case kind == "string":
+		usage += fmt.Sprintf(" (default %q)", f.DefValue)
+	default:
+		usage += fmt.Sprintf(" (default %v)", f.DefValue)
+	}
+	fmt.Fprintf(out, "%s\n    \t%s\n", line, strings.ReplaceAll(usage, "\n", "\n    \t"))
+}
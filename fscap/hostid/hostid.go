@@ -0,0 +1,177 @@
+// Package hostid names the host dfmon runs on, the same way in every
+// report, alert and metric. The name is the host's fully qualified domain
+// name where one can be found, so short names in different domains do
+// not collide, and it is resolved once per process.
+package hostid
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Identity is how dfmon identifies this host.
+type Identity struct {
+	// Name is the FQDN, or the short host name when no domain is known,
+	// or the -node-name override; lower case and DNS-safe in each case.
+	Name string
+	// MachineID is the ID from /etc/machine-id, when Options.MachineID
+	// asks for it and it exists. It survives renames, unlike Name.
+	MachineID string
+}
+
+// Options change how Get resolves the identity.
+type Options struct {
+	// NodeName replaces the resolved name.
+	NodeName string
+	// MachineID adds the machine ID to the identity.
+	MachineID bool
+}
+
+// lookupTimeout bounds the DNS lookups of Resolve.
+const lookupTimeout = 2 * time.Second
+
+var (
+	mu       sync.Mutex
+	opts     Options
+	resolved *Identity
+)
+
+// Configure sets the options of Get. It has no effect once Get has been
+// called.
+func Configure(o Options) {
+	mu.Lock()
+	defer mu.Unlock()
+	if resolved == nil {
+		opts = o
+	}
+}
+
+// Get is Resolve with the options from Configure, resolved on first use
+// and cached for the life of the process.
+func Get() Identity {
+	mu.Lock()
+	defer mu.Unlock()
+	if resolved == nil {
+		id := Resolve(opts)
+		resolved = &id
+	}
+	return *resolved
+}
+
+// Resolve works out the identity afresh. Without an override the name is
+// the host name if it is already qualified, else the name DNS or
+// /etc/hosts gives the host's addresses that extends it, else the host
+// name in the domain or first search domain of /etc/resolv.conf, else
+// the short name.
+func Resolve(o Options) Identity {
+	var id Identity
+	if o.NodeName != "" {
+		id.Name = Clean(o.NodeName)
+	} else {
+		id.Name = Clean(fqdn(hostname()))
+	}
+	if o.MachineID {
+		id.MachineID = machineID()
+	}
+	return id
+}
+
+func hostname() string {
+	if h, err := os.Hostname(); err == nil && h != "" {
+		return h
+	}
+	if b, err := os.ReadFile("/etc/hostname"); err == nil {
+		return strings.TrimSpace(string(b))
+	}
+	return "localhost"
+}
+
+func fqdn(short string) string {
+	if strings.Contains(short, ".") {
+		return short
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+	if addrs, err := net.DefaultResolver.LookupHost(ctx, short); err == nil {
+		for _, a := range addrs {
+			names, _ := net.DefaultResolver.LookupAddr(ctx, a)
+			for _, n := range names {
+				if n = strings.TrimSuffix(n, "."); strings.HasPrefix(strings.ToLower(n), strings.ToLower(short)+".") {
+					return n
+				}
+			}
+		}
+	}
+	if domain := resolvDomain("/etc/resolv.conf"); domain != "" {
+		return short + "." + domain
+	}
+	return short
+}
+
+// resolvDomain is the domain, or else the first search domain, of a
+// resolv.conf file.
+func resolvDomain(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	var search string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "domain":
+			return strings.TrimSuffix(fields[1], ".")
+		case "search":
+			if search == "" {
+				search = strings.TrimSuffix(fields[1], ".")
+			}
+		}
+	}
+	return search
+}
+
+func machineID() string {
+	for _, path := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+		if b, err := os.ReadFile(path); err == nil {
+			if id := strings.TrimSpace(string(b)); id != "" {
+				return id
+			}
+		}
+	}
+	return ""
+}
+
+// Clean makes name DNS-safe: lower case, with every character other than
+// a letter, digit, hyphen or dot replaced by a hyphen, and without empty
+// labels or a trailing dot.
+func Clean(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '.':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return '-'
+	}, name)
+	var labels []string
+	for _, l := range strings.Split(name, ".") {
+		if l = strings.Trim(l, "-"); l != "" {
+			labels = append(labels, l)
+		}
+	}
+	if len(labels) == 0 {
+		return "localhost"
+	}
+	return strings.Join(labels, ".")
+}
@@ -0,0 +1,154 @@
+// Package mountinfo parses the Linux /proc/<pid>/mountinfo format
+// described in proc(5).
+package mountinfo
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Mount is one line of a mountinfo file.
+type Mount struct {
+	// ID is the unique mount ID (may be reused after umount).
+	ID int
+	// ParentID is the ID of the parent mount, or of itself for the
+	// root of the mount namespace.
+	ParentID int
+	// MajorMinor is the st_dev value for files on this filesystem,
+	// formatted as "major:minor".
+	MajorMinor string
+	// Root is the pathname of the directory in the filesystem which forms
+	// the root of this mount, e.g. "/@home" for a btrfs subvolume.
+	Root string
+	// MountPoint is the pathname of the mount point relative to the
+	// process's root directory.
+	MountPoint string
+	// Options are the per-mount options.
+	Options string
+	// OptionalFields holds zero or more "tag[:value]" fields such as
+	// "shared:1" or "master:2".
+	OptionalFields []string
+	// FSType is the filesystem type, e.g. "ext4" or "fuse.sshfs".
+	FSType string
+	// Source is the filesystem-specific mount source, or "none".
+	Source string
+	// SuperOptions are the per-superblock options.
+	SuperOptions string
+}
+
+// Self returns the mounts visible to the calling process.
+func Self() ([]Mount, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseReader(f)
+}
+
+// ParseMounts parses the older /proc/mounts or /etc/mtab format, for
+// when mountinfo cannot be read. It has no mount IDs, device numbers or
+// subtree roots: ID and MajorMinor stay zero, Root is "/", and the one
+// options field fills both Options and SuperOptions.
+func ParseMounts(r io.Reader) ([]Mount, error) {
+	var mounts []Mount
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		f := strings.Fields(sc.Text())
+		if len(f) < 4 || strings.HasPrefix(f[0], "#") {
+			continue
+		}
+		mounts = append(mounts, Mount{
+			Source:       Unescape(f[0]),
+			MountPoint:   Unescape(f[1]),
+			FSType:       f[2],
+			Options:      f[3],
+			SuperOptions: f[3],
+			Root:         "/",
+		})
+	}
+	return mounts, sc.Err()
+}
+
+// ParseReader parses mountinfo data from r. Malformed lines are skipped;
+// only errors reading r are returned.
+func ParseReader(r io.Reader) ([]Mount, error) {
+	var mounts []Mount
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for sc.Scan() {
+		if m, ok := parseLine(sc.Text()); ok {
+			mounts = append(mounts, m)
+		}
+	}
+	return mounts, sc.Err()
+}
+
+func parseLine(line string) (Mount, bool) {
+	var m Mount
+	f := strings.Fields(line)
+
+	// At least the six fixed fields, the separator and the three
+	// fields after it.
+	sep := -1
+	for i := 6; i < len(f); i++ {
+		if f[i] == "-" {
+			sep = i
+			break
+		}
+	}
+	if sep < 0 || len(f) < sep+3 {
+		return m, false
+	}
+
+	var err error
+	if m.ID, err = strconv.Atoi(f[0]); err != nil {
+		return m, false
+	}
+	if m.ParentID, err = strconv.Atoi(f[1]); err != nil {
+		return m, false
+	}
+	if !strings.Contains(f[2], ":") {
+		return m, false
+	}
+
+	m.MajorMinor = f[2]
+	m.Root = Unescape(f[3])
+	m.MountPoint = Unescape(f[4])
+	m.Options = f[5]
+	if sep > 6 {
+		m.OptionalFields = f[6:sep]
+	}
+	m.FSType = Unescape(f[sep+1])
+	m.Source = Unescape(f[sep+2])
+	if len(f) > sep+3 {
+		m.SuperOptions = f[sep+3]
+	}
+	return m, true
+}
+
+// Unescape decodes the octal escapes (\040, \011, \012, \134) the kernel
+// uses for whitespace and backslashes in mountinfo and mounts fields.
+func Unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && isOctal(s[i+1]) && isOctal(s[i+2]) && isOctal(s[i+3]) {
+			sb.WriteByte((s[i+1]-'0')<<6 | (s[i+2]-'0')<<3 | (s[i+3] - '0'))
+			i += 3
+			continue
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+func isOctal(c byte) bool {
+	return c >= '0' && c <= '7'
+}
@@ -0,0 +1,173 @@
+package mountinfo
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestParseReader(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want *Mount // nil when the line is skipped
+	}{
+		{
+			name: "proc(5) example",
+			line: "36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue",
+			want: &Mount{ID: 36, ParentID: 35, MajorMinor: "98:0", Root: "/mnt1", MountPoint: "/mnt2", Options: "rw,noatime",
+				OptionalFields: []string{"master:1"}, FSType: "ext3", Source: "/dev/root", SuperOptions: "rw,errors=continue"},
+		},
+		{
+			name: "no optional fields",
+			line: "22 1 8:1 / / rw,relatime - ext4 /dev/sda1 rw",
+			want: &Mount{ID: 22, ParentID: 1, MajorMinor: "8:1", Root: "/", MountPoint: "/", Options: "rw,relatime",
+				FSType: "ext4", Source: "/dev/sda1", SuperOptions: "rw"},
+		},
+		{
+			name: "several optional fields",
+			line: "40 22 0:35 / /srv rw shared:5 master:3 propagate_from:2 unbindable - nfs4 host:/export rw,vers=4.2",
+			want: &Mount{ID: 40, ParentID: 22, MajorMinor: "0:35", Root: "/", MountPoint: "/srv", Options: "rw",
+				OptionalFields: []string{"shared:5", "master:3", "propagate_from:2", "unbindable"},
+				FSType:         "nfs4", Source: "host:/export", SuperOptions: "rw,vers=4.2"},
+		},
+		{
+			name: "btrfs subvolume root",
+			line: "50 22 0:40 /@home /home rw shared:9 - btrfs /dev/sda2 rw,subvol=/@home",
+			want: &Mount{ID: 50, ParentID: 22, MajorMinor: "0:40", Root: "/@home", MountPoint: "/home", Options: "rw",
+				OptionalFields: []string{"shared:9"}, FSType: "btrfs", Source: "/dev/sda2", SuperOptions: "rw,subvol=/@home"},
+		},
+		{
+			name: "escaped space, tab, newline and backslash",
+			line: `60 22 8:3 /a\134b /mnt/my\040disk\011x\012y rw - vfat /dev/sdb\0401 rw`,
+			want: &Mount{ID: 60, ParentID: 22, MajorMinor: "8:3", Root: `/a\b`, MountPoint: "/mnt/my disk\tx\ny", Options: "rw",
+				FSType: "vfat", Source: "/dev/sdb 1", SuperOptions: "rw"},
+		},
+		{
+			name: "escaped fuse type",
+			line: `61 22 0:50 / /mnt/s rw - fuse.my\040fs src rw`,
+			want: &Mount{ID: 61, ParentID: 22, MajorMinor: "0:50", Root: "/", MountPoint: "/mnt/s", Options: "rw",
+				FSType: "fuse.my fs", Source: "src", SuperOptions: "rw"},
+		},
+		{
+			name: "no super options",
+			line: "62 22 0:51 / /x rw - tmpfs none",
+			want: &Mount{ID: 62, ParentID: 22, MajorMinor: "0:51", Root: "/", MountPoint: "/x", Options: "rw",
+				FSType: "tmpfs", Source: "none"},
+		},
+		{
+			name: "extra trailing fields",
+			line: "63 22 0:52 / /y rw - tmpfs none rw future",
+			want: &Mount{ID: 63, ParentID: 22, MajorMinor: "0:52", Root: "/", MountPoint: "/y", Options: "rw",
+				FSType: "tmpfs", Source: "none", SuperOptions: "rw"},
+		},
+		{name: "empty", line: ""},
+		{name: "blank", line: "   "},
+		{name: "no separator", line: "22 1 8:1 / / rw ext4 /dev/sda1 rw"},
+		{name: "truncated after separator", line: "22 1 8:1 / / rw - ext4"},
+		{name: "truncated before separator", line: "22 1 8:1 / /"},
+		{name: "separator too early", line: "22 1 8:1 / - rw ext4 /dev/sda1 rw"},
+		{name: "bad id", line: "x 1 8:1 / / rw - ext4 /dev/sda1 rw"},
+		{name: "bad parent id", line: "22 y 8:1 / / rw - ext4 /dev/sda1 rw"},
+		{name: "bad major:minor", line: "22 1 81 / / rw - ext4 /dev/sda1 rw"},
+	}
+	for _, tt := range tests {
+		got, err := ParseReader(strings.NewReader(tt.line + "\n"))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		switch {
+		case tt.want == nil && len(got) != 0:
+			t.Errorf("%s: parsed %+v, want the line skipped", tt.name, got)
+		case tt.want != nil && (len(got) != 1 || !reflect.DeepEqual(got[0], *tt.want)):
+			t.Errorf("%s:\n got %+v\nwant %+v", tt.name, got, *tt.want)
+		}
+	}
+}
+
+// TestParseReaderSkipsMalformed checks that a bad line among good ones
+// costs only that line.
+func TestParseReaderSkipsMalformed(t *testing.T) {
+	in := strings.Join([]string{
+		"22 1 8:1 / / rw - ext4 /dev/sda1 rw",
+		"garbage",
+		"23 22 8:2 / /boot rw - ext4 /dev/sda2",
+		"24 22 8:3 / /home rw - ext4", // cut short
+		"25 22 8:4 / /var rw - xfs /dev/sda4 rw",
+	}, "\n")
+	got, err := ParseReader(strings.NewReader(in)) // no final newline
+	if err != nil {
+		t.Fatal(err)
+	}
+	var points []string
+	for _, m := range got {
+		points = append(points, m.MountPoint)
+	}
+	if want := []string{"/", "/boot", "/var"}; !reflect.DeepEqual(points, want) {
+		t.Errorf("mount points = %q, want %q", points, want)
+	}
+}
+
+func TestParseReaderError(t *testing.T) {
+	boom := errors.New("boom")
+	r := iotest.TimeoutReader(strings.NewReader("22 1 8:1 / / rw - ext4 /dev/sda1 rw\n"))
+	if _, err := ParseReader(iotest.ErrReader(boom)); !errors.Is(err, boom) {
+		t.Errorf("ParseReader(failing reader) error = %v, want %v", err, boom)
+	}
+	if _, err := ParseReader(r); err == nil {
+		t.Error("ParseReader(timing out reader): no error")
+	}
+
+	long := "22 1 8:1 / /" + strings.Repeat("a", 2<<20) + " rw - ext4 /dev/sda1 rw\n"
+	if _, err := ParseReader(strings.NewReader(long)); err == nil {
+		t.Error("ParseReader of a line over the 1 MiB limit: no error")
+	}
+}
+
+func TestParseMounts(t *testing.T) {
+	in := strings.Join([]string{
+		"/dev/sda1 / ext4 rw,relatime 0 0",
+		"# comment line",
+		`/dev/sdb1 /mnt/my\040disk vfat rw 0 0`,
+		"short line",
+		"tmpfs /tmp tmpfs rw",
+	}, "\n")
+	got, err := ParseMounts(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Mount{
+		{Source: "/dev/sda1", MountPoint: "/", FSType: "ext4", Options: "rw,relatime", SuperOptions: "rw,relatime", Root: "/"},
+		{Source: "/dev/sdb1", MountPoint: "/mnt/my disk", FSType: "vfat", Options: "rw", SuperOptions: "rw", Root: "/"},
+		{Source: "tmpfs", MountPoint: "/tmp", FSType: "tmpfs", Options: "rw", SuperOptions: "rw", Root: "/"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseMounts:\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"", ""},
+		{"/plain", "/plain"},
+		{`a\040b`, "a b"},
+		{`\011\012\134`, "\t\n\\"},
+		{`\040\040`, "  "},
+		{`end\040`, "end "},
+		{`\04`, `\04`},    // too short
+		{`\0`, `\0`},      // too short
+		{`\`, `\`},        // lone backslash
+		{`\089`, `\089`},  // not octal
+		{`\\040`, `\ `},   // backslash, then an escape
+		{`\1234`, "S4"},   // three digits only
+		{`x\101y`, "xAy"}, // any octal is decoded
+	}
+	for _, tt := range tests {
+		if got := Unescape(tt.in); got != tt.want {
+			t.Errorf("Unescape(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Package units converts between byte counts and the human-readable
+// sizes dfmon prints and accepts, such as "1.5 GiB" and "800G", so other
+// tools can show the same numbers.
+package units
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Options control how FormatBytes prints a size.
+type Options struct {
+	// SI selects powers of 1000 and the kB, MB, ... units instead of
+	// powers of 1024 and KiB, MiB, ....
+	SI bool
+	// Precision is the number of decimals shown, 0 for none.
+	Precision int
+}
+
+var (
+	// Binary is how dfmon prints sizes: "1.5 GiB".
+	Binary = Options{Precision: 1}
+	// SI is Binary in powers of 1000: "1.6 GB".
+	SI = Options{SI: true, Precision: 1}
+)
+
+var (
+	binaryUnits = []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+	siUnits     = []string{"B", "kB", "MB", "GB", "TB", "PB", "EB"}
+)
+
+// FormatBytes prints n in the largest unit it reaches, rounded to
+// o.Precision decimals; counts under one kilobyte print as whole bytes.
+// Rounding never moves to the next unit, so 1048575 prints as
+// "1024.0 KiB".
+func FormatBytes(n uint64, o Options) string {
+	base, units := uint64(1024), binaryUnits
+	if o.SI {
+		base, units = 1000, siUnits
+	}
+	if n < base {
+		return fmt.Sprintf("%d B", n)
+	}
+	idx, div := 0, uint64(1)
+	for idx < len(units)-1 && n/div >= base {
+		idx, div = idx+1, div*base
+	}
+	return fmt.Sprintf("%.*f %s", max(o.Precision, 0), float64(n)/float64(div), units[idx])
+}
+
+// ParseBytes parses a size as dfmon's flags take it: a decimal number
+// with an optional unit of K, M, G, T, P or E, each a power of 1024
+// whether written as "G", "GB" or "GiB", in any case, with or without a
+// space. Fractions of a byte are dropped.
+func ParseBytes(s string) (uint64, error) {
+	return parse(s, false)
+}
+
+// ParseBytesSI is ParseBytes with "K", "KB", "M", "MB" and so on as
+// powers of 1000. "KiB", "MiB" and the other binary units stay powers
+// of 1024.
+func ParseBytesSI(s string) (uint64, error) {
+	return parse(s, true)
+}
+
+func parse(s string, si bool) (uint64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	num, unit := s, ""
+	if i >= 0 {
+		num, unit = s[:i], strings.ToUpper(strings.TrimSpace(s[i:]))
+	}
+
+	// The number is taken exactly, so "1.023 kB" is 1023 bytes rather
+	// than whatever a float64 rounds it down to.
+	val, ok := new(big.Rat).SetString(num)
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	unit = strings.TrimSuffix(unit, "B")
+	base := int64(1024)
+	if trimmed, binary := strings.CutSuffix(unit, "I"); binary {
+		unit = trimmed
+		if unit == "" {
+			return 0, fmt.Errorf("invalid size unit in %q", s)
+		}
+	} else if si {
+		base = 1000
+	}
+	exp := 0
+	if unit != "" {
+		exp = strings.Index("KMGTPE", unit) + 1
+		if exp == 0 || len(unit) != 1 {
+			return 0, fmt.Errorf("invalid size unit in %q", s)
+		}
+	}
+
+	scale := new(big.Int).Exp(big.NewInt(base), big.NewInt(int64(exp)), nil)
+	val.Mul(val, new(big.Rat).SetInt(scale))
+	b := new(big.Int).Quo(val.Num(), val.Denom())
+	if !b.IsUint64() {
+		return 0, fmt.Errorf("size %q out of range", s)
+	}
+	return b.Uint64(), nil
+}
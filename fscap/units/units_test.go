@@ -0,0 +1,174 @@
+package units
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    uint64
+		o    Options
+		want string
+	}{
+		{0, Binary, "0 B"},
+		{1, Binary, "1 B"},
+		{1023, Binary, "1023 B"},
+		{1024, Binary, "1.0 KiB"},
+		{1536, Binary, "1.5 KiB"},
+		{1048575, Binary, "1024.0 KiB"}, // rounding stays in the unit
+		{1048576, Binary, "1.0 MiB"},
+		{1610612736, Binary, "1.5 GiB"},
+		{1 << 40, Binary, "1.0 TiB"},
+		{1 << 50, Binary, "1.0 PiB"},
+		{1 << 60, Binary, "1.0 EiB"},
+		{math.MaxUint64, Binary, "16.0 EiB"},
+
+		{999, SI, "999 B"},
+		{1000, SI, "1.0 kB"},
+		{1500, SI, "1.5 kB"},
+		{999999, SI, "1000.0 kB"},
+		{1000000, SI, "1.0 MB"},
+		{1610612736, SI, "1.6 GB"},
+		{1e12, SI, "1.0 TB"},
+		{1e15, SI, "1.0 PB"},
+		{1e18, SI, "1.0 EB"},
+		{math.MaxUint64, SI, "18.4 EB"},
+
+		{1610612736, Options{Precision: 0}, "2 GiB"},
+		{1610612736, Options{Precision: 3}, "1.500 GiB"},
+		{1234567, Options{Precision: 2}, "1.18 MiB"},
+		{1234567, Options{SI: true, Precision: 2}, "1.23 MB"},
+		{1536, Options{Precision: -1}, "2 KiB"}, // negative is none
+		{512, Options{Precision: 3}, "512 B"},   // bytes are whole
+	}
+	for _, tt := range tests {
+		if got := FormatBytes(tt.n, tt.o); got != tt.want {
+			t.Errorf("FormatBytes(%d, %+v) = %q, want %q", tt.n, tt.o, got, tt.want)
+		}
+	}
+}
+
+func TestParseBytes(t *testing.T) {
+	tests := []struct {
+		s        string
+		want     uint64
+		wantSI   uint64
+		wantFail bool
+	}{
+		{s: "0", want: 0, wantSI: 0},
+		{s: "123", want: 123, wantSI: 123},
+		{s: "123B", want: 123, wantSI: 123},
+		{s: "1K", want: 1 << 10, wantSI: 1e3},
+		{s: "1k", want: 1 << 10, wantSI: 1e3},
+		{s: "1KB", want: 1 << 10, wantSI: 1e3},
+		{s: "1kb", want: 1 << 10, wantSI: 1e3},
+		{s: "1KiB", want: 1 << 10, wantSI: 1 << 10},
+		{s: "1kib", want: 1 << 10, wantSI: 1 << 10},
+		{s: "800G", want: 800 << 30, wantSI: 800e9},
+		{s: "800 GB", want: 800 << 30, wantSI: 800e9},
+		{s: "1.5 GiB", want: 1.5 * (1 << 30), wantSI: 1.5 * (1 << 30)},
+		{s: "  2M  ", want: 2 << 20, wantSI: 2e6},
+		{s: "1T", want: 1 << 40, wantSI: 1e12},
+		{s: "1P", want: 1 << 50, wantSI: 1e15},
+		{s: "1E", want: 1 << 60, wantSI: 1e18},
+		{s: "15EiB", want: 15 << 60, wantSI: 15 << 60},
+		{s: "18446744073709551615", want: math.MaxUint64, wantSI: math.MaxUint64},
+		{s: "18446744073709551615.9", want: math.MaxUint64, wantSI: math.MaxUint64},
+		{s: "1.023 kB", want: 1047, wantSI: 1023}, // exact, not float-rounded
+		{s: "4.1K", want: 4198, wantSI: 4100},
+		{s: ".5K", want: 512, wantSI: 500},
+		{s: "1.", want: 1, wantSI: 1},
+		{s: "0.3", want: 0, wantSI: 0}, // fractions of a byte are dropped
+		{s: "1.0009K", want: 1024, wantSI: 1000},
+
+		// Overflow.
+		{s: "16EiB", wantFail: true},
+		{s: "19E", wantFail: true},
+		{s: "18446744073709551616", wantFail: true},
+		{s: "99999999999999999999", wantFail: true},
+		{s: "1e30", wantFail: true},
+
+		// Malformed.
+		{s: "", wantFail: true},
+		{s: " ", wantFail: true},
+		{s: "G", wantFail: true},
+		{s: "-1", wantFail: true},
+		{s: "-1G", wantFail: true},
+		{s: "+1G", wantFail: true},
+		{s: "1..5G", wantFail: true},
+		{s: "1.5.G", wantFail: true},
+		{s: "1X", wantFail: true},
+		{s: "1 GG", wantFail: true},
+		{s: "1GiBB", wantFail: true},
+		{s: "1iB", wantFail: true},
+		{s: "1 G B", wantFail: true},
+		{s: "1,5G", wantFail: true},
+		{s: "0x10", wantFail: true},
+		{s: "NaN", wantFail: true},
+		{s: "Inf", wantFail: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseBytes(tt.s)
+		gotSI, errSI := ParseBytesSI(tt.s)
+		if tt.wantFail {
+			if err == nil || errSI == nil {
+				t.Errorf("ParseBytes(%q) = %d, %v; ParseBytesSI = %d, %v; want errors", tt.s, got, err, gotSI, errSI)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseBytes(%q) = %d, %v; want %d", tt.s, got, err, tt.want)
+		}
+		if errSI != nil || gotSI != tt.wantSI {
+			t.Errorf("ParseBytesSI(%q) = %d, %v; want %d", tt.s, gotSI, errSI, tt.wantSI)
+		}
+	}
+}
+
+func TestParseBytesErrors(t *testing.T) {
+	tests := []struct{ s, want string }{
+		{"abc", `invalid size "abc"`},
+		{"1X", `invalid size unit in "1X"`},
+		{"16E", `size "16E" out of range`},
+	}
+	for _, tt := range tests {
+		if _, err := ParseBytes(tt.s); err == nil || err.Error() != tt.want {
+			t.Errorf("ParseBytes(%q) error = %v, want %q", tt.s, err, tt.want)
+		}
+	}
+}
+
+// TestRoundTrip parses what FormatBytes prints: exact at whole units,
+// and otherwise within the rounding of the precision shown.
+func TestRoundTrip(t *testing.T) {
+	sizes := []uint64{0, 1, 1023, 1024, 1536, 4096, 1 << 20, 5 << 30, 3<<40 + 1<<39, 1 << 50, 7 << 60}
+	for _, o := range []Options{Binary, SI, {Precision: 3}, {SI: true, Precision: 3}} {
+		parse := ParseBytes
+		if o.SI {
+			parse = ParseBytesSI
+		}
+		for _, n := range sizes {
+			s := FormatBytes(n, o)
+			got, err := parse(s)
+			if err != nil {
+				t.Errorf("%+v: parse(FormatBytes(%d) = %q): %v", o, n, s, err)
+				continue
+			}
+			// Half the last decimal shown, in the unit printed.
+			tolerance := 0.0
+			if _, unit, _ := strings.Cut(s, " "); unit != "B" {
+				base := 1024.0
+				if o.SI {
+					base = 1000
+				}
+				exp := strings.Index("KMGTPE", strings.ToUpper(unit[:1])) + 1
+				tolerance = 0.5 * math.Pow(10, -float64(o.Precision)) * math.Pow(base, float64(exp))
+			}
+			if diff := math.Abs(float64(got) - float64(n)); diff > tolerance {
+				t.Errorf("%+v: parse(FormatBytes(%d) = %q) = %d, off by %g, more than %g", o, n, s, got, diff, tolerance)
+			}
+		}
+	}
+}
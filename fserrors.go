@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AScotM/filesystem_cap/fscap/mountinfo"
+)
+
+// fsErrorTypes are the filesystems served by the ext4 driver, which keeps
+// an error count in the superblock and shows it in /sys/fs/ext4.
+var fsErrorTypes = map[string]bool{"ext2": true, "ext3": true, "ext4": true}
+
+// fsErrorsCollector sets FS.ErrorsCount and FS.LastErrorTime from the
+// errors the kernel recorded in the superblock. A filesystem with errors
+// is often remounted read-only soon, or at least needs a fsck. Kernels
+// without the attributes are skipped.
+type fsErrorsCollector struct{}
+
+func (fsErrorsCollector) Name() string                   { return "fserrors" }
+func (fsErrorsCollector) Applies(m mountinfo.Mount) bool { return fsErrorTypes[m.FSType] }
+func (fsErrorsCollector) Enrich(ctx context.Context, m mountinfo.Mount, d *FS) error {
+	dir := filepath.Join("/sys/fs/ext4", blockDevName(m))
+	count, err := readSysfsUint(filepath.Join(dir, "errors_count"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	d.ErrorsCount = count
+	if at, err := readSysfsUint(filepath.Join(dir, "last_error_time")); err == nil && at > 0 {
+		t := time.Unix(int64(at), 0)
+		d.LastErrorTime = &t
+	}
+	return nil
+}
+
+// blockDevName is the kernel's name for the device of m, such as "dm-0"
+// for /dev/mapper/vg-root, which names its directories in /sys/fs.
+func blockDevName(m mountinfo.Mount) string {
+	if p, err := filepath.EvalSymlinks("/sys/dev/block/" + m.MajorMinor); err == nil {
+		return filepath.Base(p)
+	}
+	return filepath.Base(m.Source)
+}
+
+func readSysfsUint(path string) (uint64, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", path, err)
+	}
+	return n, nil
+}
+
+// fsErrorStatus is critical once the kernel has recorded any error on d.
+func fsErrorStatus(d FS) Status {
+	if d.ErrorsCount > 0 {
+		return StatusCritical
+	}
+	return StatusOK
+}
+
+func fsErrorsLine(d FS) string {
+	line := fmt.Sprintf("    filesystem errors detected: %d", d.ErrorsCount)
+	if d.LastErrorTime != nil {
+		line += ", last at " + d.LastErrorTime.Format(time.RFC3339)
+	}
+	return line
+}
@@ -0,0 +1,40 @@
+package main
+
+import "strings"
+
+var networkTypes = map[string]bool{
+	"nfs":       true,
+	"nfs4":      true,
+	"cifs":      true,
+	"smb3":      true,
+	"smbfs":     true,
+	"ceph":      true,
+	"glusterfs": true,
+	"9p":        true,
+	"afs":       true,
+	"lustre":    true,
+	"gpfs":      true,
+	"beegfs":    true,
+}
+
+var networkFuseTypes = map[string]bool{
+	"fuse.sshfs":     true,
+	"fuse.rclone":    true,
+	"fuse.s3fs":      true,
+	"fuse.glusterfs": true,
+	"fuse.ceph":      true,
+	"fuse.gcsfuse":   true,
+}
+
+func isNetworkFS(fsType string) bool {
+	return networkTypes[fsType] || networkFuseTypes[fsType] || strings.HasPrefix(fsType, "nfs")
+}
+
+func isReadOnly(d FS) bool {
+	for _, f := range d.Flags {
+		if f == "ST_RDONLY" {
+			return true
+		}
+	}
+	return false
+}
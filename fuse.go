@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/AScotM/filesystem_cap/fscap/mountinfo"
+)
+
+func isFuse(fsType string) bool {
+	return fsType == "fuse" || fsType == "fuseblk" || strings.HasPrefix(fsType, "fuse.")
+}
+
+// fuseMode resolves -fuse=auto: root sees every fuse mount, other users
+// only their own, matching what statfs would allow anyway.
+func fuseMode(mode string) string {
+	if mode != "auto" {
+		return mode
+	}
+	if os.Geteuid() == 0 {
+		return "all"
+	}
+	return "mine"
+}
+
+func filterFuse(mounts []mountinfo.Mount, mode string, tr *trace) []mountinfo.Mount {
+	mode = fuseMode(mode)
+	if mode == "all" {
+		return mounts
+	}
+
+	uid := strconv.Itoa(os.Geteuid())
+	var filtered []mountinfo.Mount
+	for _, m := range mounts {
+		if isFuse(m.FSType) {
+			if mode == "none" {
+				tr.removed(m.ID, m.MountPoint, "fuse", "fuse mounts are hidden with -fuse none")
+				continue
+			}
+			if owner := mountOption(m.SuperOptions, "user_id"); owner != uid {
+				tr.removed(m.ID, m.MountPoint, "fuse", "fuse mount of uid %s, not %s (-fuse mine)", owner, uid)
+				continue
+			}
+		}
+		filtered = append(filtered, m)
+	}
+	return filtered
+}
+
+var (
+	ownerMu    sync.Mutex
+	ownerNames = map[string]string{}
+)
+
+// fuseOwner returns the user owning a fuse mount, by name when the uid
+// resolves.
+func fuseOwner(m mountinfo.Mount) string {
+	uid := mountOption(m.SuperOptions, "user_id")
+	if uid == "" {
+		return ""
+	}
+	return userName(uid)
+}
+
+// userName resolves uid to a user name, falling back to the number.
+func userName(uid string) string {
+	ownerMu.Lock()
+	defer ownerMu.Unlock()
+	if name, ok := ownerNames[uid]; ok {
+		return name
+	}
+	name := uid
+	if u, err := user.LookupId(uid); err == nil {
+		name = u.Username
+	}
+	ownerNames[uid] = name
+	return name
+}
+
+// accessDenied reports whether an all-zero statfs result for m is more
+// likely a permission problem than an empty filesystem: the caller cannot
+// enter the mount point, or it is someone else's fuse mount.
+func accessDenied(m mountinfo.Mount) bool {
+	if syscall.Access(hostPath(m.MountPoint), 0x1) != nil { // X_OK
+		return true
+	}
+	if isFuse(m.FSType) {
+		uid := mountOption(m.SuperOptions, "user_id")
+		return uid != "" && uid != strconv.Itoa(os.Geteuid())
+	}
+	return false
+}
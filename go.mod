@@ -0,0 +1,10 @@
+module github.com/AScotM/filesystem_cap
+
+go 1.22
+
+require (
+	github.com/BurntSushi/toml v1.4.0
+	github.com/klauspost/compress v1.17.11
+	golang.org/x/crypto v0.31.0
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// GroupConfig names a set of mounts judged on their combined usage. A
+// mount may belong to several groups.
+type GroupConfig struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+	Warn    *float64 `json:"warn,omitempty"`
+	Crit    *float64 `json:"crit,omitempty"`
+	// Sink receives alerts for the group; the alerts default is used when
+	// empty.
+	Sink string `json:"sink,omitempty"`
+}
+
+// Group is the aggregate of a GroupConfig over the collected mounts.
+// Missing lists member patterns that matched nothing, so a vanished mount
+// is visible instead of quietly shrinking Total.
+type Group struct {
+	Name    string   `json:"name"`
+	Mounts  []string `json:"mounts"`
+	Missing []string `json:"missing,omitempty"`
+	Total   uint64   `json:"total"`
+	Used    uint64   `json:"used"`
+	Free    uint64   `json:"free"`
+	Usage   float64  `json:"usage"`
+	Warn    float64  `json:"warn"`
+	Crit    float64  `json:"crit"`
+	Status  string   `json:"status"`
+	sink    string
+}
+
+func (g GroupConfig) check(key string) []error {
+	var errs []error
+	if g.Name == "" {
+		errs = append(errs, fmt.Errorf("%s.name: required", key))
+	}
+	if len(g.Members) == 0 {
+		errs = append(errs, fmt.Errorf("%s.members: empty", key))
+	}
+	for j, m := range g.Members {
+		if _, err := filepath.Match(m, ""); err != nil {
+			errs = append(errs, fmt.Errorf("%s.members[%d]: bad pattern %q", key, j, m))
+		}
+	}
+	return append(errs, checkOrder(key, g.Warn, g.Crit)...)
+}
+
+// buildGroups aggregates list into the configured groups. A filesystem
+// mounted at several member paths is counted once.
+func buildGroups(list []FS, configs []GroupConfig, config Config) []Group {
+	var groups []Group
+	for _, gc := range configs {
+		g := Group{Name: gc.Name, Mounts: []string{}, Warn: config.WarnThreshold, Crit: config.CritThreshold, sink: gc.Sink}
+		if gc.Warn != nil {
+			g.Warn = *gc.Warn
+		}
+		if gc.Crit != nil {
+			g.Crit = *gc.Crit
+		}
+
+		seen := map[string]bool{}
+		for _, pattern := range gc.Members {
+			found := false
+			for _, d := range list {
+				if ok, _ := filepath.Match(pattern, d.Mount); !ok {
+					continue
+				}
+				found = true
+				key := d.Dev + d.Subtree
+				if d.Dev == "" {
+					key = d.Mount
+				}
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				g.Mounts = append(g.Mounts, d.Mount)
+				g.Total = addClamp(g.Total, d.Total)
+				g.Used = addClamp(g.Used, d.Used)
+				g.Free = addClamp(g.Free, d.Free)
+			}
+			if !found {
+				g.Missing = append(g.Missing, pattern)
+			}
+		}
+		if g.Total > 0 {
+			g.Usage = float64(g.Used) / float64(g.Total) * 100
+		}
+		g.Status = groupStatus(g).String()
+		groups = append(groups, g)
+	}
+	return groups
+}
+
+func groupStatus(g Group) Status {
+	return Thresholds{Warn: g.Warn, Crit: g.Crit}.Evaluate(g.Usage)
+}
+
+func printGroups(groups []Group, config Config) {
+	fmt.Println()
+	fmt.Printf("%s %s %s %s %s %s\n", fitCell(T("Group"), 25), fitCell(T("Mounts"), 8),
+		fitCell(T("Total"), 10), fitCell(T("Used"), 10), fitCell(T("Free"), 10), T("Usage"))
+	for _, g := range groups {
+		color := Colors.ForUsage(g.Usage, Thresholds{Warn: g.Warn, Crit: g.Crit}, config.NoColor)
+		reset := ""
+		if color != "" {
+			reset = Colors.Reset
+		}
+		note := ""
+		if len(g.Missing) > 0 {
+			note = " (missing " + strings.Join(g.Missing, ", ") + ")"
+		}
+		fmt.Printf("%s %-8s %-10s %-10s %-10s %s%s%%%s%s\n",
+			fitCell(g.Name, 25), strconv.Itoa(len(g.Mounts)),
+			fmtBytes(g.Total, config.HumanReadable),
+			fmtBytes(g.Used, config.HumanReadable),
+			fmtBytes(g.Free, config.HumanReadable),
+			color, strconv.FormatFloat(g.Usage, 'f', 2, 64), reset, note,
+		)
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Usage samples in the -events-file older than these are folded into
+// hourly, and then daily, "aggregate" records by compaction.
+const (
+	historyHourlyAfter = 7 * 24 * time.Hour
+	historyDailyAfter  = 30 * 24 * time.Hour
+	// historyCompactEvery is how often -watch compacts the events file.
+	historyCompactEvery = time.Hour
+	// historySampleEvery is how often -watch records each mount's usage
+	// in an events file it compacts.
+	historySampleEvery = 10 * time.Minute
+)
+
+// historyLimits bound the -events-file. Records older than Retention are
+// dropped, then the oldest while the file is over MaxSize. Zero disables
+// either. With Segments, what MaxSize drops is kept in that many
+// gzip-compressed segments instead.
+type historyLimits struct {
+	Retention time.Duration
+	MaxSize   uint64
+	Segments  int
+}
+
+func (l historyLimits) enabled() bool { return l.Retention > 0 || l.MaxSize > 0 }
+
+// parseAge parses a duration that may also be given in days, e.g. "90d".
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("bad age %q", s)
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("bad age %q", s)
+	}
+	return d, nil
+}
+
+// lockFile takes the lock that serializes changes to path between
+// processes: appending to and compacting the history, or updating the
+// state file. It is a separate file since both replace path itself.
+func lockFile(path string) (func(), error) {
+	f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// historyBucket accumulates the records of one mount and period.
+type historyBucket struct {
+	e   Event
+	sum float64
+}
+
+func (b *historyBucket) add(e Event) {
+	n, lo, hi := e.Count, e.Min, e.Max
+	if e.Event != "aggregate" {
+		n, lo, hi = 1, e.Usage, e.Usage
+	}
+	if b.e.Count == 0 || lo < b.e.Min {
+		b.e.Min = lo
+	}
+	if b.e.Count == 0 || hi > b.e.Max {
+		b.e.Max = hi
+	}
+	b.e.Count += n
+	b.sum += e.Usage * float64(n)
+	b.e.Usage = b.sum / float64(b.e.Count)
+	b.e.Device, b.e.Identity = e.Device, e.Identity
+}
+
+// foldable reports whether compaction may fold e into an aggregate.
+func foldable(e Event) bool {
+	return e.Event == "sample" || e.Event == "aggregate"
+}
+
+// compactHistory rewrites the events file at path within lim: records
+// past the retention are dropped, older usage samples folded into hourly
+// or daily aggregates, and the oldest records dropped, or moved to the
+// file's segments, while it is too big. Transitions, such as status or flags changes, are kept as they
+// are: folding them would lose what changed. The new file replaces the old by rename under the history lock, so
+// a dfmon appending meanwhile neither loses records nor writes into the
+// replaced file. It returns the number of records before and after.
+func compactHistory(path string, lim historyLimits, now time.Time) (int, int, error) {
+	unlock, err := lockFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer unlock()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	var keep []Event
+	buckets := map[string]*historyBucket{}
+	before := 0
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	sc.Buffer(nil, 1<<20)
+	for line := 1; sc.Scan(); line++ {
+		if line == 1 {
+			if version, ok := parseEventsHeader(sc.Bytes()); ok {
+				if version > eventsVersion {
+					return 0, 0, newerVersion(path, "events file", version, eventsVersion)
+				}
+				continue
+			}
+		}
+		var e Event
+		if json.Unmarshal(sc.Bytes(), &e) != nil {
+			// Unparsable lines, such as one cut short by a crash, go.
+			continue
+		}
+		before++
+		age := now.Sub(e.Time)
+		if lim.Retention > 0 && age > lim.Retention {
+			continue
+		}
+		// Aggregates join the buckets of their own period or a coarser
+		// one, so records folded later merge with them.
+		period := e.Period
+		switch {
+		case age > historyDailyAfter:
+			period = "day"
+		case age > historyHourlyAfter && period == "":
+			period = "hour"
+		}
+		if period == "" || e.Mount == "" || !foldable(e) {
+			keep = append(keep, e)
+			continue
+		}
+		start := e.Time.Truncate(time.Hour)
+		if period == "day" {
+			y, m, d := e.Time.Date()
+			start = time.Date(y, m, d, 0, 0, 0, 0, e.Time.Location())
+		}
+		key := period + "\x00" + e.Mount + "\x00" + start.String()
+		bk := buckets[key]
+		if bk == nil {
+			bk = &historyBucket{e: Event{Time: start, Mount: e.Mount, Event: "aggregate", Period: period}}
+			buckets[key] = bk
+		}
+		bk.add(e)
+	}
+	if err := sc.Err(); err != nil {
+		return 0, 0, err
+	}
+	for _, bk := range buckets {
+		keep = append(keep, bk.e)
+	}
+	sort.SliceStable(keep, func(i, j int) bool { return keep[i].Time.Before(keep[j].Time) })
+
+	lines := make([][]byte, len(keep))
+	var size uint64
+	for i, e := range keep {
+		if lines[i], err = json.Marshal(e); err != nil {
+			return 0, 0, err
+		}
+		size += uint64(len(lines[i])) + 1
+	}
+	var dropped [][]byte
+	for lim.MaxSize > 0 && size > lim.MaxSize && len(lines) > 0 {
+		size -= uint64(len(lines[0])) + 1
+		dropped = append(dropped, lines[0])
+		lines = lines[1:]
+	}
+	// The segment is written first: a failure between the two writes
+	// repeats records rather than losing them.
+	if lim.Segments > 0 && len(dropped) > 0 {
+		if err := rotateHistory(path, dropped, lim); err != nil {
+			return 0, 0, err
+		}
+	}
+	if err := expireSegments(path, lim, now); err != nil {
+		return 0, 0, err
+	}
+
+	_, err = writeOutputFile(path, "", func(w io.Writer) error {
+		return writeEventLines(w, nil, lines)
+	})
+	return before, len(lines), err
+}
+
+// writeEventLines writes an events file holding the records of prev, the
+// content of an earlier file with its header, followed by lines.
+func writeEventLines(w io.Writer, prev []byte, lines [][]byte) error {
+	if len(prev) == 0 {
+		if err := writeEventsHeader(w); err != nil {
+			return err
+		}
+	} else if _, err := w.Write(prev); err != nil {
+		return err
+	}
+	for _, l := range lines {
+		if _, err := w.Write(append(l, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// historySegment is the name of the i-th newest segment of the events
+// file at path, counting from 1.
+func historySegment(path string, i int) string {
+	return fmt.Sprintf("%s.%d.gz", path, i)
+}
+
+// historySegments lists the segments of the events file at path that
+// exist, oldest first.
+func historySegments(path string) []string {
+	var names []string
+	for i := 1; ; i++ {
+		name := historySegment(path, i)
+		if _, err := os.Stat(name); err != nil {
+			break
+		}
+		names = append([]string{name}, names...)
+	}
+	return names
+}
+
+// rotateHistory appends lines, the oldest records of the events file at
+// path, to its newest segment. A segment that has reached lim.MaxSize,
+// compressed, is moved up first, and segments past lim.Segments deleted.
+func rotateHistory(path string, lines [][]byte, lim historyLimits) error {
+	newest := historySegment(path, 1)
+	if fi, err := os.Stat(newest); err == nil && uint64(fi.Size()) >= lim.MaxSize {
+		for i := lim.Segments; ; i++ {
+			if err := os.Remove(historySegment(path, i)); errors.Is(err, fs.ErrNotExist) {
+				break
+			} else if err != nil {
+				return err
+			}
+		}
+		for i := lim.Segments - 1; i >= 1; i-- {
+			if err := os.Rename(historySegment(path, i), historySegment(path, i+1)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+	}
+
+	prev, err := readInput(newest)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	_, err = writeOutputFile(strings.TrimSuffix(newest, ".gz"), "gzip", func(w io.Writer) error {
+		return writeEventLines(w, prev, lines)
+	})
+	return err
+}
+
+// expireSegments deletes the segments of the events file at path last
+// written before lim.Retention, which hold nothing newer.
+func expireSegments(path string, lim historyLimits, now time.Time) error {
+	if lim.Retention <= 0 {
+		return nil
+	}
+	for _, name := range historySegments(path) {
+		fi, err := os.Stat(name)
+		if err != nil || now.Sub(fi.ModTime()) <= lim.Retention {
+			continue
+		}
+		if err := os.Remove(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runHistory implements "dfmon history compact", for compacting an
+// -events-file from cron rather than from a -watch daemon.
+func runHistory(ctx context.Context, config Config, args []string, logger *log.Logger) {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	retention := flags.String("retention", "", "Drop records older than this (e.g. 90d; default -history-retention)")
+	maxSize := flags.String("max-size", "", "Drop the oldest records while the file is bigger (e.g. 100M; default -history-max-size)")
+	segments := flags.Int("segments", config.History.Segments, "Keep the records -max-size drops in this many gzip-compressed segments, FILE.1.gz the newest")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: dfmon history compact [-retention AGE] [-max-size SIZE] [-segments N] FILE")
+		flags.PrintDefaults()
+	}
+	if len(args) == 0 || args[0] != "compact" {
+		flags.Usage()
+		os.Exit(2)
+	}
+	flags.Parse(args[1:])
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	lim := config.History
+	var err error
+	if *retention != "" {
+		if lim.Retention, err = parseAge(*retention); err != nil {
+			logger.Fatalf("Invalid -retention: %v", err)
+		}
+	}
+	if *maxSize != "" {
+		if lim.MaxSize, err = parseSize(*maxSize); err != nil {
+			logger.Fatalf("Invalid -max-size: %v", err)
+		}
+	}
+	if lim.Segments = *segments; lim.Segments < 0 {
+		logger.Fatalf("Invalid -segments: must not be negative")
+	}
+	before, after, err := compactHistory(flags.Arg(0), lim, time.Now())
+	if err != nil {
+		logger.Fatalf("Failed to compact history: %v", err)
+	}
+	fmt.Printf("%s: %d records, %d after compaction\n", flags.Arg(0), before, after)
+}
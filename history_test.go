@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeEvents(t *testing.T, path string, events []Event) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := writeEventsHeader(f); err != nil {
+		t.Fatal(err)
+	}
+	enc := json.NewEncoder(f)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readEvents(t *testing.T, path string) []Event {
+	t.Helper()
+	f, err := openInput(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var events []Event
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if _, ok := parseEventsHeader(sc.Bytes()); ok {
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestCompactHistory(t *testing.T) {
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	hourOld := now.Add(-10 * 24 * time.Hour).Truncate(time.Hour)
+	dayOld := time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)
+	events := []Event{
+		{Time: dayOld.Add(time.Hour), Mount: "/", Event: "sample", Usage: 10},
+		{Time: dayOld.Add(5 * time.Hour), Mount: "/", Event: "sample", Usage: 30},
+		{Time: dayOld.Add(6 * time.Hour), Mount: "/", Event: "status", Old: "ok", New: "warning", Usage: 31},
+		{Time: hourOld.Add(time.Minute), Mount: "/", Event: "sample", Usage: 40},
+		{Time: hourOld.Add(20 * time.Minute), Mount: "/", Event: "sample", Usage: 60},
+		{Time: hourOld.Add(30 * time.Minute), Mount: "/", Event: "readonly", Old: "ST_NOSUID", New: "ST_RDONLY,ST_NOSUID", Severity: "critical"},
+		{Time: hourOld.Add(40 * time.Minute), Mount: "/", Event: "flags", Old: "ST_RDONLY,ST_NOSUID", New: "ST_RDONLY", Severity: "warning"},
+		{Time: now.Add(-time.Hour), Mount: "/", Event: "sample", Usage: 70},
+		{Time: now.Add(-100 * 24 * time.Hour), Mount: "/", Event: "status", Old: "warning", New: "ok"},
+	}
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	writeEvents(t, path, events)
+
+	before, after, err := compactHistory(path, historyLimits{Retention: 90 * 24 * time.Hour}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before != len(events) {
+		t.Errorf("before = %d, want %d", before, len(events))
+	}
+	got := readEvents(t, path)
+	if after != len(got) {
+		t.Errorf("after = %d, file has %d", after, len(got))
+	}
+
+	want := []Event{
+		{Time: dayOld, Mount: "/", Event: "aggregate", Period: "day", Count: 2, Usage: 20, Min: 10, Max: 30},
+		events[2],
+		{Time: hourOld, Mount: "/", Event: "aggregate", Period: "hour", Count: 2, Usage: 50, Min: 40, Max: 60},
+		events[5],
+		events[6],
+		events[7],
+	}
+	if len(got) != len(want) {
+		t.Fatalf("compacted to %d records, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if !g.Time.Equal(w.Time) || g.Event != w.Event || g.Old != w.Old || g.New != w.New ||
+			g.Severity != w.Severity || g.Period != w.Period || g.Count != w.Count ||
+			g.Usage != w.Usage || g.Min != w.Min || g.Max != w.Max {
+			t.Errorf("record %d = %+v, want %+v", i, g, w)
+		}
+	}
+
+	// A second compaction merges into the aggregates without changing them.
+	if _, _, err := compactHistory(path, historyLimits{Retention: 90 * 24 * time.Hour}, now); err != nil {
+		t.Fatal(err)
+	}
+	if again := readEvents(t, path); len(again) != len(got) || again[0].Count != 2 || again[2].Usage != 50 {
+		t.Errorf("recompaction changed the records: %+v", again)
+	}
+}
+
+func TestCompactHistoryMaxSize(t *testing.T) {
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	var events []Event
+	for i := 0; i < 10; i++ {
+		events = append(events, Event{Time: now.Add(time.Duration(i-10) * time.Minute), Mount: "/", Event: "status", Old: "ok", New: "warning"})
+	}
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	writeEvents(t, path, events)
+	b, _ := json.Marshal(events[0])
+	if _, _, err := compactHistory(path, historyLimits{MaxSize: uint64(3 * (len(b) + 1))}, now); err != nil {
+		t.Fatal(err)
+	}
+	got := readEvents(t, path)
+	if len(got) != 3 || !got[0].Time.Equal(events[7].Time) {
+		t.Errorf("kept %+v, want the newest 3", got)
+	}
+}
+
+// TestCompactHistorySegments compacts a growing events file in rounds and
+// checks the records -history-max-size drops are kept, in order, in at
+// most -history-segments gzip segments that dfmon events reads back.
+func TestCompactHistorySegments(t *testing.T) {
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	// Mounts that do not compress away fill the segments.
+	event := func(n int) Event {
+		return Event{Time: now.Add(time.Duration(n) * time.Second), Mount: fmt.Sprintf("/%x", sha256.Sum256([]byte{byte(n), byte(n >> 8)})),
+			Event: "status", Old: "ok", New: "warning"}
+	}
+	b, _ := json.Marshal(event(0))
+	lim := historyLimits{MaxSize: uint64(4 * (len(b) + 1)), Segments: 2}
+
+	var written []Event
+	for round := 0; round < 30; round++ {
+		events := []Event{}
+		if round > 0 {
+			events = readEvents(t, path)
+		}
+		for i := 0; i < 5; i++ {
+			e := event(len(written))
+			events, written = append(events, e), append(written, e)
+		}
+		writeEvents(t, path, events)
+		if _, _, err := compactHistory(path, lim, now.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	segments := historySegments(path)
+	if len(segments) != 2 || segments[0] != path+".2.gz" || segments[1] != path+".1.gz" {
+		t.Fatalf("segments = %q", segments)
+	}
+	if _, err := os.Stat(path + ".3.gz"); err == nil {
+		t.Errorf("kept a third segment")
+	}
+	var got []Event
+	for _, name := range append(segments, path) {
+		got = append(got, readEvents(t, name)...)
+	}
+	// What is left is the newest records, without gaps or repeats.
+	if len(got) == 0 || len(got) >= len(written) {
+		t.Fatalf("%d of %d records kept", len(got), len(written))
+	}
+	tail := written[len(written)-len(got):]
+	for i := range got {
+		if !got[i].Time.Equal(tail[i].Time) {
+			t.Fatalf("record %d at %v, want %v", i, got[i].Time, tail[i].Time)
+		}
+	}
+
+	dfmon := buildDfmon(t)
+	cmd := exec.Command(dfmon, "events", "-event", "status", path)
+	cmd.Env = withoutDfmonEnv()
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("dfmon events: %v\n%s", err, out)
+	}
+	if n := strings.Count(string(out), "\n"); n != len(got) {
+		t.Errorf("dfmon events printed %d records, want %d:\n%s", n, len(got), out)
+	}
+
+	// A segment last written before the retention goes.
+	old := now.Add(-48 * time.Hour)
+	if err := os.Chtimes(segments[0], old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := compactHistory(path, historyLimits{Retention: 24 * time.Hour, MaxSize: lim.MaxSize, Segments: 2}, now); err != nil {
+		t.Fatal(err)
+	}
+	if segments := historySegments(path); len(segments) != 1 || segments[0] != path+".1.gz" {
+		t.Errorf("after expiry: segments = %q", segments)
+	}
+}
+
+func TestEventLogSamples(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	l, err := openEventLog(path, historyLimits{Retention: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.f.Close()
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	list := []FS{
+		{Mount: "/", Usage: 50},
+		{Mount: "/denied", Denied: true},
+		{Mount: "/ignored", Ignored: true},
+	}
+	for _, dt := range []time.Duration{0, time.Minute, historySampleEvery} {
+		if err := l.observe(list, Config{}, now.Add(dt), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := readEvents(t, path)
+	if len(got) != 2 {
+		t.Fatalf("recorded %+v, want 2 samples of /", got)
+	}
+	for _, e := range got {
+		if e.Event != "sample" || e.Mount != "/" || e.Usage != 50 {
+			t.Errorf("recorded %+v, want a sample of /", e)
+		}
+	}
+
+	// Without limits the file holds transitions only.
+	path = filepath.Join(t.TempDir(), "events.ndjson")
+	l, err = openEventLog(path, historyLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.f.Close()
+	if err := l.observe(list, Config{}, now, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := readEvents(t, path); len(got) != 0 {
+		t.Errorf("recorded %+v without history limits", got)
+	}
+}
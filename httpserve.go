@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// httpServer is the -listen server of the exporter and the aggregator:
+// one http.Server on every comma-separated -listen address, with TLS
+// when -tls-cert is given and basic auth with -basic-auth-file.
+type httpServer struct {
+	srv       *http.Server
+	listeners []net.Listener
+}
+
+// listenHTTP loads the certificates and password file and listens on
+// every address. Anything unreadable is an error here, before a port is
+// open, rather than a server quietly left without TLS or auth. The files
+// are read again on SIGHUP; a failed reload keeps the previous ones.
+func listenHTTP(ctx context.Context, config Config, handler http.Handler, logger *log.Logger) (*httpServer, error) {
+	var tlsConfig atomic.Pointer[tls.Config]
+	var auth *basicAuth
+	loadTLS, loadAuth := func() error { return nil }, func() error { return nil }
+	if config.TLSCert != "" {
+		loadTLS = func() error {
+			c, err := serverTLSConfig(config.TLSCert, config.TLSKey, config.TLSClientCA)
+			if err == nil {
+				tlsConfig.Store(c)
+			}
+			return err
+		}
+	}
+	if config.BasicAuthFile != "" {
+		auth = &basicAuth{next: handler}
+		handler = auth
+		loadAuth = func() error {
+			users, err := readHtpasswd(config.BasicAuthFile)
+			if err == nil {
+				auth.users.Store(&users)
+			}
+			return err
+		}
+	}
+	if err := errors.Join(loadTLS(), loadAuth()); err != nil {
+		return nil, err
+	}
+
+	s := &httpServer{srv: &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}}
+	for _, addr := range splitList(config.Listen) {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+		if tlsConfig.Load() != nil {
+			ln = tls.NewListener(ln, &tls.Config{
+				GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) { return tlsConfig.Load(), nil },
+			})
+		}
+		s.listeners = append(s.listeners, ln)
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				s.srv.Shutdown(shutdownCtx)
+				return
+			case <-hup:
+				if err := errors.Join(loadTLS(), loadAuth()); err != nil {
+					logger.Printf("Warning: keeping the previous TLS and auth files: %v", err)
+				}
+			}
+		}
+	}()
+	return s, nil
+}
+
+// serve serves on every listener until the server is shut down, and
+// returns the first error from any of them.
+func (s *httpServer) serve() error {
+	errs := make(chan error, len(s.listeners))
+	for _, ln := range s.listeners {
+		go func() { errs <- s.srv.Serve(ln) }()
+	}
+	for range s.listeners {
+		if err := <-errs; err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.srv.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *httpServer) close() {
+	for _, ln := range s.listeners {
+		ln.Close()
+	}
+}
+
+// serverTLSConfig loads the server's key pair and, when caFile is given,
+// the bundle of CAs a client certificate must be signed by.
+func serverTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("-tls-cert: %v", err)
+	}
+	c := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("-tls-client-ca: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("-tls-client-ca: %s: no certificates found", caFile)
+		}
+		c.ClientCAs, c.ClientAuth = pool, tls.RequireAndVerifyClientCert
+	}
+	return c, nil
+}
+
+// basicAuth admits the requests carrying a user and password from the
+// -basic-auth-file.
+type basicAuth struct {
+	next  http.Handler
+	users atomic.Pointer[map[string]string]
+}
+
+// dummyHash is what a password for an unknown user is compared with, so
+// timing does not tell which users exist.
+var dummyHash = sync.OnceValue(func() string {
+	h, _ := bcrypt.GenerateFromPassword([]byte("dfmon"), bcrypt.DefaultCost)
+	return string(h)
+})
+
+func (a *basicAuth) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	user, pass, ok := req.BasicAuth()
+	hash, known := (*a.users.Load())[user]
+	if !known {
+		hash = dummyHash()
+	}
+	if !passwordMatches(hash, pass) || !ok || !known {
+		w.Header().Set("WWW-Authenticate", `Basic realm="dfmon"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	a.next.ServeHTTP(w, req)
+}
+
+// passwordMatches reports whether pass is the password hash was made
+// from, hash being a bcrypt or {SHA} hash checked by readHtpasswd.
+func passwordMatches(hash, pass string) bool {
+	if digest, ok := strings.CutPrefix(hash, "{SHA}"); ok {
+		want, _ := base64.StdEncoding.DecodeString(digest)
+		sum := sha1.Sum([]byte(pass))
+		return subtle.ConstantTimeCompare(sum[:], want) == 1
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) == nil
+}
+
+// readHtpasswd reads a password file in the htpasswd format, one
+// "user:hash" line per user, with blank lines and # comments skipped.
+// The bcrypt hashes of "htpasswd -B" are understood, and the unsalted
+// {SHA} ones of "htpasswd -s" for older files. Any other hash is refused
+// here rather than locking everyone out at the first request.
+func readHtpasswd(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("-basic-auth-file: %v", err)
+	}
+	defer f.Close()
+
+	users := map[string]string{}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("-basic-auth-file: %s:%d: not user:hash", path, n)
+		}
+		switch {
+		case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+			if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+				return nil, fmt.Errorf("-basic-auth-file: %s:%d: invalid bcrypt hash for %s", path, n, user)
+			}
+		case strings.HasPrefix(hash, "{SHA}"):
+			sum, err := base64.StdEncoding.DecodeString(hash[len("{SHA}"):])
+			if err != nil || len(sum) != sha1.Size {
+				return nil, fmt.Errorf("-basic-auth-file: %s:%d: invalid {SHA} hash for %s", path, n, user)
+			}
+		default:
+			return nil, fmt.Errorf("-basic-auth-file: %s:%d: unsupported hash for %s; use bcrypt (htpasswd -B)", path, n, user)
+		}
+		users[user] = hash
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("-basic-auth-file: %v", err)
+	}
+	return users, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBasicAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// htpasswd -B writes $2y$, which is the same algorithm as Go's $2a$.
+	apache := "$2y$" + strings.TrimPrefix(string(hash), "$2a$")
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	writeFile(t, path, "# scrapers\n\nprometheus:"+string(hash)+"\ngrafana:"+apache+"\n"+
+		"legacy:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n")
+	users, err := readHtpasswd(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := &basicAuth{next: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {})}
+	auth.users.Store(&users)
+
+	tests := []struct {
+		user, pass string
+		want       int
+	}{
+		{"prometheus", "s3cret", http.StatusOK},
+		{"grafana", "s3cret", http.StatusOK},
+		{"legacy", "password", http.StatusOK},
+		{"prometheus", "wrong", http.StatusUnauthorized},
+		{"grafana", "", http.StatusUnauthorized},
+		{"legacy", "s3cret", http.StatusUnauthorized},
+		{"nobody", "s3cret", http.StatusUnauthorized},
+		{"", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if tt.user != "" {
+			req.SetBasicAuth(tt.user, tt.pass)
+		}
+		w := httptest.NewRecorder()
+		auth.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s:%s: status %d, want %d", tt.user, tt.pass, w.Code, tt.want)
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s:%s: no WWW-Authenticate", tt.user, tt.pass)
+		}
+	}
+}
+
+func TestReadHtpasswdInvalid(t *testing.T) {
+	for _, line := range []string{
+		"no-colon",
+		":$2y$05$abcdefghijklmnopqrstuu",
+		"user:$apr1$salt$hash",
+		"user:plaintext",
+		"user:$2y$05$short",
+		"user:{SHA}not-base64!",
+	} {
+		path := filepath.Join(t.TempDir(), "htpasswd")
+		writeFile(t, path, line+"\n")
+		if _, err := readHtpasswd(path); err == nil {
+			t.Errorf("%q accepted", line)
+		}
+	}
+}
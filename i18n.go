@@ -0,0 +1,56 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"os"
+	"strings"
+)
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// catalog holds the messages for the selected language; English fills
+// any key a translation lacks. Only human-facing output is translated,
+// never JSON or CSV field names.
+var catalog = loadCatalog("en")
+
+func loadCatalog(lang string) map[string]string {
+	msgs := map[string]string{}
+	for _, l := range []string{"en", lang} {
+		b, err := localeFiles.ReadFile("locales/" + l + ".json")
+		if err != nil {
+			continue
+		}
+		var m map[string]string
+		if json.Unmarshal(b, &m) != nil {
+			continue
+		}
+		for k, v := range m {
+			msgs[k] = v
+		}
+	}
+	return msgs
+}
+
+// setLang selects the catalog for lang, or from the locale environment
+// when lang is empty. "de_DE.UTF-8" selects "de".
+func setLang(lang string) {
+	for _, v := range []string{lang, os.Getenv("LC_ALL"), os.Getenv("LC_MESSAGES"), os.Getenv("LANG")} {
+		if v != "" {
+			lang = v
+			break
+		}
+	}
+	lang, _, _ = strings.Cut(lang, ".")
+	lang, _, _ = strings.Cut(lang, "_")
+	catalog = loadCatalog(strings.ToLower(lang))
+}
+
+// T returns the translation of key, or key itself if no catalog has it.
+func T(key string) string {
+	if s, ok := catalog[key]; ok {
+		return s
+	}
+	return key
+}
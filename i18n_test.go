@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"os/exec"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func readCatalog(t *testing.T, lang string) map[string]string {
+	t.Helper()
+	b, err := localeFiles.ReadFile("locales/" + lang + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]string
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatalf("%s: %v", lang, err)
+	}
+	return m
+}
+
+var verbs = regexp.MustCompile(`%[-+# 0]*[0-9.]*[a-zA-Z%]`)
+
+// TestCatalogs checks every translation against English: each English key
+// is translated or falls back to English, no catalog has keys English
+// lacks, and translations keep the format verbs in order.
+func TestCatalogs(t *testing.T) {
+	en := readCatalog(t, "en")
+	files, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		lang := strings.TrimSuffix(f.Name(), ".json")
+		tr := readCatalog(t, lang)
+		for k := range tr {
+			if _, ok := en[k]; !ok {
+				t.Errorf("%s: key %q is not in English", lang, k)
+			}
+		}
+		msgs := loadCatalog(lang)
+		for k, v := range en {
+			got, ok := msgs[k]
+			if !ok || got == "" {
+				t.Errorf("%s: %q has no message", lang, k)
+				continue
+			}
+			if _, translated := tr[k]; !translated && got != v {
+				t.Errorf("%s: %q = %q, want the English %q", lang, k, got, v)
+			}
+			if want, have := verbs.FindAllString(v, -1), verbs.FindAllString(got, -1); strings.Join(want, " ") != strings.Join(have, " ") {
+				t.Errorf("%s: %q has verbs %q, English %q", lang, k, have, want)
+			}
+		}
+	}
+}
+
+func TestSetLang(t *testing.T) {
+	t.Cleanup(func() { catalog = loadCatalog("en") })
+	tests := []struct {
+		lang, lcAll, lang2 string
+		want               string
+	}{
+		{"de", "", "", "Gerät"},
+		{"", "", "de_DE.UTF-8", "Gerät"},
+		{"", "de_AT", "en_US.UTF-8", "Gerät"},
+		{"en", "de_DE", "", "Device"},
+		// An unknown language is English.
+		{"xx", "", "", "Device"},
+		{"", "C", "", "Device"},
+		{"", "", "", "Device"},
+	}
+	for _, tt := range tests {
+		t.Setenv("LC_ALL", tt.lcAll)
+		t.Setenv("LC_MESSAGES", "")
+		t.Setenv("LANG", tt.lang2)
+		setLang(tt.lang)
+		if got := T("Device"); got != tt.want {
+			t.Errorf("lang %q, LC_ALL %q, LANG %q: %q, want %q", tt.lang, tt.lcAll, tt.lang2, got, tt.want)
+		}
+	}
+	if got := T("no.such.key"); got != "no.such.key" {
+		t.Errorf("missing key: %q", got)
+	}
+}
+
+// TestLangOutputs checks that only the table is translated.
+func TestLangOutputs(t *testing.T) {
+	exe := buildDfmon(t)
+	run := func(args ...string) string {
+		cmd := exec.Command(exe, append(append([]string{"-config", emptyConfig(t)}, quietThresholds...), args...)...)
+		cmd.Env = append(withoutDfmonEnv(), "LC_ALL=", "LC_MESSAGES=", "LANG=de_DE.UTF-8")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("%v: %s", err, out)
+		}
+		return string(out)
+	}
+	if out := run("-o", "table"); !strings.Contains(out, "Einhängepunkt") || !strings.Contains(out, "Dateisysteme") {
+		t.Errorf("table not in German:\n%s", out)
+	}
+	for _, format := range []string{"json", "csv"} {
+		out := run("-o", format)
+		if strings.Contains(out, "Einhängepunkt") || strings.Contains(out, "Dateisysteme") || !strings.Contains(strings.ToLower(out), "mount") {
+			t.Errorf("%s is translated:\n%s", format, out)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// deviceUUIDs maps each device node to its filesystem UUID from the
+// /dev/disk/by-uuid links.
+func deviceUUIDs() map[string]string {
+	uuids := map[string]string{}
+	entries, _ := os.ReadDir("/dev/disk/by-uuid")
+	for _, e := range entries {
+		if dev := resolveDevice(filepath.Join("/dev/disk/by-uuid", e.Name())); dev != "" {
+			uuids[dev] = e.Name()
+		}
+	}
+	return uuids
+}
+
+// applyIdentity sets the identity of each entry, which unlike the device
+// name survives /dev/sdb coming back as /dev/sdc: "uuid:" and the
+// filesystem UUID where the device resolves to one, else "fsid:" with the
+// statfs fsid and mount path, else "mount:" and the path. A UUID found on
+// more than one device, as with an LVM snapshot mounted next to its
+// origin, is not used for either.
+func applyIdentity(list []FS, uuids map[string]string) {
+	devs := map[string]map[string]bool{}
+	resolved := make([]string, len(list))
+	for i, d := range list {
+		resolved[i] = resolveDevice(d.Device)
+		if uuid, ok := uuids[resolved[i]]; ok {
+			if devs[uuid] == nil {
+				devs[uuid] = map[string]bool{}
+			}
+			devs[uuid][resolved[i]] = true
+		}
+	}
+	for i := range list {
+		d := &list[i]
+		switch uuid, ok := uuids[resolved[i]]; {
+		case ok && len(devs[uuid]) == 1:
+			d.Identity = "uuid:" + uuid
+		case d.Fsid != "" && d.Fsid != "0000000000000000":
+			d.Identity = "fsid:" + d.Fsid + ":" + d.Mount
+		default:
+			d.Identity = "mount:" + d.Mount
+		}
+	}
+}
+
+// identityKey returns the key d is stored under in the state file. Files
+// before state version 3 used the mount path, which identities never
+// start with.
+func identityKey(d FS) string {
+	if d.Identity == "" {
+		return d.Mount
+	}
+	return d.Identity
+}
+
+// differentUUID reports whether two entries are known to be different
+// filesystems: only two UUIDs are trusted to tell them apart, since the
+// other forms depend on the mount path or on udev being there.
+func differentUUID(a, b FS) bool {
+	return strings.HasPrefix(a.Identity, "uuid:") && strings.HasPrefix(b.Identity, "uuid:") && a.Identity != b.Identity
+}
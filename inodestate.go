@@ -0,0 +1,44 @@
+package main
+
+import "fmt"
+
+// The states of a filesystem's inode accounting. Inode numbers are only
+// meaningful when they are available: FAT and btrfs have no fixed inode
+// table, and a filesystem reporting no inodes that dfmon does not know,
+// or one it may not stat, is unknown.
+const (
+	inodesAvailable     = "available"
+	inodesNotApplicable = "not-applicable"
+	inodesUnknown       = "unknown"
+)
+
+// noInodeTypes report zero inodes in statfs because they have none to
+// count.
+var noInodeTypes = map[string]bool{
+	"vfat": true, "msdos": true, "exfat": true, "btrfs": true,
+}
+
+func inodeState(fsType string, files uint64) string {
+	switch {
+	case files > 0:
+		return inodesAvailable
+	case noInodeTypes[fsType]:
+		return inodesNotApplicable
+	}
+	return inodesUnknown
+}
+
+// inodeStateValue is dfmon_filesystem_inode_state for each state.
+var inodeStateValue = map[string]float64{inodesAvailable: 1, inodesNotApplicable: 0, inodesUnknown: -1}
+
+// inodesCell is d's inode usage as the verbose table shows it, or its
+// state when there is none.
+func inodesCell(d FS) string {
+	if d.Inodes == 0 {
+		if d.InodeState == "" {
+			return inodesUnknown
+		}
+		return d.InodeState
+	}
+	return fmt.Sprintf("%d/%d (%.2f%%)", d.InodesUsed, d.Inodes, d.InodeUsage)
+}
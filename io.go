@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// diskStat is the cumulative sector counts for one /proc/diskstats line.
+type diskStat struct {
+	name    string
+	read    uint64
+	written uint64
+}
+
+func readDiskstats() (map[string]diskStat, error) {
+	f, err := os.Open("/proc/diskstats")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stats := map[string]diskStat{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		read, _ := strconv.ParseUint(fields[5], 10, 64)
+		written, _ := strconv.ParseUint(fields[9], 10, 64)
+		stats[fields[0]+":"+fields[1]] = diskStat{name: fields[2], read: read, written: written}
+	}
+	return stats, sc.Err()
+}
+
+func uptime() (time.Duration, error) {
+	b, err := os.ReadFile("/proc/uptime")
+	if err != nil {
+		return 0, err
+	}
+	secs, _ := strconv.ParseFloat(strings.Fields(string(b) + " 0")[0], 64)
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+// diskStatFor finds the block device behind d: its own major:minor when
+// that is a real device, otherwise the node its source resolves to, which
+// covers btrfs and other filesystems reporting an anonymous st_dev.
+func diskStatFor(d FS, stats map[string]diskStat) (diskStat, bool) {
+	if s, ok := stats[d.Dev]; ok {
+		return s, true
+	}
+	name := filepath.Base(resolveDevice(d.Device))
+	for _, s := range stats {
+		if s.name == name {
+			return s, true
+		}
+	}
+	return diskStat{}, false
+}
+
+// applyIO sets read and write rates from two diskstats samples taken
+// sample apart, or from the averages since boot when sample is zero.
+func applyIO(ctx context.Context, list []FS, sample time.Duration) error {
+	first, err := readDiskstats()
+	if err != nil {
+		return err
+	}
+	before := map[string]diskStat{}
+	var elapsed time.Duration
+	if sample > 0 {
+		before = first
+		select {
+		case <-time.After(sample):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if first, err = readDiskstats(); err != nil {
+			return err
+		}
+		elapsed = sample
+	} else if elapsed, err = uptime(); err != nil {
+		return err
+	}
+	if elapsed <= 0 {
+		return fmt.Errorf("no sample interval")
+	}
+
+	for i := range list {
+		now, ok := diskStatFor(list[i], first)
+		if !ok {
+			continue
+		}
+		then, _ := diskStatFor(list[i], before)
+		// diskstats always counts 512-byte sectors.
+		list[i].ReadBps = float64(now.read-then.read) * 512 / elapsed.Seconds()
+		list[i].WriteBps = float64(now.written-then.written) * 512 / elapsed.Seconds()
+		if list[i].WriteBps > 0 {
+			list[i].FullIn = float64(list[i].Free) / list[i].WriteBps
+		}
+	}
+	return nil
+}
+
+func ioLine(d FS, humanReadable bool) string {
+	line := fmt.Sprintf("    io: read %s/s write %s/s",
+		fmtBytes(uint64(d.ReadBps), humanReadable), fmtBytes(uint64(d.WriteBps), humanReadable))
+	if d.FullIn > 0 {
+		line += ", full in ~" + fmtDuration(time.Duration(d.FullIn*float64(time.Second)))
+	}
+	return line
+}
+
+// fmtDuration rounds d to the two largest of days, hours and minutes.
+func fmtDuration(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("%dd%dh", d/(24*time.Hour), d%(24*time.Hour)/time.Hour)
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh%dm", d/time.Hour, d%time.Hour/time.Minute)
+	default:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// JobConfig declares a recurring job known to write to matching mounts,
+// such as a nightly backup, so that a mount without room for its next run
+// is reported before the run fails.
+type JobConfig struct {
+	Name  string `json:"name"`
+	Match string `json:"match"`
+	// Size is what one run adds and Margin the free space that must be
+	// left afterwards, both as sizes like 300G.
+	Size   string `json:"size"`
+	Margin string `json:"margin,omitempty"`
+	// At is the start time and optional days, e.g. "02:00" or
+	// "23:30 Mon-Fri".
+	At string `json:"at"`
+}
+
+// JobFit is the outcome of checking a job against a mount's free space.
+// Free is the space expected at the next run, after the current fill rate
+// when -state has a trusted one.
+type JobFit struct {
+	Name   string    `json:"name"`
+	Size   uint64    `json:"size"`
+	Margin uint64    `json:"margin"`
+	Next   time.Time `json:"next_run"`
+	Free   uint64    `json:"free_at_run"`
+	Fits   bool      `json:"fits"`
+}
+
+// jobTime is a parsed At: minutes since midnight on the given days.
+type jobTime struct {
+	minute int
+	days   [7]bool
+}
+
+func parseJobTime(s string) (jobTime, error) {
+	var t jobTime
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return t, fmt.Errorf("invalid time %q: want HH:MM [DAYS]", s)
+	}
+	var err error
+	if t.minute, err = parseClock(fields[0]); err != nil {
+		return t, err
+	}
+	t.days, err = parseDays(fields[1:], "time", s)
+	return t, err
+}
+
+// next is the first start at or after now.
+func (t jobTime) next(now time.Time) time.Time {
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	for i := 0; i <= 7; i++ {
+		start := day.AddDate(0, 0, i).Add(time.Duration(t.minute) * time.Minute)
+		if t.days[start.Weekday()] && !start.Before(now) {
+			return start
+		}
+	}
+	return time.Time{}
+}
+
+func (j JobConfig) check(key string) []error {
+	var errs []error
+	if j.Name == "" {
+		errs = append(errs, fmt.Errorf("%s.name: required", key))
+	}
+	if j.Match == "" {
+		errs = append(errs, fmt.Errorf("%s.match: required", key))
+	} else if _, err := filepath.Match(j.Match, ""); err != nil {
+		errs = append(errs, fmt.Errorf("%s.match: bad pattern %q", key, j.Match))
+	}
+	if _, err := parseSize(j.Size); err != nil {
+		errs = append(errs, fmt.Errorf("%s.size: %v", key, err))
+	}
+	if j.Margin != "" {
+		if _, err := parseSize(j.Margin); err != nil {
+			errs = append(errs, fmt.Errorf("%s.margin: %v", key, err))
+		}
+	}
+	if _, err := parseJobTime(j.At); err != nil {
+		errs = append(errs, fmt.Errorf("%s.at: %v", key, err))
+	}
+	return errs
+}
+
+// applyJobs checks every job against the mounts it matches at time now.
+func applyJobs(list []FS, jobs []JobConfig, now time.Time) {
+	for i := range list {
+		d := &list[i]
+		if d.Denied {
+			continue
+		}
+		for _, j := range jobs {
+			if ok, _ := filepath.Match(j.Match, d.Mount); !ok {
+				continue
+			}
+			d.Jobs = append(d.Jobs, fitJob(*d, j, now))
+		}
+	}
+}
+
+func fitJob(d FS, j JobConfig, now time.Time) JobFit {
+	// The config is validated on load.
+	size, _ := parseSize(j.Size)
+	var margin uint64
+	if j.Margin != "" {
+		margin, _ = parseSize(j.Margin)
+	}
+	at, _ := parseJobTime(j.At)
+
+	f := JobFit{Name: j.Name, Size: size, Margin: margin, Next: at.next(now), Free: d.Free}
+	if g := d.Growth; g != nil && !g.LowConfidence && g.Smoothed > 0 {
+		f.Free -= min(f.Free, uint64(g.Smoothed*f.Next.Sub(now).Seconds()))
+	}
+	f.Fits = f.Free >= size+margin
+	return f
+}
+
+// jobStatus is a warning when a job will not fit, whatever the usage.
+func jobStatus(d FS) Status {
+	for _, f := range d.Jobs {
+		if !f.Fits {
+			return StatusWarning
+		}
+	}
+	return StatusOK
+}
+
+func jobLine(f JobFit, humanReadable bool) string {
+	return fmt.Sprintf("    job %s at %s: needs %s + %s margin, %s free — insufficient headroom",
+		sanitize(f.Name), f.Next.Format("Mon 15:04"), fmtBytes(f.Size, humanReadable),
+		fmtBytes(f.Margin, humanReadable), fmtBytes(f.Free, humanReadable))
+}
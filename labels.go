@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"strings"
+)
+
+// labelStyles are the ways a mount path can be turned into a metric label
+// or key, for systems with stricter character rules than Prometheus label
+// values:
+//
+//	raw         the path unchanged
+//	underscore  [A-Za-z0-9_] only, e.g. var_lib_docker, with root for /
+//	url         the path percent-encoded, e.g. %2Fvar%2Flib%2Fdocker
+//	hash        underscore plus a short hash of the path, always
+var labelStyles = []string{"raw", "underscore", "url", "hash"}
+
+// labelHashLen is the number of hex digits of the hash suffix.
+const labelHashLen = 6
+
+// mountLabels maps each distinct mount path in list to its label in style.
+// Paths that would end up with the same label, such as /data/a-b and
+// /data/a_b in the underscore style, all get the hash suffix, so distinct
+// mounts never share one. A suffixed label can still meet another mount's,
+// say that of a mount named /data/a_b_<hash>; those are suffixed again,
+// hashing the label too, until none is shared.
+func mountLabels(list []FS, style string) map[string]string {
+	labels := map[string]string{}
+	for _, d := range list {
+		if _, ok := labels[d.Mount]; !ok {
+			labels[d.Mount] = mountLabel(d.Mount, style)
+		}
+	}
+	for round := 0; ; round++ {
+		byLabel := map[string][]string{}
+		for m, l := range labels {
+			byLabel[l] = append(byLabel[l], m)
+		}
+		shared := false
+		for l, mounts := range byLabel {
+			if len(mounts) < 2 {
+				continue
+			}
+			shared = true
+			for _, m := range mounts {
+				suffix := pathHash(m)
+				if round > 0 {
+					suffix = pathHash(l + "\x00" + m)
+				}
+				labels[m] = l + "_" + suffix
+			}
+		}
+		if !shared {
+			return labels
+		}
+	}
+}
+
+func mountLabel(mount, style string) string {
+	switch style {
+	case "underscore":
+		return underscoreLabel(mount)
+	case "url":
+		return url.PathEscape(mount)
+	case "hash":
+		return underscoreLabel(mount) + "_" + pathHash(mount)
+	default:
+		return mount
+	}
+}
+
+func underscoreLabel(mount string) string {
+	s := strings.Trim(mount, "/")
+	if s == "" {
+		return "root"
+	}
+	var sb strings.Builder
+	for _, c := range s {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' {
+			sb.WriteRune(c)
+		} else {
+			sb.WriteByte('_')
+		}
+	}
+	return sb.String()
+}
+
+func pathHash(mount string) string {
+	h := fnv.New32a()
+	h.Write([]byte(mount))
+	return fmt.Sprintf("%08x", h.Sum32())[:labelHashLen]
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+)
+
+func TestMountLabel(t *testing.T) {
+	tests := []struct {
+		mount, style, want string
+	}{
+		{"/var/lib/docker", "raw", "/var/lib/docker"},
+		{"/var/lib/docker", "underscore", "var_lib_docker"},
+		{"/", "underscore", "root"},
+		{"/mnt/usb stick/", "underscore", "mnt_usb_stick"},
+		{"/srv/données", "underscore", "srv_donn_es"},
+		{"/var/lib/docker", "url", "%2Fvar%2Flib%2Fdocker"},
+		{"/mnt/a b", "url", "%2Fmnt%2Fa%20b"},
+		{"/var/lib/docker", "hash", "var_lib_docker_" + pathHash("/var/lib/docker")},
+	}
+	for _, tt := range tests {
+		if got := mountLabel(tt.mount, tt.style); got != tt.want {
+			t.Errorf("mountLabel(%q, %s) = %q, want %q", tt.mount, tt.style, got, tt.want)
+		}
+	}
+}
+
+func labelsOf(mounts ...string) []FS {
+	var list []FS
+	for _, m := range mounts {
+		list = append(list, FS{Mount: m})
+	}
+	return list
+}
+
+// checkDistinct fails unless every mount has a label no other mount has.
+func checkDistinct(t *testing.T, style string, labels map[string]string) {
+	t.Helper()
+	owner := map[string]string{}
+	for m, l := range labels {
+		if other, ok := owner[l]; ok {
+			t.Errorf("%s: %q and %q share the label %q", style, m, other, l)
+		}
+		owner[l] = m
+	}
+}
+
+func TestMountLabelsCollisions(t *testing.T) {
+	labels := mountLabels(labelsOf("/data/a-b", "/data/a_b", "/data/a.b", "/srv", "/data/a-b"), "underscore")
+	if len(labels) != 4 {
+		t.Errorf("%d labels for 4 mounts: %v", len(labels), labels)
+	}
+	for _, m := range []string{"/data/a-b", "/data/a_b", "/data/a.b"} {
+		if want := "data_a_b_" + pathHash(m); labels[m] != want {
+			t.Errorf("%s: label %q, want %q", m, labels[m], want)
+		}
+	}
+	// Only colliding mounts are suffixed.
+	if labels["/srv"] != "srv" {
+		t.Errorf("/srv: label %q", labels["/srv"])
+	}
+	checkDistinct(t, "underscore", labels)
+
+	// A mount named after another's suffixed label.
+	sneaky := "/data/a_b_" + pathHash("/data/a-b")
+	labels = mountLabels(labelsOf("/data/a-b", "/data/a_b", sneaky), "underscore")
+	checkDistinct(t, "underscore", labels)
+	if labels["/data/a_b"] != "data_a_b_"+pathHash("/data/a_b") {
+		t.Errorf("/data/a_b: label %q", labels["/data/a_b"])
+	}
+
+	// / and a mount named root.
+	labels = mountLabels(labelsOf("/", "/root"), "underscore")
+	checkDistinct(t, "underscore", labels)
+	if labels["/"] == "root" {
+		t.Errorf("/ keeps label root next to /root")
+	}
+
+	// raw and url are injective, so they never need a suffix.
+	for _, style := range []string{"raw", "url"} {
+		list := labelsOf("/data/a-b", "/data/a_b", "/data/a b", "/data/a%20b")
+		for _, d := range list {
+			if l := mountLabels(list, style)[d.Mount]; l != mountLabel(d.Mount, style) {
+				t.Errorf("%s: %q suffixed to %q", style, d.Mount, l)
+			}
+		}
+	}
+}
+
+func TestMountLabelsDistinct(t *testing.T) {
+	var mounts []string
+	for i := 0; i < 200; i++ {
+		for _, sep := range []string{"-", "_", ".", " ", "/", "+"} {
+			mounts = append(mounts, fmt.Sprintf("/data/%d%sx", i%20, sep), fmt.Sprintf("/data%s%d/x", sep, i))
+		}
+	}
+	safe := regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+	for _, style := range labelStyles {
+		labels := mountLabels(labelsOf(mounts...), style)
+		checkDistinct(t, style, labels)
+		if style == "underscore" || style == "hash" {
+			for m, l := range labels {
+				if !safe.MatchString(l) {
+					t.Errorf("%s: %q has label %q", style, m, l)
+				}
+			}
+		}
+	}
+}
@@ -1,18 +1,27 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
-	"math"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
+
+	"github.com/AScotM/filesystem_cap/fscap/hostid"
+	"github.com/AScotM/filesystem_cap/fscap/mountinfo"
+	"github.com/AScotM/filesystem_cap/fscap/units"
 )
 
 type Config struct {
@@ -20,20 +29,226 @@ type Config struct {
 	HumanReadable bool
 	OutputFormat  string
 	SortBy        string
-	ExcludeTypes  string
+	ExcludeTypes  []string
 	WarnThreshold float64
 	CritThreshold float64
 	NoColor       bool
+	ConfigPath    string
+	ConfigDir     string
+	NoSummary     bool
+	Container     string
+	InContainer   bool
+	// Degraded are warnings from features found unavailable while
+	// parsing flags; Require lists the features that must not degrade.
+	Degraded          []string
+	Require           map[string]bool
+	Verbose           bool
+	Alert             bool
+	OnelineMin        float64
+	Top               int
+	Watch             time.Duration
+	NDJSON            bool
+	Progress          bool
+	Dedup             bool
+	StatusFD          int
+	RawStatfs         bool
+	NFSQuota          bool
+	LocalQuota        bool
+	FATCheck          bool
+	Snapshots         bool
+	Thin              bool
+	NativeBlocks      bool
+	DedupWindow       time.Duration
+	UnixSocket        string
+	SocketMode        os.FileMode
+	SocketGroup       string
+	Aggregate         bool
+	TargetsFile       string
+	PollInterval      time.Duration
+	TargetTimeout     time.Duration
+	MaxTargets        int
+	SanitizeFields    bool
+	Explain           string
+	Trace             *trace
+	SparseCheck       bool
+	Sparse            sparseLimits
+	MinInterval       time.Duration
+	MaxInterval       time.Duration
+	Scheduler         *scheduler
+	AnnotationLabels  []string
+	WatchConfig       bool
+	LabelStyle        string
+	StaleDays         int
+	Probe             bool
+	ThinWarn          float64
+	ThinCrit          float64
+	Version           bool
+	Sandbox           bool
+	Baseline          string
+	BaselineStrict    bool
+	BaselineTolerance float64
+	BaselineUsage     float64
+	InodeWarn         float64
+	InodeCrit         float64
+	Fuse              string
+	TZ                string
+	Location          *time.Location
+	DeletedSpace      bool
+	DeletedTimeout    time.Duration
+	Listen            string
+	CacheTTL          time.Duration
+	TLSCert           string
+	TLSKey            string
+	TLSClientCA       string
+	BasicAuthFile     string
+	SelfMetrics       bool
+	MaxCollections    int
+	IO                bool
+	IOSample          time.Duration
+	OutputFile        string
+	Compress          string
+	StrictFlags       bool
+	// HumanExplicit is set when -h was given, so machine formats add
+	// human-readable sizes too.
+	HumanExplicit bool
+	// Collectors are the enrichers run after statfs, from -disable-collectors.
+	Collectors []Collector
+	Stream     bool
+	Lang       string
+	Deep       bool
+	DeepBudget time.Duration
+	DeepTTL    time.Duration
+	Spark      bool
+	Outputs    []output
+	EventsFile string
+	History    historyLimits
+	// Columns selects the CSV columns; nil keeps the fixed layout.
+	Columns       []string
+	State         string
+	ETAAlpha      float64
+	ETAMinSamples int
+	RateWarn      rateLimit
+	RateCrit      rateLimit
+	RateWindow    time.Duration
+	AllowExec     bool
+	ExecCooldown  time.Duration
+	GroupDisplay  string
+	// Events is the -events-file of a -watch run, which exec action
+	// results are written to.
+	Events *eventLog
 }
 
 type FS struct {
-	Device string  `json:"device"`
-	Mount  string  `json:"mount"`
-	Type   string  `json:"type"`
-	Total  uint64  `json:"total"`
-	Free   uint64  `json:"free"`
-	Used   uint64  `json:"used"`
-	Usage  float64 `json:"usage"`
+	Device     string  `json:"device"`
+	Mount      string  `json:"mount"`
+	Type       string  `json:"type"`
+	Total      uint64  `json:"total"`
+	Free       uint64  `json:"free"`
+	Used       uint64  `json:"used"`
+	Usage      float64 `json:"usage"`
+	TotalHuman string  `json:"total_human,omitempty"`
+	FreeHuman  string  `json:"free_human,omitempty"`
+	UsedHuman  string  `json:"used_human,omitempty"`
+	Inodes     uint64  `json:"inodes"`
+	InodesFree uint64  `json:"inodes_free"`
+	InodesUsed uint64  `json:"inodes_used"`
+	InodeUsage float64 `json:"inode_usage"`
+	InodeState string  `json:"inode_state,omitempty"`
+	Ignored    bool    `json:"ignored,omitempty"`
+	// Denied is set when the caller may not stat the mount, so its zero
+	// sizes mean nothing and it is left out of totals and thresholds.
+	Denied  bool   `json:"denied,omitempty"`
+	Label   string `json:"label,omitempty"`
+	Backing string `json:"backing,omitempty"`
+	// UpperDir is an overlay's upperdir, and the rest describe the
+	// filesystem holding it.
+	UpperDir   string   `json:"upper_dir,omitempty"`
+	UpperTotal uint64   `json:"upper_total,omitempty"`
+	UpperFree  uint64   `json:"upper_free,omitempty"`
+	UpperUsage float64  `json:"upper_usage,omitempty"`
+	Options    string   `json:"options,omitempty"`
+	Flags      []string `json:"flags,omitempty"`
+	Fsid       string   `json:"fsid,omitempty"`
+	Identity   string   `json:"identity,omitempty"`
+	Dev        string   `json:"dev,omitempty"`
+	Subtree    string   `json:"subtree,omitempty"`
+	Owner      string   `json:"owner,omitempty"`
+	BlockSize  uint64   `json:"block_size,omitempty"`
+	// The block counts are the statfs f_blocks, f_blocks-f_bfree, f_bfree
+	// and f_bavail as the kernel reports them, in BlockSize units, with
+	// -native-blocks.
+	TotalBlocks uint64             `json:"total_blocks,omitempty"`
+	UsedBlocks  uint64             `json:"used_blocks,omitempty"`
+	FreeBlocks  uint64             `json:"free_blocks,omitempty"`
+	AvailBlocks uint64             `json:"avail_blocks,omitempty"`
+	Thresholds  *AppliedThresholds `json:"thresholds,omitempty"`
+	// Errors are failures of optional collectors; the entry is still valid.
+	Errors []string `json:"errors,omitempty"`
+	// Details are the extra fields reported by external plugins.
+	Details      map[string]json.RawMessage `json:"details,omitempty"`
+	DeletedSpace uint64                     `json:"deleted_space,omitempty"`
+	DeletedTop   []DeletedProc              `json:"deleted_top,omitempty"`
+	ReadBps      float64                    `json:"read_bps,omitempty"`
+	WriteBps     float64                    `json:"write_bps,omitempty"`
+	// FullIn is the seconds until Free is used up at WriteBps.
+	FullIn  float64  `json:"full_in_seconds,omitempty"`
+	Growth  *Growth  `json:"growth,omitempty"`
+	Resized *Resize  `json:"resized,omitempty"`
+	Largest *Largest `json:"largest,omitempty"`
+	Sparse  *Sparse  `json:"sparse,omitempty"`
+	// FlagChange is the change of the statfs flags in -watch mode since
+	// the previous collection, kept while a mount that went read-only
+	// stays so.
+	FlagChange *FlagChange `json:"flag_change,omitempty"`
+	// Quota is the caller's quota with -nfs-quota or -quota. ExportReadOnly marks
+	// a rw mount of an export the server only serves read-only. UsageBasis
+	// is "quota" when a usage_basis rule made Usage the quota's, the
+	// device's then being DeviceUsage.
+	Quota          *Quota  `json:"quota,omitempty"`
+	ExportReadOnly bool    `json:"export_read_only,omitempty"`
+	UsageBasis     string  `json:"usage_basis,omitempty"`
+	DeviceUsage    float64 `json:"device_usage,omitempty"`
+	// LastActivity is the newest mtime among the mount point and a sample
+	// of its top-level entries, with -stale-days. Stale marks it as older
+	// than that.
+	LastActivity *time.Time `json:"last_activity,omitempty"`
+	Stale        bool       `json:"stale,omitempty"`
+	// ErrorsCount is the number of errors the kernel recorded on the
+	// filesystem, the last of them at LastErrorTime.
+	ErrorsCount   uint64     `json:"errors_count,omitempty"`
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+	// Annotations are the config's metadata for the mount, e.g. its team.
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Jobs are the configured jobs writing to the mount.
+	Jobs []JobFit `json:"jobs,omitempty"`
+	// Pool is the thin pool backing the filesystem's volume, with -thin.
+	Pool *ThinPool `json:"thin_pool,omitempty"`
+	// OverlayDepth is how many overlays are stacked in an overlay's chain
+	// of layers, and EffectiveUsage the usage of the fullest filesystem
+	// holding one of them, the one of EffectiveDir.
+	OverlayDepth   int     `json:"overlay_depth,omitempty"`
+	EffectiveUsage float64 `json:"effective_usage,omitempty"`
+	EffectiveDir   string  `json:"effective_dir,omitempty"`
+	// FATRoot is the use of a FAT root directory's entries, with
+	// -fat-check.
+	FATRoot *FATRoot `json:"fat_root,omitempty"`
+	// SnapshotUsed is the space held only by the filesystem's snapshots,
+	// with -snapshots, which deleting files does not free.
+	SnapshotUsed uint64 `json:"snapshot_used,omitempty"`
+	// Clamped is set when statfs reported more bytes than fit in 64 bits:
+	// Total or Free is then math.MaxUint64, the block counts are kept
+	// and Usage is computed from them.
+	Clamped bool `json:"clamped,omitempty"`
+	// ID and ParentID place the entry in the mount tree; ParentID is the
+	// nearest listed ancestor. Excluded counts unlisted mounts below it.
+	ID       int    `json:"-"`
+	ParentID int    `json:"-"`
+	Excluded int    `json:"-"`
+	Spark    string `json:"-"`
+	Category string `json:"-"`
+	// Children are other subtrees of the same filesystem, grouped under
+	// it in -dedup mode. They share the parent's capacity numbers.
+	Children []FS `json:"children,omitempty"`
 }
 
 type ColorScheme struct {
@@ -41,6 +256,8 @@ type ColorScheme struct {
 	Medium   string
 	High     string
 	Critical string
+	Grace    string
+	Muted    string
 	Reset    string
 }
 
@@ -49,15 +266,51 @@ var Colors = ColorScheme{
 	Medium:   "\033[33m",
 	High:     "\033[31m",
 	Critical: "\033[31;1m",
+	Grace:    "\033[35m",
+	Muted:    "\033[90m",
 	Reset:    "\033[0m",
 }
 
+// Report is the envelope written by the JSON output format.
+type Report struct {
+	Version     string   `json:"version"`
+	Schema      int      `json:"schema"`
+	Time        string   `json:"time"`
+	Host        string   `json:"host,omitempty"`
+	MachineID   string   `json:"machine_id,omitempty"`
+	Filesystems []FS     `json:"filesystems"`
+	Summary     *Summary `json:"summary,omitempty"`
+	Drift       []Drift  `json:"drift,omitempty"`
+	// ExcludeTypes is the effective exclusion list after -x, -x+ and -x-.
+	ExcludeTypes []string `json:"exclude_types"`
+	// FileBinds counts bind mounts of single files left out without -a.
+	FileBinds int     `json:"suppressed_file_binds,omitempty"`
+	Groups    []Group `json:"groups,omitempty"`
+	// Warnings record features that could only partly run.
+	Warnings []string `json:"warnings,omitempty"`
+	// Sanitized is set when -sanitize-fields escaped the strings above.
+	Sanitized *Sanitization `json:"sanitized,omitempty"`
+	// Truncated is set when -top left Hidden filesystems out of the list.
+	// The summary still counts them.
+	Truncated bool `json:"truncated,omitempty"`
+	Hidden    int  `json:"hidden,omitempty"`
+}
+
 func main() {
+	if len(os.Args) == 2 && os.Args[1] == scanWorkerArg {
+		runScanWorker()
+		return
+	}
 	config := parseFlags()
 	logger := log.New(os.Stderr, "dfmon: ", log.Lshortfile)
 
-	if _, err := os.Stat("/proc/mounts"); err != nil {
-		logger.Fatal("Linux only: /proc/mounts not found")
+	if config.Version {
+		printVersion(config.OutputFormat)
+		return
+	}
+
+	if mounts, err := mountinfo.Self(); err == nil && mountRoot == "" && foreignMountTable(mounts) {
+		logger.Printf("Warning: the mount table is not this root's (chroot with the host's /proc?); mounts missing here are skipped, -root re-anchors them")
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -70,69 +323,762 @@ func main() {
 		cancel()
 	}()
 
+	// check-config reports config problems itself rather than failing
+	// on the first one.
+	if flag.Arg(0) == "check-config" {
+		runCheckConfig(ctx, config, flag.Args()[1:], logger)
+		return
+	}
+	if flag.Arg(0) == "completion" {
+		runCompletion(flag.Args()[1:])
+		return
+	}
+
+	fileConfig, err := loadFileConfig(config.ConfigPath, config)
+	if err != nil {
+		logger.Fatalf("Failed to load config: %v", err)
+	}
+	if !config.AllowExec && fileConfig.Alerts.hasExec() {
+		logger.Printf("Warning: the exec actions in the config do not run without -allow-exec")
+	}
+
+	if flag.NArg() > 0 {
+		switch flag.Arg(0) {
+		case "plan":
+			runPlan(ctx, config, fileConfig, flag.Args()[1:], logger)
+			return
+		case "bench":
+			runBench(ctx, config, flag.Args()[1:], logger)
+			return
+		case "events":
+			runEvents(ctx, config, flag.Args()[1:], logger)
+			return
+		case "tmp-usage":
+			runTmpUsage(ctx, config, flag.Args()[1:], logger)
+			return
+		case "capabilities":
+			runCapabilities(ctx, config, flag.Args()[1:], logger)
+			return
+		case "history":
+			runHistory(ctx, config, flag.Args()[1:], logger)
+			return
+		case "path":
+			runPath(ctx, config, fileConfig, flag.Args()[1:], logger)
+			return
+		default:
+			logger.Fatalf("Unknown command: %s", flag.Arg(0))
+		}
+	}
+
+	// The aggregator only talks to agents, so it runs anywhere.
+	if config.Aggregate {
+		runAggregate(ctx, config, logger)
+		return
+	}
+
+	if !liveCollection {
+		logger.Fatalf("Failed to collect: %v; offline commands such as check-config and events still work", errUnsupported)
+	}
+
+	var statusFile *os.File
+	if config.StatusFD >= 0 {
+		statusFile, err = openStatusFD(config.StatusFD)
+		if err != nil {
+			logger.Fatalf("Invalid -status-fd: %v", err)
+		}
+	}
+
+	if config.Verbose {
+		logger.Printf("Excluding: %s", strings.Join(config.ExcludeTypes, ","))
+	}
+
+	if config.Sandbox {
+		state, err := enterSandbox()
+		if err != nil {
+			logger.Fatalf("Failed to enter sandbox: %v", err)
+		}
+		if config.Verbose {
+			active := "inactive"
+			if state.Active {
+				active = "active"
+			}
+			logger.Printf("Sandbox %s: %s", active, state.Detail)
+		}
+		if !state.Active {
+			config.Degraded = append(config.Degraded, "sandbox: "+state.Detail)
+		}
+	}
+
+	if config.Explain != "" {
+		runExplain(ctx, config, fileConfig, logger)
+		return
+	}
+
+	if config.Listen != "" {
+		runExporter(ctx, config, fileConfig, logger)
+		return
+	}
+
+	if config.Stream {
+		if err := runStream(ctx, config, fileConfig, logger); err != nil {
+			logger.Fatalf("Failed to read mounts: %v", err)
+		}
+		return
+	}
+
+	if config.Watch <= 0 {
+		r, err := collect(ctx, config, fileConfig, logger)
+		if err != nil {
+			logger.Fatalf("Failed to collect: %v", err)
+		}
+		if code := report(ctx, r, config, fileConfig, statusFile, logger); code != 0 {
+			os.Exit(code)
+		}
+		return
+	}
+
+	// The watchdog is only petted after a collection, so a wedged mount
+	// that stalls collection gets the service restarted.
+	if wd := sdWatchdogInterval(); wd > 0 && config.Watch > wd/2 {
+		logger.Printf("Warning: -watch %v exceeds half of the systemd watchdog timeout %v", config.Watch, wd)
+	}
+	defer sdNotify("STOPPING=1")
+
+	changes := configChanges(ctx, config)
+
+	var snapshots *snapshotServer
+	if config.UnixSocket != "" {
+		snapshots, err = listenSnapshots(config.UnixSocket, config.SocketMode, config.SocketGroup, 2*config.Watch, logger)
+		if err != nil {
+			logger.Fatalf("Failed to listen on -unix-socket: %v", err)
+		}
+		defer snapshots.Close()
+	}
+
+	var events *eventLog
+	if config.EventsFile != "" {
+		if events, err = openEventLog(config.EventsFile, config.History); err != nil {
+			logger.Fatalf("Failed to open events file: %v", err)
+		}
+		config.Events = events
+	}
+
+	var totals map[string]uint64
+	var flags map[string]FS
+	var history sparkHistory
+	if config.Spark {
+		history = sparkHistory{}
+	}
+
+	ticker := time.NewTicker(config.Watch)
+	defer ticker.Stop()
+	ready := false
+	var last *Report
+	var lastAt time.Time
+	for {
+		r, err := collect(ctx, config, fileConfig, logger)
+		if err != nil {
+			logger.Printf("Warning: failed to collect: %v", err)
+		} else if ctx.Err() == nil {
+			last, lastAt = &r, time.Now()
+			totals = markResized(r.Filesystems, totals)
+			flags = markFlagChanges(r.Filesystems, flags)
+			if events != nil {
+				if err := events.observe(r.Filesystems, config, time.Now(), ""); err != nil {
+					logger.Printf("Warning: cannot write events: %v", err)
+				}
+				if err := events.maybeCompact(time.Now()); err != nil {
+					logger.Printf("Warning: cannot compact events: %v", err)
+				}
+			}
+			if history != nil {
+				history.add(r.Filesystems)
+				history.annotate(r.Filesystems)
+			}
+			report(ctx, r, config, fileConfig, statusFile, logger)
+			if snapshots != nil {
+				snapshots.update(envelope(r, config), lastAt)
+			}
+			if !ready {
+				sdNotify("READY=1")
+				ready = true
+			}
+			sdNotify("WATCHDOG=1")
+		}
+
+		for waiting := true; waiting; {
+			select {
+			case <-ctx.Done():
+				return
+			case <-changes:
+				reloadWatch(&fileConfig, last, events, config, statusFile, logger)
+				if snapshots != nil && last != nil {
+					snapshots.update(envelope(*last, config), lastAt)
+				}
+			case <-ticker.C:
+				waiting = false
+			}
+		}
+	}
+}
+
+// collect gathers one report. It is safe for concurrent use: config and
+// fileConfig are only read, and the state shared between collections is
+// atomic or behind a lock, the -state file's under updateState. It fails with the errors of readMounts, or
+// with the report when a -require'd feature degraded; a mount that cannot
+// be stat'd is logged and left out.
+func collect(ctx context.Context, config Config, fileConfig FileConfig, logger *log.Logger) (Report, error) {
+	var r Report
+	config.Collectors = withPlugins(config.Collectors, fileConfig.Plugins)
 	mounts, err := readMounts()
 	if err != nil {
-		logger.Fatalf("Failed to read mounts: %v", err)
+		return r, err
+	}
+	if w := mountTableWarning.Load(); w != nil {
+		r.Warnings = append(r.Warnings, *w)
+	}
+	r.Warnings = append(r.Warnings, config.Degraded...)
+
+	// Oneline output is meant for status bars, so it skips progress
+	// logging and enrichment to stay fast and quiet.
+	oneline := config.OutputFormat == "oneline"
+	collectLogger := logger
+	if oneline {
+		collectLogger = log.New(io.Discard, "", 0)
+	}
+	if config.Trace == nil && config.Verbose {
+		config.Trace = newTrace(collectLogger)
+	}
+
+	r.ExcludeTypes = config.ExcludeTypes
+	filteredMounts := filterFuse(filterMounts(mounts, config.ExcludeTypes, config.Trace), config.Fuse, config.Trace)
+	if !config.ShowAll {
+		filteredMounts, r.FileBinds = filterFileBinds(filteredMounts, map[string]bool{}, config.Trace)
 	}
 
-	excludeTypes := strings.Split(config.ExcludeTypes, ",")
-	filteredMounts := filterMounts(mounts, excludeTypes)
-	data := analyze(filteredMounts, logger, ctx)
+	now := time.Now()
+	due, cached := config.Scheduler.split(filteredMounts, now)
+	for _, d := range cached {
+		config.Trace.kept(d.ID, d.Mount, "schedule", "not due yet; the last sample is reused")
+	}
+
+	var prog *progress
+	if config.Progress && !oneline {
+		prog = newProgress(len(due))
+	}
+
+	data := analyze(due, collectLogger, ctx, prog, config)
+	applyIdentity(data, deviceUUIDs())
+	linkTree(data, mounts)
+	if config.IO {
+		if err := applyIO(ctx, data, config.IOSample); err != nil {
+			r.Warnings = append(r.Warnings, "io: "+err.Error())
+		}
+	}
+	if config.DeletedSpace && !oneline {
+		usage, warnings := scanDeleted(config.DeletedTimeout)
+		applyDeleted(data, usage)
+		r.Warnings = append(r.Warnings, warnings...)
+	}
+	// Device-mapper is the host's; a container sees neither its tables
+	// nor the devices behind its volumes.
+	if config.Thin && !config.InContainer {
+		if err := applyThin(ctx, data, config.Snapshots); err != nil {
+			r.Warnings = append(r.Warnings, "thin: "+err.Error())
+		}
+	}
+	if config.State != "" {
+		if err := applyState(data, config.State, config.ETAAlpha, config.ETAMinSamples, config.RateWindow, time.Now()); err != nil {
+			r.Warnings = append(r.Warnings, "state: "+err.Error())
+		}
+	}
+	if config.Deep && !oneline {
+		if err := applyDeep(ctx, data, fileConfig.Deep, config.State, config.DeepBudget, config.DeepTTL, time.Now()); err != nil {
+			r.Warnings = append(r.Warnings, "deep: "+err.Error())
+		}
+	}
+	if config.SparseCheck && !oneline {
+		if err := applySparse(ctx, data, fileConfig.Sparse, config.State, config.Sparse, time.Now()); err != nil {
+			r.Warnings = append(r.Warnings, "sparse: "+err.Error())
+		}
+	}
+	// Entries of mounts not yet due only have their rules applied again.
+	data = append(data, clearDerived(cached)...)
+	if config.StaleDays > 0 {
+		markStale(data, config.StaleDays, time.Now())
+	}
+	applyRules(data, fileConfig, config, time.Now())
+	if config.InContainer && !oneline {
+		labelContainerRoot(data, filteredMounts)
+	}
+	config.Scheduler.update(data, due, config, now)
+	if config.Dedup {
+		data = dedup(data, config.Trace)
+	}
 	sortFS(data, config.SortBy)
-	display(data, config)
+	r.Filesystems = data
+	r.Groups = buildGroups(data, fileConfig.Groups, config)
+	if config.SanitizeFields {
+		r.Sanitized = &Sanitization{Modified: sanitizeFields(&r)}
+	}
+	return r, checkRequired(r.Warnings, config.Require)
+}
+
+// report renders a collection and runs the checks attached to it. It
+// returns the process exit code for one-shot runs, which reflects every
+// filesystem collected, including those -top leaves out of the output.
+func report(ctx context.Context, r Report, config Config, fileConfig FileConfig, statusFile *os.File, logger *log.Logger) int {
+	code := 0
+	data := r.Filesystems
+	if config.Baseline != "" {
+		baseline, err := loadBaseline(config.Baseline)
+		if err != nil {
+			logger.Fatalf("Failed to load baseline: %v", err)
+		}
+		r.Drift = compareBaseline(data, baseline, config.BaselineTolerance, config.BaselineUsage)
+		if r.Drift == nil {
+			r.Drift = []Drift{}
+		}
+		if len(r.Drift) > 0 && config.BaselineStrict {
+			code = 1
+		}
+	}
+
+	// Each output is written independently so one failing does not
+	// stop the rest.
+	for _, o := range config.Outputs {
+		c := config
+		c.OutputFormat = o.Format
+		if o.Path == "" {
+			display(r, c)
+			continue
+		}
+		if _, err := writeOutputFile(o.Path, config.Compress, func(w io.Writer) error {
+			return write(w, r, c)
+		}); err != nil {
+			logger.Printf("Warning: cannot write %s: %v", o.Path, err)
+			code = max(code, 3)
+		}
+	}
+
+	if statusFile != nil {
+		if err := writeStatus(statusFile, r, config); err != nil {
+			logger.Printf("Warning: cannot write status: %v", err)
+		}
+	}
+
+	if config.Alert {
+		sendAlerts(ctx, r, config, fileConfig.Alerts, logger)
+	}
+	if config.AllowExec {
+		runActions(ctx, r, config, fileConfig.Alerts, logger)
+	}
+	return exitCode(code, worstStatus(r, config))
 }
 
 func parseFlags() Config {
 	var config Config
-	flag.BoolVar(&config.ShowAll, "a", false, "Show all filesystems")
+	flag.BoolVar(&config.ShowAll, "a", false, "Show all filesystems, including file bind mounts")
 	flag.BoolVar(&config.HumanReadable, "h", true, "Human readable sizes")
-	flag.StringVar(&config.OutputFormat, "o", "table", "Output format (table, json, csv)")
-	flag.StringVar(&config.SortBy, "s", "mount", "Sort by (mount, usage, size)")
-	flag.StringVar(&config.ExcludeTypes, "x", "proc,sysfs,devtmpfs,tmpfs,cgroup,devpts", "Exclude filesystem types")
+	flag.StringVar(&config.OutputFormat, "o", "table", "Output formats (table, json, csv, oneline, tree), comma-separated with =PATH for files; exit 3 if one fails")
+	flag.StringVar(&config.SortBy, "s", "mount", "Sort by ("+strings.Join(sortKeys, ", ")+")")
+	exclude := flag.String("x", strings.Join(defaultExcludes, ","), "Exclude filesystem types, replacing the defaults (type or type:dir)")
+	excludeAdd := flag.String("x+", "", "Exclude these types in addition to the defaults")
+	excludeDel := flag.String("x-", "", "Stop excluding these default types")
 	flag.Float64Var(&config.WarnThreshold, "w", 70, "Warning threshold")
 	flag.Float64Var(&config.CritThreshold, "c", 90, "Critical threshold")
+	flag.Float64Var(&config.InodeWarn, "iw", 80, "Inode warning threshold")
+	flag.Float64Var(&config.InodeCrit, "ic", 95, "Inode critical threshold")
+	flag.StringVar(&config.Fuse, "fuse", "auto", "Fuse mounts to show (all, mine, none; auto is all for root, mine otherwise)")
+	flag.StringVar(&config.TZ, "tz", "", "Time zone for threshold schedules (default local time)")
+	flag.BoolVar(&config.DeletedSpace, "deleted-space", false, "Report space held by deleted files still open by processes")
+	flag.DurationVar(&config.DeletedTimeout, "deleted-timeout", 5*time.Second, "Time limit for the -deleted-space scan")
+	flag.BoolVar(&config.IO, "io", false, "Show read/write rates from /proc/diskstats and time to full")
+	flag.DurationVar(&config.IOSample, "io-sample", time.Second, "Sample interval for -io (0 uses averages since boot)")
 	flag.BoolVar(&config.NoColor, "no-color", false, "Disable color output")
-	flag.Parse()
+	flag.BoolVar(&config.NoSummary, "no-summary", false, "Do not print the summary footer")
+	flag.StringVar(&config.Container, "container", "auto", "Container mode (auto, yes, no); in one, filesystem error counts and -thin, which need the host's /sys and device-mapper, are skipped")
+	flag.BoolVar(&config.Verbose, "verbose", false, "Show mount options, statfs flags and fsid, and log skipped mounts")
+	flag.StringVar(&config.Explain, "explain", "", "Tell which stage keeps or removes the mount at or containing this path, then exit")
+	flag.BoolVar(&config.Alert, "alert", false, "Send threshold violations to the alert sinks in the config file")
+	flag.DurationVar(&config.DedupWindow, "dedup-window", 0, "Do not resend an alert in the same state within this long (needs -state; in -watch mode with -state, one is otherwise sent once until it clears)")
+	flag.BoolVar(&config.AllowExec, "allow-exec", false, "Run the exec actions of alert routes (needs -state)")
+	flag.DurationVar(&config.ExecCooldown, "exec-cooldown", time.Hour, "Run an exec action at most once per filesystem within this long")
+	flag.Float64Var(&config.OnelineMin, "oneline-min", 0, "Only show mounts at or above this usage in oneline output")
+	flag.IntVar(&config.Top, "top", 0, "Show only the first N filesystems after sorting, and how many more there are")
+	flag.DurationVar(&config.Watch, "watch", 0, "Repeat the report at this interval (e.g. 10s)")
+	flag.BoolVar(&config.NDJSON, "ndjson", false, "Write newline-delimited JSON, one filesystem per line")
+	flag.BoolVar(&config.Progress, "progress", false, "Report collection progress on stderr")
+	flag.BoolVar(&config.Dedup, "dedup", false, "Show each filesystem once, with subtree mounts grouped under it")
+	flag.IntVar(&config.StatusFD, "status-fd", -1, "Write a JSON status object to this file descriptor")
+	flag.BoolVar(&config.NFSQuota, "nfs-quota", false, "Query the NFS server for the caller's quota on NFSv4 mounts")
+	flag.BoolVar(&config.LocalQuota, "quota", false, "Read the caller's quota on local filesystems with quotactl")
+	flag.BoolVar(&config.Snapshots, "snapshots", false, "Report the space held by btrfs and ZFS snapshots, and with -thin by LVM thin snapshots")
+	flag.BoolVar(&config.FATCheck, "fat-check", false, "Count the root directory entries of FAT filesystems against their limit, with the -iw and -ic thresholds")
+	flag.BoolVar(&config.Thin, "thin", false, "Show the LVM thin pool behind each thin volume, and alert on the pool's usage")
+	flag.Float64Var(&config.ThinWarn, "thin-warn", 80, "Thin pool warning threshold (data or metadata)")
+	flag.Float64Var(&config.ThinCrit, "thin-crit", 90, "Thin pool critical threshold (data or metadata)")
+	flag.IntVar(&config.StaleDays, "stale-days", 0, "Flag mounts whose top level has not been written to for this many days")
+	flag.BoolVar(&config.Probe, "probe", false, "With -stale-days, also look at network filesystems")
+	flag.BoolVar(&config.SanitizeFields, "sanitize-fields", false, "Escape control characters, non-printable runes and invalid UTF-8 in every string field of the output")
+	flag.BoolVar(&config.NativeBlocks, "native-blocks", false, "Also report the statfs block counts in the filesystem's own block size")
+	flag.BoolVar(&config.RawStatfs, "raw-statfs", false, "Report tmpfs totals from statfs, ignoring size= limits")
+	flag.BoolVar(&config.Version, "version", false, "Print version information and exit")
+	flag.BoolVar(&config.Sandbox, "sandbox", false, "Restrict the process to read-only filesystem access before collecting")
+	flag.StringVar(&config.Baseline, "baseline", "", "Compare against a baseline written by -o json")
+	flag.BoolVar(&config.BaselineStrict, "baseline-strict", false, "Exit non-zero when drift from the baseline is found")
+	flag.Float64Var(&config.BaselineTolerance, "baseline-tolerance", 1, "Percent change in Total reported as a resize")
+	flag.Float64Var(&config.BaselineUsage, "baseline-usage", 10, "Usage points above baseline reported as drift")
+	flag.StringVar(&config.Listen, "listen", "", "Serve Prometheus metrics on these comma-separated addresses (e.g. :9100 or 127.0.0.1:9100,[::1]:9100)")
+	flag.StringVar(&config.TLSCert, "tls-cert", "", "Serve -listen over TLS with this certificate (PEM), read again on SIGHUP")
+	flag.StringVar(&config.TLSKey, "tls-key", "", "Private key (PEM) of -tls-cert")
+	flag.StringVar(&config.TLSClientCA, "tls-client-ca", "", "Require -listen clients to present a certificate signed by a CA in this bundle (PEM)")
+	flag.StringVar(&config.BasicAuthFile, "basic-auth-file", "", "Require HTTP basic auth on -listen for the users in this htpasswd file (bcrypt hashes)")
+	flag.StringVar(&config.LabelStyle, "label-style", "raw", "How -listen writes the mountpoint label ("+strings.Join(labelStyles, ", ")+")")
+	annotationLabels := flag.String("annotation-labels", "", "Comma-separated config annotation keys exported as -listen labels")
+	flag.BoolVar(&config.SelfMetrics, "self-metrics", false, "Also export dfmon's own health on -listen, and serve /debug/status")
+	flag.DurationVar(&config.CacheTTL, "cache-ttl", 5*time.Second, "Reuse a collection for this long across -listen scrapes")
+	flag.BoolVar(&config.Aggregate, "aggregate", false, "Poll the dfmon agents in -targets-file and serve their filesystems on -listen")
+	flag.StringVar(&config.TargetsFile, "targets-file", "", "Agents for -aggregate, one base URL per line with an optional host label")
+	flag.DurationVar(&config.PollInterval, "poll-interval", 30*time.Second, "How often -aggregate polls each agent")
+	flag.DurationVar(&config.TargetTimeout, "target-timeout", 10*time.Second, "Time limit for one -aggregate poll of an agent")
+	flag.IntVar(&config.MaxTargets, "max-concurrent-targets", 16, "Agents -aggregate polls at once")
+	flag.StringVar(&config.UnixSocket, "unix-socket", "", "With -watch, send the latest JSON report to each client connecting to this socket")
+	socketMode := flag.String("socket-mode", "0660", "Permissions of the -unix-socket file (octal)")
+	flag.StringVar(&config.SocketGroup, "socket-group", "", "Group owning the -unix-socket file (name or gid)")
+	flag.IntVar(&config.MaxCollections, "max-concurrent-collections", 16, "Scrapes allowed to wait for a collection before answering 503")
+	flag.StringVar(&config.OutputFile, "output-file", "", "Write the json or csv report to this file, replaced atomically")
+	flag.StringVar(&config.Compress, "compress", "none", "Compress -output-file (none, gzip, zstd); the extension is appended")
+	disable := flag.String("disable-collectors", "", "Comma-separated enrichers to skip (fuse, tmpfs, subtree, nfsquota, quota, overlay, overlaychain, activity, fserrors, fatroot, zfssnap, btrfssnap)")
+	flag.BoolVar(&config.Stream, "stream", false, "Print each mount as it is collected, unsorted (table, csv, json as NDJSON)")
+	flag.StringVar(&config.State, "state", "", "State file remembering usage between runs, for growth and time to full")
+	flag.Float64Var(&config.ETAAlpha, "eta-alpha", 0.3, "Smoothing factor for the fill rate (0-1, higher follows changes faster)")
+	flag.IntVar(&config.ETAMinSamples, "eta-min-samples", 5, "Samples needed before time to full is trusted")
+	rateWarn := flag.String("rate-warn", "", "Alert on growth over -rate-window at this rate, e.g. 50G/h or 5%/h")
+	rateCrit := flag.String("rate-crit", "", "Critical growth rate over -rate-window, e.g. 200G/h or 20%/h")
+	flag.DurationVar(&config.RateWindow, "rate-window", 15*time.Minute, "Sliding window the -rate-warn and -rate-crit growth is measured over")
+	flag.StringVar(&config.GroupDisplay, "group-display", "", "Section the table, and nest the JSON filesystems, by: category")
+	columns := flag.String("columns", "", "Comma-separated CSV columns, e.g. mount,total,total_h (header matches the tokens)")
+	flag.StringVar(&config.EventsFile, "events-file", "", "Append state changes seen in -watch mode to this NDJSON file")
+	historyRetention := flag.String("history-retention", "", "Record usage samples in the -events-file and compact it hourly, dropping records older than this (e.g. 90d)")
+	historyMaxSize := flag.String("history-max-size", "", "Record usage samples in the -events-file and compact it hourly, dropping the oldest records beyond this size (e.g. 100M)")
+	flag.IntVar(&config.History.Segments, "history-segments", 0, "Keep the records -history-max-size drops in this many gzip-compressed segments, FILE.1.gz the newest, which dfmon events reads with FILE")
+	flag.DurationVar(&config.MaxInterval, "max-interval", 0, "With -watch or -listen, collect each mount at an interval adapted to its distance from the thresholds, up to this")
+	flag.DurationVar(&config.MinInterval, "min-interval", 0, "Shortest adaptive interval (default every -watch tick or scrape)")
+	flag.BoolVar(&config.WatchConfig, "watch-config", false, "With -watch or -listen, reload the config file when it changes, as on SIGHUP")
+	flag.BoolVar(&config.Spark, "spark", false, "In -watch table output, show a usage sparkline for the session")
+	flag.BoolVar(&config.Deep, "deep", false, "Show the largest subdirectory of the mounts listed under \"deep\" in the config")
+	flag.DurationVar(&config.DeepBudget, "deep-budget", 2*time.Second, "Time allowed per -deep mount per run; unfinished scans resume via -state")
+	flag.DurationVar(&config.DeepTTL, "deep-ttl", time.Hour, "How long a finished -deep scan is reused")
+	flag.BoolVar(&config.SparseCheck, "sparse-check", false, "Total the unallocated size of large sparse files on the mounts listed under \"sparse\" in the config")
+	sparseMin := flag.String("sparse-min-size", "64M", "Smallest file size -sparse-check looks at")
+	flag.IntVar(&config.Sparse.MaxFiles, "sparse-max-files", 100000, "Files -sparse-check examines per mount before stopping")
+	flag.DurationVar(&config.Sparse.Budget, "sparse-budget", 5*time.Second, "Time allowed per -sparse-check mount")
+	flag.DurationVar(&config.Sparse.TTL, "sparse-ttl", 6*time.Hour, "How long a -sparse-check result is reused from -state")
+	scanUser := flag.String("scan-user", "", "Walk directories for -deep, -sparse-check and tmp-usage, and run exec actions and plugins, as this user (name or uid)")
+	flag.StringVar(&config.Lang, "lang", "", "Language for table output (en, de; default from LANG)")
+	flag.BoolVar(&config.StrictFlags, "strict-flags", false, "Treat ignored flag combinations as errors")
+	require := flag.String("require", "", "Fail instead of degrading when one of these is unavailable (comma-separated: "+strings.Join(requireFeatures, ", ")+")")
+	root := flag.String("root", "", "Show the mounts at or below this directory relative to it, e.g. a system mounted for rescue")
+	flag.StringVar(&config.ConfigPath, "config", "", "Config file, in JSON, YAML or TOML by its extension (default ~/.config/dfmon/config.json, /etc/dfmon/config.json; or .yaml, .yml, .toml)")
+	nodeName := flag.String("node-name", "", "Name this host in reports, alerts and metrics (default the FQDN)")
+	machineID := flag.Bool("machine-id", false, "Also identify this host by /etc/machine-id in reports, alerts and metrics")
+	flag.StringVar(&config.ConfigDir, "config-dir", "", "Also load the *.json, *.yaml, *.yml and *.toml files in this directory, in name order, merged over the config file")
+	addLongFlags(flag.CommandLine)
+	flag.Usage = usage
+	if err := applyEnv(flag.CommandLine); err != nil {
+		fmt.Fprintf(os.Stderr, "dfmon: invalid environment: %v\n", err)
+		os.Exit(2)
+	}
+	parseCommandLine()
+
+	outputs, err := parseOutputs(config.OutputFormat)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dfmon: invalid -o: %v\n", err)
+		os.Exit(2)
+	}
+	config.OutputFormat = primaryFormat(outputs)
+
+	for _, t := range []Thresholds{config.blockLimits(), config.inodeLimits(), config.poolLimits()} {
+		if err := t.Validate(); err != nil {
+			fmt.Fprintf(os.Stderr, "dfmon: invalid thresholds: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	if !slices.Contains(sortKeys, config.SortBy) {
+		fmt.Fprintf(os.Stderr, "dfmon: invalid -s: unknown key %q (%s)\n", config.SortBy, strings.Join(sortKeys, ", "))
+		os.Exit(2)
+	}
+
+	format := config.OutputFormat
+	if config.NDJSON && !flagPassed("o") {
+		format = "json"
+	}
+	checkFlags(format, config.StrictFlags)
+	setLang(config.Lang)
+	config.HumanExplicit = flagPassed("h") && config.HumanReadable
+	if config.NDJSON && !flagPassed("o") {
+		outputs[0].Format = "json"
+	}
+	if config.NDJSON {
+		config.OutputFormat = "json"
+	}
+	if config.OutputFile != "" {
+		if len(outputs) > 1 {
+			fmt.Fprintln(os.Stderr, "dfmon: -output-file needs a single -o format; use FORMAT=PATH instead")
+			os.Exit(2)
+		}
+		outputs[0].Path = config.OutputFile
+	}
+	config.Outputs = outputs
+
+	collectors, err := enabledCollectors(*disable, config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dfmon: invalid -disable-collectors: %v\n", err)
+		os.Exit(2)
+	}
+	config.Collectors = collectors
+
+	if config.AnnotationLabels, err = parseAnnotationLabels(*annotationLabels); err != nil {
+		fmt.Fprintf(os.Stderr, "dfmon: invalid -annotation-labels: %v\n", err)
+		os.Exit(2)
+	}
+
+	if config.GroupDisplay != "" && config.GroupDisplay != "category" {
+		fmt.Fprintf(os.Stderr, "dfmon: invalid -group-display: %q is not category\n", config.GroupDisplay)
+		os.Exit(2)
+	}
+
+	if config.Columns, err = parseColumns(*columns); err != nil {
+		fmt.Fprintf(os.Stderr, "dfmon: invalid -columns: %v\n", err)
+		os.Exit(2)
+	}
+	// The block columns need the counts, which are only kept on request.
+	for _, c := range config.Columns {
+		if c == "block_size" || strings.HasSuffix(c, "_blocks") {
+			config.NativeBlocks = true
+		}
+	}
+
+	if _, err := compressExt(config.Compress); err != nil {
+		fmt.Fprintf(os.Stderr, "dfmon: invalid -compress: %v\n", err)
+		os.Exit(2)
+	}
+
+	for _, r := range []struct {
+		name  string
+		value string
+		limit *rateLimit
+	}{{"rate-warn", *rateWarn, &config.RateWarn}, {"rate-crit", *rateCrit, &config.RateCrit}} {
+		if r.value == "" {
+			continue
+		}
+		if *r.limit, err = parseRate(r.value); err != nil {
+			fmt.Fprintf(os.Stderr, "dfmon: invalid -%s: %v\n", r.name, err)
+			os.Exit(2)
+		}
+	}
+	if config.RateWindow <= 0 {
+		fmt.Fprintf(os.Stderr, "dfmon: invalid -rate-window: must be positive\n")
+		os.Exit(2)
+	}
+
+	if *historyRetention != "" {
+		if config.History.Retention, err = parseAge(*historyRetention); err != nil {
+			fmt.Fprintf(os.Stderr, "dfmon: invalid -history-retention: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	if *historyMaxSize != "" {
+		if config.History.MaxSize, err = parseSize(*historyMaxSize); err != nil {
+			fmt.Fprintf(os.Stderr, "dfmon: invalid -history-max-size: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	if config.History.Segments < 0 {
+		fmt.Fprintf(os.Stderr, "dfmon: invalid -history-segments: must not be negative\n")
+		os.Exit(2)
+	}
+
+	if config.PollInterval <= 0 || config.TargetTimeout <= 0 {
+		fmt.Fprintf(os.Stderr, "dfmon: invalid -poll-interval/-target-timeout: must be positive\n")
+		os.Exit(2)
+	}
+
+	mode, err := strconv.ParseUint(*socketMode, 8, 32)
+	if err != nil || mode > 0o777 {
+		fmt.Fprintf(os.Stderr, "dfmon: invalid -socket-mode: want octal permissions, got %q\n", *socketMode)
+		os.Exit(2)
+	}
+	config.SocketMode = os.FileMode(mode)
+
+	if !slices.Contains(labelStyles, config.LabelStyle) {
+		fmt.Fprintf(os.Stderr, "dfmon: invalid -label-style: unknown style %q (%s)\n", config.LabelStyle, strings.Join(labelStyles, ", "))
+		os.Exit(2)
+	}
+
+	if config.MaxInterval < 0 || config.MinInterval < 0 || config.MinInterval > config.MaxInterval && config.MaxInterval > 0 {
+		fmt.Fprintf(os.Stderr, "dfmon: invalid -min-interval/-max-interval: want 0 <= %v <= %v\n", config.MinInterval, config.MaxInterval)
+		os.Exit(2)
+	}
+	if config.MaxInterval > 0 && (config.Watch > 0 || config.Listen != "") {
+		config.Scheduler = newScheduler(config.MinInterval, config.MaxInterval)
+	}
+
+	if config.Sparse.MinSize, err = parseSize(*sparseMin); err != nil {
+		fmt.Fprintf(os.Stderr, "dfmon: invalid -sparse-min-size: %v\n", err)
+		os.Exit(2)
+	}
+
+	hostid.Configure(hostid.Options{NodeName: *nodeName, MachineID: *machineID})
+
+	if *scanUser != "" {
+		cred, err := lookupScanUser(*scanUser)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "dfmon: invalid -scan-user: %v\n", err)
+			os.Exit(2)
+		}
+		// Already that user, there is nothing to drop.
+		if uint32(os.Geteuid()) != cred.Uid {
+			scanCred = cred
+		}
+	}
+
+	if (config.TLSCert == "") != (config.TLSKey == "") {
+		fmt.Fprintf(os.Stderr, "dfmon: invalid -tls-cert: -tls-cert and -tls-key go together\n")
+		os.Exit(2)
+	}
+	if config.TLSClientCA != "" && config.TLSCert == "" {
+		fmt.Fprintf(os.Stderr, "dfmon: invalid -tls-client-ca: needs -tls-cert\n")
+		os.Exit(2)
+	}
+
+	if config.StaleDays < 0 {
+		fmt.Fprintf(os.Stderr, "dfmon: invalid -stale-days: %d is negative\n", config.StaleDays)
+		os.Exit(2)
+	}
+	if config.Top < 0 {
+		fmt.Fprintf(os.Stderr, "dfmon: invalid -top: %d is negative\n", config.Top)
+		os.Exit(2)
+	}
+
+	config.Location = time.Local
+	if config.TZ != "" {
+		loc, err := time.LoadLocation(config.TZ)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "dfmon: invalid -tz: %v\n", err)
+			os.Exit(2)
+		}
+		config.Location = loc
+	}
+
+	if *root != "" {
+		// Inside a chroot, -root names the chroot as the host's mount
+		// table does, which need not exist here.
+		abs, err := filepath.Abs(*root)
+		if fi, serr := os.Stat(abs); err == nil && (serr != nil || !fi.IsDir()) {
+			if mounts, merr := mountinfo.Self(); merr != nil || !foreignMountTable(mounts) {
+				err = fmt.Errorf("%s is not a directory", *root)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "dfmon: invalid -root: %v\n", err)
+			os.Exit(2)
+		}
+		mountRoot = abs
+	}
+
+	var cerr error
+	config.InContainer, cerr = resolveContainerMode(config.Container)
+	if cerr != nil {
+		config.Degraded = append(config.Degraded, "container: "+cerr.Error()+"; assuming not in a container")
+	}
+	if config.Require, err = parseRequire(*require); err == nil && config.Require["sandbox"] && !config.Sandbox {
+		err = fmt.Errorf("sandbox needs -sandbox")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dfmon: invalid -require: %v\n", err)
+		os.Exit(2)
+	}
+	base := defaultExcludes
+	if config.InContainer {
+		base = containerExcludes
+	}
+	if flagPassed("x") {
+		base = splitList(*exclude)
+	}
+	config.ExcludeTypes = excludeSet(base, splitList(*excludeAdd), splitList(*excludeDel))
 	return config
 }
 
-func readMounts() ([][]string, error) {
-	b, err := os.ReadFile("/proc/mounts")
+func flagPassed(name string) bool {
+	passed := false
+	flag.Visit(func(f *flag.Flag) {
+		if shortFlag(f.Name) == name {
+			passed = true
+		}
+	})
+	return passed
+}
+
+// readMounts returns the mount table, re-anchored under -root. It fails
+// with errUnsupported where dfmon only runs its offline commands, else
+// with the error reading the table.
+func readMounts() ([]mountinfo.Mount, error) {
+	if !liveCollection {
+		return nil, errUnsupported
+	}
+	mounts, err := readMountTable()
 	if err != nil {
 		return nil, err
 	}
-	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
-	var out [][]string
-	for _, l := range lines {
-		p := strings.Fields(l)
-		if len(p) >= 3 {
-			out = append(out, []string{p[0], p[1], p[2]})
-		}
-	}
-	return out, nil
+	foreign := foreignMountTable(mounts)
+	foreignTable.Store(foreign)
+	return anchorMounts(mounts, mountRoot, foreign), nil
 }
 
-func shouldIncludeFS(fsType string, excludeTypes []string) bool {
-	for _, ex := range excludeTypes {
-		if ex != "" && fsType == ex {
-			return false
+// mountOptions joins the per-mount and per-superblock options the way
+// /proc/mounts presents them.
+func mountOptions(m mountinfo.Mount) string {
+	opts := strings.Split(m.Options, ",")
+	seen := map[string]bool{}
+	for _, o := range opts {
+		seen[o] = true
+	}
+	for _, o := range strings.Split(m.SuperOptions, ",") {
+		if o == "" || o == "rw" || o == "ro" || seen[o] {
+			continue
 		}
+		opts = append(opts, o)
 	}
-	return true
+	return strings.Join(opts, ",")
 }
 
-func filterMounts(mounts [][]string, excludeTypes []string) [][]string {
-	var filtered [][]string
-	for _, m := range mounts {
-		if shouldIncludeFS(m[2], excludeTypes) {
-			filtered = append(filtered, m)
+// statfs is replaceable so collection can run against synthetic values.
+var statfs = syscall.Statfs
+
+const (
+	statfsAttempts = 3
+	statfsBackoff  = 20 * time.Millisecond
+)
+
+// statfsRetry retries statfs on EINTR and EAGAIN, which busy fuse and
+// network mounts return transiently. Other errors fail immediately.
+func statfsRetry(path string, s *syscall.Statfs_t) (int, error) {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = statfs(path, s)
+		if err == nil || attempt == statfsAttempts || (err != syscall.EINTR && err != syscall.EAGAIN) {
+			return attempt, err
 		}
+		time.Sleep(time.Duration(attempt) * statfsBackoff)
 	}
-	return filtered
 }
 
-func analyze(mounts [][]string, logger *log.Logger, ctx context.Context) []FS {
+func analyze(mounts []mountinfo.Mount, logger *log.Logger, ctx context.Context, prog *progress, config Config) []FS {
 	var list []FS
+	defer prog.Finish()
 
 	for i, m := range mounts {
 		select {
@@ -142,65 +1088,230 @@ func analyze(mounts [][]string, logger *log.Logger, ctx context.Context) []FS {
 		default:
 		}
 
-		if i%10 == 0 {
-			logger.Printf("Processing %d/%d mounts...", i, len(mounts))
+		prog.Begin(i, m.MountPoint)
+		if d, err := statMount(ctx, m, logger, config); err == nil {
+			list = append(list, d)
 		}
+	}
+	return list
+}
 
-		var s syscall.Statfs_t
-		if err := syscall.Statfs(m[1], &s); err != nil {
-			logger.Printf("Warning: cannot stat %s: %v", m[1], err)
-			continue
-		}
+// statMount builds the entry for one mount. If statfs fails it logs and
+// returns a *MountError; a mount the caller may not stat is still
+// returned, marked Denied.
+func statMount(ctx context.Context, m mountinfo.Mount, logger *log.Logger, config Config) (FS, error) {
+	var s syscall.Statfs_t
+	attempts, err := statfsRetry(hostPath(m.MountPoint), &s)
+	if os.Geteuid() != 0 && (errors.Is(errorCause(err), errPermission) || err == nil && s.Blocks == 0 && accessDenied(m)) {
+		config.Trace.kept(m.ID, m.MountPoint, "statfs", "permission denied; listed without usage")
+		return FS{Device: m.Source, Mount: m.MountPoint, Type: m.FSType, Dev: m.MajorMinor, ID: m.ID, Denied: true, InodeState: inodesUnknown}, nil
+	}
+	if err != nil {
+		logger.Printf("Warning: cannot stat %s after %d attempt(s): %v", m.MountPoint, attempts, err)
+		stats.statError(err)
+		config.Trace.removed(m.ID, m.MountPoint, "statfs", "statfs failed: %v", err)
+		return FS{}, &MountError{Op: "statfs", Mount: m.MountPoint, Err: err}
+	}
+	if config.Verbose && attempts > 1 {
+		logger.Printf("Statfs %s succeeded after %d attempts", m.MountPoint, attempts)
+	}
 
-		total := s.Blocks * uint64(s.Bsize)
-		free := s.Bavail * uint64(s.Bsize)
-		used := total - free
-		usage := 0.0
-		if total > 0 {
-			usage = float64(used) / float64(total) * 100
-		}
+	bsize := blockSize(&s)
+	total, totalClamped := mulClamp(s.Blocks, bsize)
+	free, freeClamped := mulClamp(s.Bavail, bsize)
+	clamped := totalClamped || freeClamped
+	if clamped {
+		logger.Printf("Warning: %s reports a size beyond 16 EiB; clamping it", m.MountPoint)
+	}
+	var overfree string
+	if free > total {
+		// Seen on some fuse and network filesystems while they shrink;
+		// used would wrap around instead of going negative.
+		overfree = fmt.Sprintf("statfs: %d bytes free of %d total", free, total)
+		logger.Printf("Warning: %s reports more free space than its size; treating it as empty", m.MountPoint)
+		free = total
+	}
+	used := total - free
+	usage := 0.0
+	switch {
+	case clamped && s.Blocks > 0:
+		// The clamped byte counts no longer relate; the blocks still do.
+		usage = float64(s.Blocks-min(s.Bavail, s.Blocks)) / float64(s.Blocks) * 100
+	case total > 0:
+		usage = float64(used) / float64(total) * 100
+	}
+	inodeUsage := 0.0
+	if s.Files > 0 && s.Ffree <= s.Files {
+		inodeUsage = float64(s.Files-s.Ffree) / float64(s.Files) * 100
+	}
 
-		list = append(list, FS{
-			Device: m[0],
-			Mount:  m[1],
-			Type:   m[2],
-			Total:  total,
-			Free:   free,
-			Used:   used,
-			Usage:  usage,
-		})
+	d := FS{
+		Device:     m.Source,
+		Mount:      m.MountPoint,
+		Type:       m.FSType,
+		Total:      total,
+		Free:       free,
+		Used:       used,
+		Usage:      usage,
+		Inodes:     s.Files,
+		InodesFree: s.Ffree,
+		InodesUsed: s.Files - min(s.Ffree, s.Files),
+		InodeUsage: inodeUsage,
+		InodeState: inodeState(m.FSType, s.Files),
+		Options:    mountOptions(m),
+		Flags:      decodeStatfsFlags(uint64(s.Flags)),
+		Fsid:       fsidString(&s),
+		Dev:        m.MajorMinor,
+		ID:         m.ID,
+		Clamped:    clamped,
 	}
-	return list
+	if overfree != "" {
+		d.Errors = append(d.Errors, overfree)
+	}
+	if config.Verbose || config.NativeBlocks || clamped {
+		d.BlockSize = bsize
+	}
+	if config.NativeBlocks || clamped {
+		d.TotalBlocks, d.FreeBlocks, d.AvailBlocks = s.Blocks, s.Bfree, s.Bavail
+		d.UsedBlocks = s.Blocks - min(s.Bfree, s.Blocks)
+	}
+	enrich(ctx, m, &d, config.Collectors)
+	return d, nil
 }
 
 func fmtBytes(b uint64, humanReadable bool) string {
 	if !humanReadable {
 		return fmt.Sprintf("%d", b)
 	}
+	return units.FormatBytes(b, units.Binary)
+}
+
+// parseSize parses the sizes flags and config files take, e.g. "800G".
+func parseSize(s string) (uint64, error) {
+	return units.ParseBytes(s)
+}
+
+type Status int
+
+const (
+	StatusOK Status = iota
+	StatusWarning
+	StatusCritical
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusCritical:
+		return "critical"
+	case StatusWarning:
+		return "warning"
+	default:
+		return "ok"
+	}
+}
 
-	if b < 1024 {
-		return fmt.Sprintf("%d B", b)
+// inodeStatus evaluates inode usage. Filesystems without inode accounting
+// (zero total inodes, e.g. btrfs, whatever their InodeState) are exempt
+// and always ok.
+func inodeStatus(d FS, t Thresholds) Status {
+	if d.Inodes == 0 {
+		return StatusOK
 	}
+	return t.Evaluate(d.InodeUsage)
+}
 
-	units := []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB"}
-	exp := math.Log(float64(b)) / math.Log(1024)
-	idx := int(exp)
-	if idx >= len(units) {
-		idx = len(units) - 1
+// fsStatus is the worst of the states in fsChecks: the block, overlay,
+// thin pool, inode, FAT root directory, growth rate and quota states of d.
+// It is a warning when a scheduled job will not fit, and critical once the
+// kernel recorded filesystem errors.
+func fsStatus(d FS, config Config) Status {
+	st := StatusOK
+	for _, c := range failedChecks(d, config) {
+		st = max(st, c.Status)
 	}
+	return st
+}
 
-	val := float64(b) / math.Pow(1024, float64(idx))
-	return fmt.Sprintf("%.1f %s", val, units[idx])
+// fsChecks are the states of a filesystem, by the kind -status-fd names
+// them, with the usage each is judged on. A muted entry keeps only those
+// marked always: the overlay layers and thin pool under it are filesystems
+// of their own.
+var fsChecks = []struct {
+	kind   string
+	always bool
+	status func(FS, Config) Status
+	usage  func(FS) float64
+}{
+	{"usage", true, blockStatus, func(d FS) float64 { return d.Usage }},
+	{"upper", true, upperStatus, func(d FS) float64 { return d.UpperUsage }},
+	{"effective", true, effectiveStatus, func(d FS) float64 { return d.EffectiveUsage }},
+	{"pool", true, poolStatus, func(d FS) float64 { return d.Pool.Usage() }},
+	{"inodes", false, func(d FS, c Config) Status { return inodeStatus(d, c.inodeLimits()) }, func(d FS) float64 { return d.InodeUsage }},
+	{"fat-root", false, fatRootStatus, func(d FS) float64 { return d.FATRoot.Usage() }},
+	{"rapid-growth", false, rateStatus, func(d FS) float64 { return d.Usage }},
+	{"headroom", false, func(d FS, _ Config) Status { return jobStatus(d) }, func(d FS) float64 { return d.Usage }},
+	{"quota", false, func(d FS, _ Config) Status { return quotaStatus(d) }, func(d FS) float64 { return d.Quota.usage() }},
+	{"fs-errors", false, func(d FS, _ Config) Status { return fsErrorStatus(d) }, func(d FS) float64 { return d.Usage }},
+	{"flags", false, func(d FS, _ Config) Status { return flagStatus(d) }, func(d FS) float64 { return d.Usage }},
+}
+
+// fsCheck is a state of a filesystem that is not ok.
+type fsCheck struct {
+	Kind   string
+	Status Status
+	Usage  float64
+}
+
+// failedChecks returns the states in fsChecks of d that are not ok. A
+// denied entry has none, there being nothing to judge.
+func failedChecks(d FS, config Config) []fsCheck {
+	if d.Denied {
+		return nil
+	}
+	var failed []fsCheck
+	for _, c := range fsChecks {
+		if !c.always && muted(d) {
+			continue
+		}
+		if st := c.status(d, config); st != StatusOK {
+			failed = append(failed, fsCheck{Kind: c.kind, Status: st, Usage: c.usage(d)})
+		}
+	}
+	return failed
 }
 
-func (c ColorScheme) ForUsage(usage, warn, crit float64, noColor bool) string {
+// ForFS colors d by block usage, or by its inode or FAT root directory
+// state when that is worse.
+// A quota within its grace period gets the grace color unless either is
+// critical. Filesystem errors are always critical.
+func (c ColorScheme) ForFS(d FS, config Config) string {
+	if d.Thresholds != nil && d.Thresholds.Muted {
+		return c.muted(config.NoColor)
+	}
+	if !config.NoColor && fsErrorStatus(d) == StatusCritical {
+		return c.Critical
+	}
+	ist := max(inodeStatus(d, config.inodeLimits()), fatRootStatus(d, config))
+	if !config.NoColor && d.Quota.inGrace() && max(ist, blockStatus(d, config)) < StatusCritical {
+		return c.Grace
+	}
+	if config.NoColor || ist <= blockStatus(d, config) {
+		return c.ForUsage(d.Usage, blockThresholds(d, config), config.NoColor)
+	}
+	if ist == StatusCritical {
+		return c.Critical
+	}
+	return c.High
+}
+
+func (c ColorScheme) ForUsage(usage float64, t Thresholds, noColor bool) string {
 	if noColor {
 		return ""
 	}
-	switch {
-	case usage >= crit:
+	switch st := t.Evaluate(usage); {
+	case st == StatusCritical:
 		return c.Critical
-	case usage >= warn:
+	case st == StatusWarning:
 		return c.High
 	case usage >= 70:
 		return c.Medium
@@ -209,11 +1320,27 @@ func (c ColorScheme) ForUsage(usage, warn, crit float64, noColor bool) string {
 	}
 }
 
+func (c ColorScheme) muted(noColor bool) string {
+	if noColor {
+		return ""
+	}
+	return c.Muted
+}
+
+// sortKeys are the values -s accepts.
+var sortKeys = []string{"mount", "usage", "size", "depth"}
+
 func sortFS(list []FS, by string) {
 	sort.Slice(list, func(i, j int) bool {
 		switch by {
 		case "mount":
 			return list[i].Mount < list[j].Mount
+		case "depth":
+			// Shallowest first, so / comes before /var before /var/lib/docker.
+			if di, dj := mountDepth(list[i].Mount), mountDepth(list[j].Mount); di != dj {
+				return di < dj
+			}
+			return list[i].Mount < list[j].Mount
 		case "usage":
 			return list[i].Usage > list[j].Usage
 		case "size":
@@ -224,50 +1351,354 @@ func sortFS(list []FS, by string) {
 	})
 }
 
-func display(list []FS, config Config) {
+// mountDepth is the number of path components in mount; / has none.
+func mountDepth(mount string) int {
+	if mount == "/" {
+		return 0
+	}
+	return strings.Count(filepath.Clean(mount), "/")
+}
+
+func display(r Report, config Config) {
 	switch config.OutputFormat {
-	case "json":
-		displayJSON(list)
-	case "csv":
-		displayCSV(list)
+	case "json", "csv":
+		if err := write(os.Stdout, r, config); err != nil {
+			log.Print(err)
+		}
+	case "oneline":
+		displayOneline(r.Filesystems, config)
+	case "tree":
+		shown, hidden := topN(r.Filesystems, config.Top)
+		displayTree(shown, config)
+		if len(hidden) > 0 {
+			fmt.Println(remainderLine(hidden))
+		}
 	default:
-		displayTable(list, config)
+		displayTable(r.Filesystems, config)
+		if len(r.Groups) > 0 {
+			printGroups(r.Groups, config)
+		}
+		if r.FileBinds > 0 && !config.NoSummary {
+			fmt.Printf(T("file_binds")+"\n", r.FileBinds)
+		}
+		if r.Drift != nil {
+			printDrift(r.Drift, config.HumanReadable)
+		}
+	}
+	for _, w := range r.Warnings {
+		fmt.Fprintln(os.Stderr, "dfmon: Warning: "+w)
+	}
+}
+
+// withHuman returns a copy of list with the *_human size fields set.
+func withHuman(list []FS) []FS {
+	out := make([]FS, len(list))
+	for i, d := range list {
+		d.TotalHuman = fmtBytes(d.Total, true)
+		d.UsedHuman = fmtBytes(d.Used, true)
+		d.FreeHuman = fmtBytes(d.Free, true)
+		d.Children = withHuman(d.Children)
+		out[i] = d
 	}
+	return out
 }
 
-func displayJSON(list []FS) {
-	enc := json.NewEncoder(os.Stdout)
-	enc.SetIndent("", "  ")
-	if err := enc.Encode(list); err != nil {
-		log.Printf("JSON encoding error: %v", err)
+// write emits the machine-readable formats to w.
+func write(w io.Writer, r Report, config Config) error {
+	if config.OutputFormat == "csv" {
+		return displayCSV(w, r.Filesystems, config)
 	}
+	return displayJSON(w, r, config)
 }
 
-func displayCSV(list []FS) {
-	fmt.Println("Device,Mount,Type,Total,Used,Free,Usage")
+// displayJSON writes an indented envelope, or in watch and -ndjson modes
+// newline-delimited records. Each record is encoded in a single write so a
+// stream interrupted between intervals stays valid.
+func displayJSON(w io.Writer, report Report, config Config) error {
+	enc := json.NewEncoder(w)
+	// -top leaves the records alone: without the envelope nothing would
+	// tell a reader the list was cut.
+	if config.NDJSON {
+		if config.HumanExplicit {
+			report.Filesystems = withHuman(report.Filesystems)
+		}
+		for _, d := range report.Filesystems {
+			if err := enc.Encode(d); err != nil {
+				return fmt.Errorf("JSON encoding error: %v", err)
+			}
+		}
+		return nil
+	}
+
+	if config.Watch <= 0 {
+		enc.SetIndent("", "  ")
+	}
+	env := envelope(report, config)
+	var hidden []FS
+	if env.Filesystems, hidden = topN(env.Filesystems, config.Top); len(hidden) > 0 {
+		env.Truncated, env.Hidden = true, len(hidden)
+	}
+	if config.GroupDisplay == "category" {
+		if err := encodeCategories(enc, env, config); err != nil {
+			return fmt.Errorf("JSON encoding error: %v", err)
+		}
+		return nil
+	}
+	if err := enc.Encode(env); err != nil {
+		return fmt.Errorf("JSON encoding error: %v", err)
+	}
+	return nil
+}
+
+// envelope fills in the fields of the -o json envelope that are not part
+// of the collection itself.
+func envelope(report Report, config Config) Report {
+	if config.HumanExplicit {
+		report.Filesystems = withHuman(report.Filesystems)
+	}
+	report.Version = buildInfo().Version
+	report.Schema = reportSchema
+	report.Time = time.Now().Format(time.RFC3339)
+	host := hostid.Get()
+	report.Host, report.MachineID = host.Name, host.MachineID
+	if report.Filesystems == nil {
+		report.Filesystems = []FS{}
+	}
+	if !config.NoSummary {
+		summary := summarize(report.Filesystems, config)
+		report.Summary = &summary
+	}
+	return report
+}
+
+func displayCSV(w io.Writer, list []FS, config Config) error {
+	bw := bufio.NewWriter(w)
+	printCSVHeader(bw, config)
 	for _, d := range list {
-		fmt.Printf("%s,%s,%s,%d,%d,%d,%.2f\n",
-			d.Device, d.Mount, d.Type, d.Total, d.Used, d.Free, d.Usage)
+		printCSVRow(bw, d, config)
+		for _, c := range d.Children {
+			printCSVRow(bw, c, config)
+		}
 	}
+	return bw.Flush()
 }
 
-func displayTable(list []FS, config Config) {
-	fmt.Printf("%-25s %-25s %-8s %-10s %-10s %-10s %s\n",
-		"Device", "Mount", "Type", "Total", "Used", "Free", "Usage")
-	
+func printCSVHeader(w io.Writer, config Config) {
+	if config.Columns != nil {
+		fmt.Fprintln(w, strings.Join(config.Columns, ","))
+		return
+	}
+	if config.Verbose {
+		fmt.Fprintln(w, "Device,Mount,Type,Total,Used,Free,Usage,Options,Flags,Fsid")
+	} else {
+		fmt.Fprintln(w, "Device,Mount,Type,Total,Used,Free,Usage")
+	}
+}
+
+func printCSVRow(w io.Writer, d FS, config Config) {
+	if config.Columns != nil {
+		printColumnsRow(w, d, config)
+		return
+	}
+	if config.HumanExplicit {
+		fmt.Fprintf(w, "%s,%s,%s,%s,%s,%s,%.2f",
+			csvField(d.Device), csvField(d.Mount), csvField(d.Type), fmtBytes(d.Total, config.HumanReadable),
+			fmtBytes(d.Used, config.HumanReadable), fmtBytes(d.Free, config.HumanReadable), d.Usage)
+	} else {
+		fmt.Fprintf(w, "%s,%s,%s,%d,%d,%d,%.2f",
+			csvField(d.Device), csvField(d.Mount), csvField(d.Type), d.Total, d.Used, d.Free, d.Usage)
+	}
+	if config.Verbose {
+		fmt.Fprintf(w, ",%s,%s,%s", csvQuote(d.Options), csvQuote(strings.Join(d.Flags, "|")), d.Fsid)
+	}
+	fmt.Fprintln(w)
+}
+
+// csvField quotes s only when it contains a separator, quote or line
+// break.
+func csvField(s string) string {
+	if strings.ContainsAny(s, ",\"\r\n") {
+		return csvQuote(s)
+	}
+	return s
+}
+
+func csvQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+func displayOneline(list []FS, config Config) {
+	var parts []string
 	for _, d := range list {
-		color := Colors.ForUsage(d.Usage, config.WarnThreshold, config.CritThreshold, config.NoColor)
-		reset := ""
-		if color != "" {
-			reset = Colors.Reset
-		}
-		
-		fmt.Printf("%-25s %-25s %-8s %-10s %-10s %-10s %s%s%%%s\n",
-			d.Device, d.Mount, d.Type,
-			fmtBytes(d.Total, config.HumanReadable),
-			fmtBytes(d.Used, config.HumanReadable),
-			fmtBytes(d.Free, config.HumanReadable),
-			color, strconv.FormatFloat(d.Usage, 'f', 2, 64), reset,
-		)
+		if d.Usage < config.OnelineMin {
+			continue
+		}
+		mark := ""
+		if !d.Ignored {
+			switch fsStatus(d, config) {
+			case StatusCritical:
+				mark = "!!"
+			case StatusWarning:
+				mark = "!"
+			}
+		}
+		parts = append(parts, fmt.Sprintf("%s %.0f%%%s", sanitize(d.Mount), d.Usage, mark))
+	}
+
+	if len(parts) == 0 {
+		fmt.Println("disks ok")
+		return
+	}
+	fmt.Println(strings.Join(parts, " "))
+}
+
+func printTableRow(d FS, device string, config Config) {
+	d, device = safeFS(d), sanitize(device)
+	color := Colors.ForFS(d, config)
+	marker := ""
+	if d.Owner != "" {
+		marker = " (" + d.Owner + ")"
+	}
+	if d.UsageBasis != "" {
+		marker += " (" + T("basis."+d.UsageBasis) + ")"
+	}
+	if d.Ignored {
+		color = Colors.muted(config.NoColor)
+		marker += " (" + T("ignored") + ")"
+	}
+	reset := ""
+	if color != "" {
+		reset = Colors.Reset
+	}
+	spark := ""
+	if d.Spark != "" {
+		spark = "  " + d.Spark
+	}
+	if d.Denied {
+		fmt.Printf("%s %s %s %-10s %-10s %-10s %s%s\n",
+			fitCell(device, 25), fitCell(d.Mount, 25), fitCell(d.Type, 8),
+			"-", "-", "-", T("denied"), marker)
+		return
+	}
+
+	fmt.Printf("%s %s %s %-10s %-10s %-10s %s%s%%%s%s\n",
+		fitCell(device, 25), fitCell(d.Mount, 25), fitCell(d.Type, 8),
+		fmtBytes(d.Total, config.HumanReadable),
+		fmtBytes(d.Used, config.HumanReadable),
+		fmtBytes(d.Free, config.HumanReadable),
+		color, strconv.FormatFloat(d.Usage, 'f', 2, 64), reset, marker+spark,
+	)
+	if config.Verbose {
+		subtree := ""
+		if d.Subtree != "" {
+			subtree = " subtree=" + d.Subtree
+		}
+		fmt.Printf("    options=%s flags=%s fsid=%s inodes=%s%s\n",
+			d.Options, strings.Join(d.Flags, ","), d.Fsid, inodesCell(d), subtree)
+		if len(d.Annotations) > 0 {
+			fmt.Println(annotationsLine(d))
+		}
+	}
+	if config.NativeBlocks && d.BlockSize > 0 {
+		fmt.Println(blocksLine(d))
+	}
+	if config.IO {
+		fmt.Println(ioLine(d, config.HumanReadable))
+	}
+	if d.Growth != nil {
+		fmt.Println(growthLine(d.Growth, config.HumanReadable))
+	}
+	if d.Quota != nil || d.ExportReadOnly {
+		fmt.Println(quotaLine(d, config.HumanReadable))
+	}
+	for _, f := range d.Jobs {
+		if !f.Fits {
+			fmt.Println(jobLine(f, config.HumanReadable))
+		}
+	}
+	if d.Pool != nil {
+		fmt.Println(poolLine(d, config))
+	}
+	if d.SnapshotUsed > 0 {
+		fmt.Println(snapshotLine(d, config.HumanReadable))
+	}
+	if len(d.Errors) > 0 {
+		fmt.Println(errorsLine(d))
+	}
+	if d.ErrorsCount > 0 {
+		fmt.Println(fsErrorsLine(d))
+	}
+	if d.Clamped {
+		fmt.Println(clampedLine(d))
+	}
+	if d.FATRoot != nil && (config.Verbose || fatRootStatus(d, config) > StatusOK) {
+		fmt.Println(fatRootLine(d.FATRoot))
+	}
+	if showUpper(d) {
+		fmt.Println(upperLine(d, config))
+	}
+	if effectiveStatus(d, config) > blockStatus(d, config) {
+		fmt.Println(effectiveLine(d, config))
+	}
+	if d.Resized != nil {
+		fmt.Println(resizeLine(d.Resized, config.HumanReadable))
+	}
+	if d.FlagChange != nil {
+		fmt.Println(flagChangeLine(d.FlagChange))
+	}
+	if d.Stale {
+		fmt.Println(staleLine(d, time.Now()))
+	}
+	if d.Largest != nil {
+		fmt.Println(largestLine(d.Largest, config.HumanReadable))
+	}
+	if d.Sparse != nil && d.Sparse.Unallocated > 0 {
+		fmt.Println(sparseLine(d.Sparse, config.HumanReadable))
+	}
+	if d.DeletedSpace > 0 {
+		fmt.Println(deletedLine(d, config.HumanReadable))
+	}
+}
+
+func blocksLine(d FS) string {
+	return fmt.Sprintf("    blocks of %d B: total %d, used %d, free %d, avail %d",
+		d.BlockSize, d.TotalBlocks, d.UsedBlocks, d.FreeBlocks, d.AvailBlocks)
+}
+
+func printTableHeader() {
+	fmt.Printf("%s %s %s %s %s %s %s\n",
+		fitCell(T("Device"), 25), fitCell(T("Mount"), 25), fitCell(T("Type"), 8),
+		fitCell(T("Total"), 10), fitCell(T("Used"), 10), fitCell(T("Free"), 10), T("Usage"))
+}
+
+// printTableRows prints d and the subtrees grouped under it.
+func printTableRows(d FS, config Config) {
+	device := d.Device
+	if d.Label != "" {
+		device = d.Label
+	}
+	printTableRow(d, device, config)
+	for _, c := range d.Children {
+		printTableRow(c, "  └ "+c.Subtree, config)
+	}
+}
+
+func displayTable(list []FS, config Config) {
+	if config.GroupDisplay == "category" {
+		displayCategories(list, config)
+		return
+	}
+	printTableHeader()
+	shown, hidden := topN(list, config.Top)
+	for _, d := range shown {
+		printTableRows(d, config)
+	}
+	if len(hidden) > 0 {
+		fmt.Println(remainderLine(hidden))
+	}
+
+	if !config.NoSummary {
+		fmt.Println()
+		fmt.Println(summarize(list, config).String(config.HumanReadable))
 	}
 }
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/AScotM/filesystem_cap/fscap/units"
+)
+
+var (
+	buildOnce sync.Once
+	buildDir  string
+	buildErr  error
+)
+
+// buildDfmon builds the binary once per test run, for the tests that run
+// dfmon end to end.
+func buildDfmon(t *testing.T) string {
+	t.Helper()
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not found")
+	}
+	buildOnce.Do(func() {
+		if buildDir, buildErr = os.MkdirTemp("", "dfmon-test"); buildErr != nil {
+			return
+		}
+		out, err := exec.Command(goTool, "build", "-o", filepath.Join(buildDir, "dfmon"), ".").CombinedOutput()
+		if err != nil {
+			buildErr = fmt.Errorf("%v: %s", err, out)
+		}
+	})
+	if buildErr != nil {
+		t.Fatalf("go build: %v", buildErr)
+	}
+	return filepath.Join(buildDir, "dfmon")
+}
+
+// emptyConfig is a config file with nothing in it, so a test does not
+// pick up the machine's.
+func emptyConfig(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "dfmon.json")
+	if err := os.WriteFile(path, []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// quietThresholds keep the host's own filesystems from setting the exit
+// status of an end-to-end run.
+var quietThresholds = []string{"-w", "100", "-c", "100", "-iw", "100", "-ic", "100"}
+
+// withoutDfmonEnv is the test's environment less the DFMON_ variables,
+// which dfmon would read as flags, and the sandbox marker.
+func withoutDfmonEnv() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, envPrefix) && !strings.HasPrefix(kv, "_"+envPrefix) {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+// TestSizesMatchUnits checks that the CLI formats and parses sizes
+// exactly as fscap/units does, so scripts using it print the same numbers.
+func TestSizesMatchUnits(t *testing.T) {
+	for _, n := range []uint64{0, 1023, 1024, 1536, 1048575, 5 << 30, 1<<63 + 1} {
+		if got, want := fmtBytes(n, true), units.FormatBytes(n, units.Binary); got != want {
+			t.Errorf("fmtBytes(%d) = %q, units.FormatBytes = %q", n, got, want)
+		}
+		if got := fmtBytes(n, false); got != fmt.Sprint(n) {
+			t.Errorf("fmtBytes(%d, false) = %q", n, got)
+		}
+	}
+	for _, s := range []string{"800G", "1.5 GiB", "1.023 kB", "16E", "1X", ""} {
+		got, err := parseSize(s)
+		want, wantErr := units.ParseBytes(s)
+		if got != want || (err == nil) != (wantErr == nil) {
+			t.Errorf("parseSize(%q) = %d, %v; units.ParseBytes = %d, %v", s, got, err, want, wantErr)
+		}
+	}
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if buildDir != "" {
+		os.RemoveAll(buildDir)
+	}
+	os.Exit(code)
+}
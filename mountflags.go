@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// volatileFlags are the statfs flags left out when a mount's flags are
+// compared between collections: the access-time policy, which tooling
+// retunes on live mounts and which says nothing of what may be done on
+// them. ST_VALID and the bits dfmon has no name for never reach FS.Flags.
+var volatileFlags = map[string]bool{
+	"ST_NOATIME":    true,
+	"ST_NODIRATIME": true,
+	"ST_RELATIME":   true,
+}
+
+// flagChange compares the statfs flags of a mount's previous and current
+// entries and returns them comma-joined, with the severity of the change:
+// "critical" when the mount became read-only, as the kernel does after
+// errors, "ok" when read-only is all it stopped being, and "warning" for
+// anything else, such as nosuid or nodev going after a remount. ok is
+// false when nothing but volatile flags changed or either entry could
+// not be stat'ed.
+func flagChange(p, d FS) (old, new, severity string, ok bool) {
+	if p.Denied || d.Denied {
+		return "", "", "", false
+	}
+	was, is := stableFlags(p.Flags), stableFlags(d.Flags)
+	old, new = strings.Join(was, ","), strings.Join(is, ",")
+	if old == new {
+		return "", "", "", false
+	}
+	wasRO, ro := isReadOnly(p), isReadOnly(d)
+	switch {
+	case ro && !wasRO:
+		severity = "critical"
+	case wasRO && !ro && len(was) == len(is)+1 && strings.Join(was[1:], ",") == new:
+		// ST_RDONLY is the lowest bit, so it leads the decoded list.
+		severity = "ok"
+	default:
+		severity = "warning"
+	}
+	return old, new, severity, true
+}
+
+func stableFlags(flags []string) []string {
+	var out []string
+	for _, f := range flags {
+		if !volatileFlags[f] {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// flagEvent names the event for a change of flags from p to d: "readonly"
+// or "readwrite" when ST_RDONLY came or went, "flags" otherwise.
+func flagEvent(p, d FS) string {
+	switch wasRO, ro := isReadOnly(p), isReadOnly(d); {
+	case ro && !wasRO:
+		return "readonly"
+	case wasRO && !ro:
+		return "readwrite"
+	}
+	return "flags"
+}
+
+// FlagChange is a change of a mount's statfs flags between two watch
+// collections, as flagChange grades it.
+type FlagChange struct {
+	Old      string `json:"old"`
+	New      string `json:"new"`
+	Severity string `json:"severity"`
+}
+
+// markFlagChanges sets FlagChange on the entries whose flags changed since
+// the previous watch collection, and returns this collection's for the
+// next. A mount that went read-only keeps its critical change while it
+// stays read-only, so it does not go back to ok at the next collection.
+func markFlagChanges(list []FS, prev map[string]FS) map[string]FS {
+	next := make(map[string]FS, len(list))
+	for i := range list {
+		d := &list[i]
+		p, seen := prev[d.Mount]
+		if old, new, severity, ok := flagChange(p, *d); seen && ok {
+			d.FlagChange = &FlagChange{Old: old, New: new, Severity: severity}
+		} else if c := p.FlagChange; c != nil && c.Severity == "critical" && isReadOnly(*d) {
+			d.FlagChange = c
+		}
+		next[d.Mount] = FS{Flags: d.Flags, Denied: d.Denied, FlagChange: d.FlagChange}
+	}
+	return next
+}
+
+// flagStatus is critical for a mount that went read-only and a warning
+// for other flag changes, such as nosuid or nodev going.
+func flagStatus(d FS) Status {
+	if d.FlagChange == nil {
+		return StatusOK
+	}
+	switch d.FlagChange.Severity {
+	case "critical":
+		return StatusCritical
+	case "warning":
+		return StatusWarning
+	}
+	return StatusOK
+}
+
+func flagChangeLine(c *FlagChange) string {
+	return fmt.Sprintf("    flags: %s -> %s (%s)", orDash(c.Old), orDash(c.New), c.Severity)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestFlagChanges runs one mount through a sequence of watch collections
+// and checks each remount's event and "flags" check: read-only is critical
+// until the mount is writable again, nosuid and nodev toggles are
+// warnings, and access-time changes are neither.
+func TestFlagChanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		flags  string
+		event  string // kind, old, new and severity of the one flag event
+		status Status // of the "flags" check
+	}{
+		{"initial", "ST_NOSUID,ST_NODEV", "", StatusOK},
+		{"unchanged", "ST_NOSUID,ST_NODEV", "", StatusOK},
+		{"rw to ro", "ST_RDONLY,ST_NOSUID,ST_NODEV", "readonly ST_NOSUID,ST_NODEV ST_RDONLY,ST_NOSUID,ST_NODEV critical", StatusCritical},
+		{"still ro", "ST_RDONLY,ST_NOSUID,ST_NODEV", "", StatusCritical},
+		{"ro to rw", "ST_NOSUID,ST_NODEV", "readwrite ST_RDONLY,ST_NOSUID,ST_NODEV ST_NOSUID,ST_NODEV ok", StatusOK},
+		{"nosuid off", "ST_NODEV", "flags ST_NOSUID,ST_NODEV ST_NODEV warning", StatusWarning},
+		{"nosuid on", "ST_NOSUID,ST_NODEV", "flags ST_NODEV ST_NOSUID,ST_NODEV warning", StatusWarning},
+		{"nodev off", "ST_NOSUID", "flags ST_NOSUID,ST_NODEV ST_NOSUID warning", StatusWarning},
+		{"nodev on", "ST_NOSUID,ST_NODEV", "flags ST_NOSUID ST_NOSUID,ST_NODEV warning", StatusWarning},
+		{"noatime", "ST_NOSUID,ST_NODEV,ST_NOATIME", "", StatusOK},
+		{"relatime", "ST_NOSUID,ST_NODEV,ST_RELATIME", "", StatusOK},
+		{"ro with relatime", "ST_RDONLY,ST_NOSUID,ST_NODEV,ST_RELATIME", "readonly ST_NOSUID,ST_NODEV ST_RDONLY,ST_NOSUID,ST_NODEV critical", StatusCritical},
+		{"ro with noatime", "ST_RDONLY,ST_NOSUID,ST_NODEV,ST_NOATIME", "", StatusCritical},
+	}
+	config := Config{WarnThreshold: 70, CritThreshold: 90, InodeWarn: 100, InodeCrit: 100}
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	events, err := openEventLog(path, historyLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var prev map[string]FS
+	seen := 0
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	for i, tt := range tests {
+		list := []FS{{Mount: "/data", Total: 100, Used: 10, Free: 90, Usage: 10, Flags: strings.Split(tt.flags, ",")}}
+		prev = markFlagChanges(list, prev)
+		if err := events.observe(list, config, now.Add(time.Duration(i)*time.Minute), ""); err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		all := readEvents(t, path)
+		for _, e := range all[seen:] {
+			switch e.Event {
+			case "readonly", "readwrite", "flags":
+				got = append(got, strings.Join([]string{e.Event, e.Old, e.New, e.Severity}, " "))
+			}
+		}
+		seen = len(all)
+		if want := tt.event; strings.Join(got, "; ") != want {
+			t.Errorf("%s: events %q, want %q", tt.name, got, want)
+		}
+
+		status := StatusOK
+		for _, c := range failedChecks(list[0], config) {
+			if c.Kind == "flags" {
+				status = c.Status
+			}
+		}
+		if status != tt.status {
+			t.Errorf("%s: flags check %v, want %v", tt.name, status, tt.status)
+		}
+		if fsStatus(list[0], config) != tt.status {
+			t.Errorf("%s: status %v, want %v", tt.name, fsStatus(list[0], config), tt.status)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/AScotM/filesystem_cap/fscap/mountinfo"
+)
+
+const (
+	rpcPortmap        = 100000
+	rpcRquota         = 100011
+	rquotaGetQuota    = 1
+	rquotaOK          = 1
+	rquotaNoQuota     = 2
+	rquotaRPCDeadline = time.Second
+)
+
+// errNoQuota means the server has no quota for the mount; it is not
+// reported.
+var errNoQuota = errors.New("no quota")
+
+// nfsQuotaCollector asks the server's rquotad for the caller's quota, since
+// statfs on an NFS mount shows the export's free space, not what the user
+// may still write. It also flags rw mounts of exports the server serves
+// read-only. Enabled with -nfs-quota.
+type nfsQuotaCollector struct{}
+
+func (nfsQuotaCollector) Name() string { return "nfsquota" }
+func (nfsQuotaCollector) Applies(m mountinfo.Mount) bool {
+	return m.FSType == "nfs4" || m.FSType == "nfs" && strings.HasPrefix(mountOption(m.SuperOptions, "vers"), "4")
+}
+func (nfsQuotaCollector) Enrich(ctx context.Context, m mountinfo.Mount, d *FS) error {
+	if !isReadOnly(*d) && syscall.Access(hostPath(m.MountPoint), 2) == syscall.EROFS {
+		d.ExportReadOnly = true
+	}
+
+	server, export, ok := strings.Cut(m.Source, ":")
+	if addr := mountOption(m.SuperOptions, "addr"); addr != "" {
+		server = addr
+	}
+	if !ok || server == "" {
+		return nil
+	}
+	server = strings.Trim(server, "[]")
+
+	q, err := rquota(ctx, server, export, os.Getuid())
+	if err != nil && !errors.Is(err, errNoQuota) {
+		q, err = quotaCommand(ctx, m)
+	}
+	if err != nil {
+		// Servers without rquotad or without quotas are the common case.
+		return nil
+	}
+	d.Quota = q
+	return nil
+}
+
+// rquota calls RQUOTAPROC_GETQUOTA on server over UDP, looking up the
+// rquotad port through the portmapper first.
+func rquota(ctx context.Context, server, export string, uid int) (*Quota, error) {
+	ctx, cancel := context.WithTimeout(ctx, rquotaRPCDeadline)
+	defer cancel()
+
+	var args xdr
+	args.uint32(rpcRquota)
+	args.uint32(1)
+	args.uint32(syscall.IPPROTO_UDP)
+	args.uint32(0)
+	res, err := rpcCall(ctx, net.JoinHostPort(server, "111"), rpcPortmap, 2, 3, args.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("portmap: %v", err)
+	}
+	port, err := res.uint32()
+	if err != nil {
+		return nil, err
+	}
+	if port == 0 {
+		return nil, errNoQuota
+	}
+
+	args.Reset()
+	args.string(export)
+	args.uint32(uint32(uid))
+	res, err = rpcCall(ctx, net.JoinHostPort(server, strconv.Itoa(int(port))), rpcRquota, 1, rquotaGetQuota, args.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("rquota: %v", err)
+	}
+
+	// getquota_rslt: status, then bsize, active, bhardlimit, bsoftlimit,
+	// curblocks, fhardlimit, fsoftlimit, curfiles and the seconds left of
+	// the block and file grace periods.
+	var v [11]uint32
+	for i := range v {
+		if v[i], err = res.uint32(); err != nil {
+			if i == 1 && v[0] != rquotaOK {
+				break
+			}
+			return nil, err
+		}
+	}
+	if v[0] == rquotaNoQuota {
+		return nil, errNoQuota
+	}
+	if v[0] != rquotaOK {
+		return nil, fmt.Errorf("rquota: status %d", v[0])
+	}
+	bsize, now := uint64(v[1]), time.Now()
+	u := quotaUsage{
+		hard: uint64(v[3]) * bsize, soft: uint64(v[4]) * bsize, used: uint64(v[5]) * bsize,
+		ihard: uint64(v[6]), isoft: uint64(v[7]), inodes: uint64(v[8]),
+	}
+	if v[9] > 0 {
+		u.blockExpiry = now.Unix() + int64(v[9])
+	}
+	if v[10] > 0 {
+		u.fileExpiry = now.Unix() + int64(v[10])
+	}
+	if u.soft == 0 && u.hard == 0 {
+		return nil, errNoQuota
+	}
+	return u.quota("rquota", now)
+}
+
+// quotaCommand falls back on quota(1), which knows more transports than
+// the plain UDP call above. Sizes are in 1 KiB blocks; with -p the grace
+// columns are the Unix time the grace period ends, or 0.
+func quotaCommand(ctx context.Context, m mountinfo.Mount) (*Quota, error) {
+	out, err := exec.CommandContext(ctx, "quota", "-w", "-p", "-Q", "-f", hostPath(m.MountPoint)).Output()
+	if err != nil && len(out) == 0 {
+		return nil, err
+	}
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		f := strings.Fields(sc.Text())
+		if len(f) < 4 || (f[0] != m.Source && f[0] != hostPath(m.MountPoint)) {
+			continue
+		}
+		// filesystem, blocks, quota, limit, grace, files, quota, limit,
+		// grace; older versions stop after the block limit.
+		var n [8]uint64
+		for i := range n {
+			if i+1 >= len(f) {
+				break
+			}
+			if n[i], err = strconv.ParseUint(strings.TrimSuffix(f[i+1], "*"), 10, 64); err != nil {
+				return nil, fmt.Errorf("quota: bad field %q", f[i+1])
+			}
+		}
+		u := quotaUsage{
+			used: n[0] << 10, soft: n[1] << 10, hard: n[2] << 10, blockExpiry: int64(n[3]),
+			inodes: n[4], isoft: n[5], ihard: n[6], fileExpiry: int64(n[7]),
+		}
+		if u.soft == 0 && u.hard == 0 {
+			return nil, errNoQuota
+		}
+		return u.quota("quota", time.Now())
+	}
+	return nil, errNoQuota
+}
+
+// rpcCall sends one ONC RPC call with AUTH_UNIX credentials and returns
+// the decoder positioned at the procedure's results.
+func rpcCall(ctx context.Context, addr string, prog, vers, proc uint32, args []byte) (*xdrReader, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if dl, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(dl)
+	}
+
+	host, _ := os.Hostname()
+	var cred xdr
+	cred.uint32(uint32(time.Now().Unix()))
+	cred.string(host)
+	cred.uint32(uint32(os.Getuid()))
+	cred.uint32(uint32(os.Getgid()))
+	cred.uint32(0)
+
+	xid := rand.Uint32()
+	var call xdr
+	for _, v := range []uint32{xid, 0, 2, prog, vers, proc, 1} {
+		call.uint32(v)
+	}
+	call.opaque(cred.Bytes())
+	call.uint32(0)
+	call.uint32(0)
+	call.Write(args)
+	if _, err := conn.Write(call.Bytes()); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 8192)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		r := &xdrReader{b: buf[:n]}
+		if id, _ := r.uint32(); id != xid {
+			continue
+		}
+		// msg_type, reply_stat, verifier flavor and body, accept_stat.
+		mtype, _ := r.uint32()
+		stat, _ := r.uint32()
+		r.uint32()
+		if _, err := r.opaque(); err != nil {
+			return nil, err
+		}
+		accept, err := r.uint32()
+		if err != nil || mtype != 1 || stat != 0 || accept != 0 {
+			return nil, fmt.Errorf("call rejected (reply %d, accept %d)", stat, accept)
+		}
+		return r, nil
+	}
+}
+
+// xdr encodes the few XDR types the RPC calls above need.
+type xdr struct{ bytes.Buffer }
+
+func (x *xdr) uint32(v uint32) { binary.Write(x, binary.BigEndian, v) }
+
+func (x *xdr) opaque(b []byte) {
+	x.uint32(uint32(len(b)))
+	x.Write(b)
+	x.Write(make([]byte, (4-len(b)%4)%4))
+}
+
+func (x *xdr) string(s string) { x.opaque([]byte(s)) }
+
+type xdrReader struct{ b []byte }
+
+func (r *xdrReader) uint32() (uint32, error) {
+	if len(r.b) < 4 {
+		return 0, errors.New("short reply")
+	}
+	v := binary.BigEndian.Uint32(r.b)
+	r.b = r.b[4:]
+	return v, nil
+}
+
+func (r *xdrReader) opaque() ([]byte, error) {
+	n, err := r.uint32()
+	if err != nil {
+		return nil, err
+	}
+	padded := int(n+3) &^ 3
+	if padded > len(r.b) {
+		return nil, errors.New("short reply")
+	}
+	b := r.b[:n]
+	r.b = r.b[padded:]
+	return b, nil
+}
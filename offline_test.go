@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// TestOfflineBuild checks that the tree builds for macOS, where only the
+// offline commands run.
+func TestOfflineBuild(t *testing.T) {
+	if testing.Short() {
+		t.Skip("cross-compiles dfmon")
+	}
+	gobin := filepath.Join(runtime.GOROOT(), "bin", "go")
+	for _, arch := range []string{"amd64", "arm64"} {
+		cmd := exec.Command(gobin, "build", "-o", os.DevNull, ".")
+		cmd.Env = append(os.Environ(), "GOOS=darwin", "GOARCH="+arch, "CGO_ENABLED=0")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Errorf("darwin/%s: %v\n%s", arch, err, out)
+		}
+	}
+}
+
+// TestOfflineCommands runs the commands meant for data captured on another
+// host. None of them reads the mount table for its result.
+func TestOfflineCommands(t *testing.T) {
+	exe := buildDfmon(t)
+	dir := t.TempDir()
+	run := func(args ...string) (string, error) {
+		cmd := exec.Command(exe, args...)
+		cmd.Env = withoutDfmonEnv()
+		out, err := cmd.CombinedOutput()
+		return string(out), err
+	}
+
+	events := filepath.Join(dir, "events.ndjson")
+	fixture := `{"time":"2026-10-01T08:00:00Z","mount":"/var","device":"/dev/sda2","event":"status","old":"ok","new":"warning","usage":81,"warn":80,"crit":90}
+{"time":"2026-10-01T09:00:00Z","mount":"/var","device":"/dev/sda2","event":"status","old":"warning","new":"critical","usage":91,"warn":80,"crit":90}
+{"time":"2026-10-01T09:30:00Z","mount":"/srv","device":"/dev/sdb1","event":"status","old":"ok","new":"critical","usage":95,"warn":80,"crit":90}
+`
+	if err := os.WriteFile(events, []byte(fixture), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := run("-tz", "UTC", "events", "-to", "critical", "-mount", "/var", events)
+	if err != nil {
+		t.Fatalf("events: %v\n%s", err, out)
+	}
+	if lines := strings.Split(strings.TrimSpace(out), "\n"); len(lines) != 1 || !strings.HasPrefix(lines[0], "2026-10-01T09:00:00Z /var") {
+		t.Errorf("events output:\n%s", out)
+	}
+
+	good := filepath.Join(dir, "good.json")
+	bad := filepath.Join(dir, "bad.json")
+	os.WriteFile(good, []byte(`{"thresholds": [{"match": "/var", "warn": 85, "crit": 95}]}`), 0o644)
+	os.WriteFile(bad, []byte(`{"thresholds": [{"match": "/var", "warn": 95, "crit": 85}]}`), 0o644)
+	if out, err := run("-config", good, "check-config"); err != nil || !strings.Contains(out, "Config: "+good) {
+		t.Errorf("check-config on a good file: %v\n%s", err, out)
+	}
+	if out, err := run("-config", bad, "check-config"); err == nil || !strings.Contains(out, "thresholds[0]") {
+		t.Errorf("check-config on a bad file: %v\n%s", err, out)
+	}
+
+	if out, err := run("capabilities", "-o", "json"); err != nil || !strings.HasPrefix(strings.TrimSpace(out), "{") {
+		t.Errorf("capabilities: %v\n%s", err, out)
+	}
+}
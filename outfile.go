@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// compressExt returns the file extension for a -compress value.
+func compressExt(compress string) (string, error) {
+	switch compress {
+	case "", "none":
+		return "", nil
+	case "gzip":
+		return ".gz", nil
+	case "zstd":
+		return ".zst", nil
+	}
+	return "", fmt.Errorf("unknown compression %q", compress)
+}
+
+// writeOutputFile writes through write into a temporary file next to path
+// and renames it into place, so readers never see a partial report. The
+// compression extension is appended to path; the final name is returned.
+func writeOutputFile(path, compress string, write func(io.Writer) error) (string, error) {
+	ext, err := compressExt(compress)
+	if err != nil {
+		return "", err
+	}
+	path += ext
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	bw := bufio.NewWriter(tmp)
+	var w io.Writer = bw
+	var zw io.WriteCloser
+	switch ext {
+	case ".gz":
+		zw = gzip.NewWriter(bw)
+	case ".zst":
+		if zw, err = zstd.NewWriter(bw); err != nil {
+			return "", err
+		}
+	}
+	if zw != nil {
+		w = zw
+	}
+	if err := write(w); err != nil {
+		return "", err
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return "", err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		return "", err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return path, os.Rename(tmp.Name(), path)
+}
+
+// readInput reads a file, or stdin for "-", decompressing gzip and zstd
+// content as it is read. Compression is told by the magic bytes, not the name.
+// Errors name the file and, for bad compressed data, the decompressor.
+func readInput(path string) ([]byte, error) {
+	r, err := openInput(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// openInput opens path, or stdin for "-", for reading through the
+// decompressor its first bytes call for.
+func openInput(path string) (io.ReadCloser, error) {
+	var f *os.File
+	if path == "-" {
+		f = os.Stdin
+	} else {
+		var err error
+		if f, err = os.Open(path); err != nil {
+			return nil, err
+		}
+	}
+	name := inputName(path)
+	br := bufio.NewReader(f)
+	magic, err := br.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: gzip: %v", name, err)
+		}
+		return &decompressed{zr, f, name + ": gzip", nil}, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: zstd: %v", name, err)
+		}
+		return &decompressed{zr, f, name + ": zstd", zr.Close}, nil
+	}
+	return &decompressed{br, f, name, nil}, nil
+}
+
+func inputName(path string) string {
+	if path == "-" {
+		return "stdin"
+	}
+	return path
+}
+
+// decompressed reads through r from f, prefixing read errors with what
+// failed. release, if set, frees the decompressor's resources.
+type decompressed struct {
+	r       io.Reader
+	f       *os.File
+	what    string
+	release func()
+}
+
+func (d *decompressed) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("%s: %w", d.what, err)
+	}
+	return n, err
+}
+
+func (d *decompressed) Close() error {
+	if d.release != nil {
+		d.release()
+	}
+	if d.f == os.Stdin {
+		return nil
+	}
+	return d.f.Close()
+}
+
+// output is one -o destination. An empty Path is stdout.
+type output struct {
+	Format string
+	Path   string
+}
+
+var outputFormats = map[string]bool{"table": true, "json": true, "csv": true, "oneline": true, "tree": true}
+
+// parseOutputs parses -o, a comma-separated list of FORMAT or FORMAT=PATH
+// such as "table,json=/var/log/dfmon/latest.json". At most one output may
+// go to stdout, and only json and csv can be written to files.
+func parseOutputs(s string) ([]output, error) {
+	var outs []output
+	stdout := 0
+	for _, tok := range strings.Split(s, ",") {
+		format, path, _ := strings.Cut(strings.TrimSpace(tok), "=")
+		if !outputFormats[format] {
+			return nil, fmt.Errorf("unknown format %q", format)
+		}
+		if path == "-" {
+			path = ""
+		}
+		if path == "" {
+			stdout++
+		} else if format != "json" && format != "csv" {
+			return nil, fmt.Errorf("%s output can only go to stdout", format)
+		}
+		outs = append(outs, output{Format: format, Path: path})
+	}
+	if stdout > 1 {
+		return nil, fmt.Errorf("at most one output may go to stdout")
+	}
+	return outs, nil
+}
+
+// primaryFormat is the format that decides collection behavior: the
+// stdout output if there is one, otherwise the first.
+func primaryFormat(outs []output) string {
+	for _, o := range outs {
+		if o.Path == "" {
+			return o.Format
+		}
+	}
+	return outs[0].Format
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func gzipBytes(t *testing.T, b []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(b)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zstdBytes(t *testing.T, b []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw, err := zstd.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw.Write(b)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadInput(t *testing.T) {
+	dir := t.TempDir()
+	want := []byte(`{"filesystems": []}` + "\n")
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"plain.json", want},
+		{"report.json.gz", gzipBytes(t, want)},
+		{"report.json.zst", zstdBytes(t, want)},
+		{"misnamed.json", zstdBytes(t, want)}, // the magic decides, not the name
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		if err := os.WriteFile(path, tt.data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readInput(path)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("readInput(%s) = %q, %v; want %q", tt.name, got, err, want)
+		}
+	}
+
+	for _, tt := range []struct{ name, want string }{{"empty", ""}, {"x", "x"}, {"abc", "abc"}} {
+		path := filepath.Join(dir, tt.name)
+		os.WriteFile(path, []byte(tt.want), 0o644)
+		if got, err := readInput(path); err != nil || string(got) != tt.want {
+			t.Errorf("readInput of %q = %q, %v", tt.want, got, err)
+		}
+	}
+}
+
+// TestReadInputErrors checks that a bad compressed file is reported with
+// its name and the decompressor that failed.
+func TestReadInputErrors(t *testing.T) {
+	dir := t.TempDir()
+	full := bytes.Repeat([]byte("dfmon history line\n"), 1000)
+	tests := []struct {
+		name, want string
+		data       []byte
+	}{
+		{"bad.gz", ": gzip: ", append(gzipBytes(t, full)[:40:40], 0, 0, 0)},
+		{"bad.zst", ": zstd: ", zstdBytes(t, full)[:20]},
+		{"header.zst", ": zstd: ", []byte{0x28, 0xb5, 0x2f, 0xfd, 0xff}},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		os.WriteFile(path, tt.data, 0o644)
+		_, err := readInput(path)
+		if err == nil || !strings.HasPrefix(err.Error(), path+tt.want) {
+			t.Errorf("readInput(%s) error = %v, want it to start with %q", tt.name, err, path+tt.want)
+		}
+	}
+	if _, err := readInput(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Errorf("readInput(missing) error = %v, want not exist", err)
+	}
+}
+
+// TestCompressedInputsEndToEnd feeds compressed files, and compressed
+// stdin, to -baseline and the events subcommand.
+func TestCompressedInputsEndToEnd(t *testing.T) {
+	exe := buildDfmon(t)
+	dir := t.TempDir()
+	config := emptyConfig(t)
+
+	events := []byte(`{"version":2}` + "\n" +
+		`{"time":"2024-01-02T03:04:05Z","mount":"/data","device":"/dev/a","event":"status","old":"ok","new":"critical","usage":95.5}` + "\n")
+	for name, data := range map[string][]byte{"events.gz": gzipBytes(t, events), "events.zst": zstdBytes(t, events)} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, data, 0o644)
+		for _, arg := range []string{path, "-"} {
+			cmd := exec.Command(exe, "-config", config, "events", "-to", "critical", arg)
+			cmd.Env = withoutDfmonEnv()
+			if arg == "-" {
+				cmd.Stdin = bytes.NewReader(data)
+			}
+			out, err := cmd.CombinedOutput()
+			if err != nil || !strings.Contains(string(out), "/data status ok -> critical") {
+				t.Errorf("dfmon events %s (%s): %v\n%s", arg, name, err, out)
+			}
+		}
+	}
+
+	baseline := []byte(`{"schema":1,"filesystems":[]}`)
+	for name, data := range map[string][]byte{"baseline.json.gz": gzipBytes(t, baseline), "baseline.json.zst": zstdBytes(t, baseline)} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, data, 0o644)
+		cmd := exec.Command(exe, append(quietThresholds, "-config", config, "-o", "json", "-baseline", path)...)
+		cmd.Env = withoutDfmonEnv()
+		out, err := cmd.Output()
+		if err != nil || !bytes.Contains(out, []byte(`"drift"`)) {
+			t.Errorf("dfmon -baseline %s: %v\n%s", name, err, out)
+		}
+	}
+}
+
+// TestWriteOutputFile writes a report with each -compress value and reads
+// it back, leaving no temporary file behind.
+func TestWriteOutputFile(t *testing.T) {
+	dir := t.TempDir()
+	want := strings.Repeat(`{"mount":"/"}`+"\n", 100)
+	for compress, ext := range map[string]string{"none": "", "gzip": ".gz", "zstd": ".zst"} {
+		path, err := writeOutputFile(filepath.Join(dir, "report-"+compress+".json"), compress, func(w io.Writer) error {
+			_, err := io.WriteString(w, want)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("-compress %s: %v", compress, err)
+		}
+		if !strings.HasSuffix(path, ".json"+ext) {
+			t.Errorf("-compress %s wrote %s, want the %q extension", compress, path, ext)
+		}
+		if got, err := readInput(path); err != nil || string(got) != want {
+			t.Errorf("-compress %s: read back %d bytes, %v", compress, len(got), err)
+		}
+	}
+	if _, err := compressExt("lz4"); err == nil {
+		t.Error("compressExt(lz4): no error")
+	}
+
+	failed := errors.New("collection failed")
+	path := filepath.Join(dir, "failed.json")
+	if _, err := writeOutputFile(path, "zstd", func(w io.Writer) error { return failed }); err != failed {
+		t.Errorf("writeOutputFile with a failing write = %v, want %v", err, failed)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 3 {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("files left in %s: %q, want only the three reports", dir, names)
+	}
+}
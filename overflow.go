@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// mulClamp is a*b, or math.MaxUint64 and true when the product does not
+// fit, as for gateways reporting more than 16 EiB in statfs.
+func mulClamp(a, b uint64) (uint64, bool) {
+	hi, lo := bits.Mul64(a, b)
+	if hi != 0 {
+		return math.MaxUint64, true
+	}
+	return lo, false
+}
+
+// addClamp is a+b, stopping at math.MaxUint64 rather than wrapping, so a
+// clamped entry keeps the totals it is summed into at the maximum.
+func addClamp(a, b uint64) uint64 {
+	sum, carry := bits.Add64(a, b, 0)
+	if carry != 0 {
+		return math.MaxUint64
+	}
+	return sum
+}
+
+func clampedLine(d FS) string {
+	line := "    size clamped: statfs reports more than 16 EiB"
+	if d.TotalBlocks > 0 {
+		line += fmt.Sprintf(" (%d blocks of %d bytes)", d.TotalBlocks, d.BlockSize)
+	}
+	return line
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestMulClamp(t *testing.T) {
+	tests := []struct {
+		a, b    uint64
+		want    uint64
+		clamped bool
+	}{
+		{1000, 4096, 4096000, false},
+		{1 << 52, 4096, math.MaxUint64, true}, // exactly 2^64
+		{1<<52 - 1, 4096, 1<<64 - 4096, false},
+		{math.MaxUint64, 1, math.MaxUint64, false},
+		{math.MaxUint64, 2, math.MaxUint64, true},
+		{math.MaxUint64, 0, 0, false},
+	}
+	for _, tt := range tests {
+		got, clamped := mulClamp(tt.a, tt.b)
+		if got != tt.want || clamped != tt.clamped {
+			t.Errorf("mulClamp(%d, %d) = %d, %v; want %d, %v", tt.a, tt.b, got, clamped, tt.want, tt.clamped)
+		}
+	}
+}
+
+func TestAddClamp(t *testing.T) {
+	tests := []struct{ a, b, want uint64 }{
+		{1, 2, 3},
+		{math.MaxUint64 - 1, 1, math.MaxUint64},
+		{math.MaxUint64, 1, math.MaxUint64},
+		{math.MaxUint64, math.MaxUint64, math.MaxUint64},
+	}
+	for _, tt := range tests {
+		if got := addClamp(tt.a, tt.b); got != tt.want {
+			t.Errorf("addClamp(%d, %d) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// TestClampedTotals checks a clamped entry keeps the summary and group
+// totals at the maximum rather than wrapping them to a small number.
+func TestClampedTotals(t *testing.T) {
+	list := []FS{
+		{Mount: "/gateway", Dev: "0:50", Total: math.MaxUint64, Used: 1 << 38, Free: math.MaxUint64 - 1<<38, Clamped: true},
+		{Mount: "/data", Dev: "8:1", Total: 1 << 40, Used: 1 << 39, Free: 1 << 39},
+	}
+	s := summarize(list, Config{})
+	if s.Total != math.MaxUint64 || s.Free != math.MaxUint64 || s.Used != 1<<38+1<<39 {
+		t.Errorf("summary: total %d, used %d, free %d", s.Total, s.Used, s.Free)
+	}
+	if s.Usage < 0 || s.Usage > 100 {
+		t.Errorf("summary usage %.2f", s.Usage)
+	}
+
+	groups := buildGroups(list, []GroupConfig{{Name: "all", Members: []string{"/*"}}}, Config{})
+	if len(groups) != 1 || groups[0].Total != math.MaxUint64 || groups[0].Free != math.MaxUint64 {
+		t.Errorf("groups = %+v", groups)
+	}
+}
+
+func TestFmtBytesEiB(t *testing.T) {
+	tests := []struct {
+		b    uint64
+		want string
+	}{
+		{1 << 60, "EiB"},
+		{math.MaxUint64, "EiB"},
+	}
+	for _, tt := range tests {
+		got := fmtBytes(tt.b, true)
+		if !strings.HasSuffix(got, tt.want) {
+			t.Errorf("fmtBytes(%d) = %q, want %s", tt.b, got, tt.want)
+		}
+	}
+	if got := fmtBytes(math.MaxUint64, false); got != "18446744073709551615" {
+		t.Errorf("fmtBytes(MaxUint64, false) = %q", got)
+	}
+}
+
+func TestClampedLine(t *testing.T) {
+	d := FS{Clamped: true, TotalBlocks: math.MaxUint64, BlockSize: 4096}
+	if got := clampedLine(d); !strings.Contains(got, "(18446744073709551615 blocks of 4096 bytes)") {
+		t.Errorf("clampedLine = %q", got)
+	}
+	if got := clampedLine(FS{Clamped: true}); strings.Contains(got, "blocks") {
+		t.Errorf("clampedLine without blocks = %q", got)
+	}
+}
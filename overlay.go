@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"syscall"
+
+	"github.com/AScotM/filesystem_cap/fscap/mountinfo"
+)
+
+// upperDiffPoints is how far the upperdir's usage must be from the
+// overlay's own before table output points it out.
+const upperDiffPoints = 5
+
+// overlayUpperCollector statfs's the filesystem holding an overlay's
+// upperdir. Writes to the overlay land there, and it can fill up while
+// the overlay's own numbers, which sometimes come from elsewhere or are
+// cached, still look fine.
+type overlayUpperCollector struct{}
+
+func (overlayUpperCollector) Name() string                   { return "overlay" }
+func (overlayUpperCollector) Applies(m mountinfo.Mount) bool { return m.FSType == "overlay" }
+func (overlayUpperCollector) Enrich(ctx context.Context, m mountinfo.Mount, d *FS) error {
+	dir := mountinfo.Unescape(mountOption(m.SuperOptions, "upperdir"))
+	if dir == "" {
+		// A read-only overlay has nothing to fill.
+		return nil
+	}
+	var s syscall.Statfs_t
+	if err := statfs(hostPath(dir), &s); err != nil {
+		return fmt.Errorf("upperdir %s: %v", dir, err)
+	}
+	bsize := blockSize(&s)
+	d.UpperDir = dir
+	d.UpperTotal, _ = mulClamp(s.Blocks, bsize)
+	d.UpperFree, _ = mulClamp(s.Bavail, bsize)
+	// From the block counts, which still relate when the bytes clamp.
+	if s.Blocks > 0 {
+		d.UpperUsage = float64(s.Blocks-min(s.Bavail, s.Blocks)) / float64(s.Blocks) * 100
+	}
+	return nil
+}
+
+// upperStatus evaluates the upperdir's usage against the mount's block
+// thresholds, on its own.
+func upperStatus(d FS, config Config) Status {
+	if d.UpperDir == "" {
+		return StatusOK
+	}
+	return blockThresholds(d, config).Evaluate(d.UpperUsage)
+}
+
+func showUpper(d FS) bool {
+	return d.UpperDir != "" && math.Abs(d.UpperUsage-d.Usage) >= upperDiffPoints
+}
+
+func upperLine(d FS, config Config) string {
+	return fmt.Sprintf("    upper: %.0f%% (%s) of %s at %s", d.UpperUsage,
+		upperStatus(d, config), fmtBytes(d.UpperTotal, config.HumanReadable), sanitize(d.UpperDir))
+}
+
+// overlayfsMagic is the statfs f_type of an overlay.
+const overlayfsMagic = 0x794c7630
+
+// overlayMaxDepth bounds how many stacked overlays are followed.
+const overlayMaxDepth = 8
+
+// overlayChainCollector follows an overlay's layers down to the
+// filesystems holding them, through any overlays they sit on, as in
+// Docker-in-Docker. It sets OverlayDepth to the number of overlays
+// stacked, and EffectiveUsage to the usage of the fullest filesystem
+// beneath, which the top-level numbers can hide. Layers not visible in
+// this mount namespace are skipped.
+type overlayChainCollector struct{}
+
+func (overlayChainCollector) Name() string                   { return "overlaychain" }
+func (overlayChainCollector) Applies(m mountinfo.Mount) bool { return m.FSType == "overlay" }
+func (overlayChainCollector) Enrich(ctx context.Context, m mountinfo.Mount, d *FS) error {
+	c := overlayChain{seen: map[string]bool{}}
+	if err := c.walk(ctx, m.SuperOptions, 1); err != nil {
+		return err
+	}
+	if c.dir != "" {
+		d.OverlayDepth, d.EffectiveUsage, d.EffectiveDir = c.depth, c.usage, c.dir
+	}
+	return nil
+}
+
+// overlayChain accumulates the walk of one overlay's layers.
+type overlayChain struct {
+	depth  int
+	usage  float64
+	dir    string
+	seen   map[string]bool
+	mounts []mountinfo.Mount
+}
+
+// walk statfs's the upper and lower layers of the overlay with options
+// at depth, descending into the overlays among them.
+func (c *overlayChain) walk(ctx context.Context, options string, depth int) error {
+	c.depth = max(c.depth, depth)
+	layers := overlayLayers(mountOption(options, "lowerdir"))
+	if upper := mountOption(options, "upperdir"); upper != "" {
+		layers = append([]string{mountinfo.Unescape(upper)}, layers...)
+	}
+	for _, dir := range layers {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var s syscall.Statfs_t
+		if statfs(dir, &s) != nil {
+			continue
+		}
+		if s.Type == overlayfsMagic {
+			if depth >= overlayMaxDepth {
+				continue
+			}
+			if c.mounts == nil {
+				mounts, err := readMounts()
+				if err != nil {
+					return err
+				}
+				c.mounts = mounts
+			}
+			if m, ok := pathMount(c.mounts, dir); ok && m.FSType == "overlay" && !c.seen["mount:"+m.MountPoint] {
+				c.seen["mount:"+m.MountPoint] = true
+				if err := c.walk(ctx, m.SuperOptions, depth+1); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		key := fsidString(&s)
+		total := s.Blocks * blockSize(&s)
+		if c.seen[key] || total == 0 {
+			continue
+		}
+		c.seen[key] = true
+		usage := float64(total-min(s.Bavail*blockSize(&s), total)) / float64(total) * 100
+		if c.dir == "" || usage > c.usage {
+			c.usage, c.dir = usage, dir
+		}
+	}
+	return nil
+}
+
+// overlayLayers splits an overlay's lowerdir option into its layers. They
+// are separated by ":", or "::" before data-only layers, and a colon in
+// a layer's path is escaped as "\:". The kernel's octal escapes are
+// decoded as for the other mountinfo fields.
+func overlayLayers(lowerdir string) []string {
+	var layers []string
+	var cur strings.Builder
+	flush := func() {
+		if cur.Len() > 0 {
+			layers = append(layers, mountinfo.Unescape(cur.String()))
+			cur.Reset()
+		}
+	}
+	for i := 0; i < len(lowerdir); i++ {
+		switch c := lowerdir[i]; {
+		case c == '\\' && i+1 < len(lowerdir) && lowerdir[i+1] == ':':
+			cur.WriteByte(':')
+			i++
+		case c == ':':
+			flush()
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	flush()
+	return layers
+}
+
+// effectiveStatus evaluates the EffectiveUsage of an overlay chain
+// against the mount's block thresholds.
+func effectiveStatus(d FS, config Config) Status {
+	if d.OverlayDepth == 0 {
+		return StatusOK
+	}
+	return blockThresholds(d, config).Evaluate(d.EffectiveUsage)
+}
+
+func effectiveLine(d FS, config Config) string {
+	return fmt.Sprintf("    effective: %.0f%% (%s) on the filesystem of %s, %d overlay(s) deep", d.EffectiveUsage,
+		effectiveStatus(d, config), sanitize(d.EffectiveDir), d.OverlayDepth)
+}
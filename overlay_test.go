@@ -0,0 +1,31 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOverlayLayers(t *testing.T) {
+	tests := []struct {
+		lowerdir string
+		want     []string
+	}{
+		{"", nil},
+		{"/l1", []string{"/l1"}},
+		{"/l1:/l2:/l3", []string{"/l1", "/l2", "/l3"}},
+		{`/a\:b:/c`, []string{"/a:b", "/c"}},
+		{`/a\:b\:c`, []string{"/a:b:c"}},
+		{"/l1:/l2::/data1::/data2", []string{"/l1", "/l2", "/data1", "/data2"}},
+		{`/l\:1::/data\:1`, []string{"/l:1", "/data:1"}},
+		{`/with\040space:/l2`, []string{"/with space", "/l2"}},
+		// An escaped backslash before a colon does not escape the colon.
+		{`/back\134:/l2`, []string{`/back\`, "/l2"}},
+		{`/trailing\`, []string{`/trailing\`}},
+		{":/l1:", []string{"/l1"}},
+	}
+	for _, tt := range tests {
+		if got := overlayLayers(tt.lowerdir); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("overlayLayers(%q) = %q, want %q", tt.lowerdir, got, tt.want)
+		}
+	}
+}
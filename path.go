@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/AScotM/filesystem_cap/fscap/mountinfo"
+)
+
+// runPath implements "dfmon path": it reports the filesystems containing
+// the given paths. Only those are stat'd, so it stays quick on hosts with
+// thousands of mounts. The config's ignore, annotation and threshold rules
+// apply, and the output and exit code are those of a one-shot run.
+func runPath(ctx context.Context, config Config, fileConfig FileConfig, args []string, logger *log.Logger) {
+	flags := flag.NewFlagSet("path", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: dfmon [flags] path PATH...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	mounts, err := readMounts()
+	if err != nil {
+		logger.Fatalf("Failed to read mounts: %v", err)
+	}
+
+	var selected []mountinfo.Mount
+	seen := map[int]bool{}
+	for _, arg := range flags.Args() {
+		path, err := resolvePath(arg)
+		if err != nil {
+			logger.Fatalf("Cannot resolve %s: %v", arg, err)
+		}
+		m, ok := pathMount(mounts, path)
+		if !ok {
+			logger.Fatalf("No mount contains %s", path)
+		}
+		if !seen[m.ID] {
+			seen[m.ID] = true
+			selected = append(selected, m)
+		}
+	}
+
+	config.Collectors = withPlugins(config.Collectors, fileConfig.Plugins)
+	data := analyze(selected, logger, ctx, nil, config)
+	if len(data) < len(selected) {
+		logger.Fatalf("Cannot get usage for every path")
+	}
+	now := time.Now()
+	applyIdentity(data, deviceUUIDs())
+	applyRules(data, fileConfig, config, now)
+	// Rows follow the arguments unless -s asks for another order.
+	if flagPassed("s") {
+		sortFS(data, config.SortBy)
+	}
+
+	r := Report{Filesystems: data, Warnings: config.Degraded}
+	if code := report(ctx, r, config, fileConfig, nil, logger); code != 0 {
+		os.Exit(code)
+	}
+}
+
+// resolvePath makes path absolute and free of symlinks. Under -root the
+// result is taken relative to the root, as the mount points are.
+func resolvePath(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if path, err = filepath.EvalSymlinks(path); err != nil {
+		return "", err
+	}
+	if mountRoot == "" || foreignTable.Load() {
+		return path, nil
+	}
+	rel, ok := underRoot(path, mountRoot)
+	if !ok {
+		return "", fmt.Errorf("not under -root %s", mountRoot)
+	}
+	return rel, nil
+}
+
+// pathMount returns the mount path is on: the innermost mount containing
+// it, the last one where several are stacked, as it shadows the others.
+func pathMount(mounts []mountinfo.Mount, path string) (mountinfo.Mount, bool) {
+	targets := explainTargets(mounts, path)
+	if len(targets) == 0 {
+		return mountinfo.Mount{}, false
+	}
+	return targets[len(targets)-1], true
+}
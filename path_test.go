@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"os/exec"
+	"testing"
+
+	"github.com/AScotM/filesystem_cap/fscap/mountinfo"
+)
+
+func TestPathMount(t *testing.T) {
+	mounts := []mountinfo.Mount{
+		{ID: 1, MountPoint: "/"},
+		{ID: 2, MountPoint: "/var"},
+		{ID: 3, MountPoint: "/var/lib"},
+		{ID: 4, MountPoint: "/var/lib"}, // stacked over 3
+		{ID: 5, MountPoint: "/variable"},
+	}
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/", 1},
+		{"/etc/passwd", 1},
+		{"/var", 2},
+		{"/var/log", 2},
+		{"/var/lib/docker", 4},
+		{"/variable/x", 5},
+		{"/varx", 1},
+	}
+	for _, tt := range tests {
+		m, ok := pathMount(mounts, tt.path)
+		if !ok || m.ID != tt.want {
+			t.Errorf("pathMount(%q) = %d, %v; want %d", tt.path, m.ID, ok, tt.want)
+		}
+	}
+}
+
+// TestPathExitCode runs "dfmon path" twice on the same filesystem: it is
+// reported once, and the thresholds set the exit status as in a full run.
+func TestPathExitCode(t *testing.T) {
+	exe := buildDfmon(t)
+	dir := t.TempDir()
+
+	cmd := exec.Command(exe, append(quietThresholds, "-config", emptyConfig(t), "-o", "json", "path", dir, dir)...)
+	cmd.Env = withoutDfmonEnv()
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("dfmon path: %v", err)
+	}
+	var r Report
+	if err := json.Unmarshal(out, &r); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Filesystems) != 1 {
+		t.Errorf("dfmon path %s %s reported %d filesystems, want 1", dir, dir, len(r.Filesystems))
+	}
+
+	cmd = exec.Command(exe, "-config", emptyConfig(t), "-w", "0", "-c", "0", "-o", "oneline", "path", dir)
+	cmd.Env = withoutDfmonEnv()
+	if err := cmd.Run(); err == nil || err.(*exec.ExitError).ExitCode() != 2 {
+		t.Errorf("dfmon -c 0 path: %v, want exit status 2", err)
+	}
+}
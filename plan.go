@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AScotM/filesystem_cap/fscap/mountinfo"
+)
+
+type planItem struct {
+	Mount string
+	Add   uint64
+}
+
+type planResult struct {
+	FS
+	Add          uint64
+	NewUsed      uint64
+	NewFree      uint64
+	NewUsage     float64
+	Before       Status
+	After        Status
+	Insufficient bool
+}
+
+// runPlan implements "dfmon plan": it projects what usage would become if
+// the given amounts were written to the given mounts, against the
+// thresholds the config's rules give them.
+func runPlan(ctx context.Context, config Config, fileConfig FileConfig, args []string, logger *log.Logger) {
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	add := fs.String("add", "", "Size to add to each listed mount (e.g. 800G)")
+	fs.Float64Var(&config.WarnThreshold, "w", config.WarnThreshold, "Warning threshold")
+	fs.Float64Var(&config.CritThreshold, "c", config.CritThreshold, "Critical threshold")
+	fs.BoolVar(&config.HumanReadable, "h", config.HumanReadable, "Human readable sizes")
+	fs.BoolVar(&config.NoColor, "no-color", config.NoColor, "Disable color output")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: dfmon plan [-add SIZE] MOUNT... | MOUNT=SIZE...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	items, err := parsePlanItems(*add, fs.Args())
+	if err != nil {
+		logger.Fatalf("Invalid plan: %v", err)
+	}
+
+	mounts, err := readMounts()
+	if err != nil {
+		logger.Fatalf("Failed to read mounts: %v", err)
+	}
+
+	var selected []mountinfo.Mount
+	for _, it := range items {
+		m, ok := findMount(mounts, it.Mount)
+		if !ok {
+			logger.Fatalf("Not a mount point: %s", it.Mount)
+		}
+		selected = append(selected, m)
+	}
+
+	data := analyze(selected, logger, ctx, nil, config)
+	applyIdentity(data, deviceUUIDs())
+	applyRules(data, fileConfig, config, time.Now())
+	current := map[string]FS{}
+	for _, d := range data {
+		current[d.Mount] = d
+	}
+
+	var results []planResult
+	for _, it := range items {
+		d, ok := current[it.Mount]
+		if !ok {
+			logger.Fatalf("Cannot get usage for %s", it.Mount)
+		}
+		results = append(results, simulate(d, it.Add, config))
+	}
+	displayPlan(results, config)
+}
+
+func parsePlanItems(add string, args []string) ([]planItem, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no mounts given")
+	}
+
+	var items []planItem
+	for _, a := range args {
+		mount, size := a, add
+		if i := strings.LastIndex(a, "="); i >= 0 {
+			mount, size = a[:i], a[i+1:]
+		}
+		if size == "" {
+			return nil, fmt.Errorf("no size given for %s", mount)
+		}
+		n, err := parseSize(size)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, planItem{Mount: filepath.Clean(mount), Add: n})
+	}
+	return items, nil
+}
+
+func findMount(mounts []mountinfo.Mount, mount string) (mountinfo.Mount, bool) {
+	var found mountinfo.Mount
+	ok := false
+	for _, m := range mounts {
+		if m.MountPoint == mount {
+			// Later entries shadow earlier ones mounted on the same path.
+			found, ok = m, true
+		}
+	}
+	return found, ok
+}
+
+func simulate(d FS, add uint64, config Config) planResult {
+	r := planResult{FS: d, Add: add, NewUsed: d.Used + add}
+	if add > d.Free {
+		r.Insufficient = true
+	} else {
+		r.NewFree = d.Free - add
+	}
+	if d.Total > 0 {
+		r.NewUsage = float64(r.NewUsed) / float64(d.Total) * 100
+	}
+	if q := d.Quota; usageBasis(d) == "quota" {
+		// Projected against the quota, as a usage_basis rule has Usage be.
+		r.NewUsage = float64(q.Used+add) / float64(q.Limit) * 100
+		r.Insufficient = r.Insufficient || q.Used+add > q.Limit
+	}
+	if d.Ignored || muted(d) {
+		return r
+	}
+	t := blockThresholds(d, config)
+	r.Before = t.Evaluate(d.Usage)
+	r.After = t.Evaluate(r.NewUsage)
+	return r
+}
+
+func displayPlan(results []planResult, config Config) {
+	fmt.Printf("%s %s %s %s %s %s %s\n",
+		fitCell("Mount", 25), fitCell("Add", 10), fitCell("Used", 10), fitCell("Free", 10),
+		fitCell("Usage", 8), fitCell("After", 8), "Note")
+
+	for _, r := range results {
+		color := ""
+		if !r.Ignored {
+			color = Colors.ForUsage(r.NewUsage, blockThresholds(r.FS, config), config.NoColor)
+		}
+		reset := ""
+		if color != "" {
+			reset = Colors.Reset
+		}
+
+		fmt.Printf("%s %s %s %s %s %s%s%s %s\n",
+			fitCell(sanitize(r.Mount), 25),
+			fitCell(fmtBytes(r.Add, config.HumanReadable), 10),
+			fitCell(fmtBytes(r.NewUsed, config.HumanReadable), 10),
+			fitCell(fmtBytes(r.NewFree, config.HumanReadable), 10),
+			fitCell(strconv.FormatFloat(r.Usage, 'f', 2, 64)+"%", 8),
+			color, fitCell(strconv.FormatFloat(r.NewUsage, 'f', 2, 64)+"%", 8), reset, r.note(),
+		)
+	}
+}
+
+// note is the last column of the plan: whether the data fits and which
+// threshold writing it crosses, or why none applies.
+func (r planResult) note() string {
+	switch {
+	case r.Insufficient:
+		return "does not fit"
+	case r.Ignored:
+		return "ignored"
+	case muted(r.FS):
+		return "muted"
+	case r.After > r.Before:
+		return "crosses " + r.After.String()
+	}
+	return ""
+}
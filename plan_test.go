@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSimulate(t *testing.T) {
+	config := Config{WarnThreshold: 80, CritThreshold: 90}
+	base := FS{Mount: "/data", Total: 1000, Used: 500, Free: 500, Usage: 50}
+	ruled := base
+	ruled.Thresholds = &AppliedThresholds{Rule: "data", Warn: 55, Crit: 95}
+	muteRule := base
+	muteRule.Thresholds = &AppliedThresholds{Rule: "data", Muted: true}
+	ignored := base
+	ignored.Ignored = true
+
+	tests := []struct {
+		name  string
+		d     FS
+		add   uint64
+		after Status
+		note  string
+	}{
+		{"flags", base, 350, StatusWarning, "crosses warning"},
+		// The flags would leave 60% ok and call 94% critical.
+		{"rule warn", ruled, 100, StatusWarning, "crosses warning"},
+		{"rule crit", ruled, 440, StatusWarning, "crosses warning"},
+		{"muted", muteRule, 450, StatusOK, "muted"},
+		{"ignored", ignored, 450, StatusOK, "ignored"},
+		{"does not fit", base, 600, StatusCritical, "does not fit"},
+	}
+	for _, tt := range tests {
+		r := simulate(tt.d, tt.add, config)
+		if r.After != tt.after || r.note() != tt.note {
+			t.Errorf("%s: after %v, note %q; want %v, %q", tt.name, r.After, r.note(), tt.after, tt.note)
+		}
+	}
+	if r := simulate(base, 100, config); r.NewUsed != 600 || r.NewFree != 400 || r.NewUsage != 60 {
+		t.Errorf("simulate = %+v", r)
+	}
+}
+
+// TestSimulateQuota checks a mount whose usage_basis rule measures it
+// against the quota is projected against the quota too.
+func TestSimulateQuota(t *testing.T) {
+	config := Config{WarnThreshold: 80, CritThreshold: 90, Location: time.UTC}
+	list := []FS{{Mount: "/home", Total: 1000, Used: 100, Free: 900, Usage: 10, Quota: &Quota{Used: 50, Limit: 100}}}
+	applyRules(list, FileConfig{UsageBasis: []UsageBasisRule{{Match: "/home", Basis: "quota"}}}, config, time.Now())
+
+	r := simulate(list[0], 35, config)
+	if r.NewUsage != 85 || r.Before != StatusOK || r.After != StatusWarning || r.Insufficient {
+		t.Errorf("within the quota: usage %.1f, %v to %v, insufficient %v", r.NewUsage, r.Before, r.After, r.Insufficient)
+	}
+	if r := simulate(list[0], 60, config); !r.Insufficient || r.After != StatusCritical {
+		t.Errorf("past the quota: %+v", r)
+	}
+}
+
+// TestPlanUsesConfig runs "dfmon plan" with a config ignoring the root
+// filesystem, which the plan must then not judge.
+func TestPlanUsesConfig(t *testing.T) {
+	bin := buildDfmon(t)
+	cfg := filepath.Join(t.TempDir(), "dfmon.json")
+	if err := os.WriteFile(cfg, []byte(`{"ignore": [{"mount": "/"}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(bin, "-config", cfg, "plan", "-w", "0", "-c", "0", "-add", "1", "/")
+	cmd.Env = withoutDfmonEnv()
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[1], "/ ") || !strings.HasSuffix(lines[1], " ignored") {
+		t.Errorf("plan output:\n%s", out)
+	}
+}
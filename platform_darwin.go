@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// The mount table and enrichers are Linux-only; on macOS dfmon builds for
+// the offline commands such as check-config, events and capabilities, e.g.
+// to look at data captured on a server.
+const liveCollection = false
+
+func blockSize(s *syscall.Statfs_t) uint64 { return uint64(s.Bsize) }
+
+func fsidString(s *syscall.Statfs_t) string {
+	return fmt.Sprintf("%08x%08x", uint32(s.Fsid.Val[0]), uint32(s.Fsid.Val[1]))
+}
+
+func totalRAM() (uint64, error) { return 0, errors.ErrUnsupported }
+
+func kernelRelease() string { return "" }
+
+func decodeStatfsFlags(flags uint64) []string { return nil }
+
+func landlockABI() int { return 0 }
+
+type SandboxState struct {
+	Active bool
+	Detail string
+}
+
+func enterSandbox() (SandboxState, error) {
+	return SandboxState{Detail: "landlock unavailable (Linux only)"}, nil
+}
+
+func quotactl(device string, xfs bool, uid int, now time.Time) (*Quota, error) {
+	return nil, errors.ErrUnsupported
+}
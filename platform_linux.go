@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// liveCollection reports whether dfmon can collect from the running
+// system. Elsewhere only the offline commands work.
+const liveCollection = true
+
+// blockSize is the unit of statfs block counts. f_bsize is only the
+// preferred I/O size and can be much larger than f_frsize on NFS and fuse.
+func blockSize(s *syscall.Statfs_t) uint64 {
+	if s.Frsize != 0 {
+		return uint64(s.Frsize)
+	}
+	return uint64(s.Bsize)
+}
+
+func fsidString(s *syscall.Statfs_t) string {
+	return fmt.Sprintf("%08x%08x", uint32(s.Fsid.X__val[0]), uint32(s.Fsid.X__val[1]))
+}
+
+func totalRAM() (uint64, error) {
+	var si syscall.Sysinfo_t
+	if err := syscall.Sysinfo(&si); err != nil {
+		return 0, err
+	}
+	return uint64(si.Totalram) * uint64(si.Unit), nil
+}
+
+func kernelRelease() string {
+	var u syscall.Utsname
+	if syscall.Uname(&u) != nil {
+		return ""
+	}
+	var sb strings.Builder
+	for _, c := range u.Release {
+		if c == 0 {
+			break
+		}
+		sb.WriteByte(byte(c))
+	}
+	return sb.String()
+}
+
+// quotactl reads the user quota of uid on the filesystem on device, with
+// Q_XGETQUOTA on XFS and Q_GETQUOTA elsewhere. A filesystem with quotas
+// off or no quota for uid gives errNoQuota.
+func quotactl(device string, xfs bool, uid int, now time.Time) (*Quota, error) {
+	const (
+		qGetQuota  = 0x800007
+		qXGetQuota = 'X'<<8 + 3
+		usrQuota   = 0
+	)
+	dev, err := syscall.BytePtrFromString(device)
+	if err != nil {
+		return nil, err
+	}
+	cmd, size := qGetQuota, dqblkSize
+	if xfs {
+		cmd, size = qXGetQuota, xfsQuotaSize
+	}
+	buf := make([]byte, size)
+	_, _, errno := syscall.Syscall6(syscall.SYS_QUOTACTL, uintptr(cmd<<8|usrQuota),
+		uintptr(unsafe.Pointer(dev)), uintptr(uid), uintptr(unsafe.Pointer(&buf[0])), 0, 0)
+	switch errno {
+	case 0:
+	case syscall.ESRCH, syscall.ENOENT, syscall.ENOSYS, syscall.EOPNOTSUPP, syscall.ENOTBLK:
+		return nil, errNoQuota
+	default:
+		return nil, errno
+	}
+	if xfs {
+		return parseXFSQuota(buf, now)
+	}
+	return parseDqblk(buf, now)
+}
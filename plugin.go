@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/AScotM/filesystem_cap/fscap/mountinfo"
+)
+
+// PluginConfig runs an external program for the mounts it matches. The
+// program gets the mount path as its only argument and prints a JSON
+// object on stdout: total, used and free in bytes, any of which replace
+// the statfs numbers, and anything else, which is kept in Details. With
+// -scan-user it runs as that user, like exec actions.
+type PluginConfig struct {
+	Name    string `json:"name"`
+	Exec    string `json:"exec"`
+	Type    string `json:"type,omitempty"`
+	Match   string `json:"match,omitempty"`
+	Timeout string `json:"timeout,omitempty"`
+}
+
+const (
+	defaultPluginTimeout = 5 * time.Second
+	// pluginOutputMax is the most a plugin may print on stdout.
+	pluginOutputMax = 1 << 20
+)
+
+func (p PluginConfig) check(key string) []error {
+	var errs []error
+	if p.Name == "" {
+		errs = append(errs, fmt.Errorf("%s.name: required", key))
+	}
+	if p.Exec == "" {
+		errs = append(errs, fmt.Errorf("%s.exec: required", key))
+	} else if !filepath.IsAbs(p.Exec) {
+		errs = append(errs, fmt.Errorf("%s.exec: %q is not an absolute path", key, p.Exec))
+	}
+	if p.Type == "" && p.Match == "" {
+		errs = append(errs, fmt.Errorf("%s: one of type or match required", key))
+	}
+	if _, err := filepath.Match(p.Match, ""); err != nil {
+		errs = append(errs, fmt.Errorf("%s.match: bad pattern %q", key, p.Match))
+	}
+	if p.Timeout != "" {
+		if d, err := time.ParseDuration(p.Timeout); err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("%s.timeout: %q is not a positive duration", key, p.Timeout))
+		}
+	}
+	return errs
+}
+
+// pluginCollector adapts a PluginConfig to the Collector interface.
+type pluginCollector struct{ PluginConfig }
+
+// withPlugins appends the config's plugins to the built-in collectors, so
+// that they run last and can override what the others found.
+func withPlugins(enabled []Collector, plugins []PluginConfig) []Collector {
+	if len(plugins) == 0 {
+		return enabled
+	}
+	out := append([]Collector{}, enabled...)
+	for _, p := range plugins {
+		out = append(out, pluginCollector{p})
+	}
+	return out
+}
+
+func (p pluginCollector) Name() string { return "plugin " + p.PluginConfig.Name }
+
+func (p pluginCollector) Applies(m mountinfo.Mount) bool {
+	if p.Type != "" && p.Type != m.FSType {
+		return false
+	}
+	if p.Match != "" {
+		if ok, _ := filepath.Match(p.Match, m.MountPoint); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (p pluginCollector) Timeout() time.Duration {
+	if d, err := time.ParseDuration(p.PluginConfig.Timeout); err == nil && d > 0 {
+		return d
+	}
+	return defaultPluginTimeout
+}
+
+// Enrich runs the plugin, as the -scan-user user when one is set, keeping
+// at most pluginOutputMax bytes of its output.
+func (p pluginCollector) Enrich(ctx context.Context, m mountinfo.Mount, d *FS) error {
+	cmd := exec.CommandContext(ctx, p.Exec, hostPath(m.MountPoint))
+	if scanCred != nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: scanCred}
+	}
+	// One byte over the limit tells a full buffer from a cut one.
+	out := &cappedBuffer{max: pluginOutputMax + 1}
+	stderr := &cappedBuffer{max: execOutputMax}
+	cmd.Stdout, cmd.Stderr = out, stderr
+	// Children left holding the output open do not keep the plugin running.
+	cmd.WaitDelay = 5 * time.Second
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%v: %s", err, firstLine(msg))
+		}
+		return err
+	}
+	if out.Len() > pluginOutputMax {
+		return fmt.Errorf("output over %d bytes", pluginOutputMax)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(out.Bytes(), &fields); err != nil {
+		return fmt.Errorf("bad output: %v", err)
+	}
+	sizes := map[string]*uint64{}
+	for _, k := range []string{"total", "used", "free"} {
+		raw, ok := fields[k]
+		if !ok {
+			continue
+		}
+		var n uint64
+		if err := json.Unmarshal(raw, &n); err != nil {
+			return fmt.Errorf("bad %s: %s", k, raw)
+		}
+		sizes[k] = &n
+		delete(fields, k)
+	}
+	applySizes(d, sizes["total"], sizes["used"], sizes["free"])
+
+	if len(fields) == 0 {
+		return nil
+	}
+	// d is a copy of the entry, but its map is still shared.
+	details := make(map[string]json.RawMessage, len(d.Details)+len(fields))
+	for k, v := range d.Details {
+		details[k] = v
+	}
+	// The plugin's output is not to be trusted: its strings reach the
+	// tables, the JSON outputs and the sinks.
+	for k, v := range fields {
+		details[strictSanitize(k)], _ = sanitizeJSON(v)
+	}
+	d.Details = details
+	return nil
+}
+
+// applySizes overrides d's sizes with those given, deriving a missing one
+// from the other two when the plugin sets total.
+func applySizes(d *FS, total, used, free *uint64) {
+	if total == nil && used == nil && free == nil {
+		return
+	}
+	if total != nil {
+		d.Total = *total
+		switch {
+		case used != nil && free == nil:
+			d.Free = d.Total - min(*used, d.Total)
+		case free != nil && used == nil:
+			d.Used = d.Total - min(*free, d.Total)
+		}
+	}
+	if used != nil {
+		d.Used = *used
+	}
+	if free != nil {
+		d.Free = *free
+	}
+	d.Usage = 0
+	if d.Total > 0 {
+		d.Usage = float64(d.Used) / float64(d.Total) * 100
+	}
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+func errorsLine(d FS) string {
+	return "    errors: " + sanitize(strings.Join(d.Errors, "; "))
+}
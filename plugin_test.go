@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AScotM/filesystem_cap/fscap/mountinfo"
+)
+
+func TestPluginDetailsSanitized(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "plugin")
+	out := `{"total": 100, "used": 40, "health": "ok\u001b[2J", "ctl\u0007": {"nested": ["a\nb"]}, "count": 7}`
+	if err := os.WriteFile(script, []byte("#!/bin/sh\ncat <<'EOF'\n"+out+"\nEOF\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	p := pluginCollector{PluginConfig{Name: "test", Exec: script, Match: "/*"}}
+	d := FS{Mount: "/data"}
+	if err := p.Enrich(context.Background(), mountinfo.Mount{MountPoint: "/data"}, &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Total != 100 || d.Used != 40 || d.Free != 60 {
+		t.Errorf("sizes = %d/%d/%d", d.Total, d.Used, d.Free)
+	}
+	want := map[string]string{
+		"health":  `"ok\\x1b[2J"`,
+		`ctl\x07`: `{"nested":["a\\x0ab"]}`,
+		"count":   `7`,
+	}
+	if len(d.Details) != len(want) {
+		t.Errorf("details = %v", d.Details)
+	}
+	for k, v := range want {
+		if got := string(d.Details[k]); got != v {
+			t.Errorf("details[%q] = %s, want %s", k, got, v)
+		}
+	}
+}
+
+// writePlugin writes a shell script plugin running body to dir.
+func writePlugin(t *testing.T, dir, body string) pluginCollector {
+	t.Helper()
+	script := filepath.Join(dir, "plugin")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n"+body+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return pluginCollector{PluginConfig{Name: "test", Exec: script, Match: "/*", Timeout: "200ms"}}
+}
+
+// TestPluginFailures checks a plugin that fails leaves the entry's sizes
+// alone and marks it with the error.
+func TestPluginFailures(t *testing.T) {
+	tests := []struct {
+		name, body, want string
+	}{
+		{"timeout", "sleep 30", "plugin test: "},
+		{"exit", "echo 'no such pool' >&2; exit 3", "plugin test: exit status 3: no such pool"},
+		{"bad json", "echo '{\"total\": 100'", "plugin test: bad output: "},
+		{"bad size", `echo '{"total": "100"}'`, `plugin test: bad total: "100"`},
+		{"too long", "head -c 2000000 /dev/zero", "plugin test: output over 1048576 bytes"},
+	}
+	for _, tt := range tests {
+		p := writePlugin(t, t.TempDir(), tt.body)
+		d := FS{Mount: "/data", Total: 10, Used: 4, Free: 6}
+		start := time.Now()
+		enrich(context.Background(), mountinfo.Mount{MountPoint: "/data"}, &d, []Collector{p})
+		if len(d.Errors) != 1 || !strings.HasPrefix(d.Errors[0], tt.want) {
+			t.Errorf("%s: errors = %q, want %q", tt.name, d.Errors, tt.want)
+		}
+		if d.Total != 10 || d.Used != 4 || d.Free != 6 {
+			t.Errorf("%s: sizes = %d/%d/%d", tt.name, d.Total, d.Used, d.Free)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("%s: took %v", tt.name, elapsed)
+		}
+	}
+}
+
+// TestPluginKilled checks a plugin that outlives its timeout is killed
+// along with the wait for its output, even with a child holding stdout.
+func TestPluginKilled(t *testing.T) {
+	p := writePlugin(t, t.TempDir(), "sleep 30 &\nsleep 30")
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := p.Enrich(ctx, mountinfo.Mount{MountPoint: "/data"}, &FS{})
+	if err == nil {
+		t.Fatal("no error")
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("took %v", elapsed)
+	}
+}
+
+// TestPluginScanUser checks a plugin runs as the -scan-user user.
+func TestPluginScanUser(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("needs root to change user")
+	}
+	cred, err := lookupScanUser("nobody")
+	if err != nil {
+		t.Skipf("no nobody user: %v", err)
+	}
+	saved := scanCred
+	scanCred = cred
+	defer func() { scanCred = saved }()
+
+	// t.TempDir is closed to anyone but its owner.
+	dir, err := os.MkdirTemp("", "dfmon-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	if err := os.Chmod(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	p := writePlugin(t, dir, `echo "{\"uid\": $(id -u)}"`)
+	d := FS{Mount: "/data"}
+	if err := p.Enrich(context.Background(), mountinfo.Mount{MountPoint: "/data"}, &d); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(d.Details["uid"]), strconv.FormatUint(uint64(cred.Uid), 10); got != want {
+		t.Errorf("uid = %s, want %s", got, want)
+	}
+}
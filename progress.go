@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// progress reports collection progress on stderr. On a terminal it keeps a
+// single line updated in place; otherwise it writes at most one line per
+// interval. A nil *progress is valid and reports nothing.
+type progress struct {
+	mu       sync.Mutex
+	tty      bool
+	start    time.Time
+	total    int
+	done     int
+	current  string
+	stop     chan struct{}
+	interval time.Duration
+}
+
+func newProgress(total int) *progress {
+	p := &progress{
+		tty:      isTerminal(os.Stderr),
+		start:    time.Now(),
+		total:    total,
+		stop:     make(chan struct{}),
+		interval: 2 * time.Second,
+	}
+	if !p.tty {
+		go p.tick()
+	}
+	return p
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// Begin records that mount is about to be stat'd after done completed.
+func (p *progress) Begin(done int, mount string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done, p.current = done, mount
+	if p.tty {
+		fmt.Fprintf(os.Stderr, "\r\033[K%s", p.line())
+	}
+}
+
+// Finish stops reporting and clears the terminal line.
+func (p *progress) Finish() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.tty {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+	} else {
+		close(p.stop)
+	}
+}
+
+func (p *progress) tick() {
+	t := time.NewTicker(p.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-t.C:
+			p.mu.Lock()
+			fmt.Fprintln(os.Stderr, p.line())
+			p.mu.Unlock()
+		}
+	}
+}
+
+func (p *progress) line() string {
+	return fmt.Sprintf("%d/%d mounts, %.1fs, stat %s",
+		p.done, p.total, time.Since(p.start).Seconds(), p.current)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/AScotM/filesystem_cap/fscap/mountinfo"
+)
+
+// Quota is the calling user's quota on a mount, from the NFS server with
+// -nfs-quota or from quotactl with -quota. Sizes are in bytes. Limit is
+// the hard limit, or the soft one when no hard limit is set. Once usage
+// is over a soft limit the grace field is the time left to get back
+// under it, zero when the grace period is over and the soft limit is
+// enforced like the hard one.
+type Quota struct {
+	Used              uint64 `json:"used"`
+	Limit             uint64 `json:"limit"`
+	Source            string `json:"source"`
+	Soft              uint64 `json:"soft_limit,omitempty"`
+	Hard              uint64 `json:"hard_limit,omitempty"`
+	GraceSeconds      *int64 `json:"grace_seconds,omitempty"`
+	Inodes            uint64 `json:"inodes,omitempty"`
+	InodeSoft         uint64 `json:"inode_soft_limit,omitempty"`
+	InodeHard         uint64 `json:"inode_hard_limit,omitempty"`
+	InodeGraceSeconds *int64 `json:"inode_grace_seconds,omitempty"`
+}
+
+// quotaUsage is a quota as every source reports it: usage, limits and
+// the times the soft limits expire, as Unix seconds, zero when not
+// running.
+type quotaUsage struct {
+	used, soft, hard        uint64
+	inodes, isoft, ihard    uint64
+	blockExpiry, fileExpiry int64
+}
+
+// quota builds the Quota of u at now, or errNoQuota when u sets no limit.
+func (u quotaUsage) quota(source string, now time.Time) (*Quota, error) {
+	if u.soft == 0 && u.hard == 0 && u.isoft == 0 && u.ihard == 0 {
+		return nil, errNoQuota
+	}
+	q := &Quota{
+		Used: u.used, Limit: u.hard, Source: source, Soft: u.soft, Hard: u.hard,
+		Inodes: u.inodes, InodeSoft: u.isoft, InodeHard: u.ihard,
+		GraceSeconds:      graceLeft(u.used, u.soft, u.blockExpiry, now),
+		InodeGraceSeconds: graceLeft(u.inodes, u.isoft, u.fileExpiry, now),
+	}
+	if q.Limit == 0 {
+		q.Limit = u.soft
+	}
+	return q, nil
+}
+
+func graceLeft(used, soft uint64, expiry int64, now time.Time) *int64 {
+	if soft == 0 || used <= soft || expiry == 0 {
+		return nil
+	}
+	left := max(expiry-now.Unix(), 0)
+	return &left
+}
+
+// usage is the share of Limit used, in percent.
+func (q *Quota) usage() float64 {
+	if q.Limit == 0 {
+		return 0
+	}
+	return float64(q.Used) / float64(q.Limit) * 100
+}
+
+// status is critical at a hard limit or past the grace period of a soft
+// one, and a warning within the grace period.
+func (q *Quota) status() Status {
+	if q == nil {
+		return StatusOK
+	}
+	st := StatusOK
+	for _, l := range []struct {
+		used, soft, hard uint64
+		grace            *int64
+	}{{q.Used, q.Soft, q.Hard, q.GraceSeconds}, {q.Inodes, q.InodeSoft, q.InodeHard, q.InodeGraceSeconds}} {
+		switch {
+		case l.hard > 0 && l.used >= l.hard, l.grace != nil && *l.grace == 0:
+			return StatusCritical
+		case l.soft > 0 && l.used > l.soft:
+			st = StatusWarning
+		}
+	}
+	return st
+}
+
+// inGrace is whether usage is over a soft limit whose grace period still
+// runs, which gets the grace color and status.
+func (q *Quota) inGrace() bool {
+	return q.status() == StatusWarning && (q.GraceSeconds != nil || q.InodeGraceSeconds != nil)
+}
+
+// statusName is status as alerts and -status-fd name it: "grace" rather
+// than "warning" within a grace period.
+func (q *Quota) statusName() string {
+	if q.inGrace() {
+		return "grace"
+	}
+	return q.status().String()
+}
+
+// quotaStatus is the state of d's quota, ok without one.
+func quotaStatus(d FS) Status {
+	return d.Quota.status()
+}
+
+// localQuotaFS are the filesystems quotactl reports quotas of.
+var localQuotaFS = map[string]bool{
+	"ext2": true, "ext3": true, "ext4": true, "xfs": true,
+	"f2fs": true, "jfs": true, "reiserfs": true,
+}
+
+// localQuotaCollector reads the caller's user quota on local filesystems
+// with quotactl, through the XFS interface on XFS and the generic one
+// elsewhere. Enabled with -quota.
+type localQuotaCollector struct{}
+
+func (localQuotaCollector) Name() string                   { return "quota" }
+func (localQuotaCollector) Applies(m mountinfo.Mount) bool { return localQuotaFS[m.FSType] }
+func (localQuotaCollector) Enrich(ctx context.Context, m mountinfo.Mount, d *FS) error {
+	q, err := quotactl(m.Source, m.FSType == "xfs", os.Getuid(), time.Now())
+	if errors.Is(err, errNoQuota) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("quotactl: %v", err)
+	}
+	d.Quota = q
+	return nil
+}
+
+const (
+	// dqblkSize is the size of the generic interface's struct if_dqblk.
+	dqblkSize = 72
+	// qifBlockSize is the unit of its block limits.
+	qifBlockSize = 1024
+	// xfsQuotaSize is the size of struct fs_disk_quota.
+	xfsQuotaSize = 112
+	// xfsBasicBlock is the unit of all its block counts.
+	xfsBasicBlock = 512
+)
+
+// parseDqblk decodes the struct if_dqblk of a Q_GETQUOTA call: the block
+// limits, current space in bytes, the inode limits and count, and the
+// block and inode grace expiry times.
+func parseDqblk(b []byte, now time.Time) (*Quota, error) {
+	if len(b) < dqblkSize {
+		return nil, errors.New("short if_dqblk")
+	}
+	le := binary.LittleEndian
+	u := quotaUsage{
+		hard:        le.Uint64(b[0:]) * qifBlockSize,
+		soft:        le.Uint64(b[8:]) * qifBlockSize,
+		used:        le.Uint64(b[16:]),
+		ihard:       le.Uint64(b[24:]),
+		isoft:       le.Uint64(b[32:]),
+		inodes:      le.Uint64(b[40:]),
+		blockExpiry: int64(le.Uint64(b[48:])),
+		fileExpiry:  int64(le.Uint64(b[56:])),
+	}
+	return u.quota("quotactl", now)
+}
+
+// parseXFSQuota decodes the struct fs_disk_quota of a Q_XGETQUOTA call.
+// Block counts are in 512-byte basic blocks, and the timers carry eight
+// more high bits in d_itimer_hi and d_btimer_hi on bigtime filesystems.
+func parseXFSQuota(b []byte, now time.Time) (*Quota, error) {
+	if len(b) < xfsQuotaSize {
+		return nil, errors.New("short fs_disk_quota")
+	}
+	le := binary.LittleEndian
+	timer := func(lo, hi int) int64 {
+		return int64(int8(b[hi]))<<32 | int64(le.Uint32(b[lo:]))
+	}
+	u := quotaUsage{
+		hard:        le.Uint64(b[8:]) * xfsBasicBlock,
+		soft:        le.Uint64(b[16:]) * xfsBasicBlock,
+		ihard:       le.Uint64(b[24:]),
+		isoft:       le.Uint64(b[32:]),
+		used:        le.Uint64(b[40:]) * xfsBasicBlock,
+		inodes:      le.Uint64(b[48:]),
+		fileExpiry:  timer(56, 68),
+		blockExpiry: timer(60, 69),
+	}
+	return u.quota("quotactl", now)
+}
+
+func quotaLine(d FS, humanReadable bool) string {
+	var parts []string
+	if q := d.Quota; q != nil {
+		if q.Limit > 0 {
+			parts = append(parts, fmt.Sprintf("quota: %s of %s (%.1f%%)",
+				fmtBytes(q.Used, humanReadable), fmtBytes(q.Limit, humanReadable), q.usage()))
+		}
+		if q.Soft > 0 && q.Hard > 0 && q.Soft != q.Hard {
+			parts = append(parts, "soft "+fmtBytes(q.Soft, humanReadable))
+		}
+		parts = append(parts, graceNote("", q.GraceSeconds)...)
+		if q.InodeSoft > 0 || q.InodeHard > 0 {
+			parts = append(parts, fmt.Sprintf("files: %d of soft %d, hard %d", q.Inodes, q.InodeSoft, q.InodeHard))
+		}
+		parts = append(parts, graceNote("file ", q.InodeGraceSeconds)...)
+	}
+	if d.UsageBasis == "quota" {
+		parts = append(parts, fmt.Sprintf("device %.1f%% used", d.DeviceUsage))
+	}
+	if d.ExportReadOnly {
+		parts = append(parts, "export is read-only")
+	}
+	return "    " + strings.Join(parts, ", ")
+}
+
+func graceNote(kind string, grace *int64) []string {
+	switch {
+	case grace == nil:
+		return nil
+	case *grace == 0:
+		return []string{"over " + kind + "soft limit, grace expired"}
+	default:
+		return []string{fmt.Sprintf("over %ssoft limit with %s grace", kind, fmtDuration(time.Duration(*grace)*time.Second))}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func dqblk(fields ...uint64) []byte {
+	b := make([]byte, dqblkSize)
+	for i, v := range fields {
+		binary.LittleEndian.PutUint64(b[8*i:], v)
+	}
+	return b
+}
+
+func TestParseDqblk(t *testing.T) {
+	now := time.Unix(1_800_000_000, 0)
+	// 1 GiB hard, 512 MiB soft in KiB; 600 MiB used in bytes; 1000 hard,
+	// 800 soft inodes, 10 used; block grace ending in two days.
+	q, err := parseDqblk(dqblk(1<<20, 1<<19, 600<<20, 1000, 800, 10, uint64(now.Unix()+2*86400), 0), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Hard != 1<<30 || q.Soft != 1<<29 || q.Used != 600<<20 || q.Limit != 1<<30 || q.Source != "quotactl" {
+		t.Errorf("blocks: %+v", q)
+	}
+	if q.InodeHard != 1000 || q.InodeSoft != 800 || q.Inodes != 10 || q.InodeGraceSeconds != nil {
+		t.Errorf("inodes: %+v", q)
+	}
+	if q.GraceSeconds == nil || *q.GraceSeconds != 2*86400 {
+		t.Errorf("grace: %v", q.GraceSeconds)
+	}
+
+	// Only a soft limit: it is the limit.
+	q, _ = parseDqblk(dqblk(0, 100, 50<<10), now)
+	if q.Limit != 100<<10 || q.GraceSeconds != nil {
+		t.Errorf("soft only: %+v", q)
+	}
+
+	if _, err := parseDqblk(dqblk(0, 0, 1<<30, 0, 0, 5000), now); !errors.Is(err, errNoQuota) {
+		t.Errorf("no limits: %v", err)
+	}
+	if _, err := parseDqblk(make([]byte, dqblkSize-1), now); err == nil {
+		t.Error("short buffer accepted")
+	}
+}
+
+// fsDiskQuota builds a struct fs_disk_quota.
+func fsDiskQuota(bhard, bsoft, ihard, isoft, bcount, icount uint64, itimer, btimer int64) []byte {
+	le := binary.LittleEndian
+	b := make([]byte, xfsQuotaSize)
+	b[0] = 1 // FS_DQUOT_VERSION
+	le.PutUint64(b[8:], bhard)
+	le.PutUint64(b[16:], bsoft)
+	le.PutUint64(b[24:], ihard)
+	le.PutUint64(b[32:], isoft)
+	le.PutUint64(b[40:], bcount)
+	le.PutUint64(b[48:], icount)
+	le.PutUint32(b[56:], uint32(itimer))
+	le.PutUint32(b[60:], uint32(btimer))
+	b[68] = byte(itimer >> 32)
+	b[69] = byte(btimer >> 32)
+	return b
+}
+
+func TestParseXFSQuota(t *testing.T) {
+	now := time.Unix(1_800_000_000, 0)
+	q, err := parseXFSQuota(fsDiskQuota(2048, 1024, 0, 50, 1500, 60, now.Unix()+3600, now.Unix()+60), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Hard != 1<<20 || q.Soft != 1<<19 || q.Used != 1500*512 || q.Limit != 1<<20 {
+		t.Errorf("blocks: %+v", q)
+	}
+	if q.InodeSoft != 50 || q.Inodes != 60 || q.InodeGraceSeconds == nil || *q.InodeGraceSeconds != 3600 {
+		t.Errorf("inodes: %+v", q)
+	}
+	if q.GraceSeconds == nil || *q.GraceSeconds != 60 {
+		t.Errorf("grace: %v", q.GraceSeconds)
+	}
+
+	// Bigtime timers past 2106 carry high bits.
+	late := time.Unix(1<<32+1000, 0)
+	q, err = parseXFSQuota(fsDiskQuota(0, 1024, 0, 0, 2048, 0, 0, late.Unix()+500), late)
+	if err != nil || q.GraceSeconds == nil || *q.GraceSeconds != 500 {
+		t.Errorf("bigtime: %+v, %v", q, err)
+	}
+
+	// An expired timer leaves no grace.
+	q, _ = parseXFSQuota(fsDiskQuota(0, 1024, 0, 0, 2048, 0, 0, now.Unix()-1), now)
+	if q.GraceSeconds == nil || *q.GraceSeconds != 0 || q.status() != StatusCritical {
+		t.Errorf("expired: %+v", q)
+	}
+
+	if _, err := parseXFSQuota(fsDiskQuota(0, 0, 0, 0, 2048, 10, 0, 0), now); !errors.Is(err, errNoQuota) {
+		t.Errorf("no limits: %v", err)
+	}
+	if _, err := parseXFSQuota(make([]byte, 64), now); err == nil {
+		t.Error("short buffer accepted")
+	}
+}
+
+func TestQuotaStatus(t *testing.T) {
+	grace := func(s int64) *int64 { return &s }
+	tests := []struct {
+		name string
+		q    *Quota
+		want Status
+		as   string
+	}{
+		{"none", nil, StatusOK, "ok"},
+		{"under soft", &Quota{Used: 50, Soft: 80, Hard: 100, Limit: 100}, StatusOK, "ok"},
+		{"in grace", &Quota{Used: 90, Soft: 80, Hard: 100, Limit: 100, GraceSeconds: grace(3600)}, StatusWarning, "grace"},
+		{"grace over", &Quota{Used: 90, Soft: 80, Hard: 100, Limit: 100, GraceSeconds: grace(0)}, StatusCritical, "critical"},
+		{"at hard", &Quota{Used: 100, Soft: 80, Hard: 100, Limit: 100, GraceSeconds: grace(3600)}, StatusCritical, "critical"},
+		// A server may not say how long the grace lasts.
+		{"over soft, no timer", &Quota{Used: 90, Soft: 80, Limit: 80}, StatusWarning, "warning"},
+		{"inodes in grace", &Quota{Used: 1, Hard: 100, Limit: 100, Inodes: 60, InodeSoft: 50, InodeGraceSeconds: grace(60)}, StatusWarning, "grace"},
+		{"inodes at hard", &Quota{Used: 1, Hard: 100, Limit: 100, Inodes: 70, InodeHard: 70}, StatusCritical, "critical"},
+	}
+	for _, tt := range tests {
+		if got := tt.q.status(); got != tt.want {
+			t.Errorf("%s: status %v, want %v", tt.name, got, tt.want)
+		}
+		if tt.q == nil {
+			continue
+		}
+		if got := tt.q.statusName(); got != tt.as {
+			t.Errorf("%s: status name %q, want %q", tt.name, got, tt.as)
+		}
+		d := FS{Mount: "/home", Usage: 10, Quota: tt.q}
+		config := Config{WarnThreshold: 80, CritThreshold: 90, InodeWarn: 100, InodeCrit: 100}
+		if color := Colors.ForFS(d, config); (color == Colors.Grace) != (tt.as == "grace") {
+			t.Errorf("%s: color %q", tt.name, color)
+		}
+	}
+}
+
+func TestQuotaLine(t *testing.T) {
+	grace := int64(2 * 86400)
+	d := FS{Quota: &Quota{Used: 900 << 20, Limit: 1 << 30, Soft: 800 << 20, Hard: 1 << 30, GraceSeconds: &grace,
+		Inodes: 10, InodeSoft: 800, InodeHard: 1000}}
+	line := quotaLine(d, true)
+	for _, want := range []string{"quota: ", "soft ", "over soft limit with 2d", "files: 10 of soft 800, hard 1000"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("quota line %q lacks %q", line, want)
+		}
+	}
+}
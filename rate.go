@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rateMinSamples is how many samples the -rate-window must hold before
+// its rate is judged: windowRate leaves the largest step out, and at
+// least one interval must remain.
+const rateMinSamples = 3
+
+// rateMaxSamples bounds the window kept per filesystem in the state file.
+const rateMaxSamples = 256
+
+// UsageSample is one used-bytes reading of the sliding rate window.
+type UsageSample struct {
+	Time time.Time `json:"time"`
+	Used uint64    `json:"used"`
+}
+
+// rateLimit is a -rate-warn or -rate-crit level: a growth in bytes, or in
+// percent of the size, per second. Zero is unset.
+type rateLimit struct {
+	Bytes   float64
+	Percent float64
+}
+
+func (l rateLimit) set() bool { return l.Bytes > 0 || l.Percent > 0 }
+
+// exceeded reports whether rate, in bytes per second on a filesystem of
+// total bytes, is at or over l.
+func (l rateLimit) exceeded(rate float64, total uint64) bool {
+	if l.Bytes > 0 && rate >= l.Bytes {
+		return true
+	}
+	return l.Percent > 0 && total > 0 && rate/float64(total)*100 >= l.Percent
+}
+
+var rateUnits = map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour, "d": 24 * time.Hour}
+
+// parseRate parses a growth rate such as "50G/h" or "5%/h".
+func parseRate(s string) (rateLimit, error) {
+	var l rateLimit
+	amount, unit, ok := strings.Cut(strings.TrimSpace(s), "/")
+	per, known := rateUnits[unit]
+	if !ok || !known {
+		return l, fmt.Errorf("invalid rate %q: want an amount per s, m, h or d, e.g. 50G/h or 5%%/h", s)
+	}
+	if pct, isPct := strings.CutSuffix(amount, "%"); isPct {
+		v, err := strconv.ParseFloat(pct, 64)
+		if err != nil || v <= 0 {
+			return l, fmt.Errorf("invalid rate %q", s)
+		}
+		l.Percent = v / per.Seconds()
+		return l, nil
+	}
+	b, err := parseSize(amount)
+	if err != nil || b == 0 {
+		return l, fmt.Errorf("invalid rate %q", s)
+	}
+	l.Bytes = float64(b) / per.Seconds()
+	return l, nil
+}
+
+// slideWindow adds the sample (now, used) to window and drops the samples
+// older than span. A drop in usage, such as a cleanup, starts the window
+// over, so the growth it measured stops counting at once.
+func slideWindow(window []UsageSample, used uint64, now time.Time, span time.Duration) []UsageSample {
+	if n := len(window); n > 0 && used < window[n-1].Used {
+		window = nil
+	}
+	var out []UsageSample
+	for _, s := range window {
+		if now.Sub(s.Time) <= span && now.After(s.Time) {
+			out = append(out, s)
+		}
+	}
+	out = append(out, UsageSample{Time: now, Used: used})
+	if len(out) > rateMaxSamples {
+		out = out[len(out)-rateMaxSamples:]
+	}
+	return out
+}
+
+// windowRate is the growth across window in bytes per second, and false
+// while the window holds too few samples to judge. The largest step
+// between two samples is left out, with its interval, so one jump between
+// two collections, such as a remount showing different numbers, is not
+// taken for growth; steady growth keeps its rate.
+func windowRate(window []UsageSample) (float64, bool) {
+	if len(window) < rateMinSamples {
+		return 0, false
+	}
+	var growth, elapsed, jump, jumpSecs float64
+	for i := 1; i < len(window); i++ {
+		step := float64(window[i].Used) - float64(window[i-1].Used)
+		secs := window[i].Time.Sub(window[i-1].Time).Seconds()
+		growth += step
+		elapsed += secs
+		if i == 1 || step > jump {
+			jump, jumpSecs = step, secs
+		}
+	}
+	if elapsed -= jumpSecs; elapsed <= 0 {
+		return 0, false
+	}
+	return (growth - jump) / elapsed, true
+}
+
+// rateStatus evaluates d's growth over the -rate-window against -rate-warn
+// and -rate-crit.
+func rateStatus(d FS, config Config) Status {
+	if d.Growth == nil || d.Growth.WindowSeconds == 0 {
+		return StatusOK
+	}
+	switch rate := d.Growth.WindowRate; {
+	case config.RateCrit.exceeded(rate, d.Total):
+		return StatusCritical
+	case config.RateWarn.exceeded(rate, d.Total):
+		return StatusWarning
+	}
+	return StatusOK
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in             string
+		bytes, percent float64
+	}{
+		{"50G/h", 50 * (1 << 30) / 3600.0, 0},
+		{"1M/s", 1 << 20, 0},
+		{"5%/h", 0, 5 / 3600.0},
+		{"0.5%/m", 0, 0.5 / 60},
+		{" 10G/d ", 10 * (1 << 30) / 86400.0, 0},
+	}
+	for _, tt := range tests {
+		l, err := parseRate(tt.in)
+		if err != nil || math.Abs(l.Bytes-tt.bytes) > 1e-9 || math.Abs(l.Percent-tt.percent) > 1e-12 {
+			t.Errorf("parseRate(%q) = %+v, %v", tt.in, l, err)
+		}
+	}
+	for _, in := range []string{"", "50G", "50G/w", "/h", "0G/h", "-5%/h", "0%/h", "x%/h", "lots/h"} {
+		if _, err := parseRate(in); err == nil {
+			t.Errorf("parseRate(%q) accepted", in)
+		}
+	}
+}
+
+func TestSlideWindow(t *testing.T) {
+	t0 := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	var w []UsageSample
+	for i := 0; i < 5; i++ {
+		w = slideWindow(w, uint64(100+i), t0.Add(time.Duration(i)*time.Minute), 3*time.Minute)
+	}
+	// Samples older than the span are dropped.
+	if len(w) != 4 || w[0].Used != 101 {
+		t.Errorf("window %+v, want the samples of the last 3m", w)
+	}
+	// A drop starts the window over.
+	if w = slideWindow(w, 50, t0.Add(5*time.Minute), 3*time.Minute); len(w) != 1 || w[0].Used != 50 {
+		t.Errorf("after a drop: %+v", w)
+	}
+	// A clock going backwards drops the samples from the future.
+	if w = slideWindow(w, 60, t0.Add(4*time.Minute), 3*time.Minute); len(w) != 1 {
+		t.Errorf("after a clock step: %+v", w)
+	}
+
+	w = nil
+	for i := 0; i < rateMaxSamples+10; i++ {
+		w = slideWindow(w, uint64(i), t0.Add(time.Duration(i)*time.Second), time.Hour)
+	}
+	if len(w) != rateMaxSamples || w[len(w)-1].Used != rateMaxSamples+9 {
+		t.Errorf("window of %d samples ending %d", len(w), w[len(w)-1].Used)
+	}
+}
+
+func TestWindowRate(t *testing.T) {
+	t0 := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	window := func(used ...uint64) []UsageSample {
+		var w []UsageSample
+		for i, u := range used {
+			w = append(w, UsageSample{Time: t0.Add(time.Duration(i) * time.Minute), Used: u})
+		}
+		return w
+	}
+	tests := []struct {
+		name   string
+		window []UsageSample
+		rate   float64
+		ok     bool
+	}{
+		{"too few", window(0, 6000), 0, false},
+		{"steady", window(0, 600, 1200, 1800, 2400), 10, true},
+		{"flat", window(500, 500, 500), 0, true},
+		// One jump between two collections is left out.
+		{"jump", window(1000, 1000, 91000, 91000, 91000), 0, true},
+		{"jump at the end", window(1000, 1000, 91000), 0, true},
+		{"jump in steady growth", window(0, 600, 60600, 61200), 10, true},
+		// Two are growth.
+		{"two jumps", window(0, 60000, 60000, 120000), 500, true},
+	}
+	for _, tt := range tests {
+		rate, ok := windowRate(tt.window)
+		if ok != tt.ok || math.Abs(rate-tt.rate) > 1e-9 {
+			t.Errorf("%s: rate %v, %v; want %v, %v", tt.name, rate, ok, tt.rate, tt.ok)
+		}
+	}
+}
+
+// TestRateStatus feeds applyState collections a minute apart and checks
+// what -rate-warn of 1%/m makes of them.
+func TestRateStatus(t *testing.T) {
+	const total = 100 << 30
+	config := Config{RateWarn: rateLimit{Percent: 1.0 / 60}, RateCrit: rateLimit{Percent: 10.0 / 60}}
+	run := func(samples []struct{ total, used uint64 }) []Status {
+		path := filepath.Join(t.TempDir(), "state.json")
+		now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+		var out []Status
+		for _, s := range samples {
+			list := []FS{{Mount: "/data", Total: s.total, Used: s.used, Free: s.total - s.used}}
+			if err := applyState(list, path, 0.3, 1, 15*time.Minute, now); err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, rateStatus(list[0], config))
+			now = now.Add(time.Minute)
+		}
+		return out
+	}
+	pct := func(p float64) uint64 { return uint64(p / 100 * total) }
+	type sample = struct{ total, used uint64 }
+	tests := []struct {
+		name    string
+		samples []sample
+		want    []Status
+	}{
+		{"steady 2%/m", []sample{{total, pct(40)}, {total, pct(42)}, {total, pct(44)}, {total, pct(46)}},
+			[]Status{StatusOK, StatusOK, StatusWarning, StatusWarning}},
+		{"fast 20%/m", []sample{{total, pct(10)}, {total, pct(30)}, {total, pct(50)}},
+			[]Status{StatusOK, StatusOK, StatusCritical}},
+		// A remount shows other numbers once.
+		{"remount", []sample{{total, pct(40)}, {total, pct(40)}, {total, pct(60)}, {total, pct(60)}, {total, pct(60)}},
+			[]Status{StatusOK, StatusOK, StatusOK, StatusOK, StatusOK}},
+		// Growing the filesystem starts the window over.
+		{"resize", []sample{{total, pct(40)}, {total, pct(40.1)}, {2 * total, pct(40.2)}, {2 * total, pct(40.3)}},
+			[]Status{StatusOK, StatusOK, StatusOK, StatusOK}},
+		// A cleanup clears the condition at once.
+		{"cleanup", []sample{{total, pct(40)}, {total, pct(42)}, {total, pct(44)}, {total, pct(30)}, {total, pct(30.5)}},
+			[]Status{StatusOK, StatusOK, StatusWarning, StatusOK, StatusOK}},
+	}
+	for _, tt := range tests {
+		got := run(tt.samples)
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: statuses %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
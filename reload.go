@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// configPollInterval is how often -watch-config looks at the config file.
+const configPollInterval = 2 * time.Second
+
+// configChanges signals when the config file should be read again: on
+// SIGHUP, and with -watch-config whenever a candidate config file changes.
+func configChanges(ctx context.Context, config Config) <-chan struct{} {
+	out := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case out <- struct{}{}:
+		default:
+		}
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				notify()
+			}
+		}
+	}()
+
+	if config.WatchConfig {
+		paths := []string{config.ConfigPath}
+		if config.ConfigPath == "" {
+			paths = defaultConfigPaths()
+		}
+		if config.ConfigDir != "" {
+			// The directory's mtime changes when files are added or
+			// removed, the files' own when they are rewritten.
+			files, _ := configDirFiles(config.ConfigDir)
+			paths = append(append(paths, config.ConfigDir), files...)
+		}
+		go pollFiles(ctx, paths, notify)
+	}
+	return out
+}
+
+// pollFiles calls changed whenever one of paths is created, removed or
+// rewritten, judged by its inode, size and mtime.
+func pollFiles(ctx context.Context, paths []string, changed func()) {
+	type version struct {
+		ino   uint64
+		size  int64
+		mtime time.Time
+	}
+	stat := func() []version {
+		v := make([]version, len(paths))
+		for i, p := range paths {
+			if fi, err := os.Stat(p); err == nil {
+				v[i] = version{size: fi.Size(), mtime: fi.ModTime()}
+				if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+					v[i].ino = uint64(st.Ino)
+				}
+			}
+		}
+		return v
+	}
+
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+	last := stat()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		cur := stat()
+		for i := range cur {
+			if cur[i] != last[i] {
+				changed()
+				break
+			}
+		}
+		last = cur
+	}
+}
+
+// reloadConfig reads the config file again and validates it in full. On
+// failure the error is logged and fc is returned unchanged.
+func reloadConfig(fc FileConfig, config Config, logger *log.Logger) (FileConfig, error) {
+	sdNotify("RELOADING=1")
+	defer sdNotify("READY=1")
+	next, err := loadFileConfig(config.ConfigPath, config)
+	if err != nil {
+		logger.Printf("Warning: keeping previous config: %v", err)
+		return fc, err
+	}
+	logger.Printf("Reloaded config")
+	return next, nil
+}
+
+// reevaluate applies the rules of fileConfig to an earlier collection
+// again, so that states changed only by a new config show at once rather
+// than at the next collection.
+func reevaluate(r Report, fileConfig FileConfig, config Config, now time.Time) Report {
+	list := clearDerived(r.Filesystems)
+	applyRules(list, fileConfig, config, now)
+	r.Filesystems = list
+	r.Groups = buildGroups(list, fileConfig.Groups, config)
+	return r
+}
+
+// clearDerived returns a copy of list without what the config rules set,
+// for applying them again. Resizes are cleared too, as they were reported
+// with the collection that found them.
+func clearDerived(list []FS) []FS {
+	out := make([]FS, len(list))
+	for i, d := range list {
+		d.Ignored, d.Thresholds, d.Jobs, d.Resized, d.Annotations = false, nil, nil, nil, nil
+		out[i] = deviceBasis(d)
+	}
+	return out
+}
+
+// reloadWatch reloads the config in -watch mode and evaluates the last
+// collection again under the new one, logging the transitions it causes
+// and updating the status file. Alerts, exec actions and the report wait
+// for the next collection. A config that does not validate is logged as
+// an event and not applied.
+func reloadWatch(fileConfig *FileConfig, last *Report, events *eventLog, config Config, statusFile *os.File, logger *log.Logger) {
+	fc, err := reloadConfig(*fileConfig, config, logger)
+	if err != nil {
+		if events != nil {
+			if err := events.configError(time.Now(), err); err != nil {
+				logger.Printf("Warning: cannot write events: %v", err)
+			}
+		}
+		return
+	}
+	*fileConfig = fc
+	if last == nil {
+		return
+	}
+	*last = reevaluate(*last, fc, config, time.Now())
+	if events != nil {
+		if err := events.observe(last.Filesystems, config, time.Now(), "config-change"); err != nil {
+			logger.Printf("Warning: cannot write events: %v", err)
+		}
+	}
+	if statusFile != nil {
+		if err := writeStatus(statusFile, *last, config); err != nil {
+			logger.Printf("Warning: cannot write status: %v", err)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestReloadWatch checks a reload only re-evaluates the last collection:
+// with unchanged thresholds it writes no events, and with changed ones a
+// config-change transition, but neither sends an alert.
+func TestReloadWatch(t *testing.T) {
+	var sent atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { sent.Add(1) }))
+	defer srv.Close()
+	dir := t.TempDir()
+	cfg := filepath.Join(dir, "dfmon.json")
+	writeConfig := func(crit float64) {
+		writeFile(t, cfg, fmt.Sprintf(`{"thresholds": [{"name": "data", "match": "/data", "warn": 50, "crit": %g}],
+			"alerts": {"default": "ops", "sinks": {"ops": {"webhook": %q}}}}`, crit, srv.URL))
+	}
+	writeConfig(90)
+	config := Config{ConfigPath: cfg, Alert: true, WarnThreshold: 70, CritThreshold: 90, InodeWarn: 100, InodeCrit: 100, Location: time.UTC}
+	logger := log.New(io.Discard, "", 0)
+	fileConfig, err := loadFileConfig(cfg, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eventsPath := filepath.Join(dir, "events.ndjson")
+	events, err := openEventLog(eventsPath, historyLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := Report{Filesystems: []FS{{Mount: "/data", Device: "/dev/sdb1", Type: "ext4", Total: 100, Used: 95, Free: 5, Usage: 95}}}
+	last = reevaluate(last, fileConfig, config, time.Now())
+	if err := events.observe(last.Filesystems, config, time.Now(), ""); err != nil {
+		t.Fatal(err)
+	}
+	statusPath := filepath.Join(dir, "status")
+	status, err := os.Create(statusPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer status.Close()
+	read := func() string {
+		b, err := os.ReadFile(eventsPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	before := read()
+
+	reloadWatch(&fileConfig, &last, events, config, status, logger)
+	if got := read(); got != before {
+		t.Errorf("unchanged config wrote events:\n%s", strings.TrimPrefix(got, before))
+	}
+	if n := sent.Load(); n != 0 {
+		t.Errorf("unchanged config sent %d alerts", n)
+	}
+
+	writeConfig(99)
+	reloadWatch(&fileConfig, &last, events, config, status, logger)
+	added := strings.TrimPrefix(read(), before)
+	if !strings.Contains(added, `"cause":"config-change"`) || !strings.Contains(added, `"new":"warning"`) {
+		t.Errorf("changed config wrote:\n%s", added)
+	}
+	if n := sent.Load(); n != 0 {
+		t.Errorf("changed config sent %d alerts", n)
+	}
+	if b, _ := os.ReadFile(statusPath); !bytes.Contains(b, []byte("warning")) {
+		t.Errorf("status not updated: %q", b)
+	}
+}
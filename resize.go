@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Resize records a change in a filesystem's Total since the previous
+// collection, as after growing a volume and its filesystem online.
+type Resize struct {
+	Old uint64 `json:"old"`
+	New uint64 `json:"new"`
+}
+
+// resized reports the change in d's size from a previous total of prev,
+// which is 0 if unknown. Denied entries carry no sizes to compare.
+func resized(d FS, prev uint64) *Resize {
+	if d.Denied || prev == 0 || d.Total == 0 || d.Total == prev {
+		return nil
+	}
+	return &Resize{Old: prev, New: d.Total}
+}
+
+// markResized flags the entries whose Total differs from the previous
+// watch collection's, and returns this collection's totals for the next.
+func markResized(list []FS, prev map[string]uint64) map[string]uint64 {
+	next := make(map[string]uint64, len(list))
+	for i := range list {
+		d := &list[i]
+		if d.Resized == nil {
+			d.Resized = resized(*d, prev[d.Mount])
+		}
+		next[d.Mount] = d.Total
+	}
+	return next
+}
+
+func resizeLine(r *Resize, humanReadable bool) string {
+	return fmt.Sprintf("    resized: %s -> %s", fmtBytes(r.Old, humanReadable), fmtBytes(r.New, humanReadable))
+}
+
+// sizeString formats a byte count kept as text in an Event.
+func sizeString(s string) string {
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return s
+	}
+	return fmtBytes(n, true)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/AScotM/filesystem_cap/fscap/mountinfo"
+)
+
+// mountRoot is the -root prefix. Mounts at or below it are listed relative
+// to it, as the system mounted there sees them.
+var mountRoot string
+
+// foreignTable is set by readMounts when the mount table describes another
+// root than ours, as in a chroot that bind-mounts the host's /proc. Its
+// paths are then only reachable with -root stripped off; otherwise they
+// are reached under -root. Enrichers that outlive their timeout may still
+// read it while the next collection sets it.
+var foreignTable atomic.Bool
+
+// foreignMountTable reports whether the "/" entry of mounts is missing or
+// names a different device than stat("/"). Btrfs reports a per-subvolume
+// device from stat, so a mismatch there proves nothing.
+func foreignMountTable(mounts []mountinfo.Mount) bool {
+	var st syscall.Stat_t
+	if syscall.Stat("/", &st) != nil {
+		return false
+	}
+	var root *mountinfo.Mount
+	for i := range mounts {
+		if mounts[i].MountPoint == "/" {
+			root = &mounts[i]
+		}
+	}
+	if root == nil {
+		return true
+	}
+	// The /proc/mounts fallback has no device numbers to compare.
+	if root.MajorMinor == "" {
+		return false
+	}
+	return root.FSType != "btrfs" && root.MajorMinor != devString(uint64(st.Dev))
+}
+
+// anchorMounts applies -root to mounts. Entries of a foreign table that
+// are not below it are kept only if their mount point exists here, instead
+// of warning about every one of them; the kernel already lists a chroot's
+// own mounts relative to it.
+func anchorMounts(mounts []mountinfo.Mount, root string, foreign bool) []mountinfo.Mount {
+	var out []mountinfo.Mount
+	for _, m := range mounts {
+		if rel, ok := underRoot(m.MountPoint, root); root != "" && ok {
+			m.MountPoint = rel
+			out = append(out, m)
+			continue
+		}
+		if root != "" && !foreign {
+			continue
+		}
+		if _, err := os.Lstat(m.MountPoint); foreign && os.IsNotExist(err) {
+			continue
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+func underRoot(path, root string) (string, bool) {
+	if root == "/" {
+		return path, true
+	}
+	if path == root {
+		return "/", true
+	}
+	if rest, ok := strings.CutPrefix(path, root+"/"); ok {
+		return "/" + rest, true
+	}
+	return "", false
+}
+
+// hostPath is where a listed mount path is reached from this process.
+func hostPath(mount string) string {
+	if mountRoot == "" || foreignTable.Load() {
+		return mount
+	}
+	return filepath.Join(mountRoot, mount)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// Landlock syscall numbers are the same on every Linux architecture.
+const (
+	sysLandlockCreateRuleset = 444
+	sysLandlockAddRule       = 445
+	sysLandlockRestrictSelf  = 446
+
+	landlockCreateRulesetVersion = 1
+	landlockRulePathBeneath      = 1
+
+	landlockAccessExecute  = 1 << 0
+	landlockAccessReadFile = 1 << 2
+	landlockAccessReadDir  = 1 << 3
+
+	prSetNoNewPrivs = 38
+	oPath           = 0x200000
+)
+
+type landlockRulesetAttr struct {
+	handledAccessFS uint64
+}
+
+// landlockPathBeneathAttr is packed in the kernel ABI; the kernel reads
+// only the first 12 bytes, which match this layout.
+type landlockPathBeneathAttr struct {
+	allowedAccess uint64
+	parentFd      int32
+}
+
+// SandboxState describes whether the read-only sandbox is in effect.
+type SandboxState struct {
+	Active bool
+	Detail string
+}
+
+// landlockABI probes the Landlock ABI version supported by the kernel, or
+// returns 0 when Landlock is unavailable.
+func landlockABI() int {
+	v, _, errno := syscall.Syscall(sysLandlockCreateRuleset, 0, 0, landlockCreateRulesetVersion)
+	if errno != 0 {
+		return 0
+	}
+	return int(v)
+}
+
+// handledAccess returns every filesystem right known to the given ABI, so
+// anything not explicitly allowed is denied.
+func handledAccess(abi int) uint64 {
+	switch {
+	case abi >= 3:
+		return 1<<15 - 1
+	case abi == 2:
+		return 1<<14 - 1
+	default:
+		return 1<<13 - 1
+	}
+}
+
+// writesDenied probes whether the process runs under a restriction that
+// denies writes, by opening /dev/null for writing, which is otherwise
+// open to everyone.
+func writesDenied() bool {
+	fd, err := syscall.Open("/dev/null", syscall.O_WRONLY|syscall.O_CLOEXEC, 0)
+	if err == nil {
+		syscall.Close(fd)
+		return false
+	}
+	return err == syscall.EACCES || err == syscall.EPERM
+}
+
+// sandboxEnv marks a process that was re-executed inside the sandbox. It
+// is kept out of the DFMON_ namespace, where it would be read as the
+// value of -sandbox.
+const sandboxEnv = "_DFMON_SANDBOXED"
+
+// enterSandbox restricts the process to read-only access to the
+// filesystem. Landlock domains apply per thread, so the restriction is
+// placed on a locked thread which then re-executes the binary; the new
+// image inherits it for every thread. Already open descriptors such as
+// stdout are not affected. Without Landlock it reports the sandbox as
+// inactive.
+//
+// The marker only says what the parent set up: anyone can set it, so the
+// re-executed process checks that writes are really denied before it
+// reports the sandbox as active.
+func enterSandbox() (SandboxState, error) {
+	if detail, ok := os.LookupEnv(sandboxEnv); ok {
+		os.Unsetenv(sandboxEnv)
+		if !writesDenied() {
+			return SandboxState{}, fmt.Errorf("%s is set, but writes are not denied", sandboxEnv)
+		}
+		return SandboxState{Active: true, Detail: detail}, nil
+	}
+
+	abi := landlockABI()
+	if abi == 0 {
+		return SandboxState{Detail: "landlock unavailable (Linux 5.13+ required)"}, nil
+	}
+
+	attr := landlockRulesetAttr{handledAccessFS: handledAccess(abi)}
+	rs, _, errno := syscall.Syscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return SandboxState{}, fmt.Errorf("landlock_create_ruleset: %v", errno)
+	}
+	defer syscall.Close(int(rs))
+
+	root, err := syscall.Open("/", oPath|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return SandboxState{}, err
+	}
+	defer syscall.Close(root)
+
+	rule := landlockPathBeneathAttr{
+		allowedAccess: landlockAccessExecute | landlockAccessReadFile | landlockAccessReadDir,
+		parentFd:      int32(root),
+	}
+	if _, _, errno := syscall.Syscall6(sysLandlockAddRule, rs, landlockRulePathBeneath, uintptr(unsafe.Pointer(&rule)), 0, 0, 0); errno != 0 {
+		return SandboxState{}, fmt.Errorf("landlock_add_rule: %v", errno)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return SandboxState{}, err
+	}
+	env := append(os.Environ(), fmt.Sprintf("%s=landlock ABI %d, read-only", sandboxEnv, abi))
+
+	// This thread is never returned to the scheduler once restricted.
+	runtime.LockOSThread()
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return SandboxState{}, fmt.Errorf("prctl(PR_SET_NO_NEW_PRIVS): %v", errno)
+	}
+	if _, _, errno := syscall.RawSyscall(sysLandlockRestrictSelf, rs, 0, 0); errno != 0 {
+		return SandboxState{}, fmt.Errorf("landlock_restrict_self: %v", errno)
+	}
+	return SandboxState{}, fmt.Errorf("re-executing in sandbox: %v", syscall.Exec(exe, os.Args, env))
+}
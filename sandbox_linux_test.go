@@ -0,0 +1,104 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+func TestHandledAccess(t *testing.T) {
+	tests := []struct {
+		abi  int
+		want uint64
+	}{
+		{1, 0x1fff},
+		{2, 0x3fff}, // LANDLOCK_ACCESS_FS_REFER
+		{3, 0x7fff}, // LANDLOCK_ACCESS_FS_TRUNCATE
+		{7, 0x7fff},
+	}
+	for _, tt := range tests {
+		if got := handledAccess(tt.abi); got != tt.want {
+			t.Errorf("handledAccess(%d) = %#x, want %#x", tt.abi, got, tt.want)
+		}
+	}
+}
+
+// TestLandlockABI checks the probe against the kernel: 0 or a version,
+// and consistent with a ruleset actually being created.
+func TestLandlockABI(t *testing.T) {
+	abi := landlockABI()
+	if abi < 0 {
+		t.Fatalf("landlockABI() = %d", abi)
+	}
+	if abi == 0 {
+		t.Skip("landlock unavailable")
+	}
+	attr := landlockRulesetAttr{handledAccessFS: handledAccess(abi)}
+	rs, _, errno := syscall.Syscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		t.Fatalf("ABI %d reported, but landlock_create_ruleset: %v", abi, errno)
+	}
+	syscall.Close(int(rs))
+}
+
+func TestWritesDeniedUnsandboxed(t *testing.T) {
+	if writesDenied() {
+		t.Error("writesDenied() = true outside the sandbox")
+	}
+}
+
+// TestSandboxEndToEnd runs dfmon -sandbox, which re-executes itself under
+// Landlock: the re-executed process must come up sandboxed rather than
+// trip over its own marker.
+func TestSandboxEndToEnd(t *testing.T) {
+	exe := buildDfmon(t)
+	cmd := exec.Command(exe, append(quietThresholds, "-config", emptyConfig(t), "-sandbox", "-verbose", "-o", "oneline")...)
+	cmd.Env = withoutDfmonEnv()
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("dfmon -sandbox: %v\n%s", err, out)
+	}
+	want := "Sandbox active: landlock ABI"
+	if landlockABI() == 0 {
+		want = "Sandbox inactive: landlock unavailable"
+	}
+	if !strings.Contains(string(out), want) {
+		t.Errorf("dfmon -sandbox -verbose output lacks %q:\n%s", want, out)
+	}
+}
+
+// TestSandboxDeniesWrites has the sandboxed dfmon write its report to a
+// file, which must fail.
+func TestSandboxDeniesWrites(t *testing.T) {
+	if landlockABI() == 0 {
+		t.Skip("landlock unavailable")
+	}
+	out := filepath.Join(t.TempDir(), "report.json")
+	cmd := exec.Command(buildDfmon(t), append(quietThresholds, "-config", emptyConfig(t), "-sandbox", "-o", "json", "-output-file", out)...)
+	cmd.Env = withoutDfmonEnv()
+	stderr, err := cmd.CombinedOutput()
+	if exit, ok := err.(*exec.ExitError); !ok || exit.ExitCode() != 3 {
+		t.Fatalf("dfmon -sandbox -output-file: %v, want exit status 3\n%s", err, stderr)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("the sandboxed dfmon wrote %s", out)
+	}
+}
+
+// TestSandboxMarkerNotTrusted sets the re-exec marker from outside: dfmon
+// must not take it as proof of a sandbox that was never set up.
+func TestSandboxMarkerNotTrusted(t *testing.T) {
+	cmd := exec.Command(buildDfmon(t), "-config", emptyConfig(t), "-sandbox", "-verbose", "-o", "oneline")
+	cmd.Env = append(withoutDfmonEnv(), sandboxEnv+"=1")
+	out, err := cmd.CombinedOutput()
+	if strings.Contains(string(out), "Sandbox active") {
+		t.Fatalf("dfmon trusted %s=1:\n%s", sandboxEnv, out)
+	}
+	if err == nil {
+		t.Errorf("dfmon -sandbox with a forged %s succeeded:\n%s", sandboxEnv, out)
+	}
+}
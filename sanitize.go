@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Sanitization records that -sanitize-fields ran over a report and how
+// many string fields it changed.
+type Sanitization struct {
+	Modified int `json:"modified_fields"`
+}
+
+// strictSanitize is sanitize for output read by programs rather than
+// terminals: besides control characters and invalid UTF-8 it escapes
+// every rune that is not printable, such as bidi overrides and zero-width
+// characters, so the result is plain printable UTF-8.
+func strictSanitize(s string) string {
+	clean := true
+	for _, r := range s {
+		if r == utf8.RuneError || !unicode.IsPrint(r) {
+			clean = false
+			break
+		}
+	}
+	if clean {
+		return s
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && size <= 1:
+			fmt.Fprintf(&sb, `\x%02x`, s[i])
+		case !unicode.IsPrint(r):
+			for _, b := range []byte(s[i : i+size]) {
+				fmt.Fprintf(&sb, `\x%02x`, b)
+			}
+		default:
+			sb.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	return sb.String()
+}
+
+// sanitizeFields applies strictSanitize to every exported string reachable
+// from v, a pointer, including map keys and values, and returns how many
+// it changed. Escapes are printable, so running it twice changes nothing
+// the second time.
+func sanitizeFields(v any) int {
+	return sanitizeValue(reflect.ValueOf(v))
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage(nil))
+
+func sanitizeValue(v reflect.Value) int {
+	n := 0
+	if v.Type() == rawMessageType {
+		if clean, changed := sanitizeJSON(v.Bytes()); changed && v.CanSet() {
+			v.SetBytes(clean)
+			n++
+		}
+		return n
+	}
+	switch v.Kind() {
+	case reflect.String:
+		if s := v.String(); v.CanSet() {
+			if clean := strictSanitize(s); clean != s {
+				v.SetString(clean)
+				n++
+			}
+		}
+	case reflect.Pointer, reflect.Interface:
+		if !v.IsNil() {
+			n += sanitizeValue(v.Elem())
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				n += sanitizeValue(v.Field(i))
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			n += sanitizeValue(v.Index(i))
+		}
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			key := reflect.New(k.Type()).Elem()
+			key.Set(k)
+			val := reflect.New(v.Type().Elem()).Elem()
+			val.Set(v.MapIndex(k))
+			if m := sanitizeValue(key) + sanitizeValue(val); m > 0 {
+				v.SetMapIndex(k, reflect.Value{})
+				v.SetMapIndex(key, val)
+				n += m
+			}
+		}
+	}
+	return n
+}
+
+// sanitizeJSON applies strictSanitize to every string in the JSON value
+// raw, object keys included, such as the Details a plugin reported. It
+// returns raw itself when no string needed escaping, and null for a value
+// that is not JSON.
+func sanitizeJSON(raw json.RawMessage) (json.RawMessage, bool) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return json.RawMessage("null"), true
+	}
+	v, n := sanitizeAny(v)
+	if n == 0 {
+		return raw, false
+	}
+	clean, err := json.Marshal(v)
+	if err != nil {
+		return json.RawMessage("null"), true
+	}
+	return clean, true
+}
+
+func sanitizeAny(v any) (any, int) {
+	n := 0
+	switch v := v.(type) {
+	case string:
+		if clean := strictSanitize(v); clean != v {
+			return clean, 1
+		}
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, e := range v {
+			clean, m := sanitizeAny(e)
+			if ck := strictSanitize(k); ck != k {
+				k, m = ck, m+1
+			}
+			out[k] = clean
+			n += m
+		}
+		return out, n
+	case []any:
+		for i, e := range v {
+			var m int
+			v[i], m = sanitizeAny(e)
+			n += m
+		}
+	}
+	return v, n
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AScotM/filesystem_cap/fscap/mountinfo"
+)
+
+// TestSanitizeFieldsEnvelope checks that collect records the sanitization
+// in the report: the device, the mount and the identity made of it.
+func TestSanitizeFieldsEnvelope(t *testing.T) {
+	fakeStatfs(t, syntheticStatfs)
+	mounts := syntheticMounts(0)
+	mounts = append(mounts, mountinfo.Mount{ID: 2, ParentID: 1, Root: "/", MountPoint: "/mnt/<b>\x1b[2J", FSType: "fuse.x",
+		Source: "x\xff", Options: "rw", SuperOptions: "rw"})
+	fakeSelfMounts(t, mounts)
+	logger := log.New(io.Discard, "", 0)
+
+	for _, on := range []bool{false, true} {
+		config := Config{Fuse: "all", ShowAll: true, Location: time.UTC, SanitizeFields: on}
+		r, err := collect(context.Background(), config, FileConfig{}, logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := json.Marshal(r)
+		if got := strings.Contains(string(b), `"sanitized":{"modified_fields":3}`); got != on {
+			t.Errorf("sanitize %v: envelope %s", on, b)
+		}
+	}
+}
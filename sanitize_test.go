@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+)
+
+func TestStrictSanitize(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"/mnt/data", "/mnt/data"},
+		{"/mnt/ünïcode", "/mnt/ünïcode"},
+		{"/mnt/\x1b[31mred", `/mnt/\x1b[31mred`},
+		{"line\r\nbreak", `line\x0d\x0abreak`},
+		{"bidi‮override", `bidi\xe2\x80\xaeoverride`},
+		{"bad\xffutf8", `bad\xffutf8`},
+	}
+	for _, tt := range tests {
+		got := strictSanitize(tt.in)
+		if got != tt.want {
+			t.Errorf("strictSanitize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if again := strictSanitize(got); again != got {
+			t.Errorf("strictSanitize is not idempotent on %q: %q", got, again)
+		}
+	}
+}
+
+func TestSanitizeJSON(t *testing.T) {
+	tests := []struct {
+		in, want string
+		changed  bool
+	}{
+		{`"plain"`, `"plain"`, false},
+		{`{"a": [1, 2.50, true, null]}`, `{"a": [1, 2.50, true, null]}`, false},
+		{`"\u001b[2J"`, `"\\x1b[2J"`, true},
+		{`{"k\n": {"v": ["x‮y", 12345678901234567890]}}`, `{"k\\x0a":{"v":["x\\xe2\\x80\\xaey",12345678901234567890]}}`, true},
+		{`not json`, `null`, true},
+		{`1 2`, `null`, true},
+	}
+	for _, tt := range tests {
+		got, changed := sanitizeJSON(json.RawMessage(tt.in))
+		if string(got) != tt.want || changed != tt.changed {
+			t.Errorf("sanitizeJSON(%s) = %s, %v; want %s, %v", tt.in, got, changed, tt.want, tt.changed)
+		}
+	}
+}
+
+func TestSanitizeFieldsDetails(t *testing.T) {
+	r := Report{Filesystems: []FS{{
+		Mount:   "/mnt/\x1b]0;title\x07",
+		Details: map[string]json.RawMessage{"pool": json.RawMessage(`{"state": "ONLINE\u001b[0m"}`), "ok": json.RawMessage(`3`)},
+	}}}
+	if n := sanitizeFields(&r); n != 2 {
+		t.Errorf("sanitizeFields changed %d fields, want 2", n)
+	}
+	d := r.Filesystems[0]
+	if d.Mount != `/mnt/\x1b]0;title\x07` {
+		t.Errorf("mount = %q", d.Mount)
+	}
+	if got := string(d.Details["pool"]); got != `{"state":"ONLINE\\x1b[0m"}` {
+		t.Errorf("details.pool = %s", got)
+	}
+	if got := string(d.Details["ok"]); got != `3` {
+		t.Errorf("details.ok = %s", got)
+	}
+	if n := sanitizeFields(&r); n != 0 {
+		t.Errorf("second pass changed %d fields", n)
+	}
+}
+
+// hostileReport carries strings a crafted fuse mount can put in a report:
+// HTML, ANSI escapes and bytes that are not UTF-8.
+func hostileReport() Report {
+	return Report{
+		Warnings: []string{"fuse: \x1b[2Kcleared"},
+		Filesystems: []FS{{
+			Device:      "evil\xff\xfe",
+			Mount:       "/mnt/<img src=x onerror=alert(1)>\x1b[31m",
+			Type:        "fuse.\x07bell",
+			Options:     "rw,user_id=0\u202e",
+			Errors:      []string{"plugin x: \xc3"},
+			Annotations: map[string]string{"team\x1b": "<b>ops</b>\x00"},
+			Total:       1000, Used: 10, Free: 990, Usage: 1,
+		}},
+	}
+}
+
+// printable reports whether s is valid UTF-8 with no unprintable runes
+// other than line ends.
+func printable(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if r != '\n' && !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSanitizeFieldsFormats(t *testing.T) {
+	r := hostileReport()
+	if n := sanitizeFields(&r); n != 8 {
+		t.Errorf("sanitizeFields changed %d fields, want 8", n)
+	}
+	d := r.Filesystems[0]
+	// HTML is printable and kept; JSON escapes it.
+	if !strings.HasPrefix(d.Mount, "/mnt/<img src=x onerror=alert(1)>") {
+		t.Errorf("mount = %q", d.Mount)
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !utf8.Valid(b) || bytes.Contains(b, []byte("<img")) || bytes.Contains(b, []byte(`\u001b`)) || bytes.Contains(b, []byte(`\u0000`)) || bytes.ContainsRune(b, utf8.RuneError) {
+		t.Errorf("JSON: %s", b)
+	}
+
+	var csv bytes.Buffer
+	printCSVRow(&csv, d, Config{Verbose: true})
+	if !printable(csv.String()) {
+		t.Errorf("CSV: %q", csv.String())
+	}
+
+	table := captureStdout(t, func() { printTableRow(d, d.Device, Config{Verbose: true, NoColor: true}) })
+	if !printable(table) {
+		t.Errorf("table: %q", table)
+	}
+
+	// Without the flag, JSON still encodes the raw strings validly, but
+	// replaces the invalid bytes.
+	b, _ = json.Marshal(hostileReport())
+	if !utf8.Valid(b) || !bytes.ContainsRune(b, utf8.RuneError) {
+		t.Errorf("unsanitized JSON: %s", b)
+	}
+}
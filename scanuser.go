@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// scanWorkerArg is the hidden argument dfmon re-executes itself with to
+// run one walk as the -scan-user user.
+const scanWorkerArg = "__scan-worker"
+
+// scanGrace is how long past its own deadline a worker may take before
+// it is killed.
+const scanGrace = 10 * time.Second
+
+// scanCred is the user -scan-user names, nil to walk in this process.
+// Exec actions and plugins run as this user too.
+var scanCred *syscall.Credential
+
+// scanRequest is one walk of a directory tree: a -deep pass over a mount
+// ("deep"), a -sparse-check pass ("sparse") or a tmp-usage root ("tmp").
+// UID and GID are what a worker checks it runs as before walking.
+type scanRequest struct {
+	Kind     string        `json:"kind"`
+	Path     string        `json:"path"`
+	Deep     DeepState     `json:"deep"`
+	Deadline time.Time     `json:"deadline"`
+	Sparse   sparseLimits  `json:"sparse"`
+	Now      time.Time     `json:"now"`
+	Depth    int           `json:"depth"`
+	Timeout  time.Duration `json:"timeout"`
+	UID      uint32        `json:"uid"`
+	GID      uint32        `json:"gid"`
+}
+
+// scanResult is what a walk found; Complete is scanDeep's result.
+type scanResult struct {
+	Deep     DeepState `json:"deep"`
+	Complete bool      `json:"complete"`
+	Sparse   Sparse    `json:"sparse"`
+	Tmp      tmpScan   `json:"tmp"`
+	Error    string    `json:"error,omitempty"`
+}
+
+func (r scanRequest) run(ctx context.Context) (scanResult, error) {
+	var res scanResult
+	var err error
+	switch r.Kind {
+	case "deep":
+		res.Deep = r.Deep
+		res.Complete = scanDeep(r.Path, &res.Deep, r.Deadline)
+	case "sparse":
+		res.Sparse = scanSparse(r.Path, r.Sparse, r.Now)
+	case "tmp":
+		res.Tmp, err = scanTmp(ctx, r.Path, r.Depth, r.Timeout)
+	default:
+		err = fmt.Errorf("unknown scan %q", r.Kind)
+	}
+	return res, err
+}
+
+// deadline is when a worker running r is killed.
+func (r scanRequest) deadline() time.Time {
+	switch r.Kind {
+	case "deep":
+		return r.Deadline.Add(scanGrace)
+	case "sparse":
+		return time.Now().Add(r.Sparse.Budget + scanGrace)
+	}
+	return time.Now().Add(r.Timeout + scanGrace)
+}
+
+// runScan performs req, in this process or, with -scan-user, in a worker
+// running as that user. It is a variable so the walks can be replaced.
+var runScan = func(ctx context.Context, req scanRequest) (scanResult, error) {
+	if scanCred == nil {
+		return req.run(ctx)
+	}
+	return scanAs(ctx, req, scanCred)
+}
+
+// scanAs runs req in a copy of dfmon started as cred. The walk is never
+// retried in this process: if the worker cannot be started as cred or
+// finds itself running as anyone else, the scan fails.
+func scanAs(ctx context.Context, req scanRequest, cred *syscall.Credential) (scanResult, error) {
+	req.UID, req.GID = cred.Uid, cred.Gid
+	in, err := json.Marshal(req)
+	if err != nil {
+		return scanResult{}, err
+	}
+	exe, err := scanExecutable()
+	if err != nil {
+		return scanResult{}, err
+	}
+
+	ctx, cancel := context.WithDeadline(ctx, req.deadline())
+	defer cancel()
+	cmd := exec.CommandContext(ctx, exe, scanWorkerArg)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: cred}
+	cmd.Env = []string{}
+	cmd.Dir = "/"
+	cmd.Stdin = bytes.NewReader(in)
+	var out bytes.Buffer
+	stderr := &cappedBuffer{max: execOutputMax}
+	cmd.Stdout, cmd.Stderr = &out, stderr
+	cmd.WaitDelay = 5 * time.Second
+	if err := cmd.Run(); err != nil {
+		if msg := firstLine(stderr.String()); msg != "" {
+			err = fmt.Errorf("%v: %s", err, msg)
+		}
+		return scanResult{}, fmt.Errorf("scan of %s as uid %d: %v", req.Path, cred.Uid, err)
+	}
+
+	var res scanResult
+	if err := json.Unmarshal(out.Bytes(), &res); err != nil {
+		return scanResult{}, fmt.Errorf("scan of %s as uid %d: %v", req.Path, cred.Uid, err)
+	}
+	if res.Error != "" {
+		return res, fmt.Errorf("scan of %s as uid %d: %s", req.Path, cred.Uid, res.Error)
+	}
+	return res, nil
+}
+
+// scanExecutable is the dfmon binary to start a worker from. On Linux it
+// is /proc/self/exe, which the worker can execute after the change of
+// user even when the directory dfmon was installed in is closed to it.
+func scanExecutable() (string, error) {
+	if _, err := os.Stat("/proc/self/exe"); err == nil {
+		return "/proc/self/exe", nil
+	}
+	return os.Executable()
+}
+
+// runScanWorker is the worker side of scanAs: it reads a scanRequest on
+// stdin and writes its scanResult to stdout, refusing to walk unless the
+// change of user took effect.
+func runScanWorker() {
+	var req scanRequest
+	var res scanResult
+	err := json.NewDecoder(os.Stdin).Decode(&req)
+	if err == nil {
+		err = checkScanUser(req.UID, req.GID)
+	}
+	if err == nil {
+		res, err = req.run(context.Background())
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(res); err != nil {
+		fmt.Fprintf(os.Stderr, "dfmon: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func checkScanUser(uid, gid uint32) error {
+	for _, id := range []int{os.Getuid(), os.Geteuid()} {
+		if id != int(uid) {
+			return fmt.Errorf("running as uid %d, not %d", id, uid)
+		}
+	}
+	for _, id := range []int{os.Getgid(), os.Getegid()} {
+		if id != int(gid) {
+			return fmt.Errorf("running as gid %d, not %d", id, gid)
+		}
+	}
+	return nil
+}
+
+// lookupScanUser resolves the -scan-user name or uid to the user's uid,
+// primary group and supplementary groups. Root is refused, as it would
+// defeat the point.
+func lookupScanUser(name string) (*syscall.Credential, error) {
+	u, err := user.Lookup(name)
+	if _, perr := strconv.ParseUint(name, 10, 32); err != nil && perr == nil {
+		u, err = user.LookupId(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("%s: uid %q is not numeric", name, u.Uid)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("%s: gid %q is not numeric", name, u.Gid)
+	}
+	if uid == 0 {
+		return nil, fmt.Errorf("%s is root", name)
+	}
+	cred := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	ids, _ := u.GroupIds()
+	for _, id := range ids {
+		if g, err := strconv.ParseUint(id, 10, 32); err == nil && g != gid {
+			cred.Groups = append(cred.Groups, uint32(g))
+		}
+	}
+	return cred, nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeScanner replaces runScan with fn for the test, recording the requests.
+func fakeScanner(t *testing.T, fn func(scanRequest) (scanResult, error)) *[]scanRequest {
+	t.Helper()
+	var reqs []scanRequest
+	saved := runScan
+	runScan = func(_ context.Context, req scanRequest) (scanResult, error) {
+		reqs = append(reqs, req)
+		return fn(req)
+	}
+	t.Cleanup(func() { runScan = saved })
+	return &reqs
+}
+
+// TestScanFailureNotRetried checks a scan that fails, as one does when the
+// worker cannot become the -scan-user user, is reported for its mount and
+// not walked again in this process.
+func TestScanFailureNotRetried(t *testing.T) {
+	errDrop := errors.New("fork/exec /proc/self/exe: operation not permitted")
+	reqs := fakeScanner(t, func(scanRequest) (scanResult, error) {
+		return scanResult{Complete: true, Sparse: Sparse{Files: 1}}, errDrop
+	})
+	now := time.Now()
+	list := []FS{{Mount: "/srv"}, {Mount: "/home"}}
+
+	err := applyDeep(context.Background(), list, []string{"/srv"}, "", time.Second, time.Hour, now)
+	if err == nil || !strings.Contains(err.Error(), "/srv: "+errDrop.Error()) {
+		t.Errorf("deep: err = %v", err)
+	}
+	if list[0].Largest != nil {
+		t.Errorf("deep: failed scan left %+v", list[0].Largest)
+	}
+
+	err = applySparse(context.Background(), list, []string{"/*"}, "", sparseLimits{TTL: time.Hour}, now)
+	if err == nil || !strings.Contains(err.Error(), "/home: ") {
+		t.Errorf("sparse: err = %v", err)
+	}
+	for _, d := range list {
+		if d.Sparse != nil {
+			t.Errorf("sparse: failed scan of %s left %+v", d.Mount, d.Sparse)
+		}
+	}
+
+	var got []string
+	for _, r := range *reqs {
+		got = append(got, r.Kind+" "+r.Path)
+	}
+	if want := "deep /srv,sparse /srv,sparse /home"; strings.Join(got, ",") != want {
+		t.Errorf("scans = %q, want %q", got, want)
+	}
+}
+
+func TestCheckScanUser(t *testing.T) {
+	uid, gid := uint32(os.Getuid()), uint32(os.Getgid())
+	if err := checkScanUser(uid, gid); err != nil {
+		t.Errorf("own ids: %v", err)
+	}
+	if err := checkScanUser(uid+1, gid); err == nil {
+		t.Error("other uid accepted")
+	}
+	if err := checkScanUser(uid, gid+1); err == nil {
+		t.Error("other gid accepted")
+	}
+}
+
+func TestLookupScanUser(t *testing.T) {
+	for _, name := range []string{"root", "0"} {
+		if _, err := lookupScanUser(name); err == nil {
+			t.Errorf("lookupScanUser(%q) accepted root", name)
+		}
+	}
+	if _, err := lookupScanUser("dfmon-no-such-user"); err == nil {
+		t.Error("unknown user accepted")
+	}
+	cred, err := lookupScanUser("nobody")
+	if err != nil {
+		t.Skipf("no nobody user: %v", err)
+	}
+	byID, err := lookupScanUser(strconv.FormatUint(uint64(cred.Uid), 10))
+	if err != nil || byID.Uid != cred.Uid || byID.Gid != cred.Gid {
+		t.Errorf("by uid: %+v, %v; by name: %+v", byID, err, cred)
+	}
+}
+
+// TestScanWorkerRefuses checks a worker that is not running as the ids it
+// was sent does not walk.
+func TestScanWorkerRefuses(t *testing.T) {
+	dfmon := buildDfmon(t)
+	root := t.TempDir()
+	writeSized(t, filepath.Join(root, "file"), 4096)
+	req, _ := json.Marshal(scanRequest{Kind: "tmp", Path: root, Depth: 8, Timeout: time.Minute,
+		UID: uint32(os.Getuid()) + 1, GID: uint32(os.Getgid())})
+
+	cmd := exec.Command(dfmon, scanWorkerArg)
+	cmd.Env = []string{}
+	cmd.Stdin = bytes.NewReader(req)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("worker: %v", err)
+	}
+	var res scanResult
+	if err := json.Unmarshal(out, &res); err != nil {
+		t.Fatalf("worker output %q: %v", out, err)
+	}
+	if !strings.Contains(res.Error, "running as uid") {
+		t.Errorf("error = %q", res.Error)
+	}
+	if len(res.Tmp.Users) != 0 {
+		t.Errorf("worker walked anyway: %+v", res.Tmp)
+	}
+}
+
+// privateTree is a directory anyone may list holding one only root may.
+func privateTree(t *testing.T) string {
+	t.Helper()
+	root, err := os.MkdirTemp("", "dfmon-scan-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+	writeSized(t, filepath.Join(root, "public", "file"), 4096)
+	writeSized(t, filepath.Join(root, "private", "file"), 4096)
+	if err := os.Chmod(root, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(root, "private"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+// TestScanUser walks as nobody and checks the walk only saw what nobody
+// may see.
+func TestScanUser(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("needs root to change user")
+	}
+	if _, err := lookupScanUser("nobody"); err != nil {
+		t.Skipf("no nobody user: %v", err)
+	}
+	dfmon := buildDfmon(t)
+	root := privateTree(t)
+
+	run := func(args ...string) string {
+		t.Helper()
+		args = append([]string{"-config", emptyConfig(t), "-container", "no"}, args...)
+		cmd := exec.Command(dfmon, append(args, "tmp-usage", root)...)
+		cmd.Env = withoutDfmonEnv()
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("dfmon %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+		return string(out)
+	}
+	if out := run(); strings.Contains(out, "inaccessible") {
+		t.Errorf("as root:\n%s", out)
+	}
+	if out := run("-scan-user", "nobody"); !strings.Contains(out, "1 directories inaccessible") {
+		t.Errorf("as nobody:\n%s", out)
+	}
+}
+
+// TestScanUserCannotDrop runs dfmon as root of a user namespace where
+// nobody has no uid, so the worker cannot be started as nobody. The walk
+// must fail rather than run with dfmon's own privileges.
+func TestScanUserCannotDrop(t *testing.T) {
+	cred, err := lookupScanUser("nobody")
+	if err != nil {
+		t.Skipf("no nobody user: %v", err)
+	}
+	dfmon := buildDfmon(t)
+	root := privateTree(t)
+
+	cmd := exec.Command(dfmon, "-config", emptyConfig(t), "-container", "no", "-scan-user", "nobody", "tmp-usage", root)
+	cmd.Env = withoutDfmonEnv()
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:                 syscall.CLONE_NEWUSER,
+		UidMappings:                []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+		GidMappings:                []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+		GidMappingsEnableSetgroups: false,
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err = cmd.Run()
+	if _, exited := err.(*exec.ExitError); err != nil && !exited {
+		t.Skipf("no user namespaces: %v", err)
+	}
+	if !strings.Contains(stderr.String(), fmt.Sprintf("as uid %d", cred.Uid)) {
+		t.Errorf("no warning for the failed scan:\nstdout:\n%s\nstderr:\n%s", &stdout, &stderr)
+	}
+	if strings.Contains(stdout.String(), "total") {
+		t.Errorf("scan ran anyway:\n%s", &stdout)
+	}
+}
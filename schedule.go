@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ThresholdRule overrides the block thresholds for matching filesystems,
+// optionally only while its Window is active. The first matching active
+// rule wins. Mute suppresses threshold evaluation entirely.
+type ThresholdRule struct {
+	Name   string   `json:"name,omitempty"`
+	Match  string   `json:"match,omitempty"`
+	Type   string   `json:"type,omitempty"`
+	Warn   *float64 `json:"warn,omitempty"`
+	Crit   *float64 `json:"crit,omitempty"`
+	Mute   bool     `json:"mute,omitempty"`
+	Window string   `json:"window,omitempty"`
+}
+
+// AppliedThresholds records which rule decided an entry's thresholds.
+type AppliedThresholds struct {
+	Rule     string  `json:"rule"`
+	Schedule string  `json:"schedule,omitempty"`
+	Warn     float64 `json:"warn"`
+	Crit     float64 `json:"crit"`
+	Muted    bool    `json:"muted,omitempty"`
+}
+
+// window is a daily time range such as "22:00-06:00 Mon-Fri". A range
+// whose end is before its start spans midnight and belongs to the day it
+// starts on.
+type window struct {
+	start, end int // minutes since midnight
+	days       [7]bool
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func parseWindow(s string) (window, error) {
+	var w window
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return w, fmt.Errorf("invalid window %q", s)
+	}
+
+	from, to, ok := strings.Cut(fields[0], "-")
+	if !ok {
+		return w, fmt.Errorf("invalid window %q: want HH:MM-HH:MM", s)
+	}
+	var err error
+	if w.start, err = parseClock(from); err != nil {
+		return w, err
+	}
+	if w.end, err = parseClock(to); err != nil {
+		return w, err
+	}
+	if w.start == w.end {
+		return w, fmt.Errorf("invalid window %q: empty range", s)
+	}
+
+	w.days, err = parseDays(fields[1:], "window", s)
+	return w, err
+}
+
+// parseDays reads an optional list of days and day ranges such as
+// "Mon-Fri,Sun"; no list means every day. kind and s locate errors.
+func parseDays(list []string, kind, s string) ([7]bool, error) {
+	var days [7]bool
+	if len(list) == 0 {
+		for i := range days {
+			days[i] = true
+		}
+		return days, nil
+	}
+	for _, part := range strings.Split(list[0], ",") {
+		first, last, isRange := strings.Cut(strings.ToLower(part), "-")
+		a, ok := weekdays[first]
+		if !ok {
+			return days, fmt.Errorf("invalid day %q in %s %q", first, kind, s)
+		}
+		b := a
+		if isRange {
+			if b, ok = weekdays[last]; !ok {
+				return days, fmt.Errorf("invalid day %q in %s %q", last, kind, s)
+			}
+		}
+		for d := a; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == b {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func parseClock(s string) (int, error) {
+	h, m, ok := strings.Cut(s, ":")
+	hh, err1 := strconv.Atoi(h)
+	mm, err2 := strconv.Atoi(m)
+	if !ok || err1 != nil || err2 != nil || hh < 0 || hh > 24 || mm < 0 || mm > 59 || (hh == 24 && mm != 0) {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return hh*60 + mm, nil
+}
+
+func (w window) active(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	if w.start < w.end {
+		return w.days[t.Weekday()] && m >= w.start && m < w.end
+	}
+	if m >= w.start {
+		return w.days[t.Weekday()]
+	}
+	return m < w.end && w.days[(t.Weekday()+6)%7]
+}
+
+func (r ThresholdRule) check(key string) []error {
+	var errs []error
+	if _, err := filepath.Match(r.Match, ""); err != nil {
+		errs = append(errs, fmt.Errorf("%s.match: bad pattern %q", key, r.Match))
+	}
+	if r.Window != "" {
+		if _, err := parseWindow(r.Window); err != nil {
+			errs = append(errs, fmt.Errorf("%s.window: %v", key, err))
+		}
+	}
+	return append(errs, checkOrder(key, r.Warn, r.Crit)...)
+}
+
+func (r ThresholdRule) matches(d FS, now time.Time) bool {
+	if r.Type != "" && r.Type != d.Type {
+		return false
+	}
+	if r.Match != "" {
+		if ok, _ := filepath.Match(r.Match, d.Mount); !ok {
+			return false
+		}
+	}
+	if r.Window != "" {
+		w, err := parseWindow(r.Window)
+		if err != nil || !w.active(now) {
+			return false
+		}
+	}
+	return true
+}
+
+// applyThresholdRules resolves each entry's thresholds at time now.
+func applyThresholdRules(list []FS, rules []ThresholdRule, config Config, now time.Time) {
+	for i := range list {
+		for j, r := range rules {
+			if !r.matches(list[i], now) {
+				continue
+			}
+			a := &AppliedThresholds{
+				Rule:  r.Name,
+				Warn:  config.WarnThreshold,
+				Crit:  config.CritThreshold,
+				Muted: r.Mute,
+			}
+			if a.Rule == "" {
+				a.Rule = fmt.Sprintf("thresholds[%d]", j)
+			}
+			if r.Window != "" {
+				a.Schedule = r.Window
+			}
+			if r.Warn != nil {
+				a.Warn = *r.Warn
+			}
+			if r.Crit != nil {
+				a.Crit = *r.Crit
+			}
+			list[i].Thresholds = a
+			break
+		}
+	}
+}
+
+// blockThresholds returns the warn and crit levels in effect for d.
+func blockThresholds(d FS, config Config) Thresholds {
+	if d.Thresholds != nil {
+		return Thresholds{Warn: d.Thresholds.Warn, Crit: d.Thresholds.Crit}
+	}
+	return config.blockLimits()
+}
+
+// blockStatus evaluates block usage against the thresholds in effect for
+// d. Muted entries are always ok.
+func blockStatus(d FS, config Config) Status {
+	if d.Thresholds != nil && d.Thresholds.Muted {
+		return StatusOK
+	}
+	return blockThresholds(d, config).Evaluate(d.Usage)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// at is a time in the week of Monday 2026-10-12, in loc.
+func at(day time.Weekday, clock string, loc *time.Location) time.Time {
+	t, err := time.ParseInLocation("15:04", clock, loc)
+	if err != nil {
+		panic(err)
+	}
+	return time.Date(2026, 10, 11+int(day), t.Hour(), t.Minute(), 0, 0, loc)
+}
+
+func TestParseWindow(t *testing.T) {
+	valid := []string{"22:00-06:00", "00:00-24:00", "9:30-17:00 Mon-Fri", "22:00-06:00 Fri-Mon", "08:00-09:00 sat,sun", "12:00-13:00 Mon,Wed-Thu"}
+	for _, s := range valid {
+		if _, err := parseWindow(s); err != nil {
+			t.Errorf("parseWindow(%q): %v", s, err)
+		}
+	}
+	invalid := []string{"", "22:00", "22:00-", "-06:00", "25:00-06:00", "22:60-06:00", "24:01-06:00", "10:00-10:00",
+		"22:00-06:00 Someday", "22:00-06:00 Mon-Funday", "22:00-06:00 Mon Fri", "aa:bb-cc:dd"}
+	for _, s := range invalid {
+		if _, err := parseWindow(s); err == nil {
+			t.Errorf("parseWindow(%q) accepted", s)
+		}
+	}
+}
+
+func TestWindowActive(t *testing.T) {
+	utc := time.UTC
+	tests := []struct {
+		window string
+		day    time.Weekday
+		clock  string
+		want   bool
+	}{
+		// Within a day: the start is in, the end is out.
+		{"09:00-17:00", time.Wednesday, "08:59", false},
+		{"09:00-17:00", time.Wednesday, "09:00", true},
+		{"09:00-17:00", time.Wednesday, "16:59", true},
+		{"09:00-17:00", time.Wednesday, "17:00", false},
+		{"00:00-24:00", time.Wednesday, "23:59", true},
+		{"00:00-24:00", time.Wednesday, "00:00", true},
+
+		// Spanning midnight.
+		{"22:00-06:00", time.Wednesday, "21:59", false},
+		{"22:00-06:00", time.Wednesday, "22:00", true},
+		{"22:00-06:00", time.Wednesday, "23:59", true},
+		{"22:00-06:00", time.Thursday, "00:00", true},
+		{"22:00-06:00", time.Thursday, "05:59", true},
+		{"22:00-06:00", time.Thursday, "06:00", false},
+		{"22:00-06:00", time.Thursday, "12:00", false},
+
+		// The night belongs to the day it starts on.
+		{"22:00-06:00 Mon-Fri", time.Friday, "23:00", true},
+		{"22:00-06:00 Mon-Fri", time.Saturday, "02:00", true},
+		{"22:00-06:00 Mon-Fri", time.Saturday, "23:00", false},
+		{"22:00-06:00 Mon-Fri", time.Sunday, "02:00", false},
+		{"22:00-06:00 Mon-Fri", time.Monday, "02:00", false},
+		{"22:00-06:00 Mon-Fri", time.Monday, "22:00", true},
+		{"22:00-06:00 Mon-Fri", time.Tuesday, "05:59", true},
+
+		// Day ranges wrap around the week.
+		{"08:00-09:00 Fri-Mon", time.Sunday, "08:30", true},
+		{"08:00-09:00 Fri-Mon", time.Monday, "08:30", true},
+		{"08:00-09:00 Fri-Mon", time.Tuesday, "08:30", false},
+		{"08:00-09:00 Sat,Sun", time.Saturday, "08:30", true},
+		{"08:00-09:00 Sat,Sun", time.Friday, "08:30", false},
+	}
+	for _, tt := range tests {
+		w, err := parseWindow(tt.window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := w.active(at(tt.day, tt.clock, utc)); got != tt.want {
+			t.Errorf("%q at %s %s: active = %v, want %v", tt.window, tt.day, tt.clock, got, tt.want)
+		}
+	}
+}
+
+func TestApplyThresholdRules(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	rules := []ThresholdRule{
+		{Name: "nightly-batch", Match: "/scratch*", Window: "22:00-06:00", Warn: f(95), Crit: f(99)},
+		{Name: "maintenance", Match: "/scratch*", Window: "12:00-13:00 Sun", Mute: true},
+		{Match: "/scratch*", Crit: f(85)},
+	}
+	config := Config{WarnThreshold: 80, CritThreshold: 90}
+	tests := []struct {
+		name       string
+		now        time.Time
+		rule       string
+		schedule   string
+		warn, crit float64
+		muted      bool
+	}{
+		{"night", at(time.Wednesday, "23:30", time.UTC), "nightly-batch", "22:00-06:00", 95, 99, false},
+		{"day", at(time.Wednesday, "10:00", time.UTC), "thresholds[2]", "", 80, 85, false},
+		{"muted", at(time.Sunday, "12:30", time.UTC), "maintenance", "12:00-13:00 Sun", 80, 90, true},
+		{"after the mute", at(time.Sunday, "13:00", time.UTC), "thresholds[2]", "", 80, 85, false},
+	}
+	for _, tt := range tests {
+		list := []FS{{Mount: "/scratch", Usage: 92}, {Mount: "/home", Usage: 92}}
+		applyThresholdRules(list, rules, config, tt.now)
+		a := list[0].Thresholds
+		if a == nil || a.Rule != tt.rule || a.Schedule != tt.schedule || a.Warn != tt.warn || a.Crit != tt.crit || a.Muted != tt.muted {
+			t.Errorf("%s: applied %+v", tt.name, a)
+		}
+		if list[1].Thresholds != nil {
+			t.Errorf("%s: /home got rule %+v", tt.name, list[1].Thresholds)
+		}
+	}
+
+	// The alert for a mount under a scheduled rule names the schedule.
+	list := []FS{{Mount: "/scratch", Usage: 97}}
+	applyThresholdRules(list, rules, config, at(time.Wednesday, "23:30", time.UTC))
+	got := routeViolations(list, config, AlertConfig{Default: "ops"})
+	if v := got["ops"]; len(v) != 1 || v[0].Schedule != "22:00-06:00" || v[0].Status != "warning" {
+		t.Errorf("violations = %+v, want a warning naming the schedule", got)
+	}
+}
+
+// TestWindowTimeZone checks windows are read in the -tz location: 23:30
+// UTC is 08:30 in Tokyo the next morning.
+func TestWindowTimeZone(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skipf("no zoneinfo: %v", err)
+	}
+	w, _ := parseWindow("22:00-06:00")
+	now := at(time.Wednesday, "23:30", time.UTC)
+	if !w.active(now) {
+		t.Error("not active at 23:30 UTC")
+	}
+	if w.active(now.In(tokyo)) {
+		t.Error("active at 08:30 in Tokyo")
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Versions of the formats dfmon writes and later reads back. Bump one when
+// its format changes, and for the state file add the upgrade from the
+// previous version to stateMigrations.
+const (
+	stateVersion  = 3
+	eventsVersion = 2
+	// reportSchema versions the -o json envelope read back by -baseline.
+	reportSchema = 1
+)
+
+// stateMigrations upgrade a state file in memory from the version they are
+// keyed by to the next one.
+var stateMigrations = map[int]func(*State){
+	// Version 2 added the -deep scan progress.
+	1: func(st *State) { st.Deep = map[string]DeepState{} },
+	// Version 3 keys mounts by filesystem identity. Without the mounts at
+	// hand entries stay under their path until applyState re-keys them.
+	2: func(st *State) {},
+}
+
+func newerVersion(path, what string, got, supported int) error {
+	return fmt.Errorf("%s: %s version %d is newer than this dfmon supports (%d)", path, what, got, supported)
+}
+
+// corruptError reports a file that could not be parsed and was renamed to
+// Moved so that the caller can carry on without it.
+type corruptError struct {
+	Path, Moved string
+	Err         error
+}
+
+func (e *corruptError) Error() string {
+	return fmt.Sprintf("%s is corrupt (%v); moved to %s", e.Path, e.Err, e.Moved)
+}
+
+func moveAside(path string, err error) error {
+	moved := path + ".corrupt"
+	if rerr := os.Rename(path, moved); rerr != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return &corruptError{Path: path, Moved: moved, Err: err}
+}
+
+// eventsHeader is the first line of an -events-file. Files from before it
+// existed start straight with events and count as version 0.
+type eventsHeader struct {
+	Schema  string `json:"schema"`
+	Version int    `json:"version"`
+}
+
+const eventsSchema = "dfmon-events"
+
+// parseEventsHeader reports the version named by line, if it is a header.
+func parseEventsHeader(line []byte) (int, bool) {
+	var h eventsHeader
+	if json.Unmarshal(line, &h) != nil || h.Schema != eventsSchema {
+		return 0, false
+	}
+	return h.Version, true
+}
+
+// prepareEventLog makes the file at path ready for appending: it writes the
+// header to a new file, rewrites an older one with the current header, and
+// terminates a last record cut short by a crash so that the next one starts
+// on its own line.
+func prepareEventLog(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		_, err = writeOutputFile(path, "", writeEventsHeader)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	first, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	version, ok := parseEventsHeader(first)
+	if version > eventsVersion {
+		return newerVersion(path, "events file", version, eventsVersion)
+	}
+	if !ok || version < eventsVersion {
+		// Records carry over unchanged; only the header is replaced.
+		var skip int64
+		if ok {
+			skip = int64(len(first))
+		}
+		if _, err := f.Seek(skip, io.SeekStart); err != nil {
+			return err
+		}
+		_, err := writeOutputFile(path, "", func(w io.Writer) error {
+			if err := writeEventsHeader(w); err != nil {
+				return err
+			}
+			_, err := io.Copy(w, f)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if f, err = os.Open(path); err != nil {
+			return err
+		}
+		defer f.Close()
+	}
+
+	fi, err := f.Stat()
+	if err != nil || fi.Size() == 0 {
+		return err
+	}
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, fi.Size()-1); err != nil {
+		return err
+	}
+	if last[0] == '\n' {
+		return nil
+	}
+	a, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	_, err = a.Write([]byte("\n"))
+	return err
+}
+
+func writeEventsHeader(w io.Writer) error {
+	return json.NewEncoder(w).Encode(eventsHeader{Schema: eventsSchema, Version: eventsVersion})
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// collectStats counts how collections went, for -self-metrics. It is
+// updated from the collector goroutines as well as the collection itself.
+type collectStats struct {
+	lastSuccess atomic.Int64 // unix nanoseconds
+	lastTook    atomic.Int64 // nanoseconds
+	failures    atomic.Uint64
+	skipped     atomic.Uint64
+	timeouts    atomic.Uint64
+
+	mu     sync.Mutex
+	errnos map[string]uint64
+}
+
+var stats collectStats
+
+// statError records a mount left out because statfs failed.
+func (s *collectStats) statError(err error) {
+	s.skipped.Add(1)
+	name := "other"
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		name = errnoName(errno)
+	}
+	s.mu.Lock()
+	if s.errnos == nil {
+		s.errnos = map[string]uint64{}
+	}
+	s.errnos[name]++
+	s.mu.Unlock()
+}
+
+func (s *collectStats) finished(start time.Time, err error) {
+	if err != nil {
+		s.failures.Add(1)
+		return
+	}
+	now := time.Now()
+	s.lastTook.Store(int64(now.Sub(start)))
+	s.lastSuccess.Store(now.UnixNano())
+}
+
+func (s *collectStats) errnoCounts() map[string]uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]uint64, len(s.errnos))
+	for k, v := range s.errnos {
+		out[k] = v
+	}
+	return out
+}
+
+var errnoNames = map[syscall.Errno]string{
+	syscall.ENOENT:    "ENOENT",
+	syscall.EACCES:    "EACCES",
+	syscall.EPERM:     "EPERM",
+	syscall.EIO:       "EIO",
+	syscall.ESTALE:    "ESTALE",
+	syscall.ETIMEDOUT: "ETIMEDOUT",
+	syscall.EINTR:     "EINTR",
+	syscall.EAGAIN:    "EAGAIN",
+	syscall.ENOTCONN:  "ENOTCONN",
+	syscall.EHOSTDOWN: "EHOSTDOWN",
+	syscall.ENOSYS:    "ENOSYS",
+}
+
+func errnoName(e syscall.Errno) string {
+	if name, ok := errnoNames[e]; ok {
+		return name
+	}
+	return fmt.Sprintf("errno%d", int(e))
+}
+
+// SelfStatus is the /debug/status document.
+type SelfStatus struct {
+	LastSuccess       *time.Time        `json:"last_success"`
+	LastDuration      float64           `json:"last_duration_seconds"`
+	Collections       uint64            `json:"collections"`
+	Failures          uint64            `json:"failures"`
+	StatErrors        map[string]uint64 `json:"stat_errors"`
+	MountsSkipped     uint64            `json:"mounts_skipped"`
+	CollectorTimeouts uint64            `json:"collector_timeouts"`
+	CacheHits         uint64            `json:"cache_hits"`
+	CacheMisses       uint64            `json:"cache_misses"`
+	Rejected          uint64            `json:"scrapes_rejected"`
+	Goroutines        int               `json:"goroutines"`
+	HeapBytes         uint64            `json:"heap_bytes"`
+	SysBytes          uint64            `json:"sys_bytes"`
+	// Intervals are the per-mount intervals with -max-interval.
+	Intervals []Interval `json:"intervals,omitempty"`
+}
+
+func (e *exporter) selfStatus() SelfStatus {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	st := SelfStatus{
+		LastDuration:      time.Duration(stats.lastTook.Load()).Seconds(),
+		Collections:       e.collections.Load(),
+		Failures:          stats.failures.Load(),
+		StatErrors:        stats.errnoCounts(),
+		MountsSkipped:     stats.skipped.Load(),
+		CollectorTimeouts: stats.timeouts.Load(),
+		CacheHits:         e.hits.Load(),
+		CacheMisses:       e.misses.Load(),
+		Rejected:          e.rejected.Load(),
+		Goroutines:        runtime.NumGoroutine(),
+		HeapBytes:         mem.HeapAlloc,
+		SysBytes:          mem.Sys,
+		Intervals:         e.config.Scheduler.intervals(),
+	}
+	if ns := stats.lastSuccess.Load(); ns != 0 {
+		t := time.Unix(0, ns).UTC()
+		st.LastSuccess = &t
+	}
+	return st
+}
+
+func (e *exporter) serveStatus(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(e.selfStatus())
+}
+
+// writeSelfMetrics appends the -self-metrics series. The last success
+// timestamp is 0 until a collection has succeeded, so an alert on its age
+// also fires for an exporter that never got one through.
+func (e *exporter) writeSelfMetrics(sb *strings.Builder) {
+	st := e.selfStatus()
+	var last float64
+	if st.LastSuccess != nil {
+		last = float64(st.LastSuccess.UnixNano()) / 1e9
+	}
+	gauges := []struct {
+		name, help string
+		v          float64
+	}{
+		{"dfmon_last_success_timestamp_seconds", "Time the last successful collection finished.", last},
+		{"dfmon_last_collection_duration_seconds", "Duration of the last successful collection.", st.LastDuration},
+		{"go_goroutines", "Number of goroutines.", float64(st.Goroutines)},
+		{"go_memstats_heap_alloc_bytes", "Heap bytes allocated and in use.", float64(st.HeapBytes)},
+		{"go_memstats_sys_bytes", "Bytes obtained from the system.", float64(st.SysBytes)},
+	}
+	for _, g := range gauges {
+		fmt.Fprintf(sb, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", g.name, g.help, g.name, g.name, g.v)
+	}
+
+	counters := []struct {
+		name, help string
+		v          uint64
+	}{
+		{"dfmon_collection_failures_total", "Collections that failed outright.", st.Failures},
+		{"dfmon_mounts_skipped_total", "Mounts left out because statfs failed.", st.MountsSkipped},
+		{"dfmon_collector_timeouts_total", "Enrichers that did not finish in time.", st.CollectorTimeouts},
+	}
+	for _, c := range counters {
+		fmt.Fprintf(sb, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", c.name, c.help, c.name, c.name, c.v)
+	}
+
+	fmt.Fprintf(sb, "# HELP dfmon_stat_errors_total Statfs failures by errno.\n# TYPE dfmon_stat_errors_total counter\n")
+	names := make([]string, 0, len(st.StatErrors))
+	for name := range st.StatErrors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(sb, "dfmon_stat_errors_total{errno=\"%s\"} %d\n", name, st.StatErrors[name])
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AScotM/filesystem_cap/fscap/mountinfo"
+)
+
+// zfsSnapshotCollector sets FS.SnapshotUsed from a dataset's
+// usedbysnapshots property, with -snapshots.
+type zfsSnapshotCollector struct{}
+
+func (zfsSnapshotCollector) Name() string                   { return "zfssnap" }
+func (zfsSnapshotCollector) Applies(m mountinfo.Mount) bool { return m.FSType == "zfs" }
+func (zfsSnapshotCollector) Enrich(ctx context.Context, m mountinfo.Mount, d *FS) error {
+	out, err := snapshotTool(ctx, "zfs", "get", "-Hp", "-o", "value", "usedbysnapshots", m.Source)
+	if out == nil || err != nil {
+		return err
+	}
+	n, err := strconv.ParseUint(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return fmt.Errorf("zfs get usedbysnapshots: %v", err)
+	}
+	d.SnapshotUsed = n
+	return nil
+}
+
+// btrfsSnapshotCollector sets FS.SnapshotUsed to the exclusive size of
+// the filesystem's snapshot subvolumes, from their qgroups, with
+// -snapshots. Without quotas enabled btrfs does not know it, and the
+// field is left out.
+type btrfsSnapshotCollector struct{}
+
+func (btrfsSnapshotCollector) Name() string                   { return "btrfssnap" }
+func (btrfsSnapshotCollector) Applies(m mountinfo.Mount) bool { return m.FSType == "btrfs" }
+
+// Timeout allows for btrfs walking the subvolume tree of a filesystem
+// with many snapshots.
+func (btrfsSnapshotCollector) Timeout() time.Duration { return 10 * time.Second }
+
+func (btrfsSnapshotCollector) Enrich(ctx context.Context, m mountinfo.Mount, d *FS) error {
+	mount := hostPath(m.MountPoint)
+	subvols, err := snapshotTool(ctx, "btrfs", "subvolume", "list", "-s", mount)
+	if subvols == nil || err != nil {
+		return err
+	}
+	qgroups, err := snapshotTool(ctx, "btrfs", "qgroup", "show", "--raw", mount)
+	if errors.Is(err, errNoQuotas) {
+		return nil
+	}
+	if qgroups == nil || err != nil {
+		return err
+	}
+
+	// "ID 258 gen 9 cgen 9 top level 5 otime ... path snap"
+	snapshots := map[string]bool{}
+	sc := bufio.NewScanner(bytes.NewReader(subvols))
+	for sc.Scan() {
+		if f := strings.Fields(sc.Text()); len(f) > 1 && f[0] == "ID" {
+			snapshots["0/"+f[1]] = true
+		}
+	}
+	// "0/258   16384   16384 ..." after a two-line header.
+	var used uint64
+	sc = bufio.NewScanner(bytes.NewReader(qgroups))
+	for sc.Scan() {
+		f := strings.Fields(sc.Text())
+		if len(f) < 3 || !snapshots[f[0]] {
+			continue
+		}
+		if n, err := strconv.ParseUint(f[2], 10, 64); err == nil {
+			used += n
+		}
+	}
+	d.SnapshotUsed = used
+	return nil
+}
+
+var errNoQuotas = errors.New("quotas not enabled")
+
+// snapshotTool runs a filesystem's own tool and returns its output, or
+// nil without an error when the tool is not installed, so the field is
+// left out rather than reported as a collector failure.
+func snapshotTool(ctx context.Context, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	switch {
+	case errors.Is(err, exec.ErrNotFound):
+		return nil, nil
+	case err != nil && strings.Contains(stderr.String(), "quotas not enabled"):
+		return nil, errNoQuotas
+	case err != nil:
+		if msg := firstLine(stderr.String()); msg != "" {
+			err = fmt.Errorf("%v: %s", err, msg)
+		}
+		return nil, fmt.Errorf("%s %s: %v", name, args[0], err)
+	}
+	return out, nil
+}
+
+// applyThinSnapshots sets FS.SnapshotUsed of each filesystem on a thin
+// volume to the space its thin snapshots have mapped in the pool, from
+// the origins lvs(8) reports and the mapped sectors in dmsetup status.
+// Without lvs the field is left out, as it is for snapshots LVM left
+// inactive, which have no device to report on. names maps major:minor to
+// device-mapper names.
+func applyThinSnapshots(ctx context.Context, list []FS, names map[string]string, status []byte) error {
+	out, err := snapshotTool(ctx, "lvs", "--noheadings", "--separator", " ", "-o", "vg_name,lv_name,origin")
+	if out == nil || err != nil {
+		return err
+	}
+	// A thin target's status starts with its mapped sectors.
+	mapped := map[string]uint64{}
+	for name, args := range dmTargets(status, "thin") {
+		if len(args) > 0 {
+			if n, err := strconv.ParseUint(args[0], 10, 64); err == nil {
+				mapped[name] = n * 512
+			}
+		}
+	}
+	used := map[string]uint64{} // origin's dm name -> bytes
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		if f := strings.Fields(sc.Text()); len(f) == 3 {
+			used[dmName(f[0], f[2])] += mapped[dmName(f[0], f[1])]
+		}
+	}
+	for i := range list {
+		if n, ok := used[names[list[i].Dev]]; ok {
+			list[i].SnapshotUsed = n
+		}
+	}
+	return nil
+}
+
+// dmName is the device-mapper name LVM gives vg/lv, with the dashes in
+// each part doubled.
+func dmName(vg, lv string) string {
+	return strings.ReplaceAll(vg, "-", "--") + "-" + strings.ReplaceAll(lv, "-", "--")
+}
+
+func snapshotLine(d FS, humanReadable bool) string {
+	return "    " + fmtBytes(d.SnapshotUsed, humanReadable) + " used by snapshots (not freeable by deleting files)"
+}
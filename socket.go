@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Snapshot is what -unix-socket sends: the -o json envelope of the latest
+// collection, how old it is, and the age after which a reader should take
+// the daemon to be stuck, two -watch intervals.
+type Snapshot struct {
+	Report
+	Age        float64 `json:"age_seconds"`
+	StaleAfter float64 `json:"stale_after_seconds"`
+	Stale      bool    `json:"stale"`
+	Error      string  `json:"error,omitempty"`
+}
+
+type snapshotEntry struct {
+	r  Report
+	at time.Time
+}
+
+// snapshotServer sends every client that connects the latest snapshot
+// and closes the connection; clients send nothing.
+type snapshotServer struct {
+	ln         net.Listener
+	latest     atomic.Pointer[snapshotEntry]
+	staleAfter time.Duration
+	logger     *log.Logger
+}
+
+// listenSnapshots creates the socket at path with the given mode and
+// group. A socket file left by a crashed dfmon is replaced, one another
+// dfmon still accepts on is not, nor is anything that is not a socket.
+func listenSnapshots(path string, mode os.FileMode, group string, staleAfter time.Duration, logger *log.Logger) (*snapshotServer, error) {
+	gid := -1
+	if group != "" {
+		var err error
+		if gid, err = lookupGroup(group); err != nil {
+			return nil, err
+		}
+	}
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	// The socket is created with the final mode so no client can connect
+	// in between.
+	old := syscall.Umask(int(0o777 &^ mode))
+	ln, err := net.Listen("unix", path)
+	syscall.Umask(old)
+	if err != nil {
+		return nil, err
+	}
+	if gid >= 0 {
+		if err := os.Chown(path, -1, gid); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	s := &snapshotServer{ln: ln, staleAfter: staleAfter, logger: logger}
+	go s.serve()
+	return s, nil
+}
+
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another process", path)
+	}
+	return os.Remove(path)
+}
+
+func lookupGroup(name string) (int, error) {
+	if g, err := user.LookupGroup(name); err == nil {
+		return strconv.Atoi(g.Gid)
+	}
+	gid, err := strconv.Atoi(name)
+	if err != nil {
+		return 0, fmt.Errorf("unknown group %q", name)
+	}
+	return gid, nil
+}
+
+// update makes r, collected at at, the snapshot sent from now on.
+func (s *snapshotServer) update(r Report, at time.Time) {
+	s.latest.Store(&snapshotEntry{r: r, at: at})
+}
+
+func (s *snapshotServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			s.logger.Printf("Warning: unix socket: %v", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go s.send(conn)
+	}
+}
+
+func (s *snapshotServer) send(conn net.Conn) {
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+
+	snap := Snapshot{
+		Report:     Report{Version: buildInfo().Version, Schema: reportSchema, Filesystems: []FS{}},
+		StaleAfter: s.staleAfter.Seconds(), Stale: true, Error: "no collection yet",
+	}
+	if e := s.latest.Load(); e != nil {
+		age := time.Since(e.at)
+		snap = Snapshot{Report: e.r, Age: age.Seconds(), StaleAfter: s.staleAfter.Seconds(), Stale: age > s.staleAfter}
+	}
+	json.NewEncoder(conn).Encode(snap)
+}
+
+// Close stops serving and removes the socket file.
+func (s *snapshotServer) Close() error {
+	return s.ln.Close()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// sparkWidth is how many samples the -spark history keeps per mount.
+const sparkWidth = 30
+
+var (
+	sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+	sparkASCII  = []rune("_.-~=+*#")
+)
+
+// sparkHistory is a per-mount ring of usage samples kept for the length
+// of a -watch session.
+type sparkHistory map[string][]float64
+
+func (h sparkHistory) add(list []FS) {
+	for i := range list {
+		s := append(h[list[i].Mount], list[i].Usage)
+		if len(s) > sparkWidth {
+			s = s[len(s)-sparkWidth:]
+		}
+		h[list[i].Mount] = s
+	}
+}
+
+// annotate sets each entry's sparkline from the history.
+func (h sparkHistory) annotate(list []FS) {
+	ascii := asciiTerminal()
+	for i := range list {
+		list[i].Spark = sparkline(h[list[i].Mount], ascii)
+	}
+}
+
+// sparkline draws usage percentages on a fixed 0-100 scale, so lines for
+// different mounts compare directly.
+func sparkline(samples []float64, ascii bool) string {
+	levels := sparkBlocks
+	if ascii {
+		levels = sparkASCII
+	}
+	var sb strings.Builder
+	for _, v := range samples {
+		i := int(v / 100 * float64(len(levels)))
+		sb.WriteRune(levels[max(0, min(i, len(levels)-1))])
+	}
+	return sb.String()
+}
+
+// asciiTerminal reports whether the terminal may not render block
+// characters: a dumb terminal or a non-UTF-8 locale.
+func asciiTerminal() bool {
+	if os.Getenv("TERM") == "dumb" {
+		return true
+	}
+	for _, v := range []string{os.Getenv("LC_ALL"), os.Getenv("LC_CTYPE"), os.Getenv("LANG")} {
+		if v != "" {
+			v = strings.ToLower(v)
+			return !strings.Contains(v, "utf-8") && !strings.Contains(v, "utf8")
+		}
+	}
+	return true
+}
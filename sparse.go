@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// Sparse is the space the large sparse files on a mount have been promised
+// but not yet given: their apparent size less what is allocated to them.
+// It is how much the filesystem can still grow without a new file, as when
+// VM images fill in. Partial means the scan hit its time or file limit.
+type Sparse struct {
+	Unallocated uint64    `json:"unallocated"`
+	Files       int       `json:"files"`
+	Scanned     int       `json:"scanned"`
+	Partial     bool      `json:"partial,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+// sparseLimits bounds one -sparse-check scan.
+type sparseLimits struct {
+	MinSize  uint64
+	MaxFiles int
+	Budget   time.Duration
+	TTL      time.Duration
+}
+
+// applySparse scans the mounts matching the config's sparse patterns,
+// reusing a result kept in the state file until it is older than the TTL.
+func applySparse(ctx context.Context, list []FS, patterns []string, statePath string, lim sparseLimits, now time.Time) error {
+	st := State{Version: stateVersion, Mounts: map[string]MountState{}}
+	var err error
+	if statePath != "" {
+		st, err = readState(statePath)
+		var corrupt *corruptError
+		if err != nil && !errors.As(err, &corrupt) {
+			return err
+		}
+	}
+	if st.Sparse == nil {
+		st.Sparse = map[string]Sparse{}
+	}
+
+	errs := []error{err}
+	scanned := map[string]Sparse{}
+	for i := range list {
+		d := &list[i]
+		if d.Denied || !matchAny(patterns, d.Mount) {
+			continue
+		}
+		sp, ok := st.Sparse[d.Mount]
+		if !ok || now.Sub(sp.Time) > lim.TTL {
+			res, serr := runScan(ctx, scanRequest{Kind: "sparse", Path: hostPath(d.Mount), Sparse: lim, Now: now})
+			if serr != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", d.Mount, serr))
+				continue
+			}
+			sp = res.Sparse
+			scanned[d.Mount] = sp
+		}
+		st.Sparse[d.Mount] = sp
+		d.Sparse = &sp
+	}
+
+	err = errors.Join(errs...)
+	if statePath == "" {
+		return err
+	}
+	// The file is read again for the update, as a collection overlapping
+	// this one may have changed it while the scans ran.
+	return errors.Join(err, updateState(statePath, func(cur *State) {
+		if cur.Sparse == nil {
+			cur.Sparse = map[string]Sparse{}
+		}
+		for mount, sp := range scanned {
+			cur.Sparse[mount] = sp
+		}
+	}))
+}
+
+// scanSparse walks mount without leaving its filesystem or visiting a
+// bind-mounted subtree twice, comparing the
+// apparent and allocated size of every regular file of at least MinSize.
+// It stops at the deadline or after MaxFiles files have been looked at.
+func scanSparse(mount string, lim sparseLimits, now time.Time) Sparse {
+	sp := Sparse{Time: now}
+	var root syscall.Stat_t
+	if syscall.Lstat(mount, &root) != nil {
+		return sp
+	}
+	deadline := time.Now().Add(lim.Budget)
+	newFSWalker(uint64(root.Dev)).walk(mount, func(p string, de fs.DirEntry, st *syscall.Stat_t, err error) error {
+		if err != nil {
+			return nil
+		}
+		if sp.Scanned >= lim.MaxFiles || time.Now().After(deadline) {
+			sp.Partial = true
+			return filepath.SkipAll
+		}
+		if !de.Type().IsRegular() {
+			return nil
+		}
+		sp.Scanned++
+		size, alloc := uint64(st.Size), uint64(st.Blocks)*512
+		if size >= lim.MinSize && size > alloc {
+			sp.Unallocated += size - alloc
+			sp.Files++
+		}
+		return nil
+	})
+	return sp
+}
+
+func sparseLine(sp *Sparse, humanReadable bool) string {
+	line := fmt.Sprintf("    sparse: %s unallocated in %d file(s)", fmtBytes(sp.Unallocated, humanReadable), sp.Files)
+	if sp.Partial {
+		line += fmt.Sprintf(" (partial, %d files scanned)", sp.Scanned)
+	}
+	return line
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+	"time"
+)
+
+// State is what dfmon remembers between runs about each filesystem, keyed
+// by identity, and about each scanned mount, keyed by path.
+type State struct {
+	Version int                   `json:"version"`
+	Mounts  map[string]MountState `json:"mounts"`
+	Deep    map[string]DeepState  `json:"deep,omitempty"`
+	Sparse  map[string]Sparse     `json:"sparse,omitempty"`
+	// Alerts holds when each alert key was last sent, for -dedup-window
+	// and the alerts still ongoing in -watch mode.
+	Alerts map[string]time.Time `json:"alerts,omitempty"`
+	// Actions holds when an exec action last ran for each filesystem,
+	// for -exec-cooldown.
+	Actions map[string]time.Time `json:"actions,omitempty"`
+}
+
+// MountState is the last sample of a mount and its smoothed fill rate in
+// bytes per second. Window holds the samples of the last -rate-window.
+type MountState struct {
+	Time    time.Time     `json:"time"`
+	Used    uint64        `json:"used"`
+	Total   uint64        `json:"total,omitempty"`
+	Rate    float64       `json:"rate"`
+	Samples int           `json:"samples"`
+	Window  []UsageSample `json:"window,omitempty"`
+}
+
+// loadState reads the state file, upgrading and rewriting one written by
+// an older dfmon. A file that does not parse is moved aside and an empty
+// state returned together with a *corruptError, which callers report but
+// otherwise carry on from.
+func loadState(path string) (State, error) {
+	empty := State{Version: stateVersion, Mounts: map[string]MountState{}}
+	b, err := readInput(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return empty, nil
+	}
+	if err != nil {
+		return empty, err
+	}
+
+	var st State
+	if err := json.Unmarshal(b, &st); err != nil {
+		return empty, moveAside(path, err)
+	}
+	if st.Version == 0 {
+		return empty, moveAside(path, errors.New("no version"))
+	}
+	if st.Version > stateVersion {
+		return empty, newerVersion(path, "state file", st.Version, stateVersion)
+	}
+	if st.Mounts == nil {
+		st.Mounts = map[string]MountState{}
+	}
+	if st.Version < stateVersion {
+		for ; st.Version < stateVersion; st.Version++ {
+			stateMigrations[st.Version](&st)
+		}
+		if err := saveState(path, st); err != nil {
+			return st, err
+		}
+	}
+	return st, nil
+}
+
+func saveState(path string, st State) error {
+	_, err := writeOutputFile(path, "", func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(st)
+	})
+	return err
+}
+
+// stateMu serializes updates to the state file within this process, where
+// collections may overlap; lockFile does so between processes.
+var stateMu sync.Mutex
+
+func lockState(path string) (func(), error) {
+	stateMu.Lock()
+	unlock, err := lockFile(path)
+	if err != nil {
+		stateMu.Unlock()
+		return nil, err
+	}
+	return func() {
+		unlock()
+		stateMu.Unlock()
+	}, nil
+}
+
+// readState is loadState under the state lock, for a caller that scans
+// for a while before recording the result with updateState.
+func readState(path string) (State, error) {
+	unlock, err := lockState(path)
+	if err != nil {
+		return State{Version: stateVersion, Mounts: map[string]MountState{}}, err
+	}
+	defer unlock()
+	return loadState(path)
+}
+
+// updateState loads the state file, applies fn and saves the result under
+// the state lock, so that overlapping collections do not lose each other's
+// updates. As with loadState, fn runs on an empty state when the file was
+// corrupt, and the *corruptError is returned with the save's.
+func updateState(path string, fn func(*State)) error {
+	unlock, err := lockState(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	st, err := loadState(path)
+	var corrupt *corruptError
+	if err != nil && !errors.As(err, &corrupt) {
+		return err
+	}
+	fn(&st)
+	return errors.Join(err, saveState(path, st))
+}
+
+// applyState sets fill rates and time to full from the previous samples in
+// the state file, and the growth rate over the last span, then records the
+// current ones. A mount whose size has
+// changed since is marked Resized. The raw delta is shown as
+// measured, but the smoothed rate feeds on it clamped at zero, so freeing
+// space (a log rotation, say) slows the estimate down rather than flipping
+// it to "never".
+func applyState(list []FS, path string, alpha float64, minSamples int, span time.Duration, now time.Time) error {
+	return updateState(path, func(st *State) { recordSamples(list, st, alpha, minSamples, span, now) })
+}
+
+func recordSamples(list []FS, st *State, alpha float64, minSamples int, span time.Duration, now time.Time) {
+	// Mounts of one filesystem share its entry, which is only updated once.
+	done := map[string]int{}
+	for i := range list {
+		d := &list[i]
+		key := identityKey(*d)
+		if j, ok := done[key]; ok {
+			d.Growth, d.Resized = list[j].Growth, list[j].Resized
+			continue
+		}
+		done[key] = i
+		prev, ok := st.Mounts[key]
+		if !ok {
+			if prev, ok = st.Mounts[d.Mount]; ok {
+				delete(st.Mounts, d.Mount)
+			}
+		}
+		next := MountState{Time: now, Used: d.Used, Total: d.Total, Rate: prev.Rate, Samples: prev.Samples}
+		// After a resize the old rate says nothing about the new size, so
+		// smoothing and the rate window start over from the next sample.
+		if d.Resized = resized(*d, prev.Total); d.Resized != nil {
+			next.Rate, next.Samples = 0, 0
+			prev.Window, ok = nil, false
+		}
+		next.Window = slideWindow(prev.Window, d.Used, now, span)
+		if ok && now.After(prev.Time) {
+			elapsed := now.Sub(prev.Time).Seconds()
+			raw := (float64(d.Used) - float64(prev.Used)) / elapsed
+			next.Rate = alpha*max(raw, 0) + (1-alpha)*prev.Rate
+			if prev.Samples == 0 {
+				next.Rate = max(raw, 0)
+			}
+			next.Samples++
+
+			d.Growth = &Growth{Delta: int64(d.Used) - int64(prev.Used), Rate: raw, Smoothed: next.Rate, Samples: next.Samples}
+			if next.Rate > 0 {
+				d.Growth.ETA = float64(d.Free) / next.Rate
+			}
+			d.Growth.LowConfidence = next.Samples < minSamples
+			if rate, ok := windowRate(next.Window); ok {
+				d.Growth.WindowRate = rate
+				d.Growth.WindowSeconds = now.Sub(next.Window[0].Time).Seconds()
+			}
+		}
+		st.Mounts[key] = next
+	}
+}
+
+// Growth describes how a filesystem's usage changed since the last run.
+type Growth struct {
+	Delta         int64   `json:"delta"`
+	Rate          float64 `json:"rate"`
+	Smoothed      float64 `json:"smoothed_rate"`
+	ETA           float64 `json:"eta_seconds,omitempty"`
+	Samples       int     `json:"samples"`
+	LowConfidence bool    `json:"low_confidence,omitempty"`
+	// WindowRate is the growth in bytes per second across the samples of
+	// the last WindowSeconds, at most -rate-window, once there are enough,
+	// leaving out the largest single step.
+	WindowRate    float64 `json:"window_rate,omitempty"`
+	WindowSeconds float64 `json:"window_seconds,omitempty"`
+}
+
+func growthLine(g *Growth, humanReadable bool) string {
+	sign := "+"
+	delta := g.Delta
+	if delta < 0 {
+		sign, delta = "-", -delta
+	}
+	line := fmt.Sprintf("    growth: %s%s (smoothed %s/s)", sign, fmtBytes(uint64(delta), humanReadable), fmtBytes(uint64(g.Smoothed), humanReadable))
+	if g.ETA > 0 {
+		line += ", full in ~" + fmtDuration(time.Duration(g.ETA*float64(time.Second)))
+	} else {
+		line += ", not filling"
+	}
+	if g.LowConfidence {
+		line += fmt.Sprintf(" (low confidence, %d samples)", g.Samples)
+	}
+	if g.WindowSeconds > 0 {
+		line += fmt.Sprintf(", %s/h over the last %s", fmtBytes(uint64(max(g.WindowRate, 0)*3600), humanReadable),
+			fmtDuration(time.Duration(g.WindowSeconds*float64(time.Second))))
+	}
+	return line
+}
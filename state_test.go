@@ -0,0 +1,152 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const gib = 1 << 30
+
+// feed runs applyState once per hourly sample of used bytes on a 100 GiB
+// filesystem and returns the growth reported for each.
+func feed(t *testing.T, used []uint64, alpha float64, minSamples int) []*Growth {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "state.json")
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	var out []*Growth
+	for _, u := range used {
+		list := []FS{{Mount: "/data", Total: 100 * gib, Used: u, Free: 100*gib - u}}
+		if err := applyState(list, path, alpha, minSamples, 0, now); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, list[0].Growth)
+		now = now.Add(time.Hour)
+	}
+	return out
+}
+
+func TestStateLinear(t *testing.T) {
+	var used []uint64
+	for i := 0; i < 10; i++ {
+		used = append(used, uint64(10+i)*gib)
+	}
+	got := feed(t, used, 0.3, 3)
+	if got[0] != nil {
+		t.Fatalf("growth on the first sample: %+v", got[0])
+	}
+	rate := float64(gib) / 3600
+	for i, g := range got[1:] {
+		if math.Abs(g.Smoothed-rate) > 1e-6 || g.Rate != g.Smoothed || g.Delta != gib {
+			t.Errorf("sample %d: %+v, want a steady %.0f B/s", i+1, g, rate)
+		}
+		// Full in as many hours as GiB are free.
+		want := float64(100-10-i-1) * 3600
+		if math.Abs(g.ETA-want) > 1 {
+			t.Errorf("sample %d: ETA %.0fs, want %.0fs", i+1, g.ETA, want)
+		}
+		if g.Samples != i+1 || g.LowConfidence != (i+1 < 3) {
+			t.Errorf("sample %d: %d samples, low confidence %v", i+1, g.Samples, g.LowConfidence)
+		}
+	}
+}
+
+// TestStateSawtooth is a log that grows 1 GiB an hour and is rotated
+// every fifth hour: the rotation must not make the ETA jump to "never".
+func TestStateSawtooth(t *testing.T) {
+	var used []uint64
+	u := uint64(50 * gib)
+	for i := 0; i < 20; i++ {
+		if i > 0 && i%5 == 0 {
+			u -= 4 * gib
+		} else if i > 0 {
+			u += gib
+		}
+		used = append(used, u)
+	}
+	got := feed(t, used, 0.3, 3)
+	steady := float64(gib) / 3600
+	for i := 1; i < len(got); i++ {
+		g := got[i]
+		if g.ETA <= 0 || g.Smoothed <= 0 {
+			t.Fatalf("sample %d: not filling after %d bytes: %+v", i, g.Delta, g)
+		}
+		if g.Smoothed > steady*1.0001 {
+			t.Errorf("sample %d: smoothed %.0f exceeds the raw growth %.0f", i, g.Smoothed, steady)
+		}
+		if i%5 == 0 {
+			// The raw delta is shown as measured.
+			if g.Delta != -4*gib || g.Rate >= 0 {
+				t.Errorf("sample %d: rotation shown as %+v", i, g)
+			}
+			if g.Smoothed >= got[i-1].Smoothed || g.ETA <= got[i-1].ETA {
+				t.Errorf("sample %d: the rotation did not slow the estimate: %+v after %+v", i, g, got[i-1])
+			}
+		}
+	}
+}
+
+// TestStateStep is a one-off 10 GiB copy into an otherwise idle
+// filesystem: the estimate jumps, then decays back towards "not filling".
+func TestStateStep(t *testing.T) {
+	used := []uint64{20 * gib, 20 * gib, 20 * gib, 30 * gib, 30 * gib, 30 * gib, 30 * gib, 30 * gib}
+	const alpha = 0.5
+	got := feed(t, used, alpha, 1)
+	if got[1].Smoothed != 0 || got[1].ETA != 0 || got[2].ETA != 0 {
+		t.Errorf("idle filesystem filling: %+v, %+v", got[1], got[2])
+	}
+	jump := alpha * 10 * gib / 3600
+	if math.Abs(got[3].Smoothed-jump) > 1e-6 || got[3].Rate != 10*gib/3600.0 {
+		t.Errorf("after the step: %+v, want smoothed %.0f", got[3], jump)
+	}
+	for i := 4; i < len(got); i++ {
+		if want := got[i-1].Smoothed * (1 - alpha); math.Abs(got[i].Smoothed-want) > 1e-6 {
+			t.Errorf("sample %d: smoothed %.0f, want %.0f", i, got[i].Smoothed, want)
+		}
+		if got[i].ETA <= got[i-1].ETA {
+			t.Errorf("sample %d: ETA %.0f not later than %.0f", i, got[i].ETA, got[i-1].ETA)
+		}
+	}
+}
+
+func TestStateResize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	sample := func(total, used uint64) FS {
+		list := []FS{{Mount: "/data", Total: total, Used: used, Free: total - used}}
+		if err := applyState(list, path, 0.3, 1, 0, now); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(time.Hour)
+		return list[0]
+	}
+	sample(100*gib, 10*gib)
+	sample(100*gib, 11*gib)
+	// Growing the filesystem is not growth of its usage.
+	d := sample(200*gib, 11*gib)
+	if d.Resized == nil || d.Resized.Old != 100*gib || d.Growth != nil {
+		t.Errorf("resize: resized %+v, growth %+v", d.Resized, d.Growth)
+	}
+	d = sample(200*gib, 12*gib)
+	if d.Growth == nil || d.Growth.Samples != 1 || d.Growth.Delta != gib {
+		t.Errorf("after the resize: %+v", d.Growth)
+	}
+}
+
+func TestStateCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte("{not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	list := []FS{{Mount: "/data", Total: 100 * gib, Used: 10 * gib}}
+	err := applyState(list, path, 0.3, 1, 0, time.Now())
+	if err == nil {
+		t.Fatal("corrupt state file: no error")
+	}
+	st, err := loadState(path)
+	if err != nil || st.Mounts["/data"].Used != 10*gib {
+		t.Errorf("state not started over: %+v, %v", st, err)
+	}
+}
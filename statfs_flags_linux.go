@@ -0,0 +1,32 @@
+package main
+
+// statfsFlagNames maps the Linux f_flags bits reported by statfs(2).
+// ST_VALID (0x20) only signals that the field is populated and is skipped.
+var statfsFlagNames = []struct {
+	bit  uint64
+	name string
+}{
+	{0x0001, "ST_RDONLY"},
+	{0x0002, "ST_NOSUID"},
+	{0x0004, "ST_NODEV"},
+	{0x0008, "ST_NOEXEC"},
+	{0x0010, "ST_SYNCHRONOUS"},
+	{0x0040, "ST_MANDLOCK"},
+	{0x0080, "ST_WRITE"},
+	{0x0100, "ST_APPEND"},
+	{0x0200, "ST_IMMUTABLE"},
+	{0x0400, "ST_NOATIME"},
+	{0x0800, "ST_NODIRATIME"},
+	{0x1000, "ST_RELATIME"},
+	{0x2000, "ST_NOSYMFOLLOW"},
+}
+
+func decodeStatfsFlags(flags uint64) []string {
+	var names []string
+	for _, f := range statfsFlagNames {
+		if flags&f.bit != 0 {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
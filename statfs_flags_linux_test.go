@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecodeStatfsFlags(t *testing.T) {
+	tests := []struct {
+		flags uint64
+		want  string
+	}{
+		{0, ""},
+		{0x0020, ""}, // ST_VALID alone
+		{0x0001, "ST_RDONLY"},
+		{0x0001 | 0x0020, "ST_RDONLY"},
+		{0x0002 | 0x0004 | 0x0008, "ST_NOSUID,ST_NODEV,ST_NOEXEC"},
+		{0x1000 | 0x0002 | 0x0004 | 0x0020, "ST_NOSUID,ST_NODEV,ST_RELATIME"},
+		{0x0400 | 0x0800 | 0x0001, "ST_RDONLY,ST_NOATIME,ST_NODIRATIME"},
+		{0x2000 | 0x0010, "ST_SYNCHRONOUS,ST_NOSYMFOLLOW"},
+		{0x0040 | 0x0080 | 0x0100 | 0x0200, "ST_MANDLOCK,ST_WRITE,ST_APPEND,ST_IMMUTABLE"},
+		{0x4000, ""},                  // a bit dfmon has no name for
+		{1<<40 | 0x0002, "ST_NOSUID"}, // unnamed bits are dropped
+	}
+	for _, tt := range tests {
+		if got := strings.Join(decodeStatfsFlags(tt.flags), ","); got != tt.want {
+			t.Errorf("decodeStatfsFlags(%#x) = %q, want %q", tt.flags, got, tt.want)
+		}
+	}
+}
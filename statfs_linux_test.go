@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/AScotM/filesystem_cap/fscap/mountinfo"
+)
+
+// fakeStatfs makes statfs return what fn does for the rest of the test.
+func fakeStatfs(tb testing.TB, fn func(path string, s *syscall.Statfs_t) error) {
+	tb.Helper()
+	saved := statfs
+	statfs = fn
+	tb.Cleanup(func() { statfs = saved })
+}
+
+func fakeMount(t *testing.T) mountinfo.Mount {
+	return mountinfo.Mount{ID: 1, MountPoint: t.TempDir(), FSType: "nfs", Source: "server:/export"}
+}
+
+func TestStatMountFrsize(t *testing.T) {
+	tests := []struct {
+		name          string
+		bsize, frsize int64
+		wantBlock     uint64
+	}{
+		// The NFS case: f_bsize is the transfer size, the counts are in
+		// f_frsize fragments.
+		{"frsize", 1048576, 4096, 4096},
+		{"no frsize", 4096, 0, 4096},
+		{"equal", 4096, 4096, 4096},
+	}
+	for _, tt := range tests {
+		fakeStatfs(t, func(path string, s *syscall.Statfs_t) error {
+			*s = syscall.Statfs_t{Bsize: tt.bsize, Frsize: tt.frsize, Blocks: 1000, Bfree: 300, Bavail: 250, Files: 100, Ffree: 40}
+			return nil
+		})
+		d, err := statMount(context.Background(), fakeMount(t), log.New(io.Discard, "", 0), Config{Verbose: true})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if d.Total != 1000*tt.wantBlock || d.Free != 250*tt.wantBlock || d.Used != 750*tt.wantBlock || d.Usage != 75 {
+			t.Errorf("%s: total %d, free %d, used %d, usage %.1f", tt.name, d.Total, d.Free, d.Used, d.Usage)
+		}
+		if d.BlockSize != tt.wantBlock {
+			t.Errorf("%s: block size %d, want %d", tt.name, d.BlockSize, tt.wantBlock)
+		}
+	}
+
+	// The block size used is only reported in verbose mode.
+	d, err := statMount(context.Background(), fakeMount(t), log.New(io.Discard, "", 0), Config{})
+	if err != nil || d.BlockSize != 0 {
+		t.Errorf("without -verbose: block size %d, %v", d.BlockSize, err)
+	}
+}
+
+func TestStatfsRetry(t *testing.T) {
+	ok := syscall.Statfs_t{Bsize: 4096, Blocks: 100, Bfree: 50, Bavail: 50}
+	tests := []struct {
+		name     string
+		errs     []error // returned by the calls in turn, then success
+		attempts int
+		fail     bool
+	}{
+		{"first try", nil, 1, false},
+		{"EINTR twice", []error{syscall.EINTR, syscall.EINTR}, 3, false},
+		{"EAGAIN once", []error{syscall.EAGAIN}, 2, false},
+		{"always EINTR", []error{syscall.EINTR, syscall.EINTR, syscall.EINTR, syscall.EINTR}, statfsAttempts, true},
+		{"ENOENT", []error{syscall.ENOENT}, 1, true},
+		{"EACCES", []error{syscall.EACCES}, 1, true},
+		{"EIO after EINTR", []error{syscall.EINTR, syscall.EIO}, 2, true},
+	}
+	for _, tt := range tests {
+		calls := 0
+		fakeStatfs(t, func(path string, s *syscall.Statfs_t) error {
+			calls++
+			if calls <= len(tt.errs) {
+				return tt.errs[calls-1]
+			}
+			*s = ok
+			return nil
+		})
+		var s syscall.Statfs_t
+		attempts, err := statfsRetry("/mnt", &s)
+		if attempts != tt.attempts || calls != tt.attempts || (err != nil) != tt.fail {
+			t.Errorf("%s: %d attempt(s), %d call(s), err %v; want %d, fail %v", tt.name, attempts, calls, err, tt.attempts, tt.fail)
+		}
+		if !tt.fail && s.Blocks != ok.Blocks {
+			t.Errorf("%s: result not filled in", tt.name)
+		}
+	}
+}
+
+func TestStatMountRetryLog(t *testing.T) {
+	calls, failing := 0, 2
+	fakeStatfs(t, func(path string, s *syscall.Statfs_t) error {
+		if calls++; calls <= failing {
+			return syscall.EINTR
+		}
+		*s = syscall.Statfs_t{Bsize: 4096, Blocks: 100, Bfree: 50, Bavail: 50}
+		return nil
+	})
+	var buf bytes.Buffer
+	m := fakeMount(t)
+	d, err := statMount(context.Background(), m, log.New(&buf, "", 0), Config{Verbose: true})
+	if err != nil || d.Total != 100*4096 {
+		t.Fatalf("statMount = %+v, %v", d, err)
+	}
+	if want := "Statfs " + m.MountPoint + " succeeded after 3 attempts"; !strings.Contains(buf.String(), want) {
+		t.Errorf("-v log %q lacks %q", buf.String(), want)
+	}
+
+	calls, failing = 0, statfsAttempts
+	buf.Reset()
+	if _, err := statMount(context.Background(), m, log.New(&buf, "", 0), Config{}); err == nil {
+		t.Fatal("statMount succeeded on a mount that always fails")
+	}
+	if want := "after 3 attempt(s): interrupted system call"; !strings.Contains(buf.String(), want) {
+		t.Errorf("warning %q lacks %q", buf.String(), want)
+	}
+}
+
+func TestOverlayUpperCollector(t *testing.T) {
+	fakeStatfs(t, func(path string, s *syscall.Statfs_t) error {
+		if path != "/var/lib/containers/upper" {
+			return syscall.ENOENT
+		}
+		*s = syscall.Statfs_t{Bsize: 4096, Frsize: 4096, Blocks: 1000, Bavail: 250}
+		return nil
+	})
+	m := mountinfo.Mount{FSType: "overlay", SuperOptions: "rw,lowerdir=/l,upperdir=/var/lib/containers/upper,workdir=/w"}
+	var d FS
+	if err := (overlayUpperCollector{}).Enrich(context.Background(), m, &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.UpperDir != "/var/lib/containers/upper" || d.UpperTotal != 4096000 || d.UpperFree != 1024000 || d.UpperUsage != 75 {
+		t.Errorf("upper %q total %d free %d usage %.1f", d.UpperDir, d.UpperTotal, d.UpperFree, d.UpperUsage)
+	}
+
+	// A read-only overlay has no upperdir to look at.
+	d = FS{}
+	m.SuperOptions = "ro,lowerdir=/l1:/l2"
+	if err := (overlayUpperCollector{}).Enrich(context.Background(), m, &d); err != nil || d.UpperDir != "" {
+		t.Errorf("read-only overlay: err %v, upper %q", err, d.UpperDir)
+	}
+
+	m.SuperOptions = "rw,upperdir=/gone"
+	if err := (overlayUpperCollector{}).Enrich(context.Background(), m, &d); err == nil {
+		t.Error("missing upperdir: no error")
+	}
+}
+
+// TestOverlayUpperEscaped checks an upperdir with a space, which the mount
+// table escapes as \040, is found under -root and does not overflow.
+func TestOverlayUpperEscaped(t *testing.T) {
+	mountRoot = "/host"
+	defer func() { mountRoot = "" }()
+	fakeStatfs(t, func(path string, s *syscall.Statfs_t) error {
+		if path != "/host/var/lib/my containers/upper" {
+			return syscall.ENOENT
+		}
+		*s = syscall.Statfs_t{Bsize: 4096, Blocks: 1 << 53, Bavail: 1 << 51}
+		return nil
+	})
+	m := mountinfo.Mount{FSType: "overlay", SuperOptions: `rw,lowerdir=/l,upperdir=/var/lib/my\040containers/upper,workdir=/w`}
+	var d FS
+	if err := (overlayUpperCollector{}).Enrich(context.Background(), m, &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.UpperDir != "/var/lib/my containers/upper" || d.UpperTotal != math.MaxUint64 || d.UpperUsage != 75 {
+		t.Errorf("upper %q total %d usage %.1f", d.UpperDir, d.UpperTotal, d.UpperUsage)
+	}
+}
+
+func TestStatMountErrors(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	tests := []struct {
+		errno syscall.Errno
+		cause error
+	}{
+		{syscall.ESTALE, errMountGone},
+		{syscall.ENOTCONN, errMountGone},
+		{syscall.ETIMEDOUT, errTimeout},
+		{syscall.EIO, nil},
+	}
+	for _, tt := range tests {
+		fakeStatfs(t, func(path string, s *syscall.Statfs_t) error { return tt.errno })
+		m := fakeMount(t)
+		_, err := statMount(context.Background(), m, logger, Config{})
+		var me *MountError
+		if !errors.As(err, &me) || me.Mount != m.MountPoint || !errors.Is(err, tt.errno) {
+			t.Errorf("%v: statMount error %v", tt.errno, err)
+		}
+		if tt.cause != nil && !errors.Is(err, tt.cause) {
+			t.Errorf("%v: not %v", tt.errno, tt.cause)
+		}
+	}
+
+	// Root gets the error; anyone else a denied entry.
+	fakeStatfs(t, func(path string, s *syscall.Statfs_t) error { return syscall.EACCES })
+	d, err := statMount(context.Background(), fakeMount(t), logger, Config{})
+	if os.Geteuid() == 0 {
+		if !errors.Is(err, errPermission) {
+			t.Errorf("EACCES as root: %v", err)
+		}
+	} else if err != nil || !d.Denied {
+		t.Errorf("EACCES: entry %+v, error %v", d, err)
+	}
+}
+
+// TestStatMountOverflow feeds statMount sizes at and beyond 2^64 bytes.
+func TestStatMountOverflow(t *testing.T) {
+	tests := []struct {
+		name           string
+		blocks, bavail uint64
+		clamped        bool
+		usage          float64
+	}{
+		{"one block below", 1<<52 - 1, 1<<51 - 1, false, 50},
+		{"exactly 2^64", 1 << 52, 1 << 50, true, 75},
+		{"nearly all free", 1 << 52, 1<<52 - 1, true, 0},
+		{"max blocks", math.MaxUint64, math.MaxUint64 / 4, true, 75},
+		{"over-free", 1 << 53, 1 << 54, true, 0},
+	}
+	for _, tt := range tests {
+		fakeStatfs(t, func(path string, s *syscall.Statfs_t) error {
+			*s = syscall.Statfs_t{Bsize: 4096, Blocks: tt.blocks, Bfree: tt.bavail, Bavail: tt.bavail, Files: 100, Ffree: 40}
+			return nil
+		})
+		var logged bytes.Buffer
+		d, err := statMount(context.Background(), fakeMount(t), log.New(&logged, "", 0), Config{})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if d.Clamped != tt.clamped || strings.Contains(logged.String(), "clamping") != tt.clamped {
+			t.Errorf("%s: clamped %v, log %q", tt.name, d.Clamped, &logged)
+		}
+		if d.Free > d.Total || d.Used > d.Total {
+			t.Errorf("%s: total %d, used %d, free %d", tt.name, d.Total, d.Used, d.Free)
+		}
+		if math.Abs(d.Usage-tt.usage) > 0.01 {
+			t.Errorf("%s: usage %.4f, want %.0f", tt.name, d.Usage, tt.usage)
+		}
+		if tt.clamped && (d.TotalBlocks != tt.blocks || d.AvailBlocks != tt.bavail || d.BlockSize != 4096) {
+			t.Errorf("%s: blocks %d/%d of %d not kept", tt.name, d.TotalBlocks, d.AvailBlocks, d.BlockSize)
+		}
+		if !tt.clamped && d.Total != tt.blocks*4096 {
+			t.Errorf("%s: total %d, want %d", tt.name, d.Total, tt.blocks*4096)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+type StatusReport struct {
+	Worst      string            `json:"worst"`
+	Violations []StatusViolation `json:"violations"`
+}
+
+type StatusViolation struct {
+	Mount  string  `json:"mount"`
+	Kind   string  `json:"kind"`
+	Usage  float64 `json:"usage"`
+	Status string  `json:"status"`
+	// GraceSeconds is what is left of a quota's grace period.
+	GraceSeconds *int64 `json:"grace_seconds,omitempty"`
+}
+
+// openStatusFD checks that fd is open for writing so a bad -status-fd is
+// reported before any collection work is done.
+func openStatusFD(fd int) (*os.File, error) {
+	flags, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_GETFL, 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("status fd %d: %v", fd, errno)
+	}
+	if flags&syscall.O_ACCMODE == syscall.O_RDONLY {
+		return nil, fmt.Errorf("status fd %d: not open for writing", fd)
+	}
+	return os.NewFile(uintptr(fd), fmt.Sprintf("fd%d", fd)), nil
+}
+
+func statusReport(report Report, config Config) StatusReport {
+	r := StatusReport{Worst: worstStatus(report, config).String(), Violations: []StatusViolation{}}
+	for _, d := range report.Filesystems {
+		if d.Ignored {
+			continue
+		}
+		for _, c := range failedChecks(d, config) {
+			v := StatusViolation{Mount: d.Mount, Kind: c.Kind, Usage: c.Usage, Status: c.Status.String()}
+			if c.Kind == "quota" {
+				v.Status, v.GraceSeconds = d.Quota.statusName(), d.Quota.GraceSeconds
+			}
+			r.Violations = append(r.Violations, v)
+		}
+	}
+	for _, g := range report.Groups {
+		if st := groupStatus(g); st != StatusOK {
+			r.Violations = append(r.Violations, StatusViolation{Mount: g.Name, Kind: "group", Usage: g.Usage, Status: st.String()})
+		}
+	}
+	return r
+}
+
+// worstStatus is the worst state among the report's filesystems, ignored
+// ones aside, and its groups. The status object and the exit code both
+// come from it, so they always agree.
+func worstStatus(report Report, config Config) Status {
+	worst := StatusOK
+	for _, d := range report.Filesystems {
+		if !d.Ignored {
+			worst = max(worst, fsStatus(d, config))
+		}
+	}
+	for _, g := range report.Groups {
+		worst = max(worst, groupStatus(g))
+	}
+	return worst
+}
+
+// exitCode adds the worst state to code, the result of the outputs and
+// -baseline-strict, in the Nagios convention: 1 for a warning, 2 for
+// critical. Critical takes precedence over the 3 of an output that could
+// not be written, so a failed sink never hides it.
+func exitCode(code int, worst Status) int {
+	switch worst {
+	case StatusCritical:
+		return 2
+	case StatusWarning:
+		return max(code, 1)
+	}
+	return code
+}
+
+func writeStatus(f *os.File, r Report, config Config) error {
+	return json.NewEncoder(f).Encode(statusReport(r, config))
+}
+
+func muted(d FS) bool {
+	return d.Thresholds != nil && d.Thresholds.Muted
+}
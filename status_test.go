@@ -0,0 +1,128 @@
+package main
+
+import "testing"
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		code  int
+		worst Status
+		want  int
+	}{
+		{0, StatusOK, 0},
+		{0, StatusWarning, 1},
+		{0, StatusCritical, 2},
+		{1, StatusOK, 1}, // -baseline-strict drift
+		{1, StatusCritical, 2},
+		{3, StatusOK, 3}, // an output could not be written
+		{3, StatusWarning, 3},
+		{3, StatusCritical, 2},
+	}
+	for _, tt := range tests {
+		if got := exitCode(tt.code, tt.worst); got != tt.want {
+			t.Errorf("exitCode(%d, %v) = %d, want %d", tt.code, tt.worst, got, tt.want)
+		}
+	}
+}
+
+func TestWorstStatus(t *testing.T) {
+	config := Config{WarnThreshold: 80, CritThreshold: 90, InodeWarn: 100, InodeCrit: 100}
+	tests := []struct {
+		name string
+		list []FS
+		want Status
+	}{
+		{"empty", nil, StatusOK},
+		{"ok", []FS{{Mount: "/", Usage: 50}}, StatusOK},
+		{"warning", []FS{{Mount: "/", Usage: 50}, {Mount: "/var", Usage: 85}}, StatusWarning},
+		{"critical", []FS{{Mount: "/", Usage: 95}, {Mount: "/var", Usage: 85}}, StatusCritical},
+		{"ignored", []FS{{Mount: "/", Usage: 95, Ignored: true}}, StatusOK},
+		{"denied", []FS{{Mount: "/", Denied: true}}, StatusOK},
+	}
+	for _, tt := range tests {
+		if got := worstStatus(Report{Filesystems: tt.list}, config); got != tt.want {
+			t.Errorf("%s: worstStatus = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStatusReportKinds(t *testing.T) {
+	config := Config{WarnThreshold: 80, CritThreshold: 90, InodeWarn: 80, InodeCrit: 90,
+		ThinWarn: 80, ThinCrit: 90, RateWarn: rateLimit{Bytes: 100}}
+	grace := int64(3600)
+	tests := []struct {
+		kind   string
+		d      FS
+		status string
+	}{
+		{"usage", FS{Usage: 95}, "critical"},
+		{"upper", FS{UpperDir: "/upper", UpperUsage: 85}, "warning"},
+		{"effective", FS{OverlayDepth: 1, EffectiveUsage: 95}, "critical"},
+		{"pool", FS{Pool: &ThinPool{Name: "pool", DataUsage: 85}}, "warning"},
+		{"inodes", FS{Inodes: 100, InodeUsage: 95}, "critical"},
+		{"fat-root", FS{FATRoot: &FATRoot{Entries: 500, Limit: 512}}, "critical"},
+		{"rapid-growth", FS{Growth: &Growth{WindowRate: 200, WindowSeconds: 600}}, "warning"},
+		{"headroom", FS{Jobs: []JobFit{{Name: "backup"}}}, "warning"},
+		{"quota", FS{Quota: &Quota{Used: 90, Limit: 100, Soft: 80, GraceSeconds: &grace}}, "grace"},
+		{"fs-errors", FS{ErrorsCount: 1}, "critical"},
+	}
+	for _, tt := range tests {
+		tt.d.Mount = "/" + tt.kind
+		r := statusReport(Report{Filesystems: []FS{tt.d}}, config)
+		if len(r.Violations) != 1 {
+			t.Errorf("%s: violations = %+v, want one", tt.kind, r.Violations)
+			continue
+		}
+		v := r.Violations[0]
+		if v.Kind != tt.kind || v.Status != tt.status || v.Mount != tt.d.Mount {
+			t.Errorf("%s: violation = %+v, want kind %s, status %s", tt.kind, v, tt.kind, tt.status)
+		}
+		want := tt.status
+		if want == "grace" {
+			want = "warning"
+		}
+		if r.Worst != want {
+			t.Errorf("%s: worst = %s, want %s", tt.kind, r.Worst, want)
+		}
+	}
+}
+
+func TestStatusReportMuted(t *testing.T) {
+	config := Config{WarnThreshold: 80, CritThreshold: 90, InodeWarn: 80, InodeCrit: 90, ThinWarn: 80, ThinCrit: 90}
+	d := FS{Mount: "/", Usage: 95, Inodes: 100, InodeUsage: 95, ErrorsCount: 1,
+		Pool: &ThinPool{Name: "pool", DataUsage: 95}, Thresholds: &AppliedThresholds{Muted: true}}
+	r := statusReport(Report{Filesystems: []FS{d}}, config)
+	if len(r.Violations) != 1 || r.Violations[0].Kind != "pool" || r.Worst != "critical" {
+		t.Errorf("muted: %+v, want only the pool, critical", r)
+	}
+}
+
+func TestStatusReportThinPool(t *testing.T) {
+	config := Config{WarnThreshold: 80, CritThreshold: 90, ThinWarn: 80, ThinCrit: 90}
+	tests := []struct {
+		name string
+		pool ThinPool
+		want string
+	}{
+		{"fits", ThinPool{Name: "pool", DataUsage: 50, MetaUsage: 10}, "ok"},
+		{"data", ThinPool{Name: "pool", DataUsage: 92, MetaUsage: 10}, "critical"},
+		{"metadata", ThinPool{Name: "pool", DataUsage: 10, MetaUsage: 85}, "warning"},
+		{"out of space", ThinPool{Name: "pool", DataUsage: 50, Mode: "out_of_data_space"}, "critical"},
+	}
+	for _, tt := range tests {
+		// The filesystem itself looks fine; only its pool is judged.
+		d := FS{Mount: "/thin", Usage: 20, Pool: &tt.pool}
+		r := statusReport(Report{Filesystems: []FS{d}}, config)
+		if r.Worst != tt.want {
+			t.Errorf("%s: worst = %s, want %s", tt.name, r.Worst, tt.want)
+		}
+		if tt.want == "ok" {
+			if len(r.Violations) != 0 {
+				t.Errorf("%s: violations = %+v", tt.name, r.Violations)
+			}
+			continue
+		}
+		if len(r.Violations) != 1 || r.Violations[0].Kind != "pool" || r.Violations[0].Usage != tt.pool.Usage() {
+			t.Errorf("%s: violations = %+v, want one of kind pool", tt.name, r.Violations)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// runStream prints each mount as soon as it is collected, in mount table
+// order, so memory does not grow with the number of mounts. Features that
+// need the whole list (sorting, dedup, the tree, baselines) do not apply.
+// JSON output is always NDJSON, ending with a {"summary": ...} record.
+func runStream(ctx context.Context, config Config, fileConfig FileConfig, logger *log.Logger) error {
+	config.Collectors = withPlugins(config.Collectors, fileConfig.Plugins)
+	mounts, err := readMounts()
+	if err != nil {
+		return err
+	}
+	filtered := filterFuse(filterMounts(mounts, config.ExcludeTypes, nil), config.Fuse, nil)
+	suppressed := 0
+	if !config.ShowAll {
+		filtered, suppressed = filterFileBinds(filtered, map[string]bool{}, nil)
+	}
+	now := time.Now().In(config.Location)
+
+	enc := json.NewEncoder(os.Stdout)
+	switch config.OutputFormat {
+	case "csv":
+		printCSVHeader(os.Stdout, config)
+	case "json":
+	default:
+		printTableHeader()
+	}
+
+	var s Summary
+	for _, m := range filtered {
+		if ctx.Err() != nil {
+			break
+		}
+		d, err := statMount(ctx, m, logger, config)
+		if err != nil {
+			continue
+		}
+		if config.SanitizeFields {
+			sanitizeFields(&d)
+		}
+		one := []FS{d}
+		applyRules(one, fileConfig, config, now)
+		d = one[0]
+		s.add(d, config)
+
+		switch config.OutputFormat {
+		case "csv":
+			printCSVRow(os.Stdout, d, config)
+		case "json":
+			if config.HumanExplicit {
+				d = withHuman(one)[0]
+			}
+			if err := enc.Encode(d); err != nil {
+				return err
+			}
+		default:
+			printTableRow(d, d.Device, config)
+		}
+	}
+
+	if config.NoSummary {
+		return nil
+	}
+	switch config.OutputFormat {
+	case "json":
+		return enc.Encode(struct {
+			Summary Summary `json:"summary"`
+		}{s})
+	case "table":
+		fmt.Println()
+		fmt.Println(s.String(config.HumanReadable))
+		if suppressed > 0 {
+			fmt.Printf(T("file_binds")+"\n", suppressed)
+		}
+	}
+	return nil
+}
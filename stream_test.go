@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/metrics"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AScotM/filesystem_cap/fscap/mountinfo"
+)
+
+// fakeMountTable makes n mounts, each on its own directory under a
+// temporary root, and has readMounts return them.
+func fakeMountTable(tb testing.TB, n int) {
+	tb.Helper()
+	root := tb.TempDir()
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		dir := filepath.Join(root, fmt.Sprintf("auto%05d", i))
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			tb.Fatal(err)
+		}
+		fmt.Fprintf(&sb, "%d 1 0:%d / %s rw,relatime shared:1 - autofs systemd-%d rw,fd=%d\n", 100+i, 100+i, dir, i, i)
+	}
+	mounts, err := mountinfo.ParseReader(strings.NewReader(sb.String()))
+	if err != nil || len(mounts) != n {
+		tb.Fatalf("fixture: %d mounts, %v", len(mounts), err)
+	}
+	fakeSelfMounts(tb, mounts)
+}
+
+// fakeSelfMounts has readMounts return mounts.
+func fakeSelfMounts(tb testing.TB, mounts []mountinfo.Mount) {
+	tb.Helper()
+	saved := selfMounts
+	selfMounts = func() ([]mountinfo.Mount, error) { return mounts, nil }
+	tb.Cleanup(func() { selfMounts = saved })
+}
+
+// peakHeap samples the heap until stop is called and returns the most it
+// saw in use.
+func peakHeap() (stop func() uint64) {
+	var peak atomic.Uint64
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	go func() {
+		defer close(finished)
+		for {
+			metrics.Read(sample)
+			if v := sample[0].Value.Uint64(); v > peak.Load() {
+				peak.Store(v)
+			}
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}()
+	return func() uint64 {
+		close(done)
+		<-finished
+		return peak.Load()
+	}
+}
+
+// BenchmarkStream compares -stream with the default collect-then-render
+// path on a 20k-mount table, as after an automount storm. peak-heap-B is
+// the most heap in use during a run; -stream's stays near the size of the
+// mount table itself, the default path's grows with every entry kept.
+func BenchmarkStream(b *testing.B) {
+	if !liveCollection {
+		b.Skip("no live collection on this platform")
+	}
+	fakeMountTable(b, 20000)
+	stdout := os.Stdout
+	devnull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	os.Stdout = devnull
+	b.Cleanup(func() {
+		os.Stdout = stdout
+		devnull.Close()
+	})
+
+	config := Config{OutputFormat: "table", Fuse: "all", ShowAll: true, Location: time.UTC,
+		WarnThreshold: 100, CritThreshold: 100, InodeWarn: 100, InodeCrit: 100}
+	logger := log.New(io.Discard, "", 0)
+	ctx := context.Background()
+
+	run := func(b *testing.B, fn func() error) {
+		b.ReportAllocs()
+		var peak uint64
+		for i := 0; i < b.N; i++ {
+			stop := peakHeap()
+			if err := fn(); err != nil {
+				b.Fatal(err)
+			}
+			peak = max(peak, stop())
+		}
+		b.ReportMetric(float64(peak), "peak-heap-B")
+	}
+	b.Run("stream", func(b *testing.B) {
+		run(b, func() error { return runStream(ctx, config, FileConfig{}, logger) })
+	})
+	b.Run("collect", func(b *testing.B) {
+		run(b, func() error {
+			r, err := collect(ctx, config, FileConfig{}, logger)
+			if err != nil {
+				return err
+			}
+			report(ctx, r, config, FileConfig{}, nil, logger)
+			return nil
+		})
+	})
+}
+
+// TestStreamRules checks -stream applies the same config rules as a
+// collection does, annotations and job fits included.
+func TestStreamRules(t *testing.T) {
+	if !liveCollection {
+		t.Skip("no live collection on this platform")
+	}
+	fakeMountTable(t, 3)
+	mounts, _ := selfMounts()
+	root := filepath.Dir(mounts[0].MountPoint)
+	config := Config{OutputFormat: "json", Fuse: "all", ShowAll: true, Location: time.UTC,
+		WarnThreshold: 100, CritThreshold: 100, InodeWarn: 100, InodeCrit: 100}
+	fileConfig := FileConfig{
+		Annotations: []AnnotationRule{{Match: root + "/auto00001", Set: map[string]string{"team": "storage"}}},
+		Jobs:        []JobConfig{{Name: "backup", Match: root + "/auto0000[02]", Size: "1K", At: "02:00"}},
+	}
+	logger := log.New(io.Discard, "", 0)
+
+	r, err := collect(context.Background(), config, fileConfig, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]FS{}
+	for _, d := range r.Filesystems {
+		want[d.Mount] = d
+	}
+	out := captureStdout(t, func() {
+		if err := runStream(context.Background(), config, fileConfig, logger); err != nil {
+			t.Error(err)
+		}
+	})
+	dec := json.NewDecoder(strings.NewReader(out))
+	seen := 0
+	for dec.More() {
+		var d FS
+		if err := dec.Decode(&d); err != nil {
+			t.Fatal(err)
+		}
+		w, ok := want[d.Mount]
+		if !ok {
+			continue
+		}
+		seen++
+		if fmt.Sprint(d.Annotations) != fmt.Sprint(w.Annotations) || len(d.Jobs) != len(w.Jobs) {
+			t.Errorf("%s: stream has annotations %v, %d jobs; collect %v, %d jobs", d.Mount, d.Annotations, len(d.Jobs), w.Annotations, len(w.Jobs))
+		}
+		if strings.HasSuffix(d.Mount, "/auto00001") != (d.Annotations["team"] == "storage") {
+			t.Errorf("%s: annotations %v", d.Mount, d.Annotations)
+		}
+		if strings.HasSuffix(d.Mount, "/auto00001") == (len(d.Jobs) == 1) {
+			t.Errorf("%s: jobs %+v", d.Mount, d.Jobs)
+		}
+	}
+	if seen != 3 {
+		t.Errorf("stream printed %d of the 3 mounts:\n%s", seen, out)
+	}
+}
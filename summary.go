@@ -0,0 +1,81 @@
+package main
+
+import "fmt"
+
+// Summary aggregates a report by threshold band. Filesystems and the
+// status counts are per mount row, but the sizes count each filesystem
+// once however many times it is mounted; Distinct is how many that is.
+type Summary struct {
+	Filesystems int     `json:"filesystems"`
+	Distinct    int     `json:"distinct"`
+	OK          int     `json:"ok"`
+	Warning     int     `json:"warning"`
+	Critical    int     `json:"critical"`
+	Ignored     int     `json:"ignored"`
+	Denied      int     `json:"denied,omitempty"`
+	Total       uint64  `json:"total"`
+	Used        uint64  `json:"used"`
+	Free        uint64  `json:"free"`
+	Usage       float64 `json:"usage"`
+
+	seen map[string]bool
+}
+
+func summarize(list []FS, config Config) Summary {
+	var s Summary
+	for _, d := range list {
+		s.add(d, config)
+	}
+	return s
+}
+
+// add counts one filesystem into s, keeping Usage current.
+func (s *Summary) add(d FS, config Config) {
+	if d.Denied {
+		s.Denied++
+		return
+	}
+	s.Filesystems++
+	if s.seen == nil {
+		s.seen = map[string]bool{}
+	}
+	if key := fsKey(d); !s.seen[key] {
+		s.seen[key] = true
+		s.Distinct++
+		s.Total = addClamp(s.Total, d.Total)
+		s.Used = addClamp(s.Used, d.Used)
+		s.Free = addClamp(s.Free, d.Free)
+		if s.Total > 0 {
+			s.Usage = float64(s.Used) / float64(s.Total) * 100
+		}
+	}
+
+	if d.Ignored {
+		s.Ignored++
+		return
+	}
+	switch fsStatus(d, config) {
+	case StatusCritical:
+		s.Critical++
+	case StatusWarning:
+		s.Warning++
+	default:
+		s.OK++
+	}
+}
+
+func (s Summary) String(humanReadable bool) string {
+	ignored := ""
+	if s.Ignored > 0 {
+		ignored = fmt.Sprintf(T("summary.ignored"), s.Ignored)
+	}
+	if s.Denied > 0 {
+		ignored += fmt.Sprintf(T("summary.denied"), s.Denied)
+	}
+	if s.Distinct != s.Filesystems {
+		ignored += fmt.Sprintf(T("summary.distinct"), s.Distinct)
+	}
+	return fmt.Sprintf(T("summary"),
+		s.Filesystems, s.OK, s.Warning, s.Critical, ignored,
+		fmtBytes(s.Total, humanReadable), s.Usage)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestSummaryAgreesWithExitCode checks the footer's bands against the
+// evaluation behind the exit code, per-mount threshold rules included.
+func TestSummaryAgreesWithExitCode(t *testing.T) {
+	config := Config{WarnThreshold: 80, CritThreshold: 90, InodeWarn: 100, InodeCrit: 100}
+	list := []FS{
+		{Mount: "/", Device: "a", Total: 100, Used: 50, Usage: 50},
+		{Mount: "/var", Device: "b", Total: 100, Used: 85, Usage: 85},
+		// A rule raising the limits keeps /srv ok at 95%.
+		{Mount: "/srv", Device: "c", Total: 100, Used: 95, Usage: 95, Thresholds: &AppliedThresholds{Rule: "srv", Warn: 97, Crit: 99}},
+		// A rule lowering them makes /data critical at 60%.
+		{Mount: "/data", Device: "d", Total: 100, Used: 60, Usage: 60, Thresholds: &AppliedThresholds{Rule: "data", Warn: 50, Crit: 60}},
+		{Mount: "/tmp", Device: "e", Total: 100, Used: 99, Usage: 99, Ignored: true},
+		{Mount: "/mnt", Device: "f", Denied: true},
+	}
+	s := summarize(list, config)
+	got := [...]int{s.Filesystems, s.OK, s.Warning, s.Critical, s.Ignored, s.Denied}
+	if want := [...]int{5, 2, 1, 1, 1, 1}; got != want {
+		t.Errorf("summarize filesystems, ok, warning, critical, ignored, denied = %v, want %v", got, want)
+	}
+	if s.Total != 500 || s.Used != 389 {
+		t.Errorf("summarize total, used = %d, %d; want 500, 389", s.Total, s.Used)
+	}
+	if got := exitCode(0, worstStatus(Report{Filesystems: list}, config)); got != 2 {
+		t.Errorf("exit code = %d, want 2 for the critical mount the summary counts", got)
+	}
+
+	list[3].Thresholds = nil
+	if s := summarize(list, config); s.Critical != 0 || worstStatus(Report{Filesystems: list}, config) != StatusWarning {
+		t.Errorf("without the /data rule: %d critical, worst %v; want 0 and warning", s.Critical, worstStatus(Report{Filesystems: list}, config))
+	}
+}
+
+// subvolumeFixture is one btrfs filesystem mounted three times, as @, @home
+// and @snapshots, and an independent ext4 filesystem.
+func subvolumeFixture() []FS {
+	const tb = 1 << 40
+	btrfs := func(mount, subvol string) FS {
+		return FS{Mount: mount, Device: "/dev/nvme0n1p2", Type: "btrfs", Dev: "0:35", Fsid: "a1b2c3d4e5f60718",
+			Subtree: "subvol=" + subvol, Total: 2 * tb, Used: tb, Free: tb, Usage: 50}
+	}
+	return []FS{
+		btrfs("/", "/@"),
+		btrfs("/home", "/@home"),
+		btrfs("/.snapshots", "/@snapshots"),
+		{Mount: "/srv", Device: "/dev/sdb1", Type: "ext4", Dev: "8:17", Fsid: "0011223344556677",
+			Total: tb, Used: tb / 4, Free: 3 * tb / 4, Usage: 25},
+	}
+}
+
+func TestSummaryDistinct(t *testing.T) {
+	const tb = 1 << 40
+	config := Config{WarnThreshold: 80, CritThreshold: 90, InodeWarn: 100, InodeCrit: 100}
+	s := summarize(subvolumeFixture(), config)
+	if s.Filesystems != 4 || s.Distinct != 2 || s.OK != 4 {
+		t.Errorf("filesystems %d, distinct %d, ok %d; want 4, 2, 4", s.Filesystems, s.Distinct, s.OK)
+	}
+	if s.Total != 3*tb || s.Used != tb+tb/4 || s.Free != tb+3*tb/4 {
+		t.Errorf("total %d, used %d, free %d; want two filesystems' worth", s.Total, s.Used, s.Free)
+	}
+	if want := float64(tb+tb/4) / float64(3*tb) * 100; s.Usage != want {
+		t.Errorf("usage %.2f, want %.2f", s.Usage, want)
+	}
+	if line := s.String(true); !strings.Contains(line, "2 distinct filesystems") || !strings.HasPrefix(line, "4 filesystems") {
+		t.Errorf("footer %q", line)
+	}
+	if line := summarize(subvolumeFixture()[2:], config).String(true); strings.Contains(line, "distinct") {
+		t.Errorf("footer %q mentions distinct filesystems without a shared one", line)
+	}
+}
+
+func TestFSKey(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b FS
+		same bool
+	}{
+		// btrfs gives each subvolume its own fsid; the device number is
+		// shared.
+		{"subvolumes", FS{Mount: "/", Dev: "0:35", Fsid: "1"}, FS{Mount: "/home", Dev: "0:35", Fsid: "2"}, true},
+		{"devices", FS{Mount: "/", Dev: "8:1"}, FS{Mount: "/srv", Dev: "8:17"}, false},
+		{"uuid", FS{Mount: "/a", Identity: "uuid:x"}, FS{Mount: "/b", Identity: "uuid:x"}, true},
+		{"fsid", FS{Mount: "/a", Fsid: "00ff"}, FS{Mount: "/b", Fsid: "00ff"}, true},
+		// A zero fsid says nothing.
+		{"zero fsid", FS{Mount: "/a", Fsid: "0000000000000000"}, FS{Mount: "/b", Fsid: "0000000000000000"}, false},
+		{"nothing", FS{Mount: "/a"}, FS{Mount: "/b"}, false},
+	}
+	for _, tt := range tests {
+		if same := fsKey(tt.a) == fsKey(tt.b); same != tt.same {
+			t.Errorf("%s: same filesystem = %v, want %v", tt.name, same, tt.same)
+		}
+	}
+}
+
+func TestDedupSubvolumes(t *testing.T) {
+	out := dedup(subvolumeFixture(), nil)
+	if len(out) != 2 {
+		t.Fatalf("%d entries, want 2", len(out))
+	}
+	if out[0].Mount != "/" || len(out[0].Children) != 2 || out[1].Mount != "/srv" {
+		t.Errorf("dedup: %s with %d children, then %s", out[0].Mount, len(out[0].Children), out[1].Mount)
+	}
+}
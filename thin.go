@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// ThinPool is the LVM/device-mapper thin pool a filesystem's volume draws
+// from. A pool can promise its volumes more than it holds, so a
+// filesystem with plenty of free space can still fail writes once the pool
+// runs out.
+type ThinPool struct {
+	Name      string  `json:"name"`
+	DataUsage float64 `json:"data_usage"`
+	MetaUsage float64 `json:"metadata_usage"`
+	// Mode is rw, ro or out_of_data_space as the kernel reports it.
+	Mode string `json:"mode,omitempty"`
+}
+
+// Usage is the fuller of the pool's data and metadata areas; running out
+// of either stops writes.
+func (p ThinPool) Usage() float64 {
+	return max(p.DataUsage, p.MetaUsage)
+}
+
+// applyThin sets the pool of every filesystem on a thin volume, from
+// dmsetup's view of the device-mapper tables, and with snapshots the
+// space held by the volume's thin snapshots.
+func applyThin(ctx context.Context, list []FS, snapshots bool) error {
+	info, err := dmsetup(ctx, "info", "-c", "--noheadings", "--separator", " ", "-o", "name,major,minor")
+	if err != nil {
+		return err
+	}
+	table, err := dmsetup(ctx, "table")
+	if err != nil {
+		return err
+	}
+	status, err := dmsetup(ctx, "status")
+	if err != nil {
+		return err
+	}
+
+	names := map[string]string{} // major:minor -> name
+	sc := bufio.NewScanner(bytes.NewReader(info))
+	for sc.Scan() {
+		if f := strings.Fields(sc.Text()); len(f) == 3 {
+			names[f[1]+":"+f[2]] = f[0]
+		}
+	}
+
+	// A thin target's first argument is its pool's device.
+	poolOf := map[string]string{} // volume name -> pool name
+	for name, args := range dmTargets(table, "thin") {
+		if len(args) > 0 {
+			poolOf[name] = names[args[0]]
+		}
+	}
+	pools := map[string]ThinPool{}
+	for name, args := range dmTargets(status, "thin-pool") {
+		if p, ok := parsePoolStatus(name, args); ok {
+			pools[name] = p
+		}
+	}
+
+	for i := range list {
+		p, ok := pools[poolOf[names[list[i].Dev]]]
+		if ok {
+			list[i].Pool = &p
+		}
+	}
+	if snapshots {
+		return applyThinSnapshots(ctx, list, names, status)
+	}
+	return nil
+}
+
+func dmsetup(ctx context.Context, args ...string) ([]byte, error) {
+	out, err := exec.CommandContext(ctx, "dmsetup", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("dmsetup %s: %v", args[0], err)
+	}
+	return out, nil
+}
+
+// dmTargets picks the lines of dmsetup table or status output whose
+// target is kind, keyed by device name, returning what follows the
+// target. Only the first segment of each device is looked at.
+func dmTargets(out []byte, kind string) map[string][]string {
+	targets := map[string][]string{}
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		name, rest, ok := strings.Cut(sc.Text(), ": ")
+		f := strings.Fields(rest)
+		if !ok || len(f) < 3 || f[2] != kind {
+			continue
+		}
+		if _, seen := targets[name]; !seen {
+			targets[name] = f[3:]
+		}
+	}
+	return targets
+}
+
+// parsePoolStatus reads "<transaction> <used>/<total> metadata blocks
+// <used>/<total> data blocks <held root> <mode> ...".
+func parsePoolStatus(name string, args []string) (ThinPool, bool) {
+	if len(args) < 3 {
+		return ThinPool{}, false
+	}
+	meta, ok1 := ratio(args[1])
+	data, ok2 := ratio(args[2])
+	if !ok1 || !ok2 {
+		return ThinPool{}, false
+	}
+	p := ThinPool{Name: name, DataUsage: data, MetaUsage: meta}
+	if len(args) > 4 {
+		p.Mode = args[4]
+	}
+	return p, true
+}
+
+func ratio(s string) (float64, bool) {
+	a, b, ok := strings.Cut(s, "/")
+	used, err1 := strconv.ParseUint(a, 10, 64)
+	total, err2 := strconv.ParseUint(b, 10, 64)
+	if !ok || err1 != nil || err2 != nil || total == 0 {
+		return 0, false
+	}
+	return float64(used) / float64(total) * 100, true
+}
+
+// poolStatus is the state of d's thin pool against the -thin thresholds.
+// A pool that has already run out is critical whatever its numbers say.
+func poolStatus(d FS, config Config) Status {
+	if d.Pool == nil {
+		return StatusOK
+	}
+	if d.Pool.Mode == "out_of_data_space" {
+		return StatusCritical
+	}
+	return config.poolLimits().Evaluate(d.Pool.Usage())
+}
+
+func poolLine(d FS, config Config) string {
+	return fmt.Sprintf("    pool: %s data %.1f%% metadata %.1f%% (%s)",
+		sanitize(d.Pool.Name), d.Pool.DataUsage, d.Pool.MetaUsage, poolStatus(d, config))
+}
@@ -0,0 +1,80 @@
+package main
+
+import "fmt"
+
+// Thresholds is a warning and a critical level in percent used. Every
+// threshold check goes through Evaluate so the rules cannot drift apart.
+type Thresholds struct {
+	Warn float64
+	Crit float64
+}
+
+// Validate requires 0 <= Warn <= Crit <= 100.
+func (t Thresholds) Validate() error {
+	switch {
+	case t.Warn < 0 || t.Warn > 100:
+		return fmt.Errorf("warning threshold %g is outside 0-100", t.Warn)
+	case t.Crit < 0 || t.Crit > 100:
+		return fmt.Errorf("critical threshold %g is outside 0-100", t.Crit)
+	case t.Warn > t.Crit:
+		return fmt.Errorf("warning threshold %g is above critical threshold %g", t.Warn, t.Crit)
+	}
+	return nil
+}
+
+// Evaluate classifies usage. A level of 0 means "always": -w 0 reports
+// every filesystem as at least a warning, even an empty one.
+func (t Thresholds) Evaluate(usage float64) Status {
+	switch {
+	case t.Crit == 0 || usage >= t.Crit:
+		return StatusCritical
+	case t.Warn == 0 || usage >= t.Warn:
+		return StatusWarning
+	default:
+		return StatusOK
+	}
+}
+
+func (c Config) blockLimits() Thresholds {
+	return Thresholds{Warn: c.WarnThreshold, Crit: c.CritThreshold}
+}
+
+func (c Config) inodeLimits() Thresholds {
+	return Thresholds{Warn: c.InodeWarn, Crit: c.InodeCrit}
+}
+
+func (c Config) poolLimits() Thresholds {
+	return Thresholds{Warn: c.ThinWarn, Crit: c.ThinCrit}
+}
+
+// checkEffective validates the thresholds each rule ends up with once the
+// levels it leaves unset are taken from the flags, naming the rule.
+func (fc FileConfig) checkEffective(path string, config Config) []error {
+	var errs []error
+	check := func(key string, warn, crit *float64) {
+		t := config.blockLimits()
+		if warn != nil {
+			t.Warn = *warn
+		}
+		if crit != nil {
+			t.Crit = *crit
+		}
+		if err := t.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s: %v", path, key, err))
+		}
+	}
+	for i, r := range fc.Thresholds {
+		key := fmt.Sprintf("thresholds[%d]", i)
+		if r.Name != "" {
+			key += " (" + r.Name + ")"
+		}
+		check(key, r.Warn, r.Crit)
+	}
+	for i, r := range fc.Alerts.Routes {
+		check(fmt.Sprintf("alerts.routes[%d]", i), r.Warn, r.Crit)
+	}
+	for i, g := range fc.Groups {
+		check(fmt.Sprintf("groups[%d] (%s)", i, g.Name), g.Warn, g.Crit)
+	}
+	return errs
+}
@@ -0,0 +1,59 @@
+package main
+
+import "testing"
+
+func TestThresholdsEvaluate(t *testing.T) {
+	tests := []struct {
+		t     Thresholds
+		usage float64
+		want  Status
+	}{
+		{Thresholds{80, 90}, 79.9, StatusOK},
+		{Thresholds{80, 90}, 80, StatusWarning},
+		{Thresholds{80, 90}, 90, StatusCritical},
+		{Thresholds{0, 90}, 0, StatusWarning}, // 0 means always
+		{Thresholds{0, 0}, 0, StatusCritical},
+		{Thresholds{100, 100}, 99.9, StatusOK},
+	}
+	for _, tt := range tests {
+		if got := tt.t.Evaluate(tt.usage); got != tt.want {
+			t.Errorf("%+v.Evaluate(%g) = %v, want %v", tt.t, tt.usage, got, tt.want)
+		}
+	}
+}
+
+// TestInodeThresholds checks that -iw/-ic are evaluated apart from the
+// block thresholds and reach the exit code, the status object and the
+// alerts, and that filesystems without inode accounting are exempt.
+func TestInodeThresholds(t *testing.T) {
+	config := Config{WarnThreshold: 80, CritThreshold: 90, InodeWarn: 90, InodeCrit: 95}
+	tests := []struct {
+		name string
+		d    FS
+		want Status
+	}{
+		{"inodes ok", FS{Mount: "/", Usage: 10, Inodes: 100, InodeUsage: 50}, StatusOK},
+		{"inodes warning", FS{Mount: "/", Usage: 10, Inodes: 100, InodeUsage: 92}, StatusWarning},
+		{"inodes critical", FS{Mount: "/", Usage: 10, Inodes: 100, InodeUsage: 99}, StatusCritical},
+		{"no inode accounting", FS{Mount: "/", Usage: 10, Inodes: 0, InodeUsage: 100, InodeState: inodesNotApplicable}, StatusOK},
+		{"blocks worse", FS{Mount: "/", Usage: 95, Inodes: 100, InodeUsage: 92}, StatusCritical},
+	}
+	for _, tt := range tests {
+		if got := fsStatus(tt.d, config); got != tt.want {
+			t.Errorf("%s: fsStatus = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	d := FS{Device: "/dev/a", Mount: "/var", Usage: 10, Inodes: 100, InodeUsage: 99}
+	r := Report{Filesystems: []FS{d}}
+	if got := exitCode(0, worstStatus(r, config)); got != 2 {
+		t.Errorf("exit code = %d, want 2 for inodes at 99%%", got)
+	}
+	if v := statusReport(r, config).Violations; len(v) != 1 || v[0].Kind != "inodes" || v[0].Status != "critical" {
+		t.Errorf("status violations = %+v, want one critical inodes", v)
+	}
+	routed := routeViolations(r.Filesystems, config, AlertConfig{Default: "pager"})
+	if v := routed["pager"]; len(v) != 1 || v[0].Kind != "inodes" || v[0].Usage != 99 {
+		t.Errorf("alert violations = %+v, want one inodes at 99%%", v)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// tmpfsSizeLimit returns the size cap configured through the size= or
+// nr_blocks= tmpfs options. A zero limit means the mount is unlimited or
+// no cap is set.
+func tmpfsSizeLimit(superOptions string) uint64 {
+	if v := mountOption(superOptions, "size"); v != "" {
+		return parseTmpfsSize(v)
+	}
+	if v := mountOption(superOptions, "nr_blocks"); v != "" {
+		n := parseTmpfsSize(v)
+		return n * uint64(os.Getpagesize())
+	}
+	return 0
+}
+
+// parseTmpfsSize parses a tmpfs size value: a number with an optional
+// k/m/g/t/p/e suffix, or a percentage of physical RAM.
+func parseTmpfsSize(v string) uint64 {
+	if pct, ok := strings.CutSuffix(v, "%"); ok {
+		p, err := strconv.ParseUint(pct, 10, 64)
+		if err != nil {
+			return 0
+		}
+		ram, err := totalRAM()
+		if err != nil {
+			return 0
+		}
+		return ram / 100 * p
+	}
+
+	mult := uint64(1)
+	if i := strings.IndexAny(strings.ToLower(v), "kmgtpe"); i > 0 && i == len(v)-1 {
+		mult = 1 << (10 * uint(strings.IndexByte("kmgtpe", strings.ToLower(v)[i])+1))
+		v = v[:i]
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n * mult
+}
+
+// applyTmpfsCap uses the configured tmpfs size as Total when it is smaller
+// than what statfs reported.
+func applyTmpfsCap(d *FS, superOptions string) {
+	limit := tmpfsSizeLimit(superOptions)
+	if limit == 0 || limit >= d.Total {
+		return
+	}
+	d.Total = limit
+	if d.Used > limit {
+		d.Used = limit
+	}
+	d.Free = limit - d.Used
+	d.Usage = float64(d.Used) / float64(d.Total) * 100
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestTmpfsSizeLimit(t *testing.T) {
+	page := uint64(os.Getpagesize())
+	tests := []struct {
+		options string
+		want    uint64
+	}{
+		{"rw,size=1048576k", 1 << 30},
+		{"rw,size=512m,mode=1777", 512 << 20},
+		{"rw,size=2G", 2 << 30},
+		{"rw,size=4096", 4096},
+		{"rw,nr_blocks=100", 100 * page},
+		{"rw,nr_blocks=1k", 1024 * page},
+		// size= is looked at first.
+		{"rw,size=1m,nr_blocks=100", 1 << 20},
+		// size=0 is unlimited.
+		{"rw,size=0", 0},
+		{"rw,nr_blocks=0", 0},
+		{"rw,mode=1777", 0},
+		{"rw,size=", 0},
+		{"rw,size=lots", 0},
+		{"rw,size=10x", 0},
+		{"rw,size=-1", 0},
+	}
+	for _, tt := range tests {
+		if got := tmpfsSizeLimit(tt.options); got != tt.want {
+			t.Errorf("tmpfsSizeLimit(%q) = %d, want %d", tt.options, got, tt.want)
+		}
+	}
+}
+
+func TestTmpfsSizePercent(t *testing.T) {
+	ram, err := totalRAM()
+	if err != nil {
+		t.Skipf("total RAM unknown: %v", err)
+	}
+	for pct, want := range map[string]uint64{
+		"size=20%":  ram / 100 * 20,
+		"size=50%":  ram / 100 * 50,
+		"size=100%": ram / 100 * 100,
+		"size=0%":   0,
+		"size=x%":   0,
+	} {
+		if got := tmpfsSizeLimit("rw," + pct); got != want {
+			t.Errorf("tmpfsSizeLimit(%q) = %d, want %d", pct, got, want)
+		}
+	}
+}
+
+func TestApplyTmpfsCap(t *testing.T) {
+	tests := []struct {
+		name    string
+		d       FS
+		options string
+		want    FS
+	}{
+		{"capped", FS{Total: 8 << 30, Used: 1 << 30, Free: 7 << 30}, "size=2g",
+			FS{Total: 2 << 30, Used: 1 << 30, Free: 1 << 30, Usage: 50}},
+		{"over the cap", FS{Total: 8 << 30, Used: 3 << 30, Free: 5 << 30}, "size=2g",
+			FS{Total: 2 << 30, Used: 2 << 30, Free: 0, Usage: 100}},
+		{"cap above statfs", FS{Total: 1 << 30, Used: 1 << 29, Free: 1 << 29, Usage: 50}, "size=2g",
+			FS{Total: 1 << 30, Used: 1 << 29, Free: 1 << 29, Usage: 50}},
+		{"unlimited", FS{Total: 8 << 30, Used: 1 << 30, Free: 7 << 30, Usage: 12.5}, "size=0",
+			FS{Total: 8 << 30, Used: 1 << 30, Free: 7 << 30, Usage: 12.5}},
+	}
+	for _, tt := range tests {
+		d := tt.d
+		applyTmpfsCap(&d, tt.options)
+		if d.Total != tt.want.Total || d.Used != tt.want.Used || d.Free != tt.want.Free || d.Usage != tt.want.Usage {
+			t.Errorf("%s: got %d/%d/%d %.1f%%, want %d/%d/%d %.1f%%", tt.name,
+				d.Total, d.Used, d.Free, d.Usage, tt.want.Total, tt.want.Used, tt.want.Free, tt.want.Usage)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type userUsage struct {
+	UID   uint32
+	Name  string
+	Bytes uint64
+	Files uint64
+}
+
+type tmpScan struct {
+	Root         string
+	Users        []userUsage
+	Inaccessible int
+	Truncated    bool
+}
+
+// runTmpUsage implements "dfmon tmp-usage": it totals the space used in
+// temporary directories per owning user.
+func runTmpUsage(ctx context.Context, config Config, args []string, logger *log.Logger) {
+	flags := flag.NewFlagSet("tmp-usage", flag.ExitOnError)
+	depth := flags.Int("depth", 32, "Maximum directory depth to descend")
+	timeout := flags.Duration("timeout", 30*time.Second, "Time limit for each directory scanned")
+	top := flags.Int("n", 10, "Number of users to list")
+	flags.BoolVar(&config.HumanReadable, "h", config.HumanReadable, "Human readable sizes")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: dfmon tmp-usage [-depth N] [-timeout D] [-n N] [PATH...]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	roots := flags.Args()
+	if len(roots) == 0 {
+		roots = []string{"/tmp", "/var/tmp"}
+	}
+
+	for i, root := range roots {
+		res, err := runScan(ctx, scanRequest{Kind: "tmp", Path: filepath.Clean(root), Depth: *depth, Timeout: *timeout})
+		scan := res.Tmp
+		if err != nil {
+			logger.Printf("Warning: %v", err)
+			continue
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		displayTmpScan(scan, *top, config)
+	}
+}
+
+// scanTmp walks root without leaving its filesystem, or counting a
+// bind-mounted subtree twice, and sums the
+// allocated size of each file by owner. Directories that cannot be read,
+// typically other users' private directories under a sticky /tmp, are
+// counted as inaccessible rather than silently skipped.
+func scanTmp(ctx context.Context, root string, depth int, timeout time.Duration) (tmpScan, error) {
+	scan := tmpScan{Root: root}
+	var rootStat syscall.Stat_t
+	if err := syscall.Lstat(root, &rootStat); err != nil {
+		return scan, fmt.Errorf("%s: %v", root, err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	rootDepth := strings.Count(root, string(os.PathSeparator))
+	byUID := map[uint32]*userUsage{}
+	errStop := errors.New("stop")
+
+	w := newFSWalker(uint64(rootStat.Dev))
+	err := w.walk(root, func(path string, de fs.DirEntry, st *syscall.Stat_t, err error) error {
+		if ctx.Err() != nil || time.Now().After(deadline) {
+			scan.Truncated = true
+			return errStop
+		}
+		if err != nil {
+			if os.IsPermission(err) {
+				scan.Inaccessible++
+			}
+			return nil
+		}
+		if de.IsDir() {
+			if strings.Count(path, string(os.PathSeparator))-rootDepth >= depth {
+				scan.Truncated = true
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		u := byUID[st.Uid]
+		if u == nil {
+			u = &userUsage{UID: st.Uid}
+			byUID[st.Uid] = u
+		}
+		u.Bytes += uint64(st.Blocks) * 512
+		u.Files++
+		return nil
+	})
+	if err != nil && err != errStop {
+		return scan, err
+	}
+
+	for _, u := range byUID {
+		u.Name = userName(strconv.FormatUint(uint64(u.UID), 10))
+		scan.Users = append(scan.Users, *u)
+	}
+	sort.Slice(scan.Users, func(i, j int) bool { return scan.Users[i].Bytes > scan.Users[j].Bytes })
+	return scan, nil
+}
+
+func displayTmpScan(scan tmpScan, top int, config Config) {
+	fmt.Println(scan.Root)
+	fmt.Printf("%-20s %-10s %s\n", "User", "Used", "Files")
+
+	var total, files uint64
+	for i, u := range scan.Users {
+		total += u.Bytes
+		files += u.Files
+		if i < top {
+			fmt.Printf("%s %-10s %d\n", fitCell(u.Name, 20), fmtBytes(u.Bytes, config.HumanReadable), u.Files)
+		}
+	}
+	if rest := len(scan.Users) - top; rest > 0 {
+		fmt.Printf("(%d more users)\n", rest)
+	}
+	fmt.Printf("%s %-10s %d\n", fitCell("total", 20), fmtBytes(total, config.HumanReadable), files)
+
+	if scan.Inaccessible > 0 {
+		fmt.Printf("%d directories inaccessible; totals are a lower bound\n", scan.Inaccessible)
+	}
+	if scan.Truncated {
+		fmt.Println("scan stopped at the depth or time limit; totals are a lower bound")
+	}
+}
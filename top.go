@@ -0,0 +1,33 @@
+package main
+
+import "fmt"
+
+// topN splits a sorted list into the first n filesystems, which -top
+// displays, and the rest. n of 0 keeps them all. Only the display is cut:
+// thresholds, alerts, the status and the summary see every filesystem.
+func topN(list []FS, n int) (shown, hidden []FS) {
+	if n <= 0 || len(list) <= n {
+		return list, nil
+	}
+	return list[:n], list[n:]
+}
+
+// remainderLine is the table's last row under -top, naming the fullest of
+// the hidden filesystems so one near its limit is not missed.
+func remainderLine(hidden []FS) string {
+	more := "top.more"
+	if len(hidden) == 1 {
+		more = "top.more.one"
+	}
+	line := fmt.Sprintf(T(more), len(hidden))
+	var fullest *FS
+	for i, d := range hidden {
+		if !d.Denied && (fullest == nil || d.Usage > fullest.Usage) {
+			fullest = &hidden[i]
+		}
+	}
+	if fullest != nil {
+		line += fmt.Sprintf(T("top.fullest"), sanitize(fullest.Mount), fullest.Usage)
+	}
+	return line
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func topList(n int) []FS {
+	var list []FS
+	for i := 0; i < n; i++ {
+		list = append(list, FS{Mount: fmt.Sprintf("/m%d", i), Total: 100, Used: uint64(10 * i), Usage: float64(10 * i)})
+	}
+	return list
+}
+
+func TestTopN(t *testing.T) {
+	tests := []struct {
+		len, n           int
+		shown, hiddenLen int
+	}{
+		{5, 0, 5, 0},
+		{5, 3, 3, 2},
+		{5, 5, 5, 0},
+		{5, 9, 5, 0},
+		{0, 3, 0, 0},
+	}
+	for _, tt := range tests {
+		shown, hidden := topN(topList(tt.len), tt.n)
+		if len(shown) != tt.shown || len(hidden) != tt.hiddenLen {
+			t.Errorf("topN(%d filesystems, %d) = %d shown, %d hidden; want %d, %d",
+				tt.len, tt.n, len(shown), len(hidden), tt.shown, tt.hiddenLen)
+		}
+	}
+}
+
+func TestRemainderLine(t *testing.T) {
+	hidden := topList(4)
+	hidden[1].Usage = 97
+	hidden[3].Denied, hidden[3].Usage = true, 100
+	if got, want := remainderLine(hidden), "…and 4 more filesystems (largest hidden: /m1 at 97%)"; got != want {
+		t.Errorf("remainderLine = %q, want %q", got, want)
+	}
+	if got, want := remainderLine(hidden[3:]), "…and 1 more filesystem"; got != want {
+		t.Errorf("remainderLine of a denied mount = %q, want %q", got, want)
+	}
+}
+
+// TestTopKeepsHiddenInExitCode cuts the JSON output to the first
+// filesystem while a later one is critical: the report is marked
+// truncated, its summary counts everything and the exit code is that of
+// the critical mount.
+func TestTopKeepsHiddenInExitCode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	config := Config{
+		WarnThreshold: 80, CritThreshold: 90, InodeWarn: 100, InodeCrit: 100,
+		Top: 1, OutputFormat: "json", Outputs: []output{{Format: "json", Path: path}},
+	}
+	list := topList(3)
+	list[2].Usage = 95
+	code := report(context.Background(), Report{Filesystems: list}, config, FileConfig{}, nil, log.New(io.Discard, "", 0))
+	if code != 2 {
+		t.Errorf("exit code = %d, want 2 for the hidden critical mount", code)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r Report
+	if err := json.Unmarshal(b, &r); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Filesystems) != 1 || !r.Truncated || r.Hidden != 2 {
+		t.Errorf("JSON has %d filesystems, truncated %v, hidden %d; want 1, true, 2", len(r.Filesystems), r.Truncated, r.Hidden)
+	}
+	if r.Summary == nil || r.Summary.Filesystems != 3 || r.Summary.Critical != 1 {
+		t.Errorf("summary = %+v, want all 3 filesystems and the critical one counted", r.Summary)
+	}
+}
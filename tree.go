@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/AScotM/filesystem_cap/fscap/mountinfo"
+)
+
+// linkTree points each entry's ParentID at its nearest listed ancestor in
+// the mount tree and counts unlisted mounts (excluded or failed to stat)
+// against their nearest listed ancestor. Entries without one get
+// ParentID 0 and unlisted mounts without one are not counted.
+func linkTree(list []FS, mounts []mountinfo.Mount) {
+	parent := make(map[int]int, len(mounts))
+	for _, m := range mounts {
+		parent[m.ID] = m.ParentID
+	}
+	index := make(map[int]int, len(list))
+	for i, d := range list {
+		index[d.ID] = i
+	}
+
+	ancestor := func(id int) int {
+		seen := map[int]bool{}
+		for p, ok := parent[id]; ok && !seen[p]; p, ok = parent[p] {
+			seen[p] = true
+			if _, listed := index[p]; listed && p != id {
+				return p
+			}
+		}
+		return 0
+	}
+
+	for i := range list {
+		list[i].ParentID = ancestor(list[i].ID)
+	}
+	for _, m := range mounts {
+		if _, listed := index[m.ID]; listed {
+			continue
+		}
+		if a := ancestor(m.ID); a != 0 {
+			list[index[a]].Excluded++
+		}
+	}
+}
+
+func displayTree(list []FS, config Config) {
+	listed := map[int]bool{}
+	for _, d := range list {
+		listed[d.ID] = true
+	}
+
+	children := map[int][]FS{}
+	var roots []FS
+	for _, d := range list {
+		if d.ParentID != 0 && listed[d.ParentID] {
+			children[d.ParentID] = append(children[d.ParentID], d)
+		} else {
+			roots = append(roots, d)
+		}
+	}
+	for _, c := range children {
+		sortByMount(c)
+	}
+	sortByMount(roots)
+
+	var walk func(d FS, prefix, branch string)
+	walk = func(d FS, prefix, branch string) {
+		fmt.Println(prefix + branch + treeLabel(d, config))
+		next := prefix
+		switch branch {
+		case "├── ":
+			next += "│   "
+		case "└── ":
+			next += "    "
+		}
+		kids := children[d.ID]
+		for i, c := range kids {
+			b := "├── "
+			if i == len(kids)-1 {
+				b = "└── "
+			}
+			walk(c, next, b)
+		}
+	}
+
+	if len(roots) == 1 {
+		walk(roots[0], "", "")
+		return
+	}
+
+	// Several top-level entries means the real root was filtered out or
+	// some parents were, so hang them all off a synthetic root.
+	fmt.Println("(mounts)")
+	for i, r := range roots {
+		b := "├── "
+		if i == len(roots)-1 {
+			b = "└── "
+		}
+		walk(r, "", b)
+	}
+}
+
+func treeLabel(d FS, config Config) string {
+	d = safeFS(d)
+	color := Colors.ForFS(d, config)
+	if d.Ignored {
+		color = Colors.muted(config.NoColor)
+	}
+	reset := ""
+	if color != "" {
+		reset = Colors.Reset
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s [%s, %s, %s%.2f%%%s]", d.Mount, d.Type,
+		fmtBytes(d.Total, config.HumanReadable), color, d.Usage, reset)
+	if d.Excluded > 0 {
+		fmt.Fprintf(&sb, " (+%d excluded)", d.Excluded)
+	}
+	return sb.String()
+}
+
+func sortByMount(list []FS) {
+	sort.Slice(list, func(i, j int) bool { return list[i].Mount < list[j].Mount })
+}
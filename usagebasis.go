@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// UsageBasisRule picks what Usage is measured against on the mounts
+// matching Match: "device", the default, or "quota", the caller's quota
+// limit where one is known. Later rules override earlier ones.
+type UsageBasisRule struct {
+	Match string `json:"match"`
+	Basis string `json:"basis"`
+}
+
+func (r UsageBasisRule) check(key string) []error {
+	var errs []error
+	if r.Match == "" {
+		errs = append(errs, fmt.Errorf("%s.match: required", key))
+	} else if _, err := filepath.Match(r.Match, ""); err != nil {
+		errs = append(errs, fmt.Errorf("%s.match: bad pattern %q", key, r.Match))
+	}
+	if r.Basis != "device" && r.Basis != "quota" {
+		errs = append(errs, fmt.Errorf("%s.basis: %q is not device or quota", key, r.Basis))
+	}
+	return errs
+}
+
+// applyUsageBasis makes Usage the share of the quota used on the entries
+// whose rule asks for it and that have a quota, keeping the device's in
+// DeviceUsage. Thresholds, colors and alerts then follow the quota.
+func applyUsageBasis(list []FS, rules []UsageBasisRule) {
+	for i := range list {
+		d := &list[i]
+		basis := "device"
+		for _, r := range rules {
+			if ok, _ := filepath.Match(r.Match, d.Mount); ok {
+				basis = r.Basis
+			}
+		}
+		if basis != "quota" || d.Quota == nil || d.Quota.Limit == 0 || d.Denied {
+			continue
+		}
+		d.UsageBasis, d.DeviceUsage = "quota", d.Usage
+		d.Usage = d.Quota.usage()
+	}
+}
+
+// usageBasis names what d's Usage is measured against.
+func usageBasis(d FS) string {
+	if d.UsageBasis == "" {
+		return "device"
+	}
+	return d.UsageBasis
+}
+
+// deviceBasis undoes applyUsageBasis on d.
+func deviceBasis(d FS) FS {
+	if d.UsageBasis != "" {
+		d.Usage, d.UsageBasis, d.DeviceUsage = d.DeviceUsage, "", 0
+	}
+	return d
+}
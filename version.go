@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set at build time with
+//
+//	-ldflags "-X main.version=1.2.0 -X main.commit=abc123 -X main.date=2024-01-02T15:04:05Z"
+var (
+	version = ""
+	commit  = ""
+	date    = ""
+)
+
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	GoVersion string `json:"go_version"`
+}
+
+// buildInfo returns the ldflags values, falling back to the module and VCS
+// information embedded by the Go toolchain.
+func buildInfo() BuildInfo {
+	bi := BuildInfo{Version: version, Commit: commit, Date: date, GoVersion: runtime.Version()}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if bi.Version == "" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			bi.Version = info.Main.Version
+		}
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && bi.Commit == "":
+				bi.Commit = s.Value
+			case s.Key == "vcs.time" && bi.Date == "":
+				bi.Date = s.Value
+			}
+		}
+	}
+	if bi.Version == "" {
+		bi.Version = "dev"
+	}
+	return bi
+}
+
+func printVersion(format string) {
+	bi := buildInfo()
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(bi)
+		return
+	}
+	fmt.Printf("dfmon %s (commit %s, built %s, %s)\n", bi.Version, orUnknown(bi.Commit), orUnknown(bi.Date), bi.GoVersion)
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
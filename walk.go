@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io/fs"
+	"path/filepath"
+	"syscall"
+)
+
+// walkFunc is called by fsWalker.walk for each entry, with its lstat. An
+// entry that could not be read or stat'ed is passed with a nil st and the
+// error.
+type walkFunc func(path string, de fs.DirEntry, st *syscall.Stat_t, err error) error
+
+// fsWalker walks trees on one filesystem for the scans that size
+// directories. It never reports entries of other filesystems mounted
+// inside, and reports every directory, and every file with more than one
+// link, once only, by device and inode: a subtree bind-mounted elsewhere
+// in the walked tree is otherwise counted twice. The seen set spans all
+// walks of one walker.
+type fsWalker struct {
+	dev  uint64
+	seen map[[2]uint64]bool
+}
+
+func newFSWalker(dev uint64) *fsWalker {
+	return &fsWalker{dev: dev, seen: map[[2]uint64]bool{}}
+}
+
+func (w *fsWalker) walk(root string, fn walkFunc) error {
+	return filepath.WalkDir(root, func(p string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return fn(p, de, nil, err)
+		}
+		var st syscall.Stat_t
+		if err := syscall.Lstat(p, &st); err != nil {
+			return fn(p, de, nil, err)
+		}
+		if uint64(st.Dev) != w.dev {
+			return skipEntry(de)
+		}
+		if de.IsDir() || st.Nlink > 1 {
+			key := [2]uint64{uint64(st.Dev), st.Ino}
+			if w.seen[key] {
+				return skipEntry(de)
+			}
+			w.seen[key] = true
+		}
+		return fn(p, de, &st, nil)
+	})
+}
+
+// skipEntry leaves out de: the whole subtree for a directory, only the
+// entry itself otherwise. Returning SkipDir for a file would skip the
+// rest of its directory.
+func skipEntry(de fs.DirEntry) error {
+	if de.IsDir() {
+		return filepath.SkipDir
+	}
+	return nil
+}
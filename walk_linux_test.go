@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func writeSized(t *testing.T, path string, size int) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, make([]byte, size), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func allocated(t *testing.T, path string) uint64 {
+	t.Helper()
+	var st syscall.Stat_t
+	if err := syscall.Lstat(path, &st); err != nil {
+		t.Fatal(err)
+	}
+	return uint64(st.Blocks) * 512
+}
+
+func scanTotal(t *testing.T, root string) (bytes, files uint64) {
+	t.Helper()
+	scan, err := scanTmp(context.Background(), root, 32, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range scan.Users {
+		bytes += u.Bytes
+		files += u.Files
+	}
+	return bytes, files
+}
+
+// walked lists the paths a walker reports under root, relative to it.
+func walked(t *testing.T, root string) []string {
+	t.Helper()
+	var st syscall.Stat_t
+	if err := syscall.Lstat(root, &st); err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	err := newFSWalker(uint64(st.Dev)).walk(root, func(p string, de fs.DirEntry, st *syscall.Stat_t, err error) error {
+		if err != nil {
+			t.Errorf("%s: %v", p, err)
+			return nil
+		}
+		rel, _ := filepath.Rel(root, p)
+		paths = append(paths, rel)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestWalkHardlinks(t *testing.T) {
+	root := t.TempDir()
+	writeSized(t, filepath.Join(root, "a", "data"), 1<<20)
+	os.Mkdir(filepath.Join(root, "b"), 0o755)
+	if err := os.Link(filepath.Join(root, "a", "data"), filepath.Join(root, "b", "data")); err != nil {
+		t.Skipf("no hard links here: %v", err)
+	}
+	writeSized(t, filepath.Join(root, "b", "other"), 4096)
+	os.Symlink(root, filepath.Join(root, "b", "loop"))
+
+	bytes, files := scanTotal(t, root)
+	want := allocated(t, filepath.Join(root, "a", "data")) + allocated(t, filepath.Join(root, "b", "other")) + allocated(t, filepath.Join(root, "b", "loop"))
+	if bytes != want || files != 3 {
+		t.Errorf("scan: %d bytes in %d files, want %d in 3", bytes, files, want)
+	}
+	got := strings.Join(walked(t, root), " ")
+	if got != ". a a/data b b/loop b/other" {
+		t.Errorf("walked %s", got)
+	}
+}
+
+// TestWalkMounts runs testWalkMounts in a new user and mount namespace,
+// where it may mount a tmpfs and a bind mount inside the walked tree.
+func TestWalkMounts(t *testing.T) {
+	if dir := os.Getenv("DFMON_TEST_WALK_NS"); dir != "" {
+		testWalkMounts(t, dir)
+		return
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestWalkMounts$", "-test.v")
+	cmd.Env = append(os.Environ(), "DFMON_TEST_WALK_NS="+t.TempDir())
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:                 syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS,
+		UidMappings:                []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+		GidMappings:                []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+		GidMappingsEnableSetgroups: false,
+	}
+	out, err := cmd.CombinedOutput()
+	if _, exited := err.(*exec.ExitError); err != nil && !exited {
+		t.Skipf("no user namespaces: %v", err)
+	}
+	if strings.Contains(string(out), "--- SKIP") {
+		t.Skipf("%s", out)
+	}
+	if err != nil {
+		t.Fatalf("%v:\n%s", err, out)
+	}
+}
+
+func testWalkMounts(t *testing.T, root string) {
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		t.Skipf("cannot mount in the namespace: %v", err)
+	}
+	tree := filepath.Join(root, "tree")
+	writeSized(t, filepath.Join(tree, "data", "file"), 1<<20)
+	for _, dir := range []string{"tmpfs", "bind"} {
+		if err := os.Mkdir(filepath.Join(tree, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := syscall.Mount("tmpfs", filepath.Join(tree, "tmpfs"), "tmpfs", 0, "size=8m"); err != nil {
+		t.Skipf("cannot mount tmpfs: %v", err)
+	}
+	writeSized(t, filepath.Join(tree, "tmpfs", "foreign"), 1<<20)
+	if err := syscall.Mount(filepath.Join(tree, "data"), filepath.Join(tree, "bind"), "", syscall.MS_BIND, ""); err != nil {
+		t.Skipf("cannot bind mount: %v", err)
+	}
+
+	// The tmpfs is left out, and the bind-mounted data counted once.
+	bytes, files := scanTotal(t, tree)
+	if want := allocated(t, filepath.Join(tree, "data", "file")); bytes != want || files != 1 {
+		t.Errorf("scan: %d bytes in %d files, want %d in 1", bytes, files, want)
+	}
+	got := strings.Join(walked(t, tree), " ")
+	if got != ". bind bind/file" && got != ". data data/file" {
+		t.Errorf("walked %s", got)
+	}
+
+	// Walked from inside the tmpfs, only the tmpfs is seen.
+	if got := strings.Join(walked(t, filepath.Join(tree, "tmpfs")), " "); got != ". foreign" {
+		t.Errorf("walked the tmpfs: %s", got)
+	}
+	// A walker keeps its seen set across walks, as -deep does across the
+	// subdirectories of a mount.
+	var st syscall.Stat_t
+	syscall.Lstat(tree, &st)
+	w := newFSWalker(uint64(st.Dev))
+	count := 0
+	for _, sub := range []string{"data", "bind"} {
+		w.walk(filepath.Join(tree, sub), func(p string, de fs.DirEntry, st *syscall.Stat_t, err error) error {
+			if err == nil && !de.IsDir() {
+				count++
+			}
+			return nil
+		})
+	}
+	if count != 1 {
+		t.Errorf("file seen %d times across two walks", count)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// wideRanges lists the East Asian Wide and Fullwidth blocks, which take two
+// terminal cells.
+var wideRanges = [][2]rune{
+	{0x1100, 0x115F},
+	{0x2E80, 0x303E},
+	{0x3041, 0x33FF},
+	{0x3400, 0x4DBF},
+	{0x4E00, 0x9FFF},
+	{0xA000, 0xA4CF},
+	{0xAC00, 0xD7A3},
+	{0xF900, 0xFAFF},
+	{0xFE30, 0xFE4F},
+	{0xFF00, 0xFF60},
+	{0xFFE0, 0xFFE6},
+	{0x1F300, 0x1F64F},
+	{0x1F900, 0x1F9FF},
+	{0x20000, 0x2FFFD},
+	{0x30000, 0x3FFFD},
+}
+
+func runeWidth(r rune) int {
+	if r == 0 || unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf) {
+		return 0
+	}
+	for _, rg := range wideRanges {
+		if r >= rg[0] && r <= rg[1] {
+			return 2
+		}
+	}
+	return 1
+}
+
+func displayWidth(s string) int {
+	w := 0
+	for _, r := range s {
+		w += runeWidth(r)
+	}
+	return w
+}
+
+// fitCell pads s to exactly width cells, truncating with an ellipsis when
+// it is too wide. Truncation only happens before a rune that starts a new
+// grapheme, so combining marks stay with their base character.
+func fitCell(s string, width int) string {
+	w := displayWidth(s)
+	if w <= width {
+		return s + strings.Repeat(" ", width-w)
+	}
+
+	var sb strings.Builder
+	used := 0
+	for _, r := range s {
+		rw := runeWidth(r)
+		if rw > 0 && used+rw > width-1 {
+			break
+		}
+		sb.WriteRune(r)
+		used += rw
+	}
+	sb.WriteString("…")
+	used++
+	return sb.String() + strings.Repeat(" ", width-used)
+}
+
+// sanitize makes an externally sourced string safe to print to a
+// terminal: control characters and invalid UTF-8 are shown as \xHH escapes
+// so a hostile mount name cannot add rows or send escape sequences.
+func sanitize(s string) string {
+	clean := true
+	for _, r := range s {
+		if r == utf8.RuneError || unicode.IsControl(r) {
+			clean = false
+			break
+		}
+	}
+	if clean {
+		return s
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && size <= 1:
+			fmt.Fprintf(&sb, `\x%02x`, s[i])
+		case unicode.IsControl(r):
+			for _, b := range []byte(s[i : i+size]) {
+				fmt.Fprintf(&sb, `\x%02x`, b)
+			}
+		default:
+			sb.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	return sb.String()
+}
+
+// safeFS returns d with its externally sourced strings sanitized for
+// terminal output.
+func safeFS(d FS) FS {
+	d.Device = sanitize(d.Device)
+	d.Mount = sanitize(d.Mount)
+	d.Type = sanitize(d.Type)
+	d.Options = sanitize(d.Options)
+	d.Label = sanitize(d.Label)
+	d.Backing = sanitize(d.Backing)
+	d.Subtree = sanitize(d.Subtree)
+	d.Owner = sanitize(d.Owner)
+	return d
+}
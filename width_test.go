@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDisplayWidth(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int
+	}{
+		{"", 0},
+		{"/var/log", 8},
+		{"/媒体/备份", 10},
+		{"/cafe\u0301", 5},           // e + combining acute
+		{"/cafe\u0301\u0327", 5},     // two marks on one base
+		{"/data\u200b", 5},           // zero-width space
+		{"/ｆｕｌｌ", 9},                 // fullwidth letters
+		{"/한국어", 7},                  // Hangul syllables
+		{"/\U0001F4BE", 3},           // emoji
+		{"/x\u20dd", 2},              // enclosing mark
+		{"/\U00020000\U0002A6D6", 5}, // CJK extension B
+	}
+	for _, tt := range tests {
+		if got := displayWidth(tt.s); got != tt.want {
+			t.Errorf("displayWidth(%q) = %d, want %d", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestFitCell(t *testing.T) {
+	tests := []struct {
+		s     string
+		width int
+		want  string
+	}{
+		{"/var", 6, "/var  "},
+		{"/var/lib", 8, "/var/lib"},
+		{"/var/lib/docker", 8, "/var/li…"},
+		{"/媒体/备份", 12, "/媒体/备份  "},
+		{"/媒体/备份", 10, "/媒体/备份"},
+		// A wide rune that does not fit leaves a cell of padding.
+		{"/媒体/备份", 7, "/媒体/…"},
+		{"/媒体/备份", 8, "/媒体/… "},
+		{"/cafe\u0301", 6, "/cafe\u0301 "},
+		// Marks stay with their base when the cut falls after it.
+		{"/cafe\u0301/bar", 6, "/cafe\u0301…"},
+		{"/cafe\u0301\u0327/bar", 6, "/cafe\u0301\u0327…"},
+	}
+	for _, tt := range tests {
+		got := fitCell(tt.s, tt.width)
+		if got != tt.want {
+			t.Errorf("fitCell(%q, %d) = %q, want %q", tt.s, tt.width, got, tt.want)
+		}
+		if w := displayWidth(got); w != tt.width {
+			t.Errorf("fitCell(%q, %d) is %d cells wide", tt.s, tt.width, w)
+		}
+	}
+}
+
+// TestColumnsAlign lays out rows as the table does and checks that the
+// type column starts on the same cell in each, whatever the mount.
+func TestColumnsAlign(t *testing.T) {
+	mounts := []string{
+		"/",
+		"/媒体/备份",
+		"/srv/cafe\u0301/donne\u0301es",
+		"/mnt/一二三四五六七八九十一二三四五六七八九十",
+		"/home/user/.local/share/containers/storage/overlay",
+		"/mnt/\U0001F4BE\U0001F4BE",
+	}
+	col := -1
+	for _, m := range mounts {
+		row := fitCell("/dev/sda1", 25) + " " + fitCell(m, 25) + " " + fitCell("ext4", 8)
+		at := displayWidth(row[:strings.LastIndex(row, "ext4")])
+		if col == -1 {
+			col = at
+		}
+		if at != col {
+			t.Errorf("mount %q: type column at cell %d, want %d:\n%s", m, at, col, row)
+		}
+	}
+}
+
+// hostileMount would clear the screen and print a fake healthy row.
+const hostileMount = "/mnt/evil\x1b[2J\n/dev/sda1 /  ext4  1T  1G  1T  1%\r"
+
+func TestSanitize(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"/var/log", "/var/log"},
+		{"/媒体/备份", "/媒体/备份"},
+		{"/a\tb", `/a\x09b`},
+		{"/a\x1b]0;title\x07", `/a\x1b]0;title\x07`},
+		{hostileMount, `/mnt/evil\x1b[2J\x0a/dev/sda1 /  ext4  1T  1G  1T  1%\x0d`},
+		// C1 controls are two bytes in UTF-8; both are shown.
+		{"/a\u009bb", `/a\xc2\x9bb`},
+		{"/bad\xff\xfe", `/bad\xff\xfe`},
+		// U+FFFD spelled out is valid and kept.
+		{"/\ufffd", "/\ufffd"},
+	}
+	for _, tt := range tests {
+		if got := sanitize(tt.in); got != tt.want {
+			t.Errorf("sanitize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// captureStdout returns what fn prints to standard output.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stdout
+	os.Stdout = w
+	done := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(r)
+		done <- b
+	}()
+	fn()
+	os.Stdout = saved
+	w.Close()
+	return string(<-done)
+}
+
+func hostileFS() FS {
+	return FS{Device: "/dev/fuse\x1b[31m", Mount: hostileMount, Type: "fuse.\x07evil", Options: "rw,\x1b[0m",
+		Total: 1000, Used: 10, Free: 990, Usage: 1}
+}
+
+func TestHostileTable(t *testing.T) {
+	d := hostileFS()
+	out := captureStdout(t, func() { printTableRow(d, d.Device, Config{Verbose: true, NoColor: true}) })
+	if strings.ContainsAny(out, "\x1b\x07\r") || strings.Count(out, "\n") != strings.Count(out, "\n    ")+1 {
+		t.Errorf("control characters reach the terminal: %q", out)
+	}
+	if !strings.Contains(out, `/mnt/evil\x1b[2J\x0a`) {
+		t.Errorf("mount not shown escaped: %q", out)
+	}
+
+	out = captureStdout(t, func() { displayOneline([]FS{d}, Config{}) })
+	if strings.ContainsAny(out, "\x1b\r") || strings.Count(out, "\n") != 1 {
+		t.Errorf("oneline: %q", out)
+	}
+}
+
+// TestHostileEncoded checks that JSON and CSV carry hostile strings
+// unmodified, encoded so they cannot break a record.
+func TestHostileEncoded(t *testing.T) {
+	d := hostileFS()
+	b, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.ContainsAny(b, "\x1b\n\r\x07") {
+		t.Errorf("raw control characters in JSON: %s", b)
+	}
+	var back FS
+	if err := json.Unmarshal(b, &back); err != nil || back.Mount != d.Mount || back.Device != d.Device || back.Type != d.Type {
+		t.Errorf("JSON round trip: %+v, %v", back, err)
+	}
+
+	for _, verbose := range []bool{false, true} {
+		var buf bytes.Buffer
+		config := Config{Verbose: verbose}
+		printCSVHeader(&buf, config)
+		printCSVRow(&buf, d, config)
+		printCSVRow(&buf, FS{Device: "a,b", Mount: `/"quoted"`, Type: "ext4"}, config)
+		records, err := csv.NewReader(&buf).ReadAll()
+		if err != nil {
+			t.Fatalf("verbose %v: %v\n%s", verbose, err, buf.String())
+		}
+		if len(records) != 3 {
+			t.Fatalf("verbose %v: %d records, want 3:\n%q", verbose, len(records), buf.String())
+		}
+		if r := records[1]; r[0] != d.Device || r[1] != d.Mount || r[2] != d.Type {
+			t.Errorf("verbose %v: hostile row read back as %q", verbose, r)
+		}
+		if verbose && records[1][7] != d.Options {
+			t.Errorf("options read back as %q", records[1][7])
+		}
+		if r := records[2]; r[0] != "a,b" || r[1] != `/"quoted"` {
+			t.Errorf("verbose %v: quoted row read back as %q", verbose, r)
+		}
+	}
+}